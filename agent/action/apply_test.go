@@ -41,6 +41,7 @@ func init() {
 				platform.GetDirProvider(),
 				specService,
 				settingsService,
+				platform.GetDevicePathResolver(),
 				logger,
 			)
 
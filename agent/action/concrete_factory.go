@@ -60,7 +60,7 @@ func NewFactory(
 			"start":      NewStart(jobSupervisor, applier, specService, dualDCSupport, platform),
 			"stop":       NewStop(jobSupervisor, dualDCSupport, platform),
 			"drain":      NewDrain(notifier, specService, jobScriptProvider, jobSupervisor, logger),
-			"get_state":  NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, platform),
+			"get_state":  NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, platform, dualDCSupport),
 			"run_errand": NewRunErrand(specService, dirProvider.JobsDir(), platform.GetRunner(), logger),
 			"run_script": NewRunScript(jobScriptProvider, specService, logger),
 
@@ -74,6 +74,18 @@ func NewFactory(
 			"mount_disk":   NewMountDisk(settingsService, platform, platform, dirProvider),
 			"unmount_disk": NewUnmountDisk(settingsService, platform),
 
+			// Dual DC
+			"drbd_resolve_split_brain": NewDrbdResolveSplitBrain(dualDCSupport),
+			"drbd_verify":              NewDrbdVerify(dualDCSupport),
+			"drbd_create_snapshot":     NewDrbdCreateSnapshot(dualDCSupport),
+			"drbd_list_snapshots":      NewDrbdListSnapshots(dualDCSupport),
+			"drbd_delete_snapshot":     NewDrbdDeleteSnapshot(dualDCSupport),
+			"drbd_rollback_snapshot":   NewDrbdRollbackSnapshot(jobSupervisor, dualDCSupport),
+			"drbd_discard_data":        NewDrbdDiscardData(dualDCSupport),
+			"drbd_grow_volume":         NewDrbdGrowVolume(dualDCSupport),
+			"drbd_switchover":          NewDrbdSwitchover(jobSupervisor, applier, specService, dualDCSupport, platform.GetFs(), dirProvider, logger),
+			"nimbus_status":            NewNimbusStatus(dualDCSupport),
+
 			// Networkingconcrete_factory_test.go
 			"prepare_network_change":     NewPrepareNetworkChange(platform.GetFs(), settingsService, NewAgentKiller()),
 			"prepare_configure_networks": NewPrepareConfigureNetworks(platform, settingsService),
@@ -59,6 +59,7 @@ var _ = Describe("concreteFactory", func() {
 			platform.GetDirProvider(),
 			specService,
 			settingsService,
+			platform.GetDevicePathResolver(),
 			logger,
 		)
 
@@ -118,7 +119,7 @@ var _ = Describe("concreteFactory", func() {
 		ntpService := boshntp.NewConcreteService(platform.GetFs(), platform.GetDirProvider())
 		action, err := factory.Create("get_state")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(action).To(Equal(NewGetState(settingsService, specService, jobSupervisor, platform.GetVitalsService(), ntpService, platform)))
+		Expect(action).To(Equal(NewGetState(settingsService, specService, jobSupervisor, platform.GetVitalsService(), ntpService, platform, dualDCSupport)))
 	})
 
 	It("list_disk", func() {
@@ -187,6 +188,66 @@ var _ = Describe("concreteFactory", func() {
 		Expect(action).To(Equal(NewUnmountDisk(settingsService, platform)))
 	})
 
+	It("drbd_resolve_split_brain", func() {
+		action, err := factory.Create("drbd_resolve_split_brain")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdResolveSplitBrain(dualDCSupport)))
+	})
+
+	It("drbd_verify", func() {
+		action, err := factory.Create("drbd_verify")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdVerify(dualDCSupport)))
+	})
+
+	It("drbd_create_snapshot", func() {
+		action, err := factory.Create("drbd_create_snapshot")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdCreateSnapshot(dualDCSupport)))
+	})
+
+	It("drbd_list_snapshots", func() {
+		action, err := factory.Create("drbd_list_snapshots")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdListSnapshots(dualDCSupport)))
+	})
+
+	It("drbd_delete_snapshot", func() {
+		action, err := factory.Create("drbd_delete_snapshot")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdDeleteSnapshot(dualDCSupport)))
+	})
+
+	It("drbd_rollback_snapshot", func() {
+		action, err := factory.Create("drbd_rollback_snapshot")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdRollbackSnapshot(jobSupervisor, dualDCSupport)))
+	})
+
+	It("drbd_discard_data", func() {
+		action, err := factory.Create("drbd_discard_data")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdDiscardData(dualDCSupport)))
+	})
+
+	It("drbd_grow_volume", func() {
+		action, err := factory.Create("drbd_grow_volume")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdGrowVolume(dualDCSupport)))
+	})
+
+	It("drbd_switchover", func() {
+		action, err := factory.Create("drbd_switchover")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewDrbdSwitchover(jobSupervisor, applier, specService, dualDCSupport, platform.GetFs(), platform.GetDirProvider(), logger)))
+	})
+
+	It("nimbus_status", func() {
+		action, err := factory.Create("nimbus_status")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal(NewNimbusStatus(dualDCSupport)))
+	})
+
 	It("compile_package", func() {
 		action, err := factory.Create("compile_package")
 		Expect(err).ToNot(HaveOccurred())
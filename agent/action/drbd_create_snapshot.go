@@ -0,0 +1,44 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdCreateSnapshotAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdCreateSnapshot(dualDCSupport *nimbus.DualDCSupport) (action DrbdCreateSnapshotAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdCreateSnapshotAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdCreateSnapshotAction) IsPersistent() bool {
+	return false
+}
+
+func (a DrbdCreateSnapshotAction) Run(name string) (value interface{}, err error) {
+	snapshot, err := a.dualDCSupport.CreateDrbdSnapshot(name)
+	if err != nil {
+		err = bosherr.WrapError(err, "Creating drbd snapshot")
+		return
+	}
+
+	value = snapshot
+	return
+}
+
+func (a DrbdCreateSnapshotAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdCreateSnapshotAction) Cancel() error {
+	return errors.New("not supported")
+}
@@ -0,0 +1,61 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("DrbdCreateSnapshot", func() {
+	var (
+		platform    *fakeplatform.FakePlatform
+		specService *fakeas.FakeV1Service
+		action      DrbdCreateSnapshotAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdCreateSnapshot(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("creates snapshot", func() {
+		value, err := action.Run("pre_upgrade")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(nimbus.DrbdSnapshot{Name: "pre_upgrade"}))
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"lvcreate", "-s", "-n", "StoreData-snap-pre_upgrade", "-l", "50%FREE", "vgStoreData/StoreData"}))
+	})
+
+	It("returns error on passive leg", func() {
+		specService.Spec.Passive = "enabled"
+
+		_, err := action.Run("pre_upgrade")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Creating drbd snapshot"))
+	})
+})
@@ -0,0 +1,43 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdDeleteSnapshotAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdDeleteSnapshot(dualDCSupport *nimbus.DualDCSupport) (action DrbdDeleteSnapshotAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdDeleteSnapshotAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdDeleteSnapshotAction) IsPersistent() bool {
+	return false
+}
+
+func (a DrbdDeleteSnapshotAction) Run(name string) (value interface{}, err error) {
+	if err = a.dualDCSupport.DeleteDrbdSnapshot(name); err != nil {
+		err = bosherr.WrapError(err, "Deleting drbd snapshot")
+		return
+	}
+
+	value = "deleted"
+	return
+}
+
+func (a DrbdDeleteSnapshotAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdDeleteSnapshotAction) Cancel() error {
+	return errors.New("not supported")
+}
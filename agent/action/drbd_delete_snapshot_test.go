@@ -0,0 +1,64 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("DrbdDeleteSnapshot", func() {
+	var (
+		platform *fakeplatform.FakePlatform
+		action   DrbdDeleteSnapshotAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService := fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdDeleteSnapshot(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("deletes snapshot", func() {
+		platform.Runner.AddCmdResult(
+			"lvs --noheadings --separator , --units k --nosuffix -o lv_name,origin,lv_attr,lv_size,data_percent,lv_time vgStoreData",
+			fakesys.FakeCmdResult{Stdout: "  StoreData-snap-pre_upgrade,StoreData,swi-a-s---,1024.00,1.00,2016-03-01 10:00:00 +0000\n"},
+		)
+
+		value, err := action.Run("pre_upgrade")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("deleted"))
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"lvremove", "-f", "vgStoreData/StoreData-snap-pre_upgrade"}))
+	})
+
+	It("returns error for unknown snapshot", func() {
+		_, err := action.Run("pre_upgrade")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Deleting drbd snapshot"))
+	})
+})
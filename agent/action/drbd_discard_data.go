@@ -0,0 +1,45 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// DrbdDiscardDataAction is run on the passive leg before drbd_rollback_snapshot
+// on the active one, the passive leg is then fully resynced from the rolled back data.
+type DrbdDiscardDataAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdDiscardData(dualDCSupport *nimbus.DualDCSupport) (action DrbdDiscardDataAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdDiscardDataAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdDiscardDataAction) IsPersistent() bool {
+	return false
+}
+
+func (a DrbdDiscardDataAction) Run() (value interface{}, err error) {
+	if err = a.dualDCSupport.DiscardDrbdData(); err != nil {
+		err = bosherr.WrapError(err, "Discarding drbd data")
+		return
+	}
+
+	value = "discarded"
+	return
+}
+
+func (a DrbdDiscardDataAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdDiscardDataAction) Cancel() error {
+	return errors.New("not supported")
+}
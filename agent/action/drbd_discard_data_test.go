@@ -0,0 +1,62 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("DrbdDiscardData", func() {
+	var (
+		platform    *fakeplatform.FakePlatform
+		specService *fakeas.FakeV1Service
+		action      DrbdDiscardDataAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "enabled"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdDiscardData(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("invalidates the disk of the passive leg", func() {
+		value, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("discarded"))
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"drbdadm", "invalidate", "r0"}))
+	})
+
+	It("refuses to run on the active leg", func() {
+		specService.Spec.Passive = "disabled"
+
+		_, err := action.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Discarding drbd data"))
+		Expect(platform.Runner.RunCommands).To(BeEmpty())
+	})
+})
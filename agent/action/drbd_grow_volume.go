@@ -0,0 +1,46 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdGrowVolumeAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdGrowVolume(dualDCSupport *nimbus.DualDCSupport) (action DrbdGrowVolumeAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdGrowVolumeAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdGrowVolumeAction) IsPersistent() bool {
+	return false
+}
+
+// Run is sent to the passive leg first and then to the active one,
+// an empty size grows to drbd_logical_volume_size from the apply spec.
+func (a DrbdGrowVolumeAction) Run(size string) (value interface{}, err error) {
+	result, err := a.dualDCSupport.GrowDrbdVolume(size)
+	if err != nil {
+		err = bosherr.WrapErrorf(err, "Growing drbd volume after steps %v", result.Steps)
+		return
+	}
+
+	value = result
+	return
+}
+
+func (a DrbdGrowVolumeAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdGrowVolumeAction) Cancel() error {
+	return errors.New("not supported")
+}
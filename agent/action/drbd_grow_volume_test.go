@@ -0,0 +1,68 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("DrbdGrowVolume", func() {
+	var (
+		platform        *fakeplatform.FakePlatform
+		settingsService *fakesettings.FakeSettingsService
+		action          DrbdGrowVolumeAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		devicePathResolver := fakedpresolv.NewFakeDevicePathResolver()
+		devicePathResolver.RealDevicePath = "/dev/xvdd"
+		platform.DevicePathResolver = devicePathResolver
+		specService := fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "enabled"}
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Disks.Persistent = map[string]interface{}{"fake-disk-id": "/dev/sdd"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			settingsService,
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdGrowVolume(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("grows the volume on this leg", func() {
+		value, err := action.Run("+10G")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value.(nimbus.DrbdGrowResult).State).To(Equal("extended"))
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"pvresize", "/dev/xvdd1"}))
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"lvextend", "-L", "+10G", "vgStoreData/StoreData"}))
+	})
+
+	It("returns error with completed steps", func() {
+		settingsService.Settings.Disks.Persistent = nil
+
+		_, err := action.Run("+10G")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Growing drbd volume after steps []"))
+	})
+})
@@ -0,0 +1,48 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdListSnapshotsAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdListSnapshots(dualDCSupport *nimbus.DualDCSupport) (action DrbdListSnapshotsAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdListSnapshotsAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a DrbdListSnapshotsAction) IsPersistent() bool {
+	return false
+}
+
+func (a DrbdListSnapshotsAction) Run() (value interface{}, err error) {
+	snapshots, err := a.dualDCSupport.DrbdSnapshots()
+	if err != nil {
+		err = bosherr.WrapError(err, "Listing drbd snapshots")
+		return
+	}
+
+	if snapshots == nil {
+		snapshots = []nimbus.DrbdSnapshot{}
+	}
+
+	value = snapshots
+	return
+}
+
+func (a DrbdListSnapshotsAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdListSnapshotsAction) Cancel() error {
+	return errors.New("not supported")
+}
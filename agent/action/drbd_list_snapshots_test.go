@@ -0,0 +1,68 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("DrbdListSnapshots", func() {
+	var (
+		platform    *fakeplatform.FakePlatform
+		specService *fakeas.FakeV1Service
+		action      DrbdListSnapshotsAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdListSnapshots(dualDCSupport)
+	})
+
+	It("is synchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeFalse())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("lists snapshots", func() {
+		platform.Runner.AddCmdResult(
+			"lvs --noheadings --separator , --units k --nosuffix -o lv_name,origin,lv_attr,lv_size,data_percent,lv_time vgStoreData",
+			fakesys.FakeCmdResult{Stdout: "  StoreData-snap-pre_upgrade,StoreData,swi-a-s---,1024.00,1.00,2016-03-01 10:00:00 +0000\n"},
+		)
+
+		value, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		boshassert.MatchesJSONString(GinkgoT(), value,
+			`[{"name":"pre_upgrade","size_kib":1024,"data_percent":1,"created_at":"2016-03-01 10:00:00 +0000","invalid":false}]`)
+	})
+
+	It("returns empty list when drbd is disabled", func() {
+		specService.Spec = boshas.V1ApplySpec{}
+
+		value, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		boshassert.MatchesJSONString(GinkgoT(), value, `[]`)
+	})
+})
@@ -0,0 +1,55 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdResolveSplitBrainAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdResolveSplitBrain(dualDCSupport *nimbus.DualDCSupport) (action DrbdResolveSplitBrainAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdResolveSplitBrainAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdResolveSplitBrainAction) IsPersistent() bool {
+	return false
+}
+
+// Run is sent to both legs with the same victim (replication ip or agent id),
+// the victim discards its changes and the survivor reconnects.
+func (a DrbdResolveSplitBrainAction) Run(victim string) (value interface{}, err error) {
+	if victim == "" {
+		err = errors.New("Victim node must be specified")
+		return
+	}
+
+	role, err := a.dualDCSupport.ResolveSplitBrain(victim)
+	if err != nil {
+		err = bosherr.WrapError(err, "Resolving drbd split brain")
+		return
+	}
+
+	type valueType struct {
+		Role string `json:"role"`
+	}
+
+	value = valueType{Role: role}
+	return
+}
+
+func (a DrbdResolveSplitBrainAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdResolveSplitBrainAction) Cancel() error {
+	return errors.New("not supported")
+}
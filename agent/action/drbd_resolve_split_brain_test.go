@@ -0,0 +1,77 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("DrbdResolveSplitBrain", func() {
+	var (
+		platform        *fakeplatform.FakePlatform
+		specService     *fakeas.FakeV1Service
+		settingsService *fakesettings.FakeSettingsService
+		action          DrbdResolveSplitBrainAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true}
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Networks = boshsettings.Networks{
+			"default": boshsettings.Network{IP: "10.76.245.71"},
+		}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			settingsService,
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdResolveSplitBrain(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("returns role played by this node", func() {
+		platform.Runner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone"})
+
+		value, err := action.Run("10.92.245.71")
+		Expect(err).ToNot(HaveOccurred())
+		boshassert.MatchesJSONString(GinkgoT(), value, `{"role":"survivor"}`)
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"drbdadm", "connect", "r0"}))
+	})
+
+	It("requires victim", func() {
+		_, err := action.Run("")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("Victim node must be specified"))
+	})
+
+	It("returns error when recovery fails", func() {
+		platform.Runner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone"})
+
+		_, err := action.Run("10.76.245.71")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Resolving drbd split brain"))
+	})
+})
@@ -0,0 +1,71 @@
+package action
+
+import (
+	"errors"
+
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdRollbackSnapshotAction struct {
+	jobSupervisor boshjobsuper.JobSupervisor
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdRollbackSnapshot(
+	jobSupervisor boshjobsuper.JobSupervisor,
+	dualDCSupport *nimbus.DualDCSupport,
+) (action DrbdRollbackSnapshotAction) {
+	action.jobSupervisor = jobSupervisor
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdRollbackSnapshotAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdRollbackSnapshotAction) IsPersistent() bool {
+	return false
+}
+
+// Run stops the jobs using the store, rolls it back to the snapshot and starts them again.
+// Jobs are only stopped once the rollback is known to be possible.
+func (a DrbdRollbackSnapshotAction) Run(name string) (value interface{}, err error) {
+	if err = a.dualDCSupport.ValidateDrbdSnapshotRollback(name); err != nil {
+		err = bosherr.WrapError(err, "Rolling back drbd snapshot")
+		return
+	}
+
+	if err = a.jobSupervisor.Stop(); err != nil {
+		err = bosherr.WrapError(err, "Stopping Monitored Services")
+		return
+	}
+
+	if err = a.dualDCSupport.RollbackDrbdSnapshot(name); err != nil {
+		if startErr := a.jobSupervisor.Start(); startErr != nil {
+			err = bosherr.WrapErrorf(err, "Rolling back drbd snapshot (starting Monitored Services again failed: %s)", startErr)
+			return
+		}
+
+		err = bosherr.WrapError(err, "Rolling back drbd snapshot")
+		return
+	}
+
+	if err = a.jobSupervisor.Start(); err != nil {
+		err = bosherr.WrapError(err, "Starting Monitored Services")
+		return
+	}
+
+	value = "rolled back"
+	return
+}
+
+func (a DrbdRollbackSnapshotAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdRollbackSnapshotAction) Cancel() error {
+	return errors.New("not supported")
+}
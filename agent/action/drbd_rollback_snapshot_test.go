@@ -0,0 +1,104 @@
+package action_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("DrbdRollbackSnapshot", func() {
+	var (
+		jobSupervisor *fakejobsuper.FakeJobSupervisor
+		platform      *fakeplatform.FakePlatform
+		action        DrbdRollbackSnapshotAction
+	)
+
+	BeforeEach(func() {
+		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+		platform = fakeplatform.NewFakePlatform()
+		specService := fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdRollbackSnapshot(jobSupervisor, dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	addSnapshot := func() {
+		platform.Runner.AddCmdResult(
+			"lvs --noheadings --separator , --units k --nosuffix -o lv_name,origin,lv_attr,lv_size,data_percent,lv_time vgStoreData",
+			fakesys.FakeCmdResult{Stdout: "  StoreData-snap-pre_upgrade,StoreData,swi-a-s---,1024.00,1.00,2016-03-01 10:00:00 +0000\n", Sticky: true},
+		)
+	}
+
+	peerDiscarded := func() {
+		platform.Runner.AddCmdResult("drbdadm dstate r0", fakesys.FakeCmdResult{Stdout: "UpToDate/Inconsistent\n", Sticky: true})
+	}
+
+	It("stops jobs, rolls back and starts jobs again", func() {
+		addSnapshot()
+		peerDiscarded()
+		platform.Runner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "SyncSource\n"})
+
+		value, err := action.Run("pre_upgrade")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("rolled back"))
+		Expect(jobSupervisor.Stopped).To(BeTrue())
+		Expect(jobSupervisor.Started).To(BeTrue())
+		Expect(platform.Runner.RunCommands).To(ContainElement([]string{"lvconvert", "--merge", "vgStoreData/StoreData-snap-pre_upgrade"}))
+	})
+
+	It("does not stop jobs when the snapshot does not exist", func() {
+		_, err := action.Run("pre_upgrade")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Snapshot 'pre_upgrade' not found"))
+		Expect(jobSupervisor.Stopped).To(BeFalse())
+	})
+
+	It("does not stop jobs before the peer discarded its data", func() {
+		addSnapshot()
+		platform.Runner.AddCmdResult("drbdadm dstate r0", fakesys.FakeCmdResult{Stdout: "UpToDate/UpToDate\n"})
+
+		_, err := action.Run("pre_upgrade")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("run drbd_discard_data on the passive leg"))
+		Expect(jobSupervisor.Stopped).To(BeFalse())
+	})
+
+	It("starts jobs again when rollback fails", func() {
+		addSnapshot()
+		peerDiscarded()
+		platform.Runner.AddCmdResult("lvconvert --merge vgStoreData/StoreData-snap-pre_upgrade", fakesys.FakeCmdResult{Error: errors.New("fake-merge-error")})
+
+		_, err := action.Run("pre_upgrade")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("fake-merge-error"))
+		Expect(jobSupervisor.Stopped).To(BeTrue())
+		Expect(jobSupervisor.Started).To(BeTrue())
+	})
+})
@@ -0,0 +1,239 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+
+	boshappl "github.com/cloudfoundry/bosh-agent/agent/applier"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	DrbdSwitchoverDemote  = "demote"
+	DrbdSwitchoverPromote = "promote"
+
+	drbdSwitchoverLogTag = "DrbdSwitchoverAction"
+)
+
+type drbdSwitchoverStep struct {
+	Name string
+	Run  func() error
+}
+
+type DrbdSwitchoverState struct {
+	Direction      string   `json:"direction"`
+	CompletedSteps []string `json:"completed_steps"`
+}
+
+// DrbdSwitchoverAction hands the active role over between the legs of a dual DC pair.
+// It is run with "demote" on the active leg and then with "promote" on the other one.
+// Completed steps are recorded under the bosh dir and the action is persistent,
+// so after an agent restart it continues from the first step not yet done.
+type DrbdSwitchoverAction struct {
+	jobSupervisor boshjobsuper.JobSupervisor
+	applier       boshappl.Applier
+	specService   boshas.V1Service
+	dualDCSupport *nimbus.DualDCSupport
+	fs            boshsys.FileSystem
+	statePath     string
+	logger        boshlog.Logger
+}
+
+func NewDrbdSwitchover(
+	jobSupervisor boshjobsuper.JobSupervisor,
+	applier boshappl.Applier,
+	specService boshas.V1Service,
+	dualDCSupport *nimbus.DualDCSupport,
+	fs boshsys.FileSystem,
+	dirProvider boshdir.Provider,
+	logger boshlog.Logger,
+) (action DrbdSwitchoverAction) {
+	action.jobSupervisor = jobSupervisor
+	action.applier = applier
+	action.specService = specService
+	action.dualDCSupport = dualDCSupport
+	action.fs = fs
+	action.statePath = filepath.Join(dirProvider.BoshDir(), "drbd_switchover.json")
+	action.logger = logger
+	return
+}
+
+func (a DrbdSwitchoverAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdSwitchoverAction) IsPersistent() bool {
+	return true
+}
+
+func (a DrbdSwitchoverAction) Run(direction string) (value interface{}, err error) {
+	if direction != DrbdSwitchoverDemote && direction != DrbdSwitchoverPromote {
+		err = bosherr.Errorf("Unknown switchover direction '%s', expected '%s' or '%s'", direction, DrbdSwitchoverDemote, DrbdSwitchoverPromote)
+		return
+	}
+
+	spec, err := a.specService.Get()
+	if err != nil {
+		err = bosherr.WrapError(err, "Getting apply spec")
+		return
+	}
+
+	if !spec.DrbdEnabled {
+		err = errors.New("Switchover requires drbd to be enabled")
+		return
+	}
+
+	state, found, err := a.loadState()
+	if err != nil {
+		return
+	}
+
+	if found && state.Direction != direction {
+		err = bosherr.Errorf("Switchover '%s' is already in progress", state.Direction)
+		return
+	}
+
+	if !found {
+		state = DrbdSwitchoverState{Direction: direction, CompletedSteps: []string{}}
+		if err = a.saveState(state); err != nil {
+			return
+		}
+	}
+
+	return a.runSteps(state)
+}
+
+func (a DrbdSwitchoverAction) Resume() (interface{}, error) {
+	state, found, err := a.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	// finished just before the task info was removed
+	if !found {
+		return "switchover finished", nil
+	}
+
+	a.logger.Info(drbdSwitchoverLogTag, "Resuming switchover '%s' after %v", state.Direction, state.CompletedSteps)
+
+	return a.runSteps(state)
+}
+
+func (a DrbdSwitchoverAction) Cancel() error {
+	return errors.New("not supported")
+}
+
+func (a DrbdSwitchoverAction) runSteps(state DrbdSwitchoverState) (interface{}, error) {
+	completed := map[string]bool{}
+	for _, name := range state.CompletedSteps {
+		completed[name] = true
+	}
+
+	for _, step := range a.steps(state.Direction) {
+		if completed[step.Name] {
+			continue
+		}
+
+		a.logger.Info(drbdSwitchoverLogTag, "Running switchover step '%s'", step.Name)
+
+		if err := step.Run(); err != nil {
+			return nil, bosherr.WrapErrorf(err, "Switchover step '%s'", step.Name)
+		}
+
+		state.CompletedSteps = append(state.CompletedSteps, step.Name)
+		if err := a.saveState(state); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := a.fs.RemoveAll(a.statePath); err != nil {
+		return nil, bosherr.WrapError(err, "Removing switchover state")
+	}
+
+	return state, nil
+}
+
+func (a DrbdSwitchoverAction) steps(direction string) []drbdSwitchoverStep {
+	if direction == DrbdSwitchoverDemote {
+		// clients stop resolving to this leg before its jobs go down,
+		// deregistering stops the dns updates as well
+		return []drbdSwitchoverStep{
+			{"deregister_dns", a.dualDCSupport.DeregisterDNSIfRequired},
+			{"stop_jobs", a.jobSupervisor.Stop},
+			{"wait_for_sync", a.dualDCSupport.WaitForDrbdInSync},
+			{"unmount", a.dualDCSupport.UnmountDrbdStore},
+			{"demote", a.dualDCSupport.DemoteDrbd},
+			{"mark_passive", func() error { return a.dualDCSupport.SetPassive(true) }},
+		}
+	}
+
+	return []drbdSwitchoverStep{
+		{"promote_and_mount", a.dualDCSupport.PromoteDrbdAndMount},
+		{"mark_active", func() error { return a.dualDCSupport.SetPassive(false) }},
+		{"start_dns_updates", a.dualDCSupport.StartDNSUpdatesIfRequired},
+		{"start_jobs", a.startJobs},
+	}
+}
+
+func (a DrbdSwitchoverAction) startJobs() error {
+	spec, err := a.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Getting apply spec")
+	}
+
+	if err = a.applier.ConfigureJobs(spec); err != nil {
+		return bosherr.WrapError(err, "Configuring jobs")
+	}
+
+	if err = a.jobSupervisor.Start(); err != nil {
+		return bosherr.WrapError(err, "Starting Monitored Services")
+	}
+
+	return nil
+}
+
+func (a DrbdSwitchoverAction) loadState() (state DrbdSwitchoverState, found bool, err error) {
+	if !a.fs.FileExists(a.statePath) {
+		return
+	}
+
+	contents, err := a.fs.ReadFile(a.statePath)
+	if err != nil {
+		err = bosherr.WrapError(err, "Reading switchover state")
+		return
+	}
+
+	if err = json.Unmarshal(contents, &state); err != nil {
+		err = bosherr.WrapError(err, "Unmarshalling switchover state")
+		return
+	}
+
+	return state, true, nil
+}
+
+func (a DrbdSwitchoverAction) saveState(state DrbdSwitchoverState) error {
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling switchover state")
+	}
+
+	// a crash while writing must not leave a truncated state behind
+	tmpPath := a.statePath + ".tmp"
+
+	if err = a.fs.WriteFile(tmpPath, contents); err != nil {
+		return bosherr.WrapError(err, "Writing switchover state")
+	}
+
+	if err = a.fs.Rename(tmpPath, a.statePath); err != nil {
+		return bosherr.WrapError(err, "Renaming switchover state")
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package action_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakeappl "github.com/cloudfoundry/bosh-agent/agent/applier/fakes"
+	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("DrbdSwitchover", func() {
+	const statePath = "/var/vcap/bosh/drbd_switchover.json"
+
+	var (
+		jobSupervisor *fakejobsuper.FakeJobSupervisor
+		applier       *fakeappl.FakeApplier
+		specService   *fakeas.FakeV1Service
+		platform      *fakeplatform.FakePlatform
+		action        DrbdSwitchoverAction
+	)
+
+	BeforeEach(func() {
+		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+		applier = fakeappl.NewFakeApplier()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+		platform = fakeplatform.NewFakePlatform()
+		platform.Fs.WriteFileString("/proc/mounts", "")
+		logger := boshlog.NewLogger(boshlog.LevelNone)
+
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			logger,
+		)
+		action = NewDrbdSwitchover(jobSupervisor, applier, specService, dualDCSupport, platform.GetFs(), platform.GetDirProvider(), logger)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is persistent", func() {
+		Expect(action.IsPersistent()).To(BeTrue())
+	})
+
+	It("rejects unknown direction", func() {
+		_, err := action.Run("sideways")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Unknown switchover direction 'sideways'"))
+	})
+
+	It("requires drbd to be enabled", func() {
+		specService.Spec = boshas.V1ApplySpec{}
+
+		_, err := action.Run("demote")
+		Expect(err).To(HaveOccurred())
+		Expect(jobSupervisor.Stopped).To(BeFalse())
+	})
+
+	Context("demote", func() {
+		BeforeEach(func() {
+			platform.Runner.AddCmdResult("drbdadm dstate r0", fakesys.FakeCmdResult{Stdout: "UpToDate/UpToDate\n"})
+		})
+
+		It("deregisters dns, stops jobs, demotes drbd and marks leg passive", func() {
+			value, err := action.Run("demote")
+			Expect(err).ToNot(HaveOccurred())
+			boshassert.MatchesJSONString(GinkgoT(), value,
+				`{"direction":"demote","completed_steps":["deregister_dns","stop_jobs","wait_for_sync","unmount","demote","mark_passive"]}`)
+
+			Expect(jobSupervisor.Stopped).To(BeTrue())
+			Expect(platform.Runner.RunCommands).To(ContainElement([]string{"drbdadm", "secondary", "r0"}))
+			Expect(specService.Spec.Passive).To(Equal("enabled"))
+			Expect(platform.Fs.FileExists(statePath)).To(BeFalse())
+		})
+
+		It("records completed steps when a step fails", func() {
+			platform.Runner.AddCmdResult("drbdadm secondary r0", fakesys.FakeCmdResult{Error: errors.New("fake-error")})
+
+			_, err := action.Run("demote")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Switchover step 'demote'"))
+
+			state, err := platform.Fs.ReadFileString(statePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state).To(MatchJSON(`{"direction":"demote","completed_steps":["deregister_dns","stop_jobs","wait_for_sync","unmount"]}`))
+			Expect(specService.Spec.Passive).To(Equal("disabled"))
+		})
+
+		It("writes the state through a rename so a crash leaves no truncated state", func() {
+			platform.Runner.AddCmdResult("drbdadm secondary r0", fakesys.FakeCmdResult{Error: errors.New("fake-error")})
+
+			_, err := action.Run("demote")
+			Expect(err).To(HaveOccurred())
+
+			Expect(platform.Fs.RenameOldPaths).To(ContainElement(statePath + ".tmp"))
+			Expect(platform.Fs.RenameNewPaths).To(ContainElement(statePath))
+			Expect(platform.Fs.FileExists(statePath + ".tmp")).To(BeFalse())
+		})
+	})
+
+	Context("promote", func() {
+		BeforeEach(func() {
+			specService.Spec.Passive = "enabled"
+		})
+
+		It("promotes drbd, marks leg active and starts jobs", func() {
+			_, err := action.Run("promote")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(platform.Runner.RunCommands).To(ContainElement([]string{"drbdadm", "primary", "r0"}))
+			Expect(specService.Spec.Passive).To(Equal("disabled"))
+			Expect(applier.Configured).To(BeTrue())
+			Expect(jobSupervisor.Started).To(BeTrue())
+		})
+
+		It("refuses to promote while demote is in progress", func() {
+			platform.Fs.WriteFileString(statePath, `{"direction":"demote","completed_steps":["stop_jobs"]}`)
+
+			_, err := action.Run("promote")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Switchover 'demote' is already in progress"))
+		})
+	})
+
+	Describe("Resume", func() {
+		It("continues from first step not yet completed", func() {
+			platform.Fs.WriteFileString(statePath, `{"direction":"promote","completed_steps":["promote_and_mount","mark_active"]}`)
+
+			_, err := action.Resume()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(platform.Runner.RunCommands).To(BeEmpty())
+			Expect(jobSupervisor.Started).To(BeTrue())
+			Expect(platform.Fs.FileExists(statePath)).To(BeFalse())
+		})
+
+		It("does nothing when switchover already finished", func() {
+			_, err := action.Resume()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(jobSupervisor.Started).To(BeFalse())
+			Expect(jobSupervisor.Stopped).To(BeFalse())
+		})
+	})
+
+	It("cannot be cancelled", func() {
+		Expect(action.Cancel()).To(HaveOccurred())
+	})
+})
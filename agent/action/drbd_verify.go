@@ -0,0 +1,46 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type DrbdVerifyAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewDrbdVerify(dualDCSupport *nimbus.DualDCSupport) (action DrbdVerifyAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a DrbdVerifyAction) IsAsynchronous() bool {
+	return true
+}
+
+func (a DrbdVerifyAction) IsPersistent() bool {
+	return false
+}
+
+// Run starts an online verify on the active leg and returns without waiting for it,
+// progress and out-of-sync result are reported under drbd.verify in get_state.
+func (a DrbdVerifyAction) Run() (value interface{}, err error) {
+	verify, err := a.dualDCSupport.StartDrbdVerify()
+	if err != nil {
+		err = bosherr.WrapError(err, "Starting drbd verify")
+		return
+	}
+
+	value = verify
+	return
+}
+
+func (a DrbdVerifyAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a DrbdVerifyAction) Cancel() error {
+	return errors.New("not supported")
+}
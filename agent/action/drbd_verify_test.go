@@ -0,0 +1,63 @@
+package action_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+var _ = Describe("DrbdVerify", func() {
+	var (
+		platform    *fakeplatform.FakePlatform
+		specService *fakeas.FakeV1Service
+		action      DrbdVerifyAction
+	)
+
+	BeforeEach(func() {
+		platform = fakeplatform.NewFakePlatform()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+		dualDCSupport := nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewDrbdVerify(dualDCSupport)
+	})
+
+	It("is asynchronous", func() {
+		Expect(action.IsAsynchronous()).To(BeTrue())
+	})
+
+	It("is not persistent", func() {
+		Expect(action.IsPersistent()).To(BeFalse())
+	})
+
+	It("starts online verify", func() {
+		platform.Fs.WriteFileString("/proc/drbd", " 1: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate A r-----\n")
+
+		value, err := action.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value.(nimbus.DrbdVerifyStatus).State).To(Equal("running"))
+		Expect(platform.Runner.RunCommands).To(Equal([][]string{{"drbdadm", "verify", "r0"}}))
+	})
+
+	It("returns error when verify cannot be started", func() {
+		specService.Spec.Passive = "enabled"
+
+		_, err := action.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Starting drbd verify"))
+	})
+})
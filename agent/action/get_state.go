@@ -5,6 +5,7 @@ import (
 
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
@@ -19,6 +20,7 @@ type GetStateAction struct {
 	vitalsService   boshvitals.Service
 	ntpService      boshntp.Service
 	platform        boshplatform.Platform
+	dualDCSupport   *nimbus.DualDCSupport
 }
 
 func NewGetState(
@@ -28,6 +30,7 @@ func NewGetState(
 	vitalsService boshvitals.Service,
 	ntpService boshntp.Service,
 	platform boshplatform.Platform,
+	dualDCSupport *nimbus.DualDCSupport,
 ) (action GetStateAction) {
 	action.settingsService = settingsService
 	action.specService = specService
@@ -35,6 +38,7 @@ func NewGetState(
 	action.vitalsService = vitalsService
 	action.ntpService = ntpService
 	action.platform = platform
+	action.dualDCSupport = dualDCSupport
 	return
 }
 
@@ -56,14 +60,8 @@ type GetStateV1ApplySpec struct {
 	Processes    []boshjobsuper.Process `json:"processes,omitempty"`
 	VM           boshsettings.VM        `json:"vm"`
 	Ntp          boshntp.Info           `json:"ntp"`
-	Drbd         Drbd                   `json:"drbd"`
-}
-
-type Drbd struct {
-	ConnectionState string `json:"connection_state"`
-	Role            string `json:"role"`
-	DiskState       string `json:"disk_state"`
-	SyncState       string `json:"sync_state"`
+	Drbd         nimbus.DrbdStatus      `json:"drbd"`
+	Nimbus       nimbus.NimbusStatus    `json:"nimbus"`
 }
 
 func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
@@ -90,11 +88,17 @@ func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
 
 	settings := a.settingsService.GetSettings()
 
+	nimbusStatus := a.dualDCSupport.NimbusStatus()
+
+	// only the passive-safe processes run on the passive leg, their state is the job state
 	jobStatus := ""
-	if spec.IsPassiveSide() {
-		jobStatus = "passive"
-	} else {
+	if !spec.IsPassiveSide() {
 		jobStatus = a.jobSupervisor.Status()
+	} else if nimbusStatus.PassiveSafe != nil {
+		jobStatus = nimbusStatus.PassiveSafe.State
+		processes = nimbusStatus.PassiveSafe.Processes
+	} else {
+		jobStatus = "passive"
 	}
 
 	value := GetStateV1ApplySpec{
@@ -107,6 +111,7 @@ func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
 		settings.VM,
 		a.ntpService.GetInfo(),
 		a.DrbdInfo(),
+		nimbusStatus,
 	}
 
 	if value.NetworkSpecs == nil {
@@ -122,21 +127,24 @@ func (a GetStateAction) Run(filters ...string) (GetStateV1ApplySpec, error) {
 	return value, nil
 }
 
-func (a GetStateAction) DrbdInfo() (drbd Drbd) {
-	if a.platform.GetFs().FileExists("/proc/drbd") {
-		stdout, _, _, _ := a.platform.GetRunner().RunCommand("sh", "-c", "drbdadm cstate r0 2>&1")
-		drbd.ConnectionState = stdout
+func (a GetStateAction) DrbdInfo() nimbus.DrbdStatus {
+	drbd, err := a.dualDCSupport.DrbdStatus()
+	if err != nil {
+		// get_state should still be served when drbd cannot be queried
+		drbd.Error = err.Error()
+	}
 
-		stdout, _, _, _ = a.platform.GetRunner().RunCommand("sh", "-c", "drbdadm role r0 2>&1")
-		drbd.Role = stdout
+	drbd.Snapshots, err = a.dualDCSupport.DrbdSnapshots()
+	if err != nil && drbd.Error == "" {
+		drbd.Error = err.Error()
+	}
 
-		stdout, _, _, _ = a.platform.GetRunner().RunCommand("sh", "-c", "drbdadm dstate r0 2>&1")
-		drbd.DiskState = stdout
-	} else {
-		drbd.ConnectionState = "not running"
+	drbd.Config, err = a.dualDCSupport.DrbdConfigStatus()
+	if err != nil && drbd.Error == "" {
+		drbd.Error = err.Error()
 	}
 
-	return
+	return drbd
 }
 
 func (a GetStateAction) Resume() (interface{}, error) {
@@ -11,6 +11,7 @@ import (
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
 	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
 	boshntp "github.com/cloudfoundry/bosh-agent/platform/ntp"
 	fakentp "github.com/cloudfoundry/bosh-agent/platform/ntp/fakes"
@@ -19,6 +20,7 @@ import (
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
 	boshassert "github.com/cloudfoundry/bosh-utils/assert"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
 var _ = Describe("GetState", func() {
@@ -28,6 +30,7 @@ var _ = Describe("GetState", func() {
 		jobSupervisor   *fakejobsuper.FakeJobSupervisor
 		vitalsService   *fakevitals.FakeService
 		platform        *fakeplatform.FakePlatform
+		dualDCSupport   *nimbus.DualDCSupport
 		action          GetStateAction
 	)
 
@@ -43,7 +46,16 @@ var _ = Describe("GetState", func() {
 				Timestamp: "12 Oct 17:37:58",
 			},
 		}
-		action = NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, platform)
+		dualDCSupport = nimbus.NewDualDCSupport(
+			platform.GetRunner(),
+			platform.GetFs(),
+			platform.GetDirProvider(),
+			specService,
+			settingsService,
+			platform.GetDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		action = NewGetState(settingsService, specService, jobSupervisor, vitalsService, ntpService, platform, dualDCSupport)
 	})
 
 	It("get state should be synchronous", func() {
@@ -81,7 +93,7 @@ var _ = Describe("GetState", func() {
 							Offset:    "0.34958",
 							Timestamp: "12 Oct 17:37:58",
 						},
-						Drbd: Drbd{ConnectionState: "not running"},
+						Drbd: nimbus.DrbdStatus{ConnectionState: "not running"},
 					}
 					expectedSpec.Deployment = "fake-deployment"
 
@@ -145,6 +157,107 @@ var _ = Describe("GetState", func() {
 					boshassert.MatchesJSONMap(GinkgoT(), state.VM, expectedVM)
 				})
 
+				It("returns structured drbd status of resource named in spec", func() {
+					minor := 7
+					specService.Spec = boshas.V1ApplySpec{DrbdResourceName: "r7", DrbdMinor: &minor}
+					platform.Fs.WriteFileString("/proc/drbd", `version: 8.4.3 (api:1/proto:86-101)
+
+ 7: cs:SyncSource ro:Primary/Secondary ds:UpToDate/Inconsistent A r-----
+    ns:1048576 nr:0 dw:0 dr:1049264 al:0 bm:64 lo:0 pe:2 ua:0 ap:0 ep:1 wo:f oos:3145728
+	[===>................] sync'ed: 25.0% (3072/4096)M
+	finish: 0:01:20 speed: 13,056 (13,056) K/sec
+`)
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.Drbd).To(Equal(nimbus.DrbdStatus{
+						Resource:        "r7",
+						ConnectionState: "Connected",
+						Role:            "Primary",
+						PeerRole:        "Secondary",
+						DiskState:       "UpToDate",
+						PeerDiskState:   "Inconsistent",
+						SyncState:       "SyncSource",
+						Protocol:        "A",
+						ResyncPercent:   25.0,
+						OutOfSyncKiB:    3145728,
+						ETASeconds:      80,
+						SentKiB:         1048576,
+					}))
+				})
+
+				It("returns dns registration status in nimbus section", func() {
+					specService.Spec = boshas.V1ApplySpec{
+						DNSRegisterOnStart: "app.dc1.example.com",
+						PropertiesSpec: boshas.PropertiesSpec{
+							DNSSpec: boshas.DNSSpec{DNSServers: []string{"10.0.0.53", "10.1.0.53"}},
+						},
+					}
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.Nimbus).To(Equal(nimbus.NimbusStatus{
+						DNS: []nimbus.DNSServerStatus{{Server: "10.0.0.53"}, {Server: "10.1.0.53"}},
+					}))
+					boshassert.MatchesJSONString(GinkgoT(), state.Nimbus,
+						`{"dns":[{"server":"10.0.0.53","consecutive_failures":0},{"server":"10.1.0.53","consecutive_failures":0}]}`)
+				})
+
+				It("returns the count of nimbus alerts that were not delivered", func() {
+					dualDCSupport.AlertFailed()
+					dualDCSupport.AlertFailed()
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.Nimbus.AlertFailures).To(Equal(2))
+				})
+
+				It("returns the status of passive-safe processes on the passive leg", func() {
+					specService.Spec = boshas.V1ApplySpec{
+						Passive:              "enabled",
+						PassiveSafeProcesses: []string{"log-shipper"},
+					}
+					jobSupervisor.ProcessesStatus = []boshjobsuper.Process{
+						{Name: "app", State: "not monitored"},
+						{Name: "log-shipper", State: "running"},
+					}
+					dualDCSupport.SetJobSupervisor(jobSupervisor)
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.JobState).To(Equal("running"))
+					Expect(state.Processes).To(Equal([]boshjobsuper.Process{{Name: "log-shipper", State: "running"}}))
+					Expect(state.Nimbus.PassiveSafe).To(Equal(&nimbus.PassiveSafeStatus{
+						State:     "running",
+						Processes: []boshjobsuper.Process{{Name: "log-shipper", State: "running"}},
+					}))
+				})
+
+				It("returns the job state of failing passive-safe processes on the passive leg", func() {
+					specService.Spec = boshas.V1ApplySpec{
+						Passive:              "enabled",
+						PassiveSafeProcesses: []string{"log-shipper"},
+					}
+					jobSupervisor.ProcessesStatus = []boshjobsuper.Process{
+						{Name: "log-shipper", State: "failing"},
+					}
+					dualDCSupport.SetJobSupervisor(jobSupervisor)
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.JobState).To(Equal("failing"))
+					Expect(state.Processes).To(Equal([]boshjobsuper.Process{{Name: "log-shipper", State: "failing"}}))
+				})
+
+				It("returns passive job state on the passive leg without passive-safe processes", func() {
+					specService.Spec = boshas.V1ApplySpec{Passive: "enabled"}
+					jobSupervisor.StatusStatus = "stopped"
+
+					state, err := action.Run()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(state.JobState).To(Equal("passive"))
+				})
+
 				Describe("non-populated field formatting", func() {
 					It("returns network as empty hash if not set", func() {
 						specService.Spec = boshas.V1ApplySpec{NetworkSpecs: nil}
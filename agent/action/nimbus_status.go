@@ -0,0 +1,43 @@
+package action
+
+import (
+	"errors"
+
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type NimbusStatusAction struct {
+	dualDCSupport *nimbus.DualDCSupport
+}
+
+func NewNimbusStatus(dualDCSupport *nimbus.DualDCSupport) (action NimbusStatusAction) {
+	action.dualDCSupport = dualDCSupport
+	return
+}
+
+func (a NimbusStatusAction) IsAsynchronous() bool {
+	return false
+}
+
+func (a NimbusStatusAction) IsPersistent() bool {
+	return false
+}
+
+func (a NimbusStatusAction) Run() (value nimbus.DualDCStatus, err error) {
+	value, err = a.dualDCSupport.DualDCStatus()
+	if err != nil {
+		err = bosherr.WrapError(err, "Getting dual DC status")
+		return
+	}
+
+	return
+}
+
+func (a NimbusStatusAction) Resume() (interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (a NimbusStatusAction) Cancel() error {
+	return errors.New("not supported")
+}
@@ -55,12 +55,29 @@ func (a StartAction) Run() (value string, err error) {
 		return
 	}
 
-	err = a.jobSupervisor.Start()
+	processes, all, err := a.actionHook.ProcessesToStart()
+	if err != nil {
+		err = bosherr.WrapError(err, "Getting processes to start")
+		return
+	}
+
+	if all {
+		err = a.jobSupervisor.Start()
+	} else {
+		err = a.jobSupervisor.StartProcesses(processes)
+	}
 	if err != nil {
 		err = bosherr.WrapError(err, "Starting Monitored Services")
 		return
 	}
 
+	if all {
+		if err = a.actionHook.OnJobsStarted(); err != nil {
+			err = bosherr.WrapError(err, "calling nimbus on jobs started hook")
+			return
+		}
+	}
+
 	value = "started"
 	return
 }
@@ -6,6 +6,7 @@ import (
 
 	"errors"
 	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	fakeappl "github.com/cloudfoundry/bosh-agent/agent/applier/fakes"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
@@ -42,6 +43,7 @@ func init() {
 				platform.GetDirProvider(),
 				specService,
 				settingsService,
+				platform.GetDevicePathResolver(),
 				logger,
 			)
 			action = NewStart(jobSupervisor, applier, specService, dualDCSupport, platform)
@@ -80,5 +82,29 @@ func init() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("Configuring jobs"))
 		})
+
+		Context("on the passive leg", func() {
+			BeforeEach(func() {
+				specService.Spec = boshas.V1ApplySpec{Passive: "enabled"}
+			})
+
+			It("refuses to start when nothing is passive-safe", func() {
+				_, err := action.Run()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Can not start services in passive mode!"))
+				Expect(jobSupervisor.Started).To(BeFalse())
+			})
+
+			It("starts only the passive-safe processes", func() {
+				specService.Spec.PassiveSafeProcesses = []string{"log-shipper"}
+
+				started, err := action.Run()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(started).To(Equal("started"))
+				Expect(applier.Configured).To(BeTrue())
+				Expect(jobSupervisor.Started).To(BeFalse())
+				Expect(jobSupervisor.StartedProcesses).To(Equal([]string{"log-shipper"}))
+			})
+		})
 	})
 }
@@ -31,14 +31,22 @@ func (a StopAction) IsPersistent() bool {
 }
 
 func (a StopAction) Run() (value string, err error) {
+	// services are stopped even when DNS could not be updated, e.g. with the servers unreachable
+	hookErr := a.actionHook.OnStopAction()
+
 	err = a.jobSupervisor.Stop()
 	if err != nil {
 		err = bosherr.WrapError(err, "Stopping Monitored Services")
 		return
 	}
 
-	if err = a.actionHook.OnStopAction(); err != nil {
-		err = bosherr.WrapError(err, "calling nimbus on stop hook")
+	if hookErr != nil {
+		err = bosherr.WrapError(hookErr, "calling nimbus on stop hook")
+		return
+	}
+
+	if err = a.actionHook.OnJobsStopped(); err != nil {
+		err = bosherr.WrapError(err, "calling nimbus on jobs stopped hook")
 		return
 	}
 
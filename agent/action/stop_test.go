@@ -5,6 +5,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry/bosh-agent/agent/action"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
 	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
@@ -37,6 +38,7 @@ func init() {
 				platform.GetDirProvider(),
 				specService,
 				settingsService,
+				platform.GetDevicePathResolver(),
 				logger,
 			)
 			action = NewStop(jobSupervisor, dualDCSupport, platform)
@@ -61,5 +63,18 @@ func init() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(jobSupervisor.Stopped).To(BeTrue())
 		})
+
+		Context("when the active leg registers itself in dns", func() {
+			BeforeEach(func() {
+				specService.Spec = boshas.V1ApplySpec{Passive: "disabled", DNSRegisterOnStart: "fake-name"}
+			})
+
+			It("still stops the services when the leg could not be deregistered from dns", func() {
+				_, err := action.Run()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Deregistering DNS if required"))
+				Expect(jobSupervisor.Stopped).To(BeTrue())
+			})
+		})
 	})
 }
@@ -9,6 +9,7 @@ import (
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshsyslog "github.com/cloudfoundry/bosh-agent/syslog"
@@ -33,6 +34,7 @@ type Agent struct {
 	settingsService   boshsettings.Service
 	uuidGenerator     boshuuid.Generator
 	timeService       clock.Clock
+	dualDCSupport     *nimbus.DualDCSupport
 }
 
 func New(
@@ -47,6 +49,7 @@ func New(
 	settingsService boshsettings.Service,
 	uuidGenerator boshuuid.Generator,
 	timeService clock.Clock,
+	dualDCSupport *nimbus.DualDCSupport,
 ) Agent {
 	return Agent{
 		logger:            logger,
@@ -60,6 +63,7 @@ func New(
 		settingsService:   settingsService,
 		uuidGenerator:     uuidGenerator,
 		timeService:       timeService,
+		dualDCSupport:     dualDCSupport,
 	}
 }
 
@@ -79,6 +83,20 @@ func (a Agent) Run() error {
 		}
 	}()
 
+	// the nimbus monitors run until the agent stops
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	go a.dualDCSupport.MonitorSplitBrain(a.handleDrbdAlert(), stopCh)
+
+	go a.dualDCSupport.MonitorDrbdHealth(a.handleDrbdAlert(), stopCh)
+
+	go a.dualDCSupport.MonitorDrbdVerify(a.handleDrbdAlert(), stopCh)
+
+	go a.dualDCSupport.MonitorDNSRegistration(a.handleNimbusAlert(), stopCh)
+
+	go a.dualDCSupport.MonitorPeer(a.handleNimbusAlert(), stopCh)
+
 	go func() {
 		err := a.syslogServer.Start(a.handleSyslogMsg(errCh))
 		if err != nil {
@@ -130,9 +148,19 @@ func (a Agent) sendHeartbeat(errCh chan error) {
 
 	err = a.mbusHandler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, heartbeat)
 	if err != nil {
-		err = bosherr.WrapError(err, "Sending heartbeat")
-		errCh <- err
+		a.reportSendErr(errCh, err, "Sending heartbeat")
+	}
+}
+
+// reportSendErr stops the agent unless the message bus is reconnecting,
+// the handler then counts the dropped message in its status
+func (a Agent) reportSendErr(errCh chan error, err error, description string) {
+	if err == boshhandler.ErrNotConnected {
+		a.logger.Warn(agentLogTag, "%s: %s", description, err)
+		return
 	}
+
+	errCh <- bosherr.WrapError(err, description)
 }
 
 func (a Agent) getHeartbeat() (Heartbeat, error) {
@@ -179,13 +207,66 @@ func (a Agent) handleJobFailure(errCh chan error) boshjobsuper.JobFailureHandler
 
 		err = a.mbusHandler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert)
 		if err != nil {
-			errCh <- bosherr.WrapError(err, "Sending monit alert")
+			a.reportSendErr(errCh, err, "Sending monit alert")
+		}
+
+		return nil
+	}
+}
+
+func (a Agent) handleDrbdAlert() nimbus.DrbdAlertHandler {
+	return func(drbdAlert boshalert.DrbdAlert) error {
+		alertAdapter := boshalert.NewDrbdAdapter(drbdAlert, a.settingsService, a.uuidGenerator, a.timeService)
+		if alertAdapter.IsIgnorable() {
+			a.logger.Debug(agentLogTag, "Ignored drbd event: ", drbdAlert.Event)
+			return nil
+		}
+
+		alert, err := alertAdapter.Alert()
+		if err != nil {
+			return a.reportAlertErr(err, "Adapting drbd alert")
+		}
+
+		err = a.mbusHandler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert)
+		if err != nil {
+			return a.reportAlertErr(err, "Sending drbd alert")
 		}
 
 		return nil
 	}
 }
 
+func (a Agent) handleNimbusAlert() nimbus.NimbusAlertHandler {
+	return func(nimbusAlert boshalert.NimbusAlert) error {
+		alertAdapter := boshalert.NewNimbusAdapter(nimbusAlert, a.settingsService, a.uuidGenerator, a.timeService)
+		if alertAdapter.IsIgnorable() {
+			a.logger.Debug(agentLogTag, "Ignored %s event: %s", nimbusAlert.Component, nimbusAlert.Event)
+			return nil
+		}
+
+		alert, err := alertAdapter.Alert()
+		if err != nil {
+			return a.reportAlertErr(err, "Adapting "+nimbusAlert.Component+" alert")
+		}
+
+		err = a.mbusHandler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert)
+		if err != nil {
+			return a.reportAlertErr(err, "Sending "+nimbusAlert.Component+" alert")
+		}
+
+		return nil
+	}
+}
+
+// reportAlertErr logs and counts an alert of the nimbus monitors that was not delivered,
+// the monitor reports it again on its next check so the agent keeps running
+func (a Agent) reportAlertErr(err error, description string) error {
+	err = bosherr.WrapError(err, description)
+	failures := a.dualDCSupport.AlertFailed()
+	a.logger.Error(agentLogTag, "%s (%d nimbus alerts failed so far)", err, failures)
+	return err
+}
+
 func (a Agent) handleSyslogMsg(errCh chan error) boshsyslog.CallbackFunc {
 	return func(msg boshsyslog.Msg) {
 		alertAdapter := boshalert.NewSSHAdapter(
@@ -207,7 +288,7 @@ func (a Agent) handleSyslogMsg(errCh chan error) boshsyslog.CallbackFunc {
 
 		err = a.mbusHandler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert)
 		if err != nil {
-			errCh <- bosherr.WrapError(err, "Sending SSH alert")
+			a.reportSendErr(errCh, err, "Sending SSH alert")
 		}
 	}
 }
@@ -16,6 +16,7 @@ import (
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
 	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
+	nimbus "github.com/cloudfoundry/bosh-agent/nimbus"
 	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
 	boshvitals "github.com/cloudfoundry/bosh-agent/platform/vitals"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
@@ -39,6 +40,7 @@ func init() {
 			settingsService  *fakesettings.FakeSettingsService
 			uuidGenerator    *fakeuuid.FakeGenerator
 			timeService      *fakeclock.FakeClock
+			dualDCSupport    *nimbus.DualDCSupport
 			agent            Agent
 		)
 
@@ -53,6 +55,15 @@ func init() {
 			settingsService = &fakesettings.FakeSettingsService{}
 			uuidGenerator = &fakeuuid.FakeGenerator{}
 			timeService = fakeclock.NewFakeClock(time.Now())
+			dualDCSupport = nimbus.NewDualDCSupport(
+				platform.GetRunner(),
+				platform.GetFs(),
+				platform.GetDirProvider(),
+				specService,
+				settingsService,
+				platform.GetDevicePathResolver(),
+				logger,
+			)
 			agent = New(
 				logger,
 				handler,
@@ -65,6 +76,7 @@ func init() {
 				settingsService,
 				uuidGenerator,
 				timeService,
+				dualDCSupport,
 			)
 		})
 
@@ -142,6 +154,7 @@ func init() {
 						settingsService,
 						uuidGenerator,
 						timeService,
+						dualDCSupport,
 					)
 
 					// Immediately exit after sending initial heartbeat
@@ -191,6 +204,23 @@ func init() {
 						},
 					}))
 				})
+
+				It("keeps running while the message bus is not connected", func() {
+					handler.SendErr = boshhandler.ErrNotConnected
+
+					sentRequests := 0
+					handler.SendCallback = func(_ fakembus.SendInput) {
+						sentRequests++
+						if sentRequests == 3 {
+							handler.SendErr = errors.New("stop")
+						}
+					}
+
+					err := agent.Run()
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("stop"))
+					Expect(handler.SendInputs()).To(HaveLen(3))
+				})
 			})
 
 			Context("when the agent fails to get job spec for a heartbeat", func() {
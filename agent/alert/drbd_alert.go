@@ -0,0 +1,8 @@
+package alert
+
+type DrbdAlert struct {
+	Resource string
+	Event    string
+	Severity SeverityLevel
+	Summary  string
+}
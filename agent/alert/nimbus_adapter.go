@@ -0,0 +1,83 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshuuid "github.com/cloudfoundry/bosh-utils/uuid"
+	"github.com/pivotal-golang/clock"
+)
+
+type nimbusAdapter struct {
+	nimbusAlert     NimbusAlert
+	settingsService boshsettings.Service
+	uuidGenerator   boshuuid.Generator
+	timeService     clock.Clock
+}
+
+func NewNimbusAdapter(
+	nimbusAlert NimbusAlert,
+	settingsService boshsettings.Service,
+	uuidGenerator boshuuid.Generator,
+	timeService clock.Clock,
+) Adapter {
+	return &nimbusAdapter{
+		nimbusAlert:     nimbusAlert,
+		settingsService: settingsService,
+		uuidGenerator:   uuidGenerator,
+		timeService:     timeService,
+	}
+}
+
+func NewDrbdAdapter(
+	drbdAlert DrbdAlert,
+	settingsService boshsettings.Service,
+	uuidGenerator boshuuid.Generator,
+	timeService clock.Clock,
+) Adapter {
+	nimbusAlert := NimbusAlert{
+		Component: "drbd",
+		Resource:  drbdAlert.Resource,
+		Event:     drbdAlert.Event,
+		Severity:  drbdAlert.Severity,
+		Summary:   drbdAlert.Summary,
+	}
+	return NewNimbusAdapter(nimbusAlert, settingsService, uuidGenerator, timeService)
+}
+
+func (m *nimbusAdapter) IsIgnorable() bool {
+	return m.nimbusAlert.Severity == SeverityIgnored
+}
+
+func (m *nimbusAdapter) Alert() (Alert, error) {
+	uuid, err := m.uuidGenerator.Generate()
+	if err != nil {
+		return Alert{}, bosherr.WrapError(err, "Generating uuid")
+	}
+
+	return Alert{
+		ID:        uuid,
+		Severity:  m.nimbusAlert.Severity,
+		Title:     m.title(),
+		Summary:   m.nimbusAlert.Summary,
+		CreatedAt: m.timeService.Now().Unix(),
+	}, nil
+}
+
+func (m *nimbusAdapter) title() string {
+	settings := m.settingsService.GetSettings()
+
+	ips := settings.Networks.IPs()
+	sort.Strings(ips)
+
+	resource := m.nimbusAlert.Component + " " + m.nimbusAlert.Resource
+
+	if len(ips) > 0 {
+		resource = fmt.Sprintf("%s (%s)", resource, strings.Join(ips, ", "))
+	}
+
+	return fmt.Sprintf("%s - %s", resource, m.nimbusAlert.Event)
+}
@@ -0,0 +1,94 @@
+package alert_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry/bosh-agent/agent/alert"
+
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	fakeuuid "github.com/cloudfoundry/bosh-utils/uuid/fakes"
+	"github.com/pivotal-golang/clock/fakeclock"
+)
+
+var _ = Describe("nimbusAdapter", func() {
+	var (
+		settingsService *fakesettings.FakeSettingsService
+		timeService     *fakeclock.FakeClock
+		uuidGenerator   *fakeuuid.FakeGenerator
+		drbdAlert       DrbdAlert
+	)
+
+	BeforeEach(func() {
+		settingsService = &fakesettings.FakeSettingsService{}
+		timeService = fakeclock.NewFakeClock(time.Now())
+		uuidGenerator = &fakeuuid.FakeGenerator{GeneratedUUID: "fake-uuid"}
+		drbdAlert = DrbdAlert{
+			Resource: "r0",
+			Event:    "split brain",
+			Severity: SeverityCritical,
+			Summary:  "fake-summary",
+		}
+	})
+
+	Describe("IsIgnorable", func() {
+		It("ignores alerts with ignored severity", func() {
+			drbdAlert.Severity = SeverityIgnored
+			adapter := NewDrbdAdapter(drbdAlert, settingsService, uuidGenerator, timeService)
+			Expect(adapter.IsIgnorable()).To(BeTrue())
+		})
+
+		It("does not ignore critical alerts", func() {
+			adapter := NewDrbdAdapter(drbdAlert, settingsService, uuidGenerator, timeService)
+			Expect(adapter.IsIgnorable()).To(BeFalse())
+		})
+	})
+
+	Describe("Alert", func() {
+		It("builds alert with resource and ips in title", func() {
+			settingsService.Settings.Networks = boshsettings.Networks{
+				"fake-net1": boshsettings.Network{IP: "192.168.0.1"},
+				"fake-net2": boshsettings.Network{IP: "10.0.0.1"},
+			}
+
+			adapter := NewDrbdAdapter(drbdAlert, settingsService, uuidGenerator, timeService)
+			alert, err := adapter.Alert()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alert).To(Equal(Alert{
+				ID:        "fake-uuid",
+				Severity:  SeverityCritical,
+				Title:     "drbd r0 (10.0.0.1, 192.168.0.1) - split brain",
+				Summary:   "fake-summary",
+				CreatedAt: timeService.Now().Unix(),
+			}))
+		})
+
+		It("uses the component of a nimbus alert in title", func() {
+			nimbusAlert := NimbusAlert{
+				Component: "dns",
+				Resource:  "10.0.0.53:53",
+				Event:     "dns registration failing",
+				Severity:  SeverityCritical,
+				Summary:   "fake-summary",
+			}
+
+			adapter := NewNimbusAdapter(nimbusAlert, settingsService, uuidGenerator, timeService)
+			alert, err := adapter.Alert()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alert.Title).To(Equal("dns 10.0.0.53:53 - dns registration failing"))
+		})
+
+		It("returns error when uuid cannot be generated", func() {
+			uuidGenerator.GenerateError = errors.New("fake-uuid-error")
+
+			adapter := NewDrbdAdapter(drbdAlert, settingsService, uuidGenerator, timeService)
+			_, err := adapter.Alert()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-uuid-error"))
+		})
+	})
+})
@@ -0,0 +1,10 @@
+package alert
+
+// NimbusAlert is raised by a dual DC component other than drbd, e.g. dns or peer
+type NimbusAlert struct {
+	Component string
+	Resource  string
+	Event     string
+	Severity  SeverityLevel
+	Summary   string
+}
@@ -1,11 +1,16 @@
 package fakes
 
 import (
+	"sync"
+
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 )
 
 type FakeV1Service struct {
+	// Get is called concurrently by the monitors the agent runs
+	lock sync.Mutex
+
 	ActionsCalled []string
 
 	Spec   boshas.V1ApplySpec
@@ -23,17 +28,26 @@ func NewFakeV1Service() *FakeV1Service {
 }
 
 func (s *FakeV1Service) Get() (boshas.V1ApplySpec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.ActionsCalled = append(s.ActionsCalled, "Get")
 	return s.Spec, s.GetErr
 }
 
 func (s *FakeV1Service) Set(spec boshas.V1ApplySpec) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.ActionsCalled = append(s.ActionsCalled, "Set")
 	s.Spec = spec
 	return s.SetErr
 }
 
 func (s *FakeV1Service) PopulateDHCPNetworks(spec boshas.V1ApplySpec, settings boshsettings.Settings) (boshas.V1ApplySpec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.ActionsCalled = append(s.ActionsCalled, "PopulateDHCPNetworks")
 	s.PopulateDHCPNetworksSpec = spec
 	s.PopulateDHCPNetworksSettings = settings
@@ -34,9 +34,78 @@ type V1ApplySpec struct {
 	DrbdReplicationType  string `json:"drbd_replication_type"`  // (A|B|C)
 	DrbdSecret           string `json:"drbd_secret"`
 	DNSRegisterOnStart   string `json:"dns_register_on_start"`
+
+	// Networks whose IPs are used for replication and DNS registration,
+	// the default gateway network is used when not set
+	DrbdReplicationNetwork string `json:"drbd_replication_network"`
+	DNSRegisterNetwork     string `json:"dns_register_network"`
+
+	// DRBD resource layout, defaults are applied by nimbus when not set
+	DrbdResourceName      string `json:"drbd_resource_name"`       // r0
+	DrbdMinor             *int   `json:"drbd_minor"`               // 1 -> /dev/drbd1, 0 is a valid minor
+	DrbdPort              int    `json:"drbd_port"`                // 7789
+	DrbdVolumeGroup       string `json:"drbd_volume_group"`        // vgStoreData
+	DrbdLogicalVolume     string `json:"drbd_logical_volume"`      // StoreData
+	DrbdLogicalVolumeSize string `json:"drbd_logical_volume_size"` // 40%FREE (extents) or 10G (size)
+	DrbdSnapshotSize      string `json:"drbd_snapshot_size"`       // 50%FREE (extents) or 5G (size)
+
+	// DRBD 9 multi-peer replication, replaces drbd_replication_node1/2 when set.
+	// Node ids follow the order of the list.
+	DrbdReplicationNodes []DrbdNodeSpec `json:"drbd_replication_nodes"`
+	DrbdQuorum           string         `json:"drbd_quorum"`       // majority (default)|all|<number of nodes>
+	DrbdOnNoQuorum       string         `json:"drbd_on_no_quorum"` // suspend-io (default)|io-error
+
+	DrbdSplitBrainPolicy string `json:"drbd_split_brain_policy"` // manual|discard-younger-primary|discard-least-changes|discard-secondary
+
+	// Replication tuning, changes are applied online with drbdadm adjust
+	DrbdResyncRate     string `json:"drbd_resync_rate"`     // 24M (default)
+	DrbdOnCongestion   string `json:"drbd_on_congestion"`   // block (default)|pull-ahead|disconnect, protocol A only
+	DrbdCongestionFill string `json:"drbd_congestion_fill"` // e.g. 2G, with pull-ahead|disconnect
+
+	// Online verify every interval (Go duration, e.g. 168h) on the active leg,
+	// optionally reconnecting afterwards to resync blocks found out of sync
+	DrbdVerifyInterval string `json:"drbd_verify_interval"`
+	DrbdVerifyResync   bool   `json:"drbd_verify_resync"`
+
+	// Severity per drbd health event, e.g. {"resync_started": "ignored"}
+	DrbdAlertSeverity map[string]string `json:"drbd_alert_severity"` // critical|alert|error|warning|ignored
+
+	// Jobs (all their monit processes) and single monit processes
+	// started on the passive leg as well
+	PassiveSafeJobs      []string `json:"passive_safe_jobs"`
+	PassiveSafeProcesses []string `json:"passive_safe_processes"`
+
+	// Heartbeat between the agents of both legs, off when peer.port is not set
+	Peer PeerSpec `json:"peer"`
 	// Nimbus stuff - end
 }
 
+type DrbdNodeSpec struct {
+	Name    string `json:"name"`    // host name of the node as reported by uname -n
+	Address string `json:"address"` // replication ip
+}
+
+type PeerSpec struct {
+	Address  string `json:"address"`  // ip of the other leg, the other drbd replication node when not set
+	Port     int    `json:"port"`     // listened on by both legs
+	Secret   string `json:"secret"`   // shared by both legs, authenticates every heartbeat
+	Interval int    `json:"interval"` // seconds between heartbeats, 5 when not set
+
+	FailoverPolicy string `json:"failover_policy"` // manual (default)|alert-only|auto-promote-after
+	GracePeriod    int    `json:"grace_period"`    // seconds the active peer must be unreachable before promoting, 60 when not set
+
+	// host:port of the witnesses granting the lease of the pair to one leg at a time,
+	// the passive leg promotes itself only with the lease and the active leg fences itself
+	// when it loses the lease. Required by auto-promote-after.
+	Witnesses     []string `json:"witnesses"`
+	Lease         string   `json:"lease"`          // name of the lease at the witnesses, <deployment>/<drbd resource> when not set
+	LeaseDuration int      `json:"lease_duration"` // seconds a witness grants the lease for, 30 when not set
+	FenceTimeout  int      `json:"fence_timeout"`  // seconds the active leg has to fence itself once its lease expired, 30 when not set
+
+	// Severity per peer event, e.g. {"peer_recovered": "ignored"}
+	AlertSeverity map[string]string `json:"alert_severity"` // critical|alert|error|warning|ignored
+}
+
 type PropertiesSpec struct {
 	LoggingSpec LoggingSpec `json:"logging"`
 	DNSSpec     DNSSpec     `json:"dns"`
@@ -50,6 +119,34 @@ type DNSSpec struct {
 	DNSServers []string `json:"dnsservers"`
 	Key        string   `json:"key"`
 	TTL        int      `json:"ttl"`
+
+	// consecutive failed registrations with a server before alerting, 3 when not set
+	FailureThreshold int `json:"failure_threshold"`
+
+	// register only while these checks pass, always registered when none is set
+	HealthCheck DNSHealthCheckSpec `json:"health_check"`
+
+	// registered in addition to the A record of dns_register_on_start
+	Records []DNSRecordSpec `json:"records"`
+
+	// Severity per dns registration event, e.g. {"dns_registration_recovered": "ignored"}
+	AlertSeverity map[string]string `json:"alert_severity"` // critical|alert|error|warning|ignored
+}
+
+type DNSRecordSpec struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`    // A (default)|AAAA|TXT|SRV
+	TTL     int    `json:"ttl"`     // dns ttl when not set
+	Network string `json:"network"` // network providing {{ .IP }}, dns_register_network when not set
+	Value   string `json:"value"`   // template with .Name .IP .Deployment .Job .Index .ID, {{ .IP }} for A when not set, required for AAAA
+}
+
+type DNSHealthCheckSpec struct {
+	Jobs          bool     `json:"jobs"`           // job supervisor reports running
+	HTTP          []string `json:"http"`           // urls answering 2xx/3xx
+	TCP           []string `json:"tcp"`            // host:port accepting connections
+	Timeout       int      `json:"timeout"`        // seconds per probe, 5 when not set
+	WithdrawAfter int      `json:"withdraw_after"` // failed checks in a row before the record is withdrawn, 3 when not set
 }
 
 const (
@@ -21,7 +21,18 @@ var _ = Describe("V1ApplySpec", func() {
 					"dns": {
 						"dnsservers": ["10.76.54.8", "10.92.54.8"],
 						"key": "dns_key",
-						"ttl": 30
+						"ttl": 30,
+						"failure_threshold": 5,
+						"health_check": {
+							"jobs": true,
+							"http": ["http://127.0.0.1:8080/health"],
+							"tcp": ["127.0.0.1:5432"],
+							"timeout": 2,
+							"withdraw_after": 4
+						},
+						"records": [
+							{"name": "_router._tcp.example.com", "type": "SRV", "ttl": 60, "network": "public", "value": "10 5 443 router.example.com."}
+						]
 					}
 				},
 				"job": {
@@ -79,7 +90,27 @@ var _ = Describe("V1ApplySpec", func() {
 				"drbd_replication_node2": "10.92.245.71",
 				"drbd_replication_type": "A",
 				"drbd_secret": "secret_value",
-				"dns_register_on_start": "cf-nats.dev-paas.bskyb.com"
+				"dns_register_on_start": "cf-nats.dev-paas.bskyb.com",
+				"drbd_replication_network": "replication",
+				"dns_register_network": "public",
+				"drbd_resource_name": "r1",
+				"drbd_minor": 2,
+				"drbd_port": 7790,
+				"drbd_volume_group": "vgStoreData",
+				"drbd_logical_volume": "StoreData",
+				"drbd_logical_volume_size": "40%FREE",
+				"drbd_resync_rate": "100M",
+				"drbd_on_congestion": "pull-ahead",
+				"drbd_congestion_fill": "2G",
+				"passive_safe_jobs": ["metrics"],
+				"passive_safe_processes": ["log-shipper"],
+				"peer": {
+					"port": 7800,
+					"secret": "peer-secret",
+					"failover_policy": "auto-promote-after",
+					"grace_period": 120,
+					"witnesses": ["10.76.0.1:53"]
+				}
 
 			}`
 
@@ -111,15 +142,27 @@ var _ = Describe("V1ApplySpec", func() {
 				},
 			}
 			expectedIndex := 4
+			expectedDrbdMinor := 2
 			expectedSpec := V1ApplySpec{
 				Index:  &expectedIndex,
 				NodeID: "node-id",
 				PropertiesSpec: PropertiesSpec{
 					LoggingSpec: LoggingSpec{MaxLogFileSize: "10M"},
 					DNSSpec: DNSSpec{
-						DNSServers: []string{"10.76.54.8", "10.92.54.8"},
-						Key:        "dns_key",
-						TTL:        30,
+						DNSServers:       []string{"10.76.54.8", "10.92.54.8"},
+						Key:              "dns_key",
+						TTL:              30,
+						FailureThreshold: 5,
+						HealthCheck: DNSHealthCheckSpec{
+							Jobs:          true,
+							HTTP:          []string{"http://127.0.0.1:8080/health"},
+							TCP:           []string{"127.0.0.1:5432"},
+							Timeout:       2,
+							WithdrawAfter: 4,
+						},
+						Records: []DNSRecordSpec{
+							{Name: "_router._tcp.example.com", Type: "SRV", TTL: 60, Network: "public", Value: "10 5 443 router.example.com."},
+						},
 					},
 				},
 				JobSpec: JobSpec{
@@ -152,6 +195,31 @@ var _ = Describe("V1ApplySpec", func() {
 				DrbdReplicationType:  "A",
 				DrbdSecret:           "secret_value",
 				DNSRegisterOnStart:   "cf-nats.dev-paas.bskyb.com",
+
+				DrbdReplicationNetwork: "replication",
+				DNSRegisterNetwork:     "public",
+
+				DrbdResourceName:      "r1",
+				DrbdMinor:             &expectedDrbdMinor,
+				DrbdPort:              7790,
+				DrbdVolumeGroup:       "vgStoreData",
+				DrbdLogicalVolume:     "StoreData",
+				DrbdLogicalVolumeSize: "40%FREE",
+
+				DrbdResyncRate:     "100M",
+				DrbdOnCongestion:   "pull-ahead",
+				DrbdCongestionFill: "2G",
+
+				PassiveSafeJobs:      []string{"metrics"},
+				PassiveSafeProcesses: []string{"log-shipper"},
+
+				Peer: PeerSpec{
+					Port:           7800,
+					Secret:         "peer-secret",
+					FailoverPolicy: "auto-promote-after",
+					GracePeriod:    120,
+					Witnesses:      []string{"10.76.0.1:53"},
+				},
 				// Nimbus stuff - end
 
 			}
@@ -19,6 +19,7 @@ import (
 	boshcomp "github.com/cloudfoundry/bosh-agent/agent/compiler"
 	boshscript "github.com/cloudfoundry/bosh-agent/agent/script"
 	boshtask "github.com/cloudfoundry/bosh-agent/agent/task"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
 	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshmonit "github.com/cloudfoundry/bosh-agent/jobsupervisor/monit"
@@ -106,6 +107,7 @@ func (app *app) Setup(args []string) error {
 		app.dirProvider,
 		boshas.NewConcreteV1Service(app.platform.GetFs(), filepath.Join(app.dirProvider.BoshDir(), "spec.json")),
 		settingsService,
+		app.platform.GetDevicePathResolver(),
 		app.logger,
 	)
 
@@ -124,7 +126,7 @@ func (app *app) Setup(args []string) error {
 		return bosherr.WrapError(err, "Running bootstrap")
 	}
 
-	mbusHandlerProvider := boshmbus.NewHandlerProvider(settingsService, app.logger)
+	mbusHandlerProvider := boshmbus.NewHandlerProvider(settingsService, config.Mbus, app.logger)
 
 	mbusHandler, err := mbusHandlerProvider.Get(app.platform, app.dirProvider)
 	if err != nil {
@@ -159,6 +161,12 @@ func (app *app) Setup(args []string) error {
 		return bosherr.WrapError(err, "Getting job supervisor")
 	}
 
+	app.dualDCSupport.SetJobSupervisor(jobSupervisor)
+
+	if mbusStatus, ok := mbusHandler.(boshhandler.StatusProvider); ok {
+		app.dualDCSupport.SetMbusStatusProvider(mbusStatus)
+	}
+
 	notifier := boshnotif.NewNotifier(mbusHandler)
 
 	applier, compiler := app.buildApplierAndCompiler(app.dirProvider, blobstore, jobSupervisor)
@@ -204,6 +212,21 @@ func (app *app) Setup(args []string) error {
 		app.logger,
 	)
 
+	// automatic failover promotes through the same resumable steps as drbd_switchover
+	peerSwitchover := boshaction.NewDrbdSwitchover(
+		jobSupervisor,
+		applier,
+		specService,
+		app.dualDCSupport,
+		app.platform.GetFs(),
+		app.dirProvider,
+		app.logger,
+	)
+	app.dualDCSupport.SetPeerPromoter(func() error {
+		_, err := peerSwitchover.Run(boshaction.DrbdSwitchoverPromote)
+		return err
+	})
+
 	actionRunner := boshaction.NewRunner()
 
 	actionDispatcher := boshagent.NewActionDispatcher(
@@ -228,6 +251,7 @@ func (app *app) Setup(args []string) error {
 		settingsService,
 		uuidGen,
 		timeService,
+		app.dualDCSupport,
 	)
 
 	return nil
@@ -235,6 +259,11 @@ func (app *app) Setup(args []string) error {
 
 func (app *app) Run() error {
 
+	// an interrupted cutover must not keep the agent from starting, get_state reports it
+	if err := app.dualDCSupport.ReconcileCutover(); err != nil {
+		app.logger.Error(app.logTag, "Reconciling drbd cutover: %s", err)
+	}
+
 	err := app.dualDCSupport.StartDNSUpdatesIfRequired()
 	if err != nil {
 		return bosherr.WrapError(err, "Starting DNS updates when required")
@@ -244,6 +273,11 @@ func (app *app) Run() error {
 	if err != nil {
 		return bosherr.WrapError(err, "Running agent")
 	}
+
+	err = app.dualDCSupport.DeregisterDNSIfRequired()
+	if err != nil {
+		return bosherr.WrapError(err, "Deregistering DNS on shutdown")
+	}
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	boshsys "github.com/cloudfoundry/bosh-utils/system"
@@ -12,6 +13,7 @@ import (
 type Config struct {
 	Platform       boshplatform.Options
 	Infrastructure boshinf.Options
+	Mbus           boshmbus.Options
 }
 
 func LoadConfigFromPath(fs boshsys.FileSystem, path string) (Config, error) {
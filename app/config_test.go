@@ -4,7 +4,9 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	boshdispatcher "github.com/cloudfoundry/bosh-agent/httpsdispatcher"
 	boshinf "github.com/cloudfoundry/bosh-agent/infrastructure"
+	boshmbus "github.com/cloudfoundry/bosh-agent/mbus"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
 )
@@ -56,6 +58,14 @@ var _ = Describe("LoadConfigFromPath", func() {
 				  "UseServerName": true,
 				  "UseRegistry": true
 				}
+			},
+			"Mbus": {
+				"HTTPS": {
+					"CertPath": "/fake-cert-path",
+					"KeyPath": "/fake-key-path",
+					"MinTLSVersion": "1.2",
+					"CipherSuites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"]
+				}
 			}
 		}`)
 
@@ -95,6 +105,14 @@ var _ = Describe("LoadConfigFromPath", func() {
 					UseRegistry:   true,
 				},
 			},
+			Mbus: boshmbus.Options{
+				HTTPS: boshdispatcher.Options{
+					CertPath:      "/fake-cert-path",
+					KeyPath:       "/fake-key-path",
+					MinTLSVersion: "1.2",
+					CipherSuites:  []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				},
+			},
 		}))
 	})
 
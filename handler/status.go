@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotConnected is returned by Send while the message bus is reconnecting,
+// the message is not sent and counted in the status of the handler
+var ErrNotConnected = errors.New("Not connected to the message bus")
+
+// MbusStatus is the connection state of a message bus handler
+type MbusStatus struct {
+	URL               string     `json:"url"` // without credentials
+	Connected         bool       `json:"connected"`
+	ConnectedSince    *time.Time `json:"connected_since,omitempty"`
+	DisconnectedSince *time.Time `json:"disconnected_since,omitempty"`
+	Reconnects        int        `json:"reconnects"`
+	LastError         string     `json:"last_error,omitempty"`
+	FailedSends       int        `json:"failed_sends"`
+	LastFailedSend    string     `json:"last_failed_send,omitempty"`
+
+	Outbox *OutboxStatus `json:"outbox,omitempty"`
+}
+
+// OutboxStatus is the state of the messages queued for delivery
+type OutboxStatus struct {
+	Queued  int        `json:"queued"`
+	Oldest  *time.Time `json:"oldest,omitempty"`
+	Dropped int        `json:"dropped"`
+}
+
+// StatusProvider is implemented by handlers that keep a connection to the message bus
+type StatusProvider interface {
+	MbusStatus() MbusStatus
+}
@@ -0,0 +1,86 @@
+package httpsdispatcher
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+// certificateReloader loads the key pair again once the modification time of
+// either file changes. The previous key pair is kept when loading fails.
+type certificateReloader struct {
+	certPath string
+	keyPath  string
+	logger   boshlog.Logger
+	logTag   string
+
+	lock        sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertificateReloader(certPath, keyPath string, logger boshlog.Logger) *certificateReloader {
+	return &certificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		logger:   logger,
+		logTag:   "certificateReloader",
+	}
+}
+
+func (r *certificateReloader) load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.reload()
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := r.reload()
+	if err != nil {
+		if r.certificate == nil {
+			return nil, err
+		}
+		r.logger.Error(r.logTag, "Keeping previous agent SSL cert: %s", err.Error())
+	}
+
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) reload() error {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return bosherr.WrapError(err, "Checking agent SSL cert")
+	}
+
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return bosherr.WrapError(err, "Checking agent SSL key")
+	}
+
+	if r.certModTime.Equal(certInfo.ModTime()) && r.keyModTime.Equal(keyInfo.ModTime()) {
+		return nil
+	}
+
+	// Files that fail to load are only retried once they change again
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return bosherr.WrapError(err, "Loading agent SSL cert")
+	}
+
+	r.certificate = &cert
+	r.logger.Info(r.logTag, "Loaded agent SSL cert from %s", r.certPath)
+
+	return nil
+}
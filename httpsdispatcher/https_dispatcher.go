@@ -14,6 +14,8 @@ type HTTPSDispatcher struct {
 	httpServer *http.Server
 	mux        *http.ServeMux
 	host       string
+	certPath   string
+	keyPath    string
 	listener   net.Listener
 	logger     boshlog.Logger
 }
@@ -21,24 +23,28 @@ type HTTPSDispatcher struct {
 type HTTPHandlerFunc func(writer http.ResponseWriter, request *http.Request)
 
 func NewHTTPSDispatcher(baseURL *url.URL, logger boshlog.Logger) *HTTPSDispatcher {
-	tlsConfig := &tls.Config{
-		// SSLv3 is insecure due to BEAST and POODLE attacks
-		MinVersion: tls.VersionTLS10,
-		// Both 3DES & RC4 ciphers can be exploited
-		// Using Mozilla's "Modern" recommended settings (where they overlap with golang support)
-		CipherSuites: []uint16{
-			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
-		PreferServerCipherSuites: true,
+	// Default options are always valid
+	dispatcher, _ := NewHTTPSDispatcherWithOptions(baseURL, Options{}, logger)
+	return dispatcher
+}
+
+func NewHTTPSDispatcherWithOptions(baseURL *url.URL, options Options, logger boshlog.Logger) (*HTTPSDispatcher, error) {
+	tlsConfig, err := options.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := NewHTTPSDispatcherWithConfig(tlsConfig, baseURL, logger)
+
+	if options.CertPath != "" {
+		dispatcher.certPath = options.CertPath
+	}
+
+	if options.KeyPath != "" {
+		dispatcher.keyPath = options.KeyPath
 	}
-	return NewHTTPSDispatcherWithConfig(tlsConfig, baseURL, logger)
+
+	return dispatcher, nil
 }
 
 func NewHTTPSDispatcherWithConfig(tlsConfig *tls.Config, baseURL *url.URL, logger boshlog.Logger) *HTTPSDispatcher {
@@ -52,6 +58,8 @@ func NewHTTPSDispatcherWithConfig(tlsConfig *tls.Config, baseURL *url.URL, logge
 		httpServer: httpServer,
 		mux:        mux,
 		host:       baseURL.Host,
+		certPath:   defaultCertPath,
+		keyPath:    defaultKeyPath,
 		logger:     logger,
 	}
 }
@@ -63,15 +71,18 @@ func (h *HTTPSDispatcher) Start() error {
 	}
 	h.listener = tcpListener
 
-	cert, err := tls.LoadX509KeyPair("agent.cert", "agent.key")
+	// cert is loaded again on the next handshake after the files change
+	certificate := newCertificateReloader(h.certPath, h.keyPath, h.logger)
+
+	err = certificate.load()
 	if err != nil {
-		return bosherr.WrapError(err, "Loading agent SSL cert")
+		return err
 	}
 
 	// update the server config with the cert
 	config := h.httpServer.TLSConfig
 	config.NextProtos = []string{"http/1.1"}
-	config.Certificates = []tls.Certificate{cert}
+	config.GetCertificate = certificate.getCertificate
 
 	tlsListener := tls.NewListener(tcpListener, config)
 
@@ -1,9 +1,19 @@
 package httpsdispatcher_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -169,6 +179,246 @@ var _ = Describe("HTTPSDispatcher", func() {
 	})
 })
 
+var _ = Describe("HTTPSDispatcher with options", func() {
+	const serverURL = "https://127.0.0.1:7789"
+
+	var (
+		logger     boshlog.Logger
+		options    boshdispatcher.Options
+		dispatcher *boshdispatcher.HTTPSDispatcher
+		certDir    string
+		ca         testCert
+		clientCert testCert
+	)
+
+	writeServerCert := func(commonName string, modTime time.Time) {
+		serverCert := generateTestCert(&x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: commonName},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, &ca)
+
+		for path, contents := range map[string]string{options.CertPath: serverCert.certPEM, options.KeyPath: serverCert.keyPEM} {
+			Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+			Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+		}
+	}
+
+	get := func(tlsConfig *tls.Config) (*http.Response, error) {
+		tlsConfig.InsecureSkipVerify = true
+		client := getHTTPClientWithConfig(tlsConfig)
+		client.Transport.(*http.Transport).DisableKeepAlives = true
+		return client.Get(serverURL + "/example")
+	}
+
+	withClientCert := func(cert testCert) *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{cert.cert.Raw},
+				PrivateKey:  cert.key,
+			}},
+		}
+	}
+
+	BeforeEach(func() {
+		logger = boshlog.NewLogger(boshlog.LevelNone)
+
+		var err error
+		certDir, err = ioutil.TempDir("", "httpsdispatcher")
+		Expect(err).ToNot(HaveOccurred())
+
+		ca = generateTestCert(&x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "fake-ca"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}, nil)
+
+		clientCert = generateTestCert(&x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "fake-director"},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &ca)
+
+		options = boshdispatcher.Options{
+			CertPath: filepath.Join(certDir, "agent.cert"),
+			KeyPath:  filepath.Join(certDir, "agent.key"),
+		}
+		writeServerCert("fake-agent", time.Now().Add(-time.Minute))
+	})
+
+	JustBeforeEach(func() {
+		serverURL, err := url.Parse(serverURL)
+		Expect(err).ToNot(HaveOccurred())
+
+		dispatcher, err = boshdispatcher.NewHTTPSDispatcherWithOptions(serverURL, options, logger)
+		Expect(err).ToNot(HaveOccurred())
+
+		dispatcher.AddRoute("/example", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+		go dispatcher.Start()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	AfterEach(func() {
+		dispatcher.Stop()
+		time.Sleep(100 * time.Millisecond)
+		Expect(os.RemoveAll(certDir)).To(Succeed())
+	})
+
+	Context("with a minimum TLS version", func() {
+		BeforeEach(func() {
+			options.MinTLSVersion = "1.2"
+		})
+
+		It("does not allow connections using an older version", func() {
+			_, err := get(&tls.Config{MaxVersion: tls.VersionTLS11})
+			Expect(err).To(HaveOccurred())
+
+			response, err := get(&tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+		})
+	})
+
+	Context("with cipher suites", func() {
+		BeforeEach(func() {
+			options.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+		})
+
+		It("only allows connections using those suites", func() {
+			_, err := get(&tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			})
+			Expect(err).To(HaveOccurred())
+
+			response, err := get(&tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+		})
+	})
+
+	Context("with a client CA", func() {
+		BeforeEach(func() {
+			options.ClientCA = ca.certPEM
+		})
+
+		It("requires a client certificate signed by the CA", func() {
+			_, err := get(&tls.Config{})
+			Expect(err).To(HaveOccurred())
+
+			otherCA := generateTestCert(&x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "fake-other-ca"},
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign,
+			}, nil)
+			otherClientCert := generateTestCert(&x509.Certificate{
+				SerialNumber: big.NewInt(3),
+				Subject:      pkix.Name{CommonName: "fake-director"},
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, &otherCA)
+
+			_, err = get(withClientCert(otherClientCert))
+			Expect(err).To(HaveOccurred())
+
+			response, err := get(withClientCert(clientCert))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(200))
+		})
+	})
+
+	It("serves the cert again after it changed", func() {
+		response, err := get(&tls.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("fake-agent"))
+
+		writeServerCert("fake-renewed-agent", time.Now())
+
+		response, err = get(&tls.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("fake-renewed-agent"))
+	})
+
+	It("keeps serving the previous cert when the changed one cannot be loaded", func() {
+		Expect(ioutil.WriteFile(options.CertPath, []byte("fake-invalid-cert"), 0600)).To(Succeed())
+		Expect(os.Chtimes(options.CertPath, time.Now(), time.Now())).To(Succeed())
+
+		response, err := get(&tls.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("fake-agent"))
+	})
+})
+
+var _ = Describe("NewHTTPSDispatcherWithOptions", func() {
+	var serverURL *url.URL
+
+	BeforeEach(func() {
+		var err error
+		serverURL, err = url.Parse("https://127.0.0.1:7789")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("errs on an unknown minimum TLS version", func() {
+		_, err := boshdispatcher.NewHTTPSDispatcherWithOptions(serverURL, boshdispatcher.Options{MinTLSVersion: "fake-version"}, boshlog.NewLogger(boshlog.LevelNone))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Unknown minimum TLS version 'fake-version'"))
+	})
+
+	It("errs on an insecure cipher suite", func() {
+		_, err := boshdispatcher.NewHTTPSDispatcherWithOptions(serverURL, boshdispatcher.Options{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, boshlog.NewLogger(boshlog.LevelNone))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Unknown or insecure cipher suite 'TLS_RSA_WITH_RC4_128_SHA'"))
+	})
+
+	It("errs on a client CA that is not PEM encoded", func() {
+		_, err := boshdispatcher.NewHTTPSDispatcherWithOptions(serverURL, boshdispatcher.Options{ClientCA: "fake-ca"}, boshlog.NewLogger(boshlog.LevelNone))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Parsing client CA"))
+	})
+})
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func generateTestCert(template *x509.Certificate, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
 func getHTTPClient() http.Client {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true,
@@ -0,0 +1,105 @@
+package httpsdispatcher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	defaultCertPath = "agent.cert"
+	defaultKeyPath  = "agent.key"
+)
+
+// Both 3DES & RC4 ciphers can be exploited
+// Using Mozilla's "Modern" recommended settings (where they overlap with golang support)
+var defaultCipherSuites = []uint16{
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Options configure the TLS of the dispatcher, empty values keep the defaults
+type Options struct {
+	// Paths of the PEM encoded server certificate and key, reloaded when changed
+	CertPath string
+	KeyPath  string
+
+	// One of 1.0, 1.1, 1.2 or 1.3
+	MinTLSVersion string
+
+	// Names as in crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	// TLS 1.3 suites are not configurable and always enabled.
+	CipherSuites []string
+
+	// PEM encoded CA, clients have to present a certificate signed by it when set
+	ClientCA string
+}
+
+func (o Options) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		// SSLv3 is insecure due to BEAST and POODLE attacks
+		MinVersion:               tls.VersionTLS10,
+		CipherSuites:             defaultCipherSuites,
+		PreferServerCipherSuites: true,
+	}
+
+	if o.MinTLSVersion != "" {
+		version, found := tlsVersions[o.MinTLSVersion]
+		if !found {
+			return nil, bosherr.Errorf("Unknown minimum TLS version '%s'", o.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(o.CipherSuites) > 0 {
+		cipherSuites, err := cipherSuiteIDs(o.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = cipherSuites
+	}
+
+	if o.ClientCA != "" {
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM([]byte(o.ClientCA)) {
+			return nil, bosherr.Error("Parsing client CA")
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// cipherSuiteIDs only knows the suites crypto/tls considers secure
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := []uint16{}
+	for _, name := range names {
+		id, found := suites[name]
+		if !found {
+			return nil, bosherr.Errorf("Unknown or insecure cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
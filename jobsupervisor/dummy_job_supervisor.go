@@ -19,6 +19,10 @@ func (s *dummyJobSupervisor) Start() error {
 	return nil
 }
 
+func (s *dummyJobSupervisor) StartProcesses(names []string) error {
+	return nil
+}
+
 func (s *dummyJobSupervisor) Stop() error {
 	s.status = "stopped"
 	return nil
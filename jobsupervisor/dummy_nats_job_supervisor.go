@@ -84,6 +84,13 @@ func (d *dummyNatsJobSupervisor) Start() error {
 	return nil
 }
 
+func (d *dummyNatsJobSupervisor) StartProcesses(names []string) error {
+	if d.status == "fail_task" {
+		return bosherror.Error("fake-task-fail-error")
+	}
+	return nil
+}
+
 func (d *dummyNatsJobSupervisor) Stop() error {
 	if d.status != "failing" && d.status != "fail_task" {
 		d.status = "stopped"
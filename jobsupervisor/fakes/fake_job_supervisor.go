@@ -17,6 +17,9 @@ type FakeJobSupervisor struct {
 	Started  bool
 	StartErr error
 
+	StartedProcesses  []string
+	StartProcessesErr error
+
 	Stopped bool
 	StopErr error
 
@@ -65,6 +68,11 @@ func (m *FakeJobSupervisor) Start() error {
 	return m.StartErr
 }
 
+func (m *FakeJobSupervisor) StartProcesses(names []string) error {
+	m.StartedProcesses = names
+	return m.StartProcessesErr
+}
+
 func (m *FakeJobSupervisor) Stop() error {
 	m.Stopped = true
 	return m.StopErr
@@ -40,6 +40,9 @@ type JobSupervisor interface {
 	// (Monit complies to above requirements.)
 	Unmonitor() error
 
+	// Starts only the named services, the others are left as they are
+	StartProcesses(names []string) error
+
 	Status() string
 	Processes() ([]Process, error)
 	// Job management
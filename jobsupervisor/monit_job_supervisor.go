@@ -131,6 +131,34 @@ func (m monitJobSupervisor) Start() error {
 	return nil
 }
 
+func (m monitJobSupervisor) StartProcesses(names []string) error {
+	services, err := m.client.ServicesInGroup("vcap")
+	if err != nil {
+		return bosherr.WrapError(err, "Getting vcap services")
+	}
+
+	supervised := map[string]bool{}
+	for _, service := range services {
+		supervised[service] = true
+	}
+
+	for _, name := range names {
+		if !supervised[name] {
+			return bosherr.Errorf("Service %s is not in group vcap", name)
+		}
+	}
+
+	for _, name := range names {
+		m.logger.Debug(monitJobSupervisorLogTag, "Starting service %s", name)
+		err = m.client.StartService(name)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Starting service %s", name)
+		}
+	}
+
+	return nil
+}
+
 func (m monitJobSupervisor) Stop() error {
 	services, err := m.client.ServicesInGroup("vcap")
 	if err != nil {
@@ -173,6 +173,36 @@ var _ = Describe("monitJobSupervisor", func() {
 		})
 	})
 
+	Describe("StartProcesses", func() {
+		It("starts only the named services", func() {
+			client.ServicesInGroupServices = []string{"fake-service", "fake-sidecar"}
+
+			err := monit.StartProcesses([]string{"fake-sidecar"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.ServicesInGroupName).To(Equal("vcap"))
+			Expect(client.StartServiceNames).To(Equal([]string{"fake-sidecar"}))
+		})
+
+		It("keeps the stopped file", func() {
+			client.ServicesInGroupServices = []string{"fake-sidecar"}
+			fs.WriteFileString("/var/vcap/monit/stopped", "")
+
+			err := monit.StartProcesses([]string{"fake-sidecar"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fs.FileExists("/var/vcap/monit/stopped")).To(BeTrue())
+		})
+
+		It("fails without starting anything when a service is not in group vcap", func() {
+			client.ServicesInGroupServices = []string{"fake-service", "fake-sidecar"}
+
+			err := monit.StartProcesses([]string{"fake-sidecar", "fake-missing"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Service fake-missing is not in group vcap"))
+			Expect(client.StartServiceNames).To(BeEmpty())
+		})
+	})
+
 	Describe("Stop", func() {
 		It("stop stops each monit service in group vcap", func() {
 			client.ServicesInGroupServices = []string{"fake-service"}
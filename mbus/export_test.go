@@ -0,0 +1,15 @@
+package mbus
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+)
+
+func NatsConnectionInfo(connectionProvider yagnats.ConnectionProvider) *yagnats.ConnectionInfo {
+	return connectionProvider.(*natsConnectionProvider).connInfo
+}
+
+func SetNatsHandlerSleep(handler Handler, sleep func(time.Duration)) {
+	handler.(*natsHandler).sleep = sleep
+}
@@ -0,0 +1,17 @@
+package fakes
+
+type FakeRequestVerifier struct {
+	VerifiedSubjects []string
+	VerifiedPayloads [][]byte
+	VerifyErr        error
+}
+
+func NewFakeRequestVerifier() *FakeRequestVerifier {
+	return &FakeRequestVerifier{}
+}
+
+func (v *FakeRequestVerifier) Verify(subject string, payload []byte) error {
+	v.VerifiedSubjects = append(v.VerifiedSubjects, subject)
+	v.VerifiedPayloads = append(v.VerifiedPayloads, payload)
+	return v.VerifyErr
+}
@@ -2,10 +2,13 @@ package mbus
 
 import (
 	"net/url"
+	"path/filepath"
 
 	"github.com/cloudfoundry/yagnats"
+	"github.com/pivotal-golang/clock"
 
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshdispatcher "github.com/cloudfoundry/bosh-agent/httpsdispatcher"
 	boshmicro "github.com/cloudfoundry/bosh-agent/micro"
 	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
@@ -14,17 +17,25 @@ import (
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
 )
 
+type Options struct {
+	// Client CA from settings takes precedence, client actions only come from settings
+	HTTPS boshdispatcher.Options
+}
+
 type HandlerProvider struct {
 	settingsService boshsettings.Service
+	options         Options
 	logger          boshlog.Logger
 	handler         boshhandler.Handler
 }
 
 func NewHandlerProvider(
 	settingsService boshsettings.Service,
+	options Options,
 	logger boshlog.Logger,
 ) (p HandlerProvider) {
 	p.settingsService = settingsService
+	p.options = options
 	p.logger = logger
 	return
 }
@@ -45,10 +56,52 @@ func (p HandlerProvider) Get(
 	}
 
 	switch mbusURL.Scheme {
-	case "nats":
-		handler = NewNatsHandler(p.settingsService, yagnats.NewClient(), p.logger)
+	case "nats", "nats+tls":
+		var requestVerifier RequestVerifier
+
+		requestVerifier, err = NewRequestVerifier(
+			p.settingsService.GetSettings().Env.Bosh.Mbus.RequestSigning,
+			platform.GetFs(),
+			filepath.Join(dirProvider.BoshDir(), "mbus_audit.log"),
+			clock.NewClock(),
+			p.logger,
+		)
+		if err != nil {
+			err = bosherr.WrapError(err, "Building request verifier")
+			return
+		}
+
+		handler = NewOutboxHandler(
+			NewNatsHandler(p.settingsService, yagnats.NewClient(), requestVerifier, p.logger),
+			platform.GetFs(),
+			filepath.Join(dirProvider.BoshDir(), "mbus_outbox.json"),
+			DefaultOutboxPolicy,
+			p.logger,
+		)
 	case "https":
-		handler = boshmicro.NewHTTPSHandler(mbusURL, p.logger, platform.GetFs(), dirProvider)
+		mbusSettings := p.settingsService.GetSettings().Env.Bosh.Mbus
+
+		options := p.options.HTTPS
+		if mbusSettings.Cert.CA != "" {
+			options.ClientCA = mbusSettings.Cert.CA
+		}
+
+		var httpsHandler boshmicro.HTTPSHandler
+
+		httpsHandler, err = boshmicro.NewHTTPSHandlerWithOptions(
+			mbusURL,
+			options,
+			mbusSettings.ClientActions,
+			p.logger,
+			platform.GetFs(),
+			dirProvider,
+		)
+		if err != nil {
+			err = bosherr.WrapError(err, "Building https handler")
+			return
+		}
+
+		handler = httpsHandler
 	default:
 		err = bosherr.Errorf("Message Bus Handler with scheme %s could not be found", mbusURL.Scheme)
 	}
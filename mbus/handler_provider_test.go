@@ -8,7 +8,9 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	boshdispatcher "github.com/cloudfoundry/bosh-agent/httpsdispatcher"
 	. "github.com/cloudfoundry/bosh-agent/mbus"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
 	"github.com/cloudfoundry/bosh-agent/micro"
 	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
 	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -30,7 +32,7 @@ var _ = Describe("HandlerProvider", func() {
 		logger = boshlog.NewLogger(boshlog.LevelNone)
 		platform = fakeplatform.NewFakePlatform()
 		dirProvider = boshdir.NewProvider("/var/vcap")
-		provider = NewHandlerProvider(settingsService, logger)
+		provider = NewHandlerProvider(settingsService, Options{}, logger)
 	})
 
 	Describe("Get", func() {
@@ -40,7 +42,28 @@ var _ = Describe("HandlerProvider", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			// yagnats.NewClient returns new object every time
-			expectedHandler := NewNatsHandler(settingsService, yagnats.NewClient(), logger)
+			expectedHandler := NewOutboxHandler(
+				NewNatsHandler(settingsService, yagnats.NewClient(), fakembus.NewFakeRequestVerifier(), logger),
+				platform.GetFs(),
+				"/var/vcap/bosh/mbus_outbox.json",
+				DefaultOutboxPolicy,
+				logger,
+			)
+			Expect(reflect.TypeOf(handler)).To(Equal(reflect.TypeOf(expectedHandler)))
+		})
+
+		It("returns nats handler for nats over TLS", func() {
+			settingsService.Settings.Mbus = "nats+tls://lol"
+			handler, err := provider.Get(platform, dirProvider)
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedHandler := NewOutboxHandler(
+				NewNatsHandler(settingsService, yagnats.NewClient(), fakembus.NewFakeRequestVerifier(), logger),
+				platform.GetFs(),
+				"/var/vcap/bosh/mbus_outbox.json",
+				DefaultOutboxPolicy,
+				logger,
+			)
 			Expect(reflect.TypeOf(handler)).To(Equal(reflect.TypeOf(expectedHandler)))
 		})
 
@@ -54,6 +77,45 @@ var _ = Describe("HandlerProvider", func() {
 			Expect(handler).To(Equal(micro.NewHTTPSHandler(url, logger, platform.GetFs(), dirProvider)))
 		})
 
+		It("returns https handler that checks client actions against the CA from settings", func() {
+			url, err := gourl.Parse("https://lol")
+			Expect(err).ToNot(HaveOccurred())
+
+			settingsService.Settings.Mbus = "https://lol"
+			settingsService.Settings.Env.Bosh.Mbus.Cert.CA = "fake-ca"
+			settingsService.Settings.Env.Bosh.Mbus.ClientActions = map[string][]string{"fake-director": {"*"}}
+
+			provider = NewHandlerProvider(settingsService, Options{
+				HTTPS: boshdispatcher.Options{MinTLSVersion: "1.2"},
+			}, logger)
+
+			_, err = provider.Get(platform, dirProvider)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Parsing client CA"))
+
+			settingsService.Settings.Env.Bosh.Mbus.Cert.CA = ""
+
+			_, err = provider.Get(platform, dirProvider)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Client actions require a client CA"))
+
+			settingsService.Settings.Env.Bosh.Mbus.ClientActions = nil
+
+			handler, err := provider.Get(platform, dirProvider)
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedHandler, err := micro.NewHTTPSHandlerWithOptions(
+				url,
+				boshdispatcher.Options{MinTLSVersion: "1.2"},
+				nil,
+				logger,
+				platform.GetFs(),
+				dirProvider,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler).To(Equal(expectedHandler))
+		})
+
 		It("returns an error if not supported", func() {
 			settingsService.Settings.Mbus = "unknown-scheme://lol"
 			_, err := provider.Get(platform, dirProvider)
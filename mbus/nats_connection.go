@@ -0,0 +1,274 @@
+package mbus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+const (
+	natsDialTimeout       = 10 * time.Second
+	natsMinReconnectDelay = 1 * time.Second
+	natsMaxReconnectDelay = 1 * time.Minute
+	natsMaxInfoLength     = 64 * 1024
+)
+
+// natsConnectionProvider is handed to the yagnats client which calls it for the
+// first connection and again after every lost connection until it succeeds.
+// The client resubscribes to all subjects once a new connection is provided.
+type natsConnectionProvider struct {
+	connInfo *yagnats.ConnectionInfo
+	status   *natsStatusTracker
+	delay    time.Duration
+	sleep    func(time.Duration)
+
+	logger boshlog.Logger
+	logTag string
+}
+
+func newNatsConnectionProvider(
+	connInfo *yagnats.ConnectionInfo,
+	status *natsStatusTracker,
+	sleep func(time.Duration),
+	logger boshlog.Logger,
+) *natsConnectionProvider {
+	return &natsConnectionProvider{
+		connInfo: connInfo,
+		status:   status,
+		sleep:    sleep,
+		logger:   logger,
+		logTag:   "NATS Connection",
+	}
+}
+
+func (p *natsConnectionProvider) ProvideConnection() (*yagnats.Connection, error) {
+	url := p.status.url()
+
+	if p.status.lost() {
+		p.logger.Warn(p.logTag, "Lost connection to %s, reconnecting", url)
+	}
+
+	// yagnats retries right away, back off exponentially between failed attempts
+	if p.delay > 0 {
+		p.logger.Info(p.logTag, "Reconnecting to %s in %s", url, p.delay)
+		p.sleep(p.delay)
+	}
+
+	conn, err := p.connInfo.ProvideConnection()
+	if err != nil {
+		p.status.failed(err)
+		p.delay = nextNatsReconnectDelay(p.delay)
+		p.logger.Error(p.logTag, "Connecting to %s: %s", url, err)
+		return nil, err
+	}
+
+	p.delay = 0
+
+	if p.status.connected() {
+		p.logger.Info(p.logTag, "Reconnected to %s, resubscribing", url)
+	} else {
+		p.logger.Info(p.logTag, "Connected to %s", url)
+	}
+
+	return conn, nil
+}
+
+func nextNatsReconnectDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return natsMinReconnectDelay
+	}
+	if delay*2 > natsMaxReconnectDelay {
+		return natsMaxReconnectDelay
+	}
+	return delay * 2
+}
+
+// natsTLSConfig trusts the CA of the mbus settings, or the system roots when
+// it is not set, and presents the client certificate when one is configured
+func natsTLSConfig(cert boshsettings.CertKeyPair) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cert.CA != "" {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM([]byte(cert.CA)) {
+			return nil, errors.New("Parsing mbus CA certificate")
+		}
+	}
+
+	if cert.Certificate != "" || cert.PrivateKey != "" {
+		clientCert, err := tls.X509KeyPair([]byte(cert.Certificate), []byte(cert.PrivateKey))
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Parsing mbus client certificate")
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return config, nil
+}
+
+// natsTLSDialer upgrades the connection the way NATS servers expect it:
+// the server sends its INFO in plain text, the TLS handshake follows.
+func natsTLSDialer(config *tls.Config) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, address, natsDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn, err := natsUpgradeTLS(conn, address, config)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
+
+func natsUpgradeTLS(conn net.Conn, address string, config *tls.Config) (net.Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := readNatsInfo(conn)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Reading NATS server info")
+	}
+
+	if !info.TLSRequired {
+		return nil, bosherr.Errorf("NATS server at %s does not require TLS", address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	config = config.Clone()
+	config.ServerName = host
+
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		return nil, bosherr.WrapErrorf(err, "TLS handshake with %s", address)
+	}
+
+	return tlsConn, conn.SetDeadline(time.Time{})
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// readNatsInfo reads the INFO line byte by byte to not consume the start of the handshake
+func readNatsInfo(conn net.Conn) (info natsInfo, err error) {
+	line := []byte{}
+	b := make([]byte, 1)
+
+	for len(line) < natsMaxInfoLength {
+		if _, err = conn.Read(b); err != nil {
+			return
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(string(line)), " ", 2)
+	if len(fields) != 2 || fields[0] != "INFO" {
+		return info, bosherr.Errorf("Unexpected NATS greeting '%s'", string(line))
+	}
+
+	err = json.Unmarshal([]byte(fields[1]), &info)
+	return
+}
+
+// natsStatusTracker is shared between the handler and its connection provider
+type natsStatusTracker struct {
+	lock   sync.Mutex
+	status boshhandler.MbusStatus
+}
+
+func (t *natsStatusTracker) url() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status.URL
+}
+
+func (t *natsStatusTracker) setURL(url string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.URL = url
+}
+
+// connected records a new connection, true when it replaces a lost one
+func (t *natsStatusTracker) connected() (reconnected bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	reconnected = t.status.DisconnectedSince != nil
+	if reconnected {
+		t.status.Reconnects++
+	}
+
+	t.status.Connected = true
+	t.status.ConnectedSince = &now
+	t.status.DisconnectedSince = nil
+	return
+}
+
+// lost marks the connection as lost when it was connected, true if it was
+func (t *natsStatusTracker) lost() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.status.Connected {
+		return false
+	}
+
+	now := time.Now()
+	t.status.Connected = false
+	t.status.ConnectedSince = nil
+	t.status.DisconnectedSince = &now
+	return true
+}
+
+func (t *natsStatusTracker) failed(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.LastError = err.Error()
+}
+
+// disconnected is only true after the connection was lost, not before the first one
+func (t *natsStatusTracker) disconnected() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status.DisconnectedSince != nil
+}
+
+func (t *natsStatusTracker) failedSend(description string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.FailedSends++
+	t.status.LastFailedSend = description
+}
+
+func (t *natsStatusTracker) get() boshhandler.MbusStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status
+}
@@ -0,0 +1,350 @@
+package mbus_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func generateTestCert(template *x509.Certificate, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// fakeNatsServer answers the CONNECT of a yagnats client, optionally over TLS
+type fakeNatsServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	lock        sync.Mutex
+	connects    []string
+	clientCerts []*x509.Certificate
+}
+
+func startFakeNatsServer(address string, tlsConfig *tls.Config) *fakeNatsServer {
+	listener, err := net.Listen("tcp", address)
+	Expect(err).ToNot(HaveOccurred())
+
+	server := &fakeNatsServer{listener: listener, tlsConfig: tlsConfig}
+	go server.serve()
+	return server
+}
+
+func (s *fakeNatsServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeNatsServer) handle(conn net.Conn) {
+	fmt.Fprintf(conn, "INFO {\"tls_required\":%t}\r\n", s.tlsConfig != nil)
+
+	if s.tlsConfig != nil {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return
+		}
+
+		s.lock.Lock()
+		s.clientCerts = append(s.clientCerts, tlsConn.ConnectionState().PeerCertificates...)
+		s.lock.Unlock()
+
+		conn = tlsConn
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	s.lock.Lock()
+	s.connects = append(s.connects, line)
+	s.lock.Unlock()
+
+	fmt.Fprint(conn, "+OK\r\n")
+}
+
+func (s *fakeNatsServer) Connects() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.connects
+}
+
+func (s *fakeNatsServer) ClientCerts() []*x509.Certificate {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.clientCerts
+}
+
+func (s *fakeNatsServer) Stop() {
+	s.listener.Close()
+}
+
+var _ = Describe("natsHandler connection", func() {
+	var (
+		ca              testCert
+		serverCert      testCert
+		clientCert      testCert
+		settingsService *fakesettings.FakeSettingsService
+		client          *fakeyagnats.FakeYagnats
+		handler         Handler
+		sleeps          []time.Duration
+		server          *fakeNatsServer
+	)
+
+	serverTLSConfig := func() *tls.Config {
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca.cert)
+
+		return &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{serverCert.cert.Raw},
+				PrivateKey:  serverCert.key,
+			}},
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+
+	BeforeEach(func() {
+		ca = generateTestCert(&x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "fake-ca"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}, nil)
+
+		serverCert = generateTestCert(&x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "fake-nats"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, &ca)
+
+		clientCert = generateTestCert(&x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "fake-agent"},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &ca)
+
+		settingsService = &fakesettings.FakeSettingsService{
+			Settings: boshsettings.Settings{AgentID: "my-agent-id"},
+		}
+		settingsService.Settings.Env.Bosh.Mbus.Cert.CA = ca.certPEM
+
+		sleeps = []time.Duration{}
+		client = fakeyagnats.New()
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Stop()
+		}
+	})
+
+	start := func(tlsConfig *tls.Config) {
+		server = startFakeNatsServer("127.0.0.1:0", tlsConfig)
+
+		scheme := "nats"
+		if tlsConfig != nil {
+			scheme = "nats+tls"
+		}
+		settingsService.Settings.Mbus = fmt.Sprintf("%s://fake-username:fake-password@%s", scheme, server.listener.Addr())
+
+		handler = NewNatsHandler(settingsService, client, fakembus.NewFakeRequestVerifier(), boshlog.NewLogger(boshlog.LevelNone))
+		SetNatsHandlerSleep(handler, func(d time.Duration) { sleeps = append(sleeps, d) })
+
+		err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	mbusStatus := func() boshhandler.MbusStatus {
+		return handler.(boshhandler.StatusProvider).MbusStatus()
+	}
+
+	Describe("nats+tls", func() {
+		It("connects over TLS trusting the CA from the settings", func() {
+			start(serverTLSConfig())
+
+			conn, err := client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Disconnect()
+
+			Expect(server.Connects()).To(HaveLen(1))
+			Expect(server.Connects()[0]).To(ContainSubstring(`"user":"fake-username"`))
+			Expect(server.ClientCerts()).To(BeEmpty())
+
+			Expect(mbusStatus().Connected).To(BeTrue())
+			Expect(mbusStatus().URL).To(Equal("nats+tls://" + server.listener.Addr().String()))
+		})
+
+		It("presents the client certificate from the settings", func() {
+			settingsService.Settings.Env.Bosh.Mbus.Cert.Certificate = clientCert.certPEM
+			settingsService.Settings.Env.Bosh.Mbus.Cert.PrivateKey = clientCert.keyPEM
+			start(serverTLSConfig())
+
+			conn, err := client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Disconnect()
+
+			Expect(server.ClientCerts()).To(HaveLen(1))
+			Expect(server.ClientCerts()[0].Subject.CommonName).To(Equal("fake-agent"))
+		})
+
+		It("does not trust a server signed by another CA", func() {
+			settingsService.Settings.Env.Bosh.Mbus.Cert.CA = clientCert.certPEM
+			start(serverTLSConfig())
+
+			_, err := client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("TLS handshake"))
+			Expect(server.Connects()).To(BeEmpty())
+		})
+
+		It("errs when the server does not require TLS", func() {
+			start(nil)
+			settingsService.Settings.Mbus = "nats+tls://" + server.listener.Addr().String()
+
+			handler = NewNatsHandler(settingsService, client, fakembus.NewFakeRequestVerifier(), boshlog.NewLogger(boshlog.LevelNone))
+			err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not require TLS"))
+		})
+
+		It("errs on a CA that cannot be parsed", func() {
+			settingsService.Settings.Env.Bosh.Mbus.Cert.CA = "fake-ca"
+			settingsService.Settings.Mbus = "nats+tls://127.0.0.1:1234"
+
+			handler = NewNatsHandler(settingsService, client, fakembus.NewFakeRequestVerifier(), boshlog.NewLogger(boshlog.LevelNone))
+			err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Parsing mbus CA certificate"))
+		})
+	})
+
+	Describe("reconnecting", func() {
+		It("backs off exponentially and reports the connection state", func() {
+			start(nil)
+			address := server.listener.Addr().String()
+
+			conn, err := client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).ToNot(HaveOccurred())
+			conn.Disconnect()
+
+			server.Stop()
+
+			for i := 0; i < 8; i++ {
+				_, err = client.ConnectedConnectionProvider().ProvideConnection()
+				Expect(err).To(HaveOccurred())
+			}
+
+			Expect(sleeps).To(Equal([]time.Duration{
+				1 * time.Second,
+				2 * time.Second,
+				4 * time.Second,
+				8 * time.Second,
+				16 * time.Second,
+				32 * time.Second,
+				60 * time.Second,
+			}))
+
+			status := mbusStatus()
+			Expect(status.Connected).To(BeFalse())
+			Expect(status.DisconnectedSince).ToNot(BeNil())
+			Expect(status.LastError).ToNot(BeEmpty())
+
+			server = startFakeNatsServer(address, nil)
+
+			conn, err = client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).ToNot(HaveOccurred())
+			conn.Disconnect()
+
+			status = mbusStatus()
+			Expect(status.Connected).To(BeTrue())
+			Expect(status.DisconnectedSince).To(BeNil())
+			Expect(status.Reconnects).To(Equal(1))
+		})
+
+		It("does not send while disconnected", func() {
+			start(nil)
+
+			conn, err := client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).ToNot(HaveOccurred())
+			conn.Disconnect()
+
+			server.Stop()
+			_, err = client.ConnectedConnectionProvider().ProvideConnection()
+			Expect(err).To(HaveOccurred())
+
+			err = handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, "fake-heartbeat")
+			Expect(err).To(Equal(boshhandler.ErrNotConnected))
+			Expect(client.PublishedMessageCount()).To(Equal(0))
+
+			Expect(mbusStatus().FailedSends).To(Equal(1))
+			Expect(mbusStatus().LastFailedSend).To(Equal("hm heartbeat"))
+		})
+	})
+})
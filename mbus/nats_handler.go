@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/cloudfoundry/yagnats"
 
@@ -33,10 +34,14 @@ type Handler interface {
 type natsHandler struct {
 	settingsService boshsettings.Service
 	client          yagnats.NATSClient
+	requestVerifier RequestVerifier
 
 	handlerFuncs     []boshhandler.Func
 	handlerFuncsLock sync.Mutex
 
+	status *natsStatusTracker
+	sleep  func(time.Duration)
+
 	logger boshlog.Logger
 	logTag string
 }
@@ -44,11 +49,16 @@ type natsHandler struct {
 func NewNatsHandler(
 	settingsService boshsettings.Service,
 	client yagnats.NATSClient,
+	requestVerifier RequestVerifier,
 	logger boshlog.Logger,
 ) Handler {
 	return &natsHandler{
 		settingsService: settingsService,
 		client:          client,
+		requestVerifier: requestVerifier,
+
+		status: &natsStatusTracker{},
+		sleep:  time.Sleep,
 
 		logger: logger,
 		logTag: "NATS Handler",
@@ -71,11 +81,13 @@ func (h *natsHandler) Run(handlerFunc boshhandler.Func) error {
 func (h *natsHandler) Start(handlerFunc boshhandler.Func) error {
 	h.RegisterAdditionalFunc(handlerFunc)
 
-	connProvider, err := h.getConnectionInfo()
+	connInfo, err := h.getConnectionInfo()
 	if err != nil {
 		return bosherr.WrapError(err, "Getting connection info")
 	}
 
+	connProvider := newNatsConnectionProvider(connInfo, h.status, h.sleep, h.logger)
+
 	err = h.client.Connect(connProvider)
 	if err != nil {
 		return bosherr.WrapError(err, "Connecting")
@@ -88,6 +100,15 @@ func (h *natsHandler) Start(handlerFunc boshhandler.Func) error {
 	h.logger.Info(h.logTag, "Subscribing to %s", subject)
 
 	_, err = h.client.Subscribe(subject, func(natsMsg *yagnats.Message) {
+		// Verified once per message, a nonce would be a replay for the next handler func
+		err := h.requestVerifier.Verify(natsMsg.Subject, natsMsg.Payload)
+		if err != nil {
+			h.handleNatsMsg(natsMsg, func(req boshhandler.Request) boshhandler.Response {
+				return boshhandler.NewExceptionResponse(err)
+			})
+			return
+		}
+
 		// Do not lock handler funcs around possible network calls!
 		h.handlerFuncsLock.Lock()
 		handlerFuncs := h.handlerFuncs
@@ -124,7 +145,24 @@ func (h *natsHandler) Send(target boshhandler.Target, topic boshhandler.Topic, m
 	settings := h.settingsService.GetSettings()
 
 	subject := fmt.Sprintf("%s.agent.%s.%s", target, topic, settings.AgentID)
-	return h.client.Publish(subject, bytes)
+
+	// the client blocks publishing until it reconnected, drop the message instead
+	if h.status.disconnected() {
+		h.status.failedSend(fmt.Sprintf("%s %s", target, topic))
+		return boshhandler.ErrNotConnected
+	}
+
+	err = h.client.Publish(subject, bytes)
+	if err != nil && err.Error() == "disconnected" {
+		h.status.failedSend(fmt.Sprintf("%s %s", target, topic))
+		return boshhandler.ErrNotConnected
+	}
+
+	return err
+}
+
+func (h *natsHandler) MbusStatus() boshhandler.MbusStatus {
+	return h.status.get()
 }
 
 func (h *natsHandler) Stop() {
@@ -178,6 +216,18 @@ func (h *natsHandler) getConnectionInfo() (*yagnats.ConnectionInfo, error) {
 	connInfo := new(yagnats.ConnectionInfo)
 	connInfo.Addr = natsURL.Host
 
+	switch natsURL.Scheme {
+	case "nats":
+	case "nats+tls":
+		tlsConfig, err := natsTLSConfig(settings.Env.Bosh.Mbus.Cert)
+		if err != nil {
+			return nil, err
+		}
+		connInfo.Dial = natsTLSDialer(tlsConfig)
+	default:
+		return nil, bosherr.Errorf("Unsupported Nats URL scheme '%s'", natsURL.Scheme)
+	}
+
 	user := natsURL.User
 	if user != nil {
 		password, passwordIsSet := user.Password()
@@ -188,5 +238,8 @@ func (h *natsHandler) getConnectionInfo() (*yagnats.ConnectionInfo, error) {
 		connInfo.Username = user.Username()
 	}
 
+	natsURL.User = nil
+	h.status.setURL(natsURL.String())
+
 	return connInfo, nil
 }
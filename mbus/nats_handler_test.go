@@ -1,6 +1,8 @@
 package mbus_test
 
 import (
+	"errors"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -9,6 +11,7 @@ import (
 
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	. "github.com/cloudfoundry/bosh-agent/mbus"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
 	boshlog "github.com/cloudfoundry/bosh-utils/logger"
@@ -19,6 +22,7 @@ func init() {
 		var (
 			settingsService *fakesettings.FakeSettingsService
 			client          *fakeyagnats.FakeYagnats
+			requestVerifier *fakembus.FakeRequestVerifier
 			logger          boshlog.Logger
 			handler         boshhandler.Handler
 		)
@@ -32,7 +36,8 @@ func init() {
 			}
 			logger = boshlog.NewLogger(boshlog.LevelNone)
 			client = fakeyagnats.New()
-			handler = NewNatsHandler(settingsService, client, logger)
+			requestVerifier = fakembus.NewFakeRequestVerifier()
+			handler = NewNatsHandler(settingsService, client, requestVerifier, logger)
 		})
 
 		Describe("Start", func() {
@@ -169,6 +174,35 @@ func init() {
 				Expect(len(messages)).To(Equal(2))
 				Expect(messages[0].Payload).To(Equal([]byte(`{"value":"first-handler-resp"}`)))
 				Expect(messages[1].Payload).To(Equal([]byte(`{"value":"second-handler-resp"}`)))
+
+				// Verified once for both handlers
+				Expect(requestVerifier.VerifiedSubjects).To(Equal([]string{"agent.my-agent-id"}))
+				Expect(requestVerifier.VerifiedPayloads).To(Equal([][]byte{expectedPayload}))
+			})
+
+			It("responds with an exception to requests that fail verification", func() {
+				handlerCalled := false
+
+				err := handler.Start(func(req boshhandler.Request) (resp boshhandler.Response) {
+					handlerCalled = true
+					return boshhandler.NewValueResponse("expected value")
+				})
+				Expect(err).ToNot(HaveOccurred())
+				defer handler.Stop()
+
+				requestVerifier.VerifyErr = errors.New("fake-verify-error")
+
+				subscription := client.Subscriptions("agent.my-agent-id")[0]
+				subscription.Callback(&yagnats.Message{
+					Subject: "agent.my-agent-id",
+					Payload: []byte(`{"method":"ssh","arguments":[], "reply_to": "fake-reply-to"}`),
+				})
+
+				Expect(handlerCalled).To(BeFalse())
+
+				messages := client.PublishedMessages("fake-reply-to")
+				Expect(len(messages)).To(Equal(1))
+				Expect(messages[0].Payload).To(Equal([]byte(`{"exception":{"message":"fake-verify-error"}}`)))
 			})
 
 			It("has the correct connection info", func() {
@@ -176,7 +210,7 @@ func init() {
 				Expect(err).ToNot(HaveOccurred())
 				defer handler.Stop()
 
-				Expect(client.ConnectedConnectionProvider()).To(Equal(&yagnats.ConnectionInfo{
+				Expect(NatsConnectionInfo(client.ConnectedConnectionProvider())).To(Equal(&yagnats.ConnectionInfo{
 					Addr:     "127.0.0.1:1234",
 					Username: "fake-username",
 					Password: "fake-password",
@@ -185,7 +219,7 @@ func init() {
 
 			It("does not err when no username and password", func() {
 				settingsService.Settings.Mbus = "nats://127.0.0.1:1234"
-				handler = NewNatsHandler(settingsService, client, logger)
+				handler = NewNatsHandler(settingsService, client, requestVerifier, logger)
 
 				err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
 				Expect(err).ToNot(HaveOccurred())
@@ -194,7 +228,7 @@ func init() {
 
 			It("errs when has username without password", func() {
 				settingsService.Settings.Mbus = "nats://foo@127.0.0.1:1234"
-				handler = NewNatsHandler(settingsService, client, logger)
+				handler = NewNatsHandler(settingsService, client, requestVerifier, logger)
 
 				err := handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })
 				Expect(err).To(HaveOccurred())
@@ -0,0 +1,319 @@
+package mbus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+// OutboxPolicy bounds the outbox. Only the latest heartbeat is kept, a newer
+// one replaces the queued one. Once MaxMessages are queued the oldest message
+// is dropped for every new one, whatever its topic.
+type OutboxPolicy struct {
+	MaxMessages   int
+	RetryInterval time.Duration
+}
+
+var DefaultOutboxPolicy = OutboxPolicy{
+	MaxMessages:   1000,
+	RetryInterval: 10 * time.Second,
+}
+
+// Messages of other topics are sent straight through
+var outboxTopics = map[boshhandler.Topic]bool{
+	boshhandler.Heartbeat: true,
+	boshhandler.Alert:     true,
+	boshhandler.Shutdown:  true,
+}
+
+type outboxMessage struct {
+	Target  boshhandler.Target `json:"target"`
+	Topic   boshhandler.Topic  `json:"topic"`
+	Message json.RawMessage    `json:"message"`
+	Queued  time.Time          `json:"queued"`
+}
+
+// outboxHandler queues the messages its handler fails to send while the bus
+// is not connected in a file and delivers them in order once it is again.
+// A new message is only sent once the queue is empty, otherwise it is queued
+// behind the pending ones. Other send errors are returned, not queued.
+type outboxHandler struct {
+	handler Handler
+	fs      boshsys.FileSystem
+	path    string
+	policy  OutboxPolicy
+
+	lock     sync.Mutex
+	loaded   bool
+	messages []outboxMessage
+	dropped  int
+	stopCh   chan struct{}
+
+	logger boshlog.Logger
+	logTag string
+}
+
+func NewOutboxHandler(
+	handler Handler,
+	fs boshsys.FileSystem,
+	path string,
+	policy OutboxPolicy,
+	logger boshlog.Logger,
+) Handler {
+	return &outboxHandler{
+		handler: handler,
+		fs:      fs,
+		path:    path,
+		policy:  policy,
+
+		logger: logger,
+		logTag: "Outbox Handler",
+	}
+}
+
+func (o *outboxHandler) Run(handlerFunc boshhandler.Func) error {
+	o.startRetrying()
+	defer o.stopRetrying()
+
+	return o.handler.Run(handlerFunc)
+}
+
+func (o *outboxHandler) Start(handlerFunc boshhandler.Func) error {
+	err := o.handler.Start(handlerFunc)
+	if err != nil {
+		return err
+	}
+
+	o.startRetrying()
+
+	return nil
+}
+
+func (o *outboxHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
+	o.handler.RegisterAdditionalFunc(handlerFunc)
+}
+
+func (o *outboxHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	if !outboxTopics[topic] {
+		return o.handler.Send(target, topic, message)
+	}
+
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Marshalling message (target=%s, topic=%s): %#v", target, topic, message)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.load()
+
+	flushed := o.flush()
+
+	if len(o.messages) == 0 {
+		err = o.handler.Send(target, topic, message)
+		if err != boshhandler.ErrNotConnected {
+			if flushed > 0 {
+				o.save()
+			}
+			return err
+		}
+
+		o.logger.Warn(o.logTag, "Queueing %s message '%s': %s", target, topic, err)
+	}
+
+	o.enqueue(outboxMessage{
+		Target:  target,
+		Topic:   topic,
+		Message: json.RawMessage(bytes),
+		Queued:  time.Now(),
+	})
+	o.save()
+
+	return nil
+}
+
+func (o *outboxHandler) Stop() {
+	o.stopRetrying()
+	o.handler.Stop()
+}
+
+func (o *outboxHandler) MbusStatus() boshhandler.MbusStatus {
+	var status boshhandler.MbusStatus
+	if provider, ok := o.handler.(boshhandler.StatusProvider); ok {
+		status = provider.MbusStatus()
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.load()
+
+	status.Outbox = &boshhandler.OutboxStatus{
+		Queued:  len(o.messages),
+		Dropped: o.dropped,
+	}
+	if len(o.messages) > 0 {
+		oldest := o.messages[0].Queued
+		status.Outbox.Oldest = &oldest
+	}
+
+	return status
+}
+
+func (o *outboxHandler) enqueue(message outboxMessage) {
+	if message.Topic == boshhandler.Heartbeat {
+		messages := []outboxMessage{}
+		for _, queued := range o.messages {
+			if queued.Topic != boshhandler.Heartbeat {
+				messages = append(messages, queued)
+			}
+		}
+		o.messages = messages
+	}
+
+	o.messages = append(o.messages, message)
+
+	for len(o.messages) > o.policy.MaxMessages {
+		dropped := o.messages[0]
+		o.messages = o.messages[1:]
+		o.dropped++
+
+		o.logger.Error(o.logTag, "Outbox is full, dropping %s message '%s' queued at %s", dropped.Target, dropped.Topic, dropped.Queued)
+	}
+}
+
+// flush sends the queued messages in order until the bus is not connected,
+// a message failing for another reason would keep failing and is dropped
+func (o *outboxHandler) flush() (flushed int) {
+	for len(o.messages) > 0 {
+		message := o.messages[0]
+
+		err := o.handler.Send(message.Target, message.Topic, message.Message)
+		if err == boshhandler.ErrNotConnected {
+			o.logger.Debug(o.logTag, "Delivering queued messages: %s", err)
+			break
+		}
+
+		o.messages = o.messages[1:]
+		flushed++
+
+		if err != nil {
+			o.dropped++
+			o.logger.Error(o.logTag, "Dropping %s message '%s' queued at %s: %s", message.Target, message.Topic, message.Queued, err)
+		}
+	}
+
+	if flushed > 0 {
+		o.logger.Info(o.logTag, "Flushed %d queued messages, %d left", flushed, len(o.messages))
+	}
+
+	return
+}
+
+func (o *outboxHandler) retry() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.load()
+
+	if o.flush() > 0 {
+		o.save()
+	}
+}
+
+func (o *outboxHandler) startRetrying() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.stopCh != nil {
+		return
+	}
+
+	stopCh := make(chan struct{})
+	o.stopCh = stopCh
+
+	go func() {
+		defer o.logger.HandlePanic("Outbox Retries")
+
+		ticker := time.NewTicker(o.policy.RetryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				o.retry()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (o *outboxHandler) stopRetrying() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.stopCh != nil {
+		close(o.stopCh)
+		o.stopCh = nil
+	}
+}
+
+// load reads the messages queued before a restart, a queue that cannot be
+// read is dropped rather than keeping the agent from sending
+func (o *outboxHandler) load() {
+	if o.loaded {
+		return
+	}
+	o.loaded = true
+
+	if !o.fs.FileExists(o.path) {
+		return
+	}
+
+	contents, err := o.fs.ReadFile(o.path)
+	if err != nil {
+		o.logger.Error(o.logTag, "Reading outbox %s: %s", o.path, err)
+		return
+	}
+
+	if err = json.Unmarshal(contents, &o.messages); err != nil {
+		o.logger.Error(o.logTag, "Unmarshalling outbox %s: %s", o.path, err)
+		o.messages = nil
+		return
+	}
+
+	if len(o.messages) > 0 {
+		o.logger.Info(o.logTag, "Loaded %d queued messages from %s", len(o.messages), o.path)
+	}
+}
+
+func (o *outboxHandler) save() {
+	if o.messages == nil {
+		o.messages = []outboxMessage{}
+	}
+
+	contents, err := json.Marshal(o.messages)
+	if err != nil {
+		o.logger.Error(o.logTag, "Marshalling outbox: %s", err)
+		return
+	}
+
+	// a crash while writing must not leave a truncated outbox behind
+	tmpPath := o.path + ".tmp"
+
+	if err = o.fs.WriteFile(tmpPath, contents); err != nil {
+		o.logger.Error(o.logTag, "Writing outbox %s: %s", tmpPath, err)
+		return
+	}
+
+	if err = o.fs.Rename(tmpPath, o.path); err != nil {
+		o.logger.Error(o.logTag, "Renaming outbox %s to %s: %s", tmpPath, o.path, err)
+	}
+}
@@ -0,0 +1,199 @@
+package mbus_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+	fakembus "github.com/cloudfoundry/bosh-agent/mbus/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("outboxHandler", func() {
+	var (
+		fs      *fakesys.FakeFileSystem
+		inner   *fakembus.FakeHandler
+		policy  OutboxPolicy
+		handler Handler
+	)
+
+	const outboxPath = "/var/vcap/bosh/mbus_outbox.json"
+
+	alert := func(id string) map[string]string {
+		return map[string]string{"id": id}
+	}
+
+	newHandler := func() Handler {
+		return NewOutboxHandler(inner, fs, outboxPath, policy, boshlog.NewLogger(boshlog.LevelNone))
+	}
+
+	sentMessages := func() []string {
+		messages := []string{}
+		for _, input := range inner.SendInputs() {
+			bytes, err := json.Marshal(input.Message)
+			Expect(err).ToNot(HaveOccurred())
+			messages = append(messages, string(input.Topic)+" "+string(bytes))
+		}
+		return messages
+	}
+
+	mbusStatus := func() boshhandler.MbusStatus {
+		return handler.(boshhandler.StatusProvider).MbusStatus()
+	}
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		inner = fakembus.NewFakeHandler()
+		policy = OutboxPolicy{MaxMessages: 3, RetryInterval: time.Hour}
+		handler = newHandler()
+	})
+
+	Describe("Send", func() {
+		It("sends straight through while the bus works", func() {
+			err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(sentMessages()).To(Equal([]string{`alert {"id":"1"}`}))
+			Expect(fs.FileExists(outboxPath)).To(BeFalse())
+		})
+
+		It("queues what fails and delivers it in order once the bus is back", func() {
+			inner.SendErr = boshhandler.ErrNotConnected
+
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Shutdown, nil)).To(Succeed())
+			Expect(fs.FileExists(outboxPath)).To(BeTrue())
+			Expect(mbusStatus().Outbox.Queued).To(Equal(2))
+
+			inner.SendErr = nil
+			sentBefore := len(sentMessages())
+
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("2"))).To(Succeed())
+			Expect(sentMessages()[sentBefore:]).To(Equal([]string{
+				`alert {"id":"1"}`,
+				`shutdown null`,
+				`alert {"id":"2"}`,
+			}))
+			Expect(mbusStatus().Outbox.Queued).To(Equal(0))
+			Expect(mbusStatus().Outbox.Oldest).To(BeNil())
+		})
+
+		It("keeps only the latest heartbeat", func() {
+			inner.SendErr = boshhandler.ErrNotConnected
+
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, alert("hb-1"))).To(Succeed())
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Heartbeat, alert("hb-2"))).To(Succeed())
+
+			inner.SendErr = nil
+			sentBefore := len(sentMessages())
+
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("2"))).To(Succeed())
+			Expect(sentMessages()[sentBefore:]).To(Equal([]string{
+				`alert {"id":"1"}`,
+				`heartbeat {"id":"hb-2"}`,
+				`alert {"id":"2"}`,
+			}))
+		})
+
+		It("drops the oldest messages when the outbox is full", func() {
+			inner.SendErr = boshhandler.ErrNotConnected
+
+			for _, id := range []string{"1", "2", "3", "4", "5"} {
+				Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert(id))).To(Succeed())
+			}
+
+			status := mbusStatus()
+			Expect(status.Outbox.Queued).To(Equal(3))
+			Expect(status.Outbox.Dropped).To(Equal(2))
+			Expect(status.Outbox.Oldest).ToNot(BeNil())
+
+			inner.SendErr = nil
+			sentBefore := len(sentMessages())
+
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("6"))).To(Succeed())
+			Expect(sentMessages()[sentBefore:]).To(Equal([]string{
+				`alert {"id":"3"}`,
+				`alert {"id":"4"}`,
+				`alert {"id":"5"}`,
+				`alert {"id":"6"}`,
+			}))
+		})
+
+		It("returns errors other than not being connected without queueing", func() {
+			inner.SendErr = errors.New("fake-send-error")
+
+			err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))
+			Expect(err).To(Equal(inner.SendErr))
+			Expect(mbusStatus().Outbox.Queued).To(Equal(0))
+			Expect(fs.FileExists(outboxPath)).To(BeFalse())
+		})
+
+		It("drops a queued message that fails for another reason than the connection", func() {
+			inner.SendErr = boshhandler.ErrNotConnected
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+
+			inner.SendErr = errors.New("fake-send-error")
+			err := handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("2"))
+			Expect(err).To(Equal(inner.SendErr))
+
+			status := mbusStatus()
+			Expect(status.Outbox.Queued).To(Equal(0))
+			Expect(status.Outbox.Dropped).To(Equal(1))
+		})
+
+		It("replaces the outbox file by renaming a new one over it", func() {
+			inner.SendErr = boshhandler.ErrNotConnected
+			Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+
+			Expect(fs.RenameOldPaths).To(Equal([]string{outboxPath + ".tmp"}))
+			Expect(fs.RenameNewPaths).To(Equal([]string{outboxPath}))
+			Expect(fs.FileExists(outboxPath + ".tmp")).To(BeFalse())
+		})
+
+		It("returns the error of topics it does not queue", func() {
+			inner.SendErr = errors.New("fake-send-error")
+
+			err := handler.Send(boshhandler.Director, boshhandler.Topic("fake-topic"), alert("1"))
+			Expect(err).To(Equal(inner.SendErr))
+		})
+	})
+
+	It("delivers the messages queued before a restart", func() {
+		inner.SendErr = boshhandler.ErrNotConnected
+		Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+
+		inner = fakembus.NewFakeHandler()
+		handler = newHandler()
+		Expect(mbusStatus().Outbox.Queued).To(Equal(1))
+
+		Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("2"))).To(Succeed())
+		Expect(sentMessages()).To(Equal([]string{
+			`alert {"id":"1"}`,
+			`alert {"id":"2"}`,
+		}))
+	})
+
+	It("retries the delivery on an interval once started", func() {
+		policy.RetryInterval = 10 * time.Millisecond
+		handler = newHandler()
+
+		inner.SendErr = boshhandler.ErrNotConnected
+		Expect(handler.Send(boshhandler.HealthMonitor, boshhandler.Alert, alert("1"))).To(Succeed())
+
+		inner.SendErr = nil
+		Expect(handler.Start(func(req boshhandler.Request) (res boshhandler.Response) { return })).To(Succeed())
+		defer handler.Stop()
+
+		Eventually(mbusStatus).Should(WithTransform(func(status boshhandler.MbusStatus) int {
+			return status.Outbox.Queued
+		}, Equal(0)))
+		Expect(sentMessages()).To(ContainElement(`alert {"id":"1"}`))
+	})
+})
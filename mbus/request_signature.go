@@ -0,0 +1,289 @@
+package mbus
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	boshsys "github.com/cloudfoundry/bosh-utils/system"
+)
+
+const (
+	SigningAlgorithmHMACSHA256 = "hmac-sha256"
+	SigningAlgorithmEd25519    = "ed25519"
+
+	defaultMaxClockSkew = 5 * time.Minute
+)
+
+// RequestVerifier checks a request received on subject before it is handed to the handler funcs
+type RequestVerifier interface {
+	Verify(subject string, payload []byte) error
+}
+
+// RequestSignature is attached to a request by the director as
+//
+//	{"method": ..., "arguments": ..., "reply_to": ..., "signature": {...}}
+//
+// Value signs the subject the request is published on (agent.<agent_id>), the method,
+// reply_to, timestamp, nonce and the arguments exactly as they appear in the payload,
+// each followed by a newline but the last. The subject keeps a captured request
+// from being replayed to another agent.
+type RequestSignature struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	Timestamp int64  `json:"timestamp"` // unix seconds
+	Nonce     string `json:"nonce"`
+	Value     string `json:"value"` // base64
+}
+
+type signedRequest struct {
+	Method    string            `json:"method"`
+	ReplyTo   string            `json:"reply_to"`
+	Arguments json.RawMessage   `json:"arguments"`
+	Signature *RequestSignature `json:"signature"`
+}
+
+// SignedRequestMessage returns what the signature of a request signs
+func SignedRequestMessage(subject, method, replyTo string, arguments []byte, signature RequestSignature) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%d\n%s\n%s", subject, method, replyTo, signature.Timestamp, signature.Nonce, arguments))
+}
+
+type signingKey struct {
+	algorithm string
+	key       []byte
+}
+
+// signatureVerifier keeps the nonces it accepted until their timestamp is too
+// old to pass the clock skew check, they are not kept across restarts.
+type signatureVerifier struct {
+	keys         map[string]signingKey
+	required     bool
+	maxClockSkew time.Duration
+
+	nonces     map[string]time.Time
+	noncesLock sync.Mutex
+
+	fs           boshsys.FileSystem
+	auditLogPath string
+	timeService  clock.Clock
+
+	logger boshlog.Logger
+	logTag string
+}
+
+func NewRequestVerifier(
+	signing boshsettings.RequestSigning,
+	fs boshsys.FileSystem,
+	auditLogPath string,
+	timeService clock.Clock,
+	logger boshlog.Logger,
+) (RequestVerifier, error) {
+	verifier := &signatureVerifier{
+		keys:         map[string]signingKey{},
+		required:     signing.Required,
+		maxClockSkew: time.Duration(signing.MaxClockSkew) * time.Second,
+		nonces:       map[string]time.Time{},
+		fs:           fs,
+		auditLogPath: auditLogPath,
+		timeService:  timeService,
+		logger:       logger,
+		logTag:       "Request Verifier",
+	}
+
+	if verifier.maxClockSkew <= 0 {
+		verifier.maxClockSkew = defaultMaxClockSkew
+	}
+
+	for _, key := range signing.Keys {
+		decoded, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Decoding request signing key '%s'", key.ID)
+		}
+
+		switch key.Algorithm {
+		case SigningAlgorithmHMACSHA256:
+		case SigningAlgorithmEd25519:
+			if len(decoded) != ed25519.PublicKeySize {
+				return nil, bosherr.Errorf("Request signing key '%s' is not an Ed25519 public key", key.ID)
+			}
+		default:
+			return nil, bosherr.Errorf("Unknown algorithm '%s' of request signing key '%s'", key.Algorithm, key.ID)
+		}
+
+		verifier.keys[key.ID] = signingKey{algorithm: key.Algorithm, key: decoded}
+	}
+
+	if signing.Required && len(verifier.keys) == 0 {
+		return nil, bosherr.Error("Request signing is required but no keys are configured")
+	}
+
+	return verifier, nil
+}
+
+func (v *signatureVerifier) Verify(subject string, payload []byte) error {
+	if len(v.keys) == 0 {
+		return nil
+	}
+
+	var request signedRequest
+
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		return v.reject(request, bosherr.WrapError(err, "Unmarshalling JSON payload"))
+	}
+
+	if request.Signature == nil {
+		if v.required {
+			return v.reject(request, bosherr.Error("Request is not signed"))
+		}
+
+		// still audited, a key without required signing must not hide unsigned requests
+		v.logger.Warn(v.logTag, "Accepting unsigned request with action %s", request.Method)
+		v.auditRequest(request, "accepted", "Request is not signed")
+		return nil
+	}
+
+	err = v.verifySignature(subject, request)
+	if err != nil {
+		return v.reject(request, err)
+	}
+
+	v.logger.Info(v.logTag, "Request with action %s signed by '%s'", request.Method, request.Signature.KeyID)
+
+	return nil
+}
+
+func (v *signatureVerifier) verifySignature(subject string, request signedRequest) error {
+	signature := *request.Signature
+
+	key, found := v.keys[signature.KeyID]
+	if !found {
+		return bosherr.Errorf("Unknown signing key '%s'", signature.KeyID)
+	}
+
+	if signature.Algorithm != key.algorithm {
+		return bosherr.Errorf("Signing key '%s' does not use %s", signature.KeyID, signature.Algorithm)
+	}
+
+	if signature.Nonce == "" {
+		return bosherr.Error("Signature has no nonce")
+	}
+
+	now := v.timeService.Now()
+	timestamp := time.Unix(signature.Timestamp, 0)
+	if timestamp.Before(now.Add(-v.maxClockSkew)) || timestamp.After(now.Add(v.maxClockSkew)) {
+		return bosherr.Errorf("Signature timestamp %s is more than %s off", timestamp.UTC().Format(time.RFC3339), v.maxClockSkew)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(signature.Value)
+	if err != nil {
+		return bosherr.WrapError(err, "Decoding signature")
+	}
+
+	message := SignedRequestMessage(subject, request.Method, request.ReplyTo, request.Arguments, signature)
+
+	switch key.algorithm {
+	case SigningAlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, key.key)
+		mac.Write(message)
+		if !hmac.Equal(mac.Sum(nil), value) {
+			return bosherr.Error("Signature does not match")
+		}
+
+	case SigningAlgorithmEd25519:
+		if !ed25519.Verify(ed25519.PublicKey(key.key), message, value) {
+			return bosherr.Error("Signature does not match")
+		}
+	}
+
+	// nonces are only recorded for valid signatures so they cannot be used up by others
+	return v.useNonce(signature.KeyID+"/"+signature.Nonce, timestamp, now)
+}
+
+func (v *signatureVerifier) useNonce(nonce string, timestamp, now time.Time) error {
+	v.noncesLock.Lock()
+	defer v.noncesLock.Unlock()
+
+	for seen, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, seen)
+		}
+	}
+
+	if _, seen := v.nonces[nonce]; seen {
+		return bosherr.Error("Nonce was already used")
+	}
+
+	v.nonces[nonce] = timestamp.Add(v.maxClockSkew)
+
+	return nil
+}
+
+type requestAuditEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	ReplyTo string    `json:"reply_to"`
+	KeyID   string    `json:"key_id,omitempty"`
+	Nonce   string    `json:"nonce,omitempty"`
+	Result  string    `json:"result"`
+	Reason  string    `json:"reason"`
+}
+
+func (v *signatureVerifier) reject(request signedRequest, err error) error {
+	v.logger.Error(v.logTag, "Rejecting request with action %s: %s", request.Method, err)
+
+	v.auditRequest(request, "rejected", err.Error())
+
+	return bosherr.WrapError(err, "Rejecting request")
+}
+
+func (v *signatureVerifier) auditRequest(request signedRequest, result, reason string) {
+	entry := requestAuditEntry{
+		Time:    v.timeService.Now(),
+		Method:  request.Method,
+		ReplyTo: request.ReplyTo,
+		Result:  result,
+		Reason:  reason,
+	}
+	if request.Signature != nil {
+		entry.KeyID = request.Signature.KeyID
+		entry.Nonce = request.Signature.Nonce
+	}
+
+	err := v.audit(entry)
+	if err != nil {
+		v.logger.Error(v.logTag, "Writing audit log: %s", err)
+	}
+}
+
+// audit appends the entry as a JSON line to the audit log
+func (v *signatureVerifier) audit(entry requestAuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling audit entry")
+	}
+
+	file, err := v.fs.OpenFile(v.auditLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.FileMode(0600))
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Opening %s", v.auditLogPath)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Writing %s", v.auditLogPath)
+	}
+
+	return nil
+}
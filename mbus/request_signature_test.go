@@ -0,0 +1,223 @@
+package mbus_test
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/cloudfoundry/bosh-agent/mbus"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+)
+
+var _ = Describe("RequestVerifier", func() {
+	const (
+		auditLogPath = "/var/vcap/bosh/mbus_audit.log"
+		subject      = "agent.fake-agent-id"
+	)
+
+	var (
+		fs          *fakesys.FakeFileSystem
+		timeService *fakeclock.FakeClock
+		hmacSecret  []byte
+		publicKey   ed25519.PublicKey
+		privateKey  ed25519.PrivateKey
+		signing     boshsettings.RequestSigning
+		verifier    RequestVerifier
+	)
+
+	sign := func(algorithm, keyID, nonce string, timestamp time.Time, method, arguments string) []byte {
+		signature := RequestSignature{
+			KeyID:     keyID,
+			Algorithm: algorithm,
+			Timestamp: timestamp.Unix(),
+			Nonce:     nonce,
+		}
+
+		message := SignedRequestMessage(subject, method, "fake-reply-to", []byte(arguments), signature)
+
+		var value []byte
+		if algorithm == SigningAlgorithmEd25519 {
+			value = ed25519.Sign(privateKey, message)
+		} else {
+			mac := hmac.New(sha256.New, hmacSecret)
+			mac.Write(message)
+			value = mac.Sum(nil)
+		}
+		signature.Value = base64.StdEncoding.EncodeToString(value)
+
+		signatureJSON, err := json.Marshal(signature)
+		Expect(err).ToNot(HaveOccurred())
+
+		return []byte(fmt.Sprintf(`{"method":"%s","arguments":%s,"reply_to":"fake-reply-to","signature":%s}`, method, arguments, signatureJSON))
+	}
+
+	signHMAC := func(nonce string) []byte {
+		return sign(SigningAlgorithmHMACSHA256, "director", nonce, timeService.Now(), "ssh", `["setup",{"user":"fake-user"}]`)
+	}
+
+	lastAuditEntry := func() map[string]interface{} {
+		contents, err := fs.ReadFile(auditLogPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(contents, &entry)).To(Succeed())
+		return entry
+	}
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		timeService = fakeclock.NewFakeClock(time.Unix(1700000000, 0))
+
+		hmacSecret = []byte("fake-hmac-secret")
+
+		var err error
+		publicKey, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		signing = boshsettings.RequestSigning{
+			Keys: []boshsettings.SigningKey{
+				{ID: "director", Algorithm: "hmac-sha256", Key: base64.StdEncoding.EncodeToString(hmacSecret)},
+				{ID: "director-ed25519", Algorithm: "ed25519", Key: base64.StdEncoding.EncodeToString(publicKey)},
+			},
+			Required: true,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		verifier, err = NewRequestVerifier(signing, fs, auditLogPath, timeService, boshlog.NewLogger(boshlog.LevelNone))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("without keys", func() {
+		BeforeEach(func() {
+			signing = boshsettings.RequestSigning{}
+		})
+
+		It("accepts every request", func() {
+			Expect(verifier.Verify(subject, []byte(`{"method":"ssh","arguments":[]}`))).To(Succeed())
+			Expect(fs.FileExists(auditLogPath)).To(BeFalse())
+		})
+	})
+
+	It("accepts a request signed with HMAC", func() {
+		Expect(verifier.Verify(subject, signHMAC("nonce-1"))).To(Succeed())
+		Expect(fs.FileExists(auditLogPath)).To(BeFalse())
+	})
+
+	It("accepts a request signed with Ed25519", func() {
+		payload := sign(SigningAlgorithmEd25519, "director-ed25519", "nonce-1", timeService.Now(), "apply", `[{}]`)
+		Expect(verifier.Verify(subject, payload)).To(Succeed())
+	})
+
+	It("rejects a request whose arguments were changed and audits it", func() {
+		payload := signHMAC("nonce-1")
+		tampered := []byte(string(payload[:len(`{"method":"ssh","arguments":["setup",{"user":"`)]) + "root" +
+			string(payload[len(`{"method":"ssh","arguments":["setup",{"user":"fake-user`):]))
+
+		err := verifier.Verify(subject, tampered)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Signature does not match"))
+
+		entry := lastAuditEntry()
+		Expect(entry["method"]).To(Equal("ssh"))
+		Expect(entry["reply_to"]).To(Equal("fake-reply-to"))
+		Expect(entry["key_id"]).To(Equal("director"))
+		Expect(entry["nonce"]).To(Equal("nonce-1"))
+		Expect(entry["result"]).To(Equal("rejected"))
+		Expect(entry["reason"]).To(Equal("Signature does not match"))
+	})
+
+	It("rejects a request replayed to another agent", func() {
+		err := verifier.Verify("agent.other-agent-id", signHMAC("nonce-1"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Signature does not match"))
+	})
+
+	It("rejects a replayed nonce", func() {
+		payload := signHMAC("nonce-1")
+		Expect(verifier.Verify(subject, payload)).To(Succeed())
+
+		err := verifier.Verify(subject, payload)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Nonce was already used"))
+	})
+
+	It("rejects a request with a timestamp outside of the clock skew", func() {
+		payload := signHMAC("nonce-1")
+		timeService.Increment(6 * time.Minute)
+
+		err := verifier.Verify(subject, payload)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("more than 5m0s off"))
+	})
+
+	It("rejects a key it does not know", func() {
+		payload := sign(SigningAlgorithmHMACSHA256, "fake-key", "nonce-1", timeService.Now(), "ssh", `[]`)
+
+		err := verifier.Verify(subject, payload)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Unknown signing key 'fake-key'"))
+	})
+
+	It("rejects a signature made with another algorithm than the one of the key", func() {
+		payload := sign(SigningAlgorithmHMACSHA256, "director-ed25519", "nonce-1", timeService.Now(), "ssh", `[]`)
+
+		err := verifier.Verify(subject, payload)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("does not use hmac-sha256"))
+	})
+
+	It("rejects an unsigned request when signing is required", func() {
+		err := verifier.Verify(subject, []byte(`{"method":"ssh","arguments":[],"reply_to":"fake-reply-to"}`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Request is not signed"))
+		Expect(lastAuditEntry()["reason"]).To(Equal("Request is not signed"))
+	})
+
+	Context("when signing is not required", func() {
+		BeforeEach(func() {
+			signing.Required = false
+		})
+
+		It("accepts an unsigned request and audits it", func() {
+			Expect(verifier.Verify(subject, []byte(`{"method":"ping","arguments":[],"reply_to":"fake-reply-to"}`))).To(Succeed())
+
+			entry := lastAuditEntry()
+			Expect(entry["method"]).To(Equal("ping"))
+			Expect(entry["result"]).To(Equal("accepted"))
+			Expect(entry["reason"]).To(Equal("Request is not signed"))
+		})
+
+		It("still rejects a bad signature", func() {
+			payload := sign(SigningAlgorithmHMACSHA256, "fake-key", "nonce-1", timeService.Now(), "ssh", `[]`)
+			Expect(verifier.Verify(subject, payload)).ToNot(Succeed())
+		})
+	})
+
+	Describe("NewRequestVerifier", func() {
+		It("errs on a key of an unknown algorithm", func() {
+			signing.Keys[0].Algorithm = "fake-algorithm"
+
+			_, err := NewRequestVerifier(signing, fs, auditLogPath, timeService, boshlog.NewLogger(boshlog.LevelNone))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unknown algorithm 'fake-algorithm'"))
+		})
+
+		It("errs when signing is required without keys", func() {
+			_, err := NewRequestVerifier(boshsettings.RequestSigning{Required: true}, fs, auditLogPath, timeService, boshlog.NewLogger(boshlog.LevelNone))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
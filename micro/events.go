@@ -0,0 +1,167 @@
+package micro
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	maxBufferedEvents = 1000
+
+	defaultEventsWait = 30 * time.Second
+	maxEventsWait     = 5 * time.Minute
+)
+
+// Event is a message sent by the agent, kept until a client acknowledges it
+type Event struct {
+	ID      uint64             `json:"id"`
+	Target  boshhandler.Target `json:"target"`
+	Topic   boshhandler.Topic  `json:"topic"`
+	Message json.RawMessage    `json:"message"`
+	Time    time.Time          `json:"time"`
+}
+
+// EventsResponse is returned by GET /events, Cursor is passed with the next
+// request to acknowledge the events up to it
+type EventsResponse struct {
+	Events  []Event `json:"events"`
+	Cursor  string  `json:"cursor"`
+	Dropped int     `json:"dropped"`
+}
+
+// eventsCursor is <boot-id>:<id>, event ids start over when the agent restarts
+// and the boot id tells the ids of an earlier agent process from the current ones
+type eventsCursor struct {
+	BootID string
+	ID     uint64
+}
+
+func parseEventsCursor(value string) (eventsCursor, error) {
+	if value == "" {
+		return eventsCursor{}, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return eventsCursor{}, bosherr.Errorf("Cursor '%s' is not <boot-id>:<id>", value)
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return eventsCursor{}, bosherr.WrapErrorf(err, "Parsing id of cursor '%s'", value)
+	}
+
+	return eventsCursor{BootID: parts[0], ID: id}, nil
+}
+
+func (c eventsCursor) String() string {
+	return fmt.Sprintf("%s:%d", c.BootID, c.ID)
+}
+
+// eventBuffer keeps the unacknowledged events in memory. Only the latest
+// heartbeat is kept and the oldest event is dropped once it is full.
+type eventBuffer struct {
+	bootID string
+
+	lock    sync.Mutex
+	events  []Event
+	lastID  uint64
+	dropped int
+
+	// closed when an event is added, created by the first waiting request
+	added chan struct{}
+}
+
+// bootID is generated once per agent process, so the cursors of an earlier
+// process are recognized after a restart
+var bootID = newBootID()
+
+func newBootID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// still differs between restarts
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func newEventBuffer() *eventBuffer {
+	return &eventBuffer{bootID: bootID}
+}
+
+func (b *eventBuffer) add(target boshhandler.Target, topic boshhandler.Topic, message json.RawMessage) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if topic == boshhandler.Heartbeat {
+		events := []Event{}
+		for _, event := range b.events {
+			if event.Topic != boshhandler.Heartbeat {
+				events = append(events, event)
+			}
+		}
+		b.events = events
+	}
+
+	b.lastID++
+	b.events = append(b.events, Event{
+		ID:      b.lastID,
+		Target:  target,
+		Topic:   topic,
+		Message: message,
+		Time:    time.Now(),
+	})
+
+	for len(b.events) > maxBufferedEvents {
+		b.events = b.events[1:]
+		b.dropped++
+	}
+
+	if b.added != nil {
+		close(b.added)
+		b.added = nil
+	}
+}
+
+// since acknowledges the events up to the cursor and returns the ones after it.
+// A cursor of another boot id is from before a restart of the agent and
+// acknowledges none of the events of this one, all are returned then.
+// The channel is closed once another event is added.
+func (b *eventBuffer) since(cursor eventsCursor) (EventsResponse, <-chan struct{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if cursor.BootID != b.bootID {
+		cursor = eventsCursor{BootID: b.bootID}
+	}
+
+	events := []Event{}
+	for _, event := range b.events {
+		if event.ID > cursor.ID {
+			events = append(events, event)
+		}
+	}
+	b.events = events
+
+	if len(events) > 0 {
+		cursor.ID = events[len(events)-1].ID
+	}
+
+	response := EventsResponse{Events: events, Cursor: cursor.String(), Dropped: b.dropped}
+
+	if b.added == nil {
+		b.added = make(chan struct{})
+	}
+
+	return response, b.added
+}
@@ -3,11 +3,14 @@ package micro
 import (
 	"bufio"
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
 	boshdispatcher "github.com/cloudfoundry/bosh-agent/httpsdispatcher"
@@ -24,6 +27,10 @@ type HTTPSHandler struct {
 	dispatcher  *boshdispatcher.HTTPSDispatcher
 	fs          boshsys.FileSystem
 	dirProvider boshdir.Provider
+	events      *eventBuffer
+
+	// CN of a client certificate to the actions that client may run, "*" allows all
+	clientActions map[string][]string
 }
 
 func NewHTTPSHandler(
@@ -36,10 +43,39 @@ func NewHTTPSHandler(
 	handler.logger = logger
 	handler.fs = fs
 	handler.dirProvider = dirProvider
+	handler.events = newEventBuffer()
 	handler.dispatcher = boshdispatcher.NewHTTPSDispatcher(parsedURL, logger)
 	return
 }
 
+// NewHTTPSHandlerWithOptions only lets clients with a certificate CN from
+// clientActions in when any are given, this requires a client CA in options
+func NewHTTPSHandlerWithOptions(
+	parsedURL *url.URL,
+	options boshdispatcher.Options,
+	clientActions map[string][]string,
+	logger boshlog.Logger,
+	fs boshsys.FileSystem,
+	dirProvider boshdir.Provider,
+) (HTTPSHandler, error) {
+	handler := NewHTTPSHandler(parsedURL, logger, fs, dirProvider)
+
+	if len(clientActions) > 0 {
+		if options.ClientCA == "" {
+			return handler, bosherr.Error("Client actions require a client CA")
+		}
+		handler.clientActions = clientActions
+	}
+
+	dispatcher, err := boshdispatcher.NewHTTPSDispatcherWithOptions(parsedURL, options, logger)
+	if err != nil {
+		return handler, bosherr.WrapError(err, "Building https dispatcher")
+	}
+	handler.dispatcher = dispatcher
+
+	return handler, nil
+}
+
 func (h HTTPSHandler) Run(handlerFunc boshhandler.Func) error {
 	err := h.Start(handlerFunc)
 	if err != nil {
@@ -51,6 +87,7 @@ func (h HTTPSHandler) Run(handlerFunc boshhandler.Func) error {
 func (h HTTPSHandler) Start(handlerFunc boshhandler.Func) error {
 	h.dispatcher.AddRoute("/agent", h.agentHandler(handlerFunc))
 	h.dispatcher.AddRoute("/blobs/", h.blobsHandler())
+	h.dispatcher.AddRoute("/events", h.eventsHandler())
 	return h.dispatcher.Start()
 }
 
@@ -62,7 +99,18 @@ func (h HTTPSHandler) RegisterAdditionalFunc(handlerFunc boshhandler.Func) {
 	panic("HTTPSHandler does not support registering additional handler funcs")
 }
 
+// Send buffers the message until a client fetches and acknowledges it with GET /events
 func (h HTTPSHandler) Send(target boshhandler.Target, topic boshhandler.Topic, message interface{}) error {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Marshalling message (target=%s, topic=%s): %#v", target, topic, message)
+	}
+
+	h.logger.Info("https_handler", "Buffering %s message '%s'", target, topic)
+	h.logger.DebugWithDetails("https_handler", "Message Payload", string(bytes))
+
+	h.events.add(target, topic, json.RawMessage(bytes))
+
 	return nil
 }
 
@@ -75,6 +123,57 @@ func (h HTTPSHandler) requestNotAuthorized(request *http.Request) bool {
 	return expectedAuthorizationHeader != request.Header.Get("Authorization")
 }
 
+// clientForbidden is true when client actions are configured and the CN of the
+// verified client certificate has none
+func (h HTTPSHandler) clientForbidden(request *http.Request) bool {
+	if h.clientActions == nil {
+		return false
+	}
+
+	commonName, found := h.clientCommonName(request)
+	if !found {
+		return true
+	}
+
+	if _, found = h.clientActions[commonName]; !found {
+		h.logger.Error("https_handler", "Rejecting client '%s' without actions for %s", commonName, request.URL.Path)
+		return true
+	}
+
+	return false
+}
+
+// actionForbidden is true when client actions are configured and the method
+// is not one of the actions for the CN of the verified client certificate
+func (h HTTPSHandler) actionForbidden(request *http.Request, method string) bool {
+	if h.clientActions == nil {
+		return false
+	}
+
+	commonName, found := h.clientCommonName(request)
+	if !found {
+		return true
+	}
+
+	for _, action := range h.clientActions[commonName] {
+		if action == "*" || action == method {
+			return false
+		}
+	}
+
+	h.logger.Error("https_handler", "Rejecting action '%s' of client '%s'", method, commonName)
+	return true
+}
+
+func (h HTTPSHandler) clientCommonName(request *http.Request) (string, bool) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		h.logger.Error("https_handler", "Rejecting client without verified certificate for %s", request.URL.Path)
+		return "", false
+	}
+
+	return request.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
 func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler func(http.ResponseWriter, *http.Request)) {
 	agentHandler = func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -95,6 +194,16 @@ func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler f
 			return
 		}
 
+		var request struct {
+			Method string `json:"method"`
+		}
+		_ = json.Unmarshal(rawJSONPayload, &request)
+
+		if h.actionForbidden(r, request.Method) {
+			w.WriteHeader(403)
+			return
+		}
+
 		respBytes, _, err := boshhandler.PerformHandlerWithJSON(
 			rawJSONPayload,
 			handlerFunc,
@@ -116,8 +225,82 @@ func (h HTTPSHandler) agentHandler(handlerFunc boshhandler.Func) (agentHandler f
 	return
 }
 
+// eventsHandler serves GET /events?cursor=<boot-id>:<id>&wait=<seconds>. The events up to
+// the cursor are acknowledged, the request waits for new events when none are left.
+func (h HTTPSHandler) eventsHandler() (eventsHandler func(http.ResponseWriter, *http.Request)) {
+	eventsHandler = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(404)
+			return
+		}
+
+		if h.requestNotAuthorized(r) {
+			w.Header().Add("WWW-Authenticate", `Basic realm=""`)
+			w.WriteHeader(401)
+			return
+		}
+
+		if h.clientForbidden(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		cursor, err := parseEventsCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		wait := defaultEventsWait
+		if value := r.URL.Query().Get("wait"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				w.WriteHeader(400)
+				return
+			}
+			wait = time.Duration(seconds) * time.Second
+		}
+		if wait > maxEventsWait {
+			wait = maxEventsWait
+		}
+
+		response, added := h.events.since(cursor)
+
+		if len(response.Events) == 0 && wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+
+			select {
+			case <-added:
+				response, _ = h.events.since(cursor)
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		respBytes, err := json.Marshal(response)
+		if err != nil {
+			h.logger.Error("https_handler", "Marshalling events: %s", err.Error())
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err = w.Write(respBytes); err != nil {
+			h.logger.Error("https_handler", "Failed to write response body: %s", err.Error())
+		}
+	}
+	return
+}
+
 func (h HTTPSHandler) blobsHandler() (blobsHandler func(http.ResponseWriter, *http.Request)) {
 	blobsHandler = func(w http.ResponseWriter, r *http.Request) {
+		if h.clientForbidden(r) {
+			w.WriteHeader(403)
+			return
+		}
+
 		switch r.Method {
 		case "GET":
 			h.getBlob(w, r)
@@ -24,23 +24,63 @@ func (a ActionHook) OnStartAction() error {
 
 	// we can not let bosh director to start services on a passive leg
 	// this in fact should never happen as bosh director understands active/passive
-	passive, err := a.dualDCSupport.isPassiveSide()
+	spec, err := a.dualDCSupport.specService.Get()
 	if err != nil {
-		return bosherr.WrapError(err, "chcking if passive side")
+		return bosherr.WrapError(err, "Fetching spec")
 	}
 
-	if passive {
+	// only passive-safe jobs and processes are started there, see ProcessesToStart
+	if spec.IsPassiveSide() && !passiveSafeConfigured(spec) {
 		return errors.New("Can not start services in passive mode!")
 	}
 
 	return nil
 }
 
+// ProcessesToStart returns all=true on the active leg,
+// on the passive leg only the passive-safe processes may be started.
+func (a ActionHook) ProcessesToStart() (processes []string, all bool, err error) {
+	spec, err := a.dualDCSupport.specService.Get()
+	if err != nil {
+		return nil, false, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.IsPassiveSide() {
+		return nil, true, nil
+	}
+
+	processes, err = a.dualDCSupport.PassiveSafeProcesses()
+	if err != nil {
+		return nil, false, bosherr.WrapError(err, "Finding passive-safe processes")
+	}
+
+	a.dualDCSupport.logger.Info(nimbusLogTag, "Passive leg, starting passive-safe processes only: %v", processes)
+
+	return processes, false, nil
+}
+
+// OnJobsStarted completes the cutover of the active leg once all jobs run
+func (a ActionHook) OnJobsStarted() error {
+	return a.dualDCSupport.RecordCutoverJobs(true)
+}
+
+// OnStopAction takes the leg out of DNS before its jobs are stopped,
+// clients must not be sent to a leg whose services are going down
 func (a ActionHook) OnStopAction() error {
 	a.dualDCSupport.logger.Debug(nimbusLogTag, "OnStopAction - begin")
 
-	if err := a.dualDCSupport.StopDNSUpdatesIfRequired(); err != nil {
-		return bosherr.WrapError(err, "Stopping DNS updates if required")
+	// stops the DNS updates as well
+	if err := a.dualDCSupport.DeregisterDNSIfRequired(); err != nil {
+		return bosherr.WrapError(err, "Deregistering DNS if required")
+	}
+
+	return nil
+}
+
+// OnJobsStopped records in the cutover state that the jobs of the leg are down
+func (a ActionHook) OnJobsStopped() error {
+	if err := a.dualDCSupport.RecordCutoverJobs(false); err != nil {
+		return bosherr.WrapError(err, "Recording drbd cutover state")
 	}
 
 	return nil
@@ -49,21 +89,29 @@ func (a ActionHook) OnStopAction() error {
 func (a ActionHook) OnApplyAction() error {
 	a.dualDCSupport.logger.Debug(nimbusLogTag, "OnApplyAction - begin")
 
+	if err := a.dualDCSupport.WithdrawDNSIfPassive(); err != nil {
+		return bosherr.WrapError(err, "Withdrawing DNS registration of passive leg")
+	}
+
 	spec, err := a.dualDCSupport.specService.Get()
 	if err != nil {
 		return bosherr.WrapError(err, "Fetching spec")
 	}
 
-	disk, found := a.dualDCSupport.persistentDiskSettings()
+	_, found := a.dualDCSupport.persistentDiskSettings()
 	if found && spec.DrbdEnabled {
 
-		// when cut-over is done - disks need to be remounted to make sure drbd is setup correctly
-		if _, err := a.platform.UnmountPersistentDisk(disk); err != nil {
-			return bosherr.WrapErrorf(err, "Unmounting persistent disk %s", disk)
+		// options such as the protocol or the resync rate are changed on the running resource
+		applied, err := a.dualDCSupport.ApplyDrbdConfigOnline()
+		if err != nil {
+			return bosherr.WrapError(err, "Applying drbd config online")
 		}
 
-		if err := a.platform.MountPersistentDisk(disk, a.platform.GetDirProvider().StoreDir()); err != nil {
-			return bosherr.WrapErrorf(err, "Mounting persistent disk %s", disk)
+		// when cut-over is done - drbd is set up again and the leg taken to the state of its role
+		if !applied {
+			if err := a.dualDCSupport.RunApplyCutover(); err != nil {
+				return bosherr.WrapError(err, "Running drbd cutover")
+			}
 		}
 
 	}
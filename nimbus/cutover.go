@@ -0,0 +1,330 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// States of the DRBD cutover of a leg, in the order they are reached.
+// The passive leg stops at secondary, the active leg goes on to mounted.
+// Jobs-started is only recorded once the start action started the jobs.
+const (
+	CutoverUnconfigured = "unconfigured"
+	CutoverConfigured   = "configured"
+	CutoverConnected    = "connected"
+	CutoverSecondary    = "secondary"
+	CutoverPrimary      = "primary"
+	CutoverMounted      = "mounted"
+	CutoverJobsStarted  = "jobs-started"
+)
+
+var cutoverStates = []string{
+	CutoverUnconfigured,
+	CutoverConfigured,
+	CutoverConnected,
+	CutoverSecondary,
+	CutoverPrimary,
+	CutoverMounted,
+	CutoverJobsStarted,
+}
+
+// CutoverState is persisted under the bosh dir after every transition,
+// so that an agent restarted halfway can continue the cutover.
+type CutoverState struct {
+	State   string    `json:"state"`
+	Target  string    `json:"target"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"` // of the last failed transition
+}
+
+func cutoverIndex(state string) int {
+	for i, s := range cutoverStates {
+		if s == state {
+			return i
+		}
+	}
+	return 0
+}
+
+func cutoverTarget(spec boshas.V1ApplySpec) string {
+	if spec.IsActiveSide() {
+		return CutoverMounted
+	}
+	return CutoverSecondary
+}
+
+// RunApplyCutover applies the DRBD configuration of a new spec: the store is
+// unmounted and demoted, then the leg is brought up to the state of its role.
+func (d DualDCSupport) RunApplyCutover() error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	state := CutoverState{State: CutoverUnconfigured, Target: cutoverTarget(spec)}
+
+	if _, err = d.unmountDRBD(); err != nil {
+		return d.failCutover(state, CutoverUnconfigured, err)
+	}
+
+	if err = d.saveCutoverState(state); err != nil {
+		return err
+	}
+
+	return d.driveCutover(state)
+}
+
+// ReconcileCutover continues on bootstrap a cutover that was interrupted by a restart
+// of the agent or stopped by a failed transition. A cutover that reached its target is
+// left alone, the jobs and the store are up to the director and the operators then.
+// The leg is taken from what DRBD and the mounts say it is to the state of its role,
+// it is never taken down: a leg found past its target is reported in the state instead.
+func (d DualDCSupport) ReconcileCutover() error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return nil
+	}
+
+	state, found, err := d.loadCutoverState()
+	if err != nil || !found {
+		return err
+	}
+
+	if state.State == state.Target {
+		return nil
+	}
+
+	target := cutoverTarget(spec)
+
+	// the configuration of the spec was never applied
+	observed := CutoverUnconfigured
+	if state.State != CutoverUnconfigured {
+		if observed, err = d.observeCutoverState(); err != nil {
+			return err
+		}
+	}
+
+	d.logger.Info(nimbusLogTag, "Reconciling cutover: persisted '%s', observed '%s', target '%s'", state.State, observed, target)
+
+	state.State = observed
+	state.Target = target
+
+	if cutoverIndex(observed) > cutoverIndex(target) {
+		return d.failCutover(state, target, bosherr.Errorf("Leg is '%s', it is not taken down on bootstrap", observed))
+	}
+
+	if err = d.saveCutoverState(state); err != nil {
+		return err
+	}
+
+	return d.driveCutover(state)
+}
+
+// RecordCutoverJobs follows the start and stop of the jobs on the active leg
+func (d DualDCSupport) RecordCutoverJobs(started bool) error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled || !spec.IsActiveSide() {
+		return nil
+	}
+
+	state := CutoverState{State: CutoverMounted, Target: CutoverMounted}
+	if started {
+		state = CutoverState{State: CutoverJobsStarted, Target: CutoverJobsStarted}
+	}
+
+	return d.saveCutoverState(state)
+}
+
+// Cutover returns the persisted cutover state, nil before the first cutover
+func (d DualDCSupport) Cutover() (*CutoverState, error) {
+	state, found, err := d.loadCutoverState()
+	if err != nil || !found {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (d DualDCSupport) driveCutover(state CutoverState) error {
+	for cutoverIndex(state.State) < cutoverIndex(state.Target) {
+		next := cutoverStates[cutoverIndex(state.State)+1]
+
+		d.logger.Info(nimbusLogTag, "Cutover from '%s' to '%s'", state.State, next)
+
+		if err := d.cutoverTransition(next); err != nil {
+			return d.failCutover(state, next, err)
+		}
+
+		state.State = next
+		state.Error = ""
+		if err := d.saveCutoverState(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) cutoverTransition(state string) error {
+	switch state {
+	case CutoverConfigured:
+		return d.setupDRBD()
+
+	case CutoverConnected:
+		return d.drbdConnect()
+
+	case CutoverSecondary:
+		return d.drbdEnsureSecondary()
+
+	case CutoverPrimary:
+		resource, err := d.DrbdResource()
+		if err != nil {
+			return err
+		}
+		return d.drbdMakePrimary(resource)
+
+	case CutoverMounted:
+		return d.mountDRBD()
+	}
+
+	return bosherr.Errorf("Unknown cutover state '%s'", state)
+}
+
+func (d DualDCSupport) failCutover(state CutoverState, next string, err error) error {
+	state.Error = fmt.Sprintf("%s: %s", next, err)
+	if saveErr := d.saveCutoverState(state); saveErr != nil {
+		d.logger.Error(nimbusLogTag, "Persisting failed cutover: %s", saveErr)
+	}
+
+	return bosherr.WrapErrorf(err, "Cutover to '%s'", next)
+}
+
+// observeCutoverState derives the state from DRBD and the store mount,
+// jobs-started is never observed as the jobs are not checked
+func (d DualDCSupport) observeCutoverState() (string, error) {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return "", err
+	}
+
+	if !d.isDRBDConfigWritten(resource) {
+		return CutoverUnconfigured, nil
+	}
+
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return "", bosherr.WrapError(err, "Getting drbd status")
+	}
+
+	switch status.ConnectionState {
+	case "", "not running", "Unconfigured":
+		return CutoverUnconfigured, nil
+	}
+
+	mounted, err := d.mounter.IsMounted(d.dirProvider.StoreDir())
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Checking if %s is mounted", d.dirProvider.StoreDir())
+	}
+
+	switch {
+	case mounted:
+		return CutoverMounted, nil
+	case status.Role == "Primary":
+		return CutoverPrimary, nil
+	case status.ConnectionState == "StandAlone":
+		return CutoverConfigured, nil
+	}
+
+	return CutoverSecondary, nil
+}
+
+// drbdConnect makes the resource look for its peer, the peer does not need to be up
+func (d DualDCSupport) drbdConnect() error {
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return bosherr.WrapError(err, "Getting drbd status")
+	}
+
+	if status.ConnectionState != "StandAlone" {
+		return nil
+	}
+
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return err
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "connect", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Connecting drbd resource %s", resource.Name)
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) drbdEnsureSecondary() error {
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return bosherr.WrapError(err, "Getting drbd status")
+	}
+
+	if status.Role != "Primary" {
+		return nil
+	}
+
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return err
+	}
+
+	return d.drbdMakeSecondary(resource)
+}
+
+func (d DualDCSupport) cutoverStatePath() string {
+	return filepath.Join(d.dirProvider.BoshDir(), "drbd_cutover.json")
+}
+
+func (d DualDCSupport) loadCutoverState() (state CutoverState, found bool, err error) {
+	if !d.fs.FileExists(d.cutoverStatePath()) {
+		return
+	}
+
+	contents, err := d.fs.ReadFile(d.cutoverStatePath())
+	if err != nil {
+		err = bosherr.WrapError(err, "Reading cutover state")
+		return
+	}
+
+	if err = json.Unmarshal(contents, &state); err != nil {
+		err = bosherr.WrapError(err, "Unmarshalling cutover state")
+		return
+	}
+
+	return state, true, nil
+}
+
+func (d DualDCSupport) saveCutoverState(state CutoverState) error {
+	state.Updated = time.Now()
+
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling cutover state")
+	}
+
+	if err = d.fs.WriteFile(d.cutoverStatePath(), contents); err != nil {
+		return bosherr.WrapError(err, "Writing cutover state")
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"errors"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const procDrbdPrimaryConnected = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+const procDrbdSecondaryStandAlone = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:StandAlone ro:Secondary/Unknown ds:UpToDate/DUnknown r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+const storeMounted = "/dev/drbd1 on /var/vcap/store type ext4 (rw)\n"
+
+var _ = Describe("Cutover", func() {
+	var (
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+		jobSupervisor *fakejobsuper.FakeJobSupervisor
+		dualDCSupport *DualDCSupport
+	)
+
+	persist := func(state CutoverState) {
+		contents, err := json.Marshal(state)
+		Expect(err).ToNot(HaveOccurred())
+		fs.WriteFile("/var/vcap/bosh/drbd_cutover.json", contents)
+	}
+
+	persisted := func() *CutoverState {
+		state, err := dualDCSupport.Cutover()
+		Expect(err).ToNot(HaveOccurred())
+		return state
+	}
+
+	BeforeEach(func() {
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		fs = fakesys.NewFakeFileSystem()
+		fs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+		fs.WriteFileString("/proc/drbd", procDrbdSecondaryWFConnection)
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{Passive: "disabled", DrbdEnabled: true}
+
+		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		dualDCSupport.SetJobSupervisor(jobSupervisor)
+	})
+
+	Describe("ReconcileCutover", func() {
+		It("does nothing before the first cutover", func() {
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+			Expect(persisted()).To(BeNil())
+		})
+
+		It("continues from the observed state to the target of the active leg", func() {
+			persist(CutoverState{State: CutoverConnected, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "primary", "r0"}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"mount", "/dev/drbd1", "/var/vcap/store"}))
+			Expect(persisted().State).To(Equal(CutoverMounted))
+			Expect(persisted().Error).To(BeEmpty())
+			Expect(jobSupervisor.Started).To(BeFalse())
+		})
+
+		It("connects a resource left standalone", func() {
+			fs.WriteFileString("/proc/drbd", procDrbdSecondaryStandAlone)
+			persist(CutoverState{State: CutoverConfigured, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands[0]).To(Equal([]string{"mount"}))
+			Expect(cmdRunner.RunCommands[1]).To(Equal([]string{"drbdadm", "connect", "r0"}))
+		})
+
+		It("leaves the jobs alone on a normal restart", func() {
+			fs.WriteFileString("/proc/drbd", procDrbdPrimaryConnected)
+			cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: storeMounted, Sticky: true})
+			persist(CutoverState{State: CutoverJobsStarted, Target: CutoverJobsStarted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(jobSupervisor.Started).To(BeFalse())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+			Expect(persisted().State).To(Equal(CutoverJobsStarted))
+		})
+
+		It("leaves the jobs stopped by an operator alone on a normal restart", func() {
+			persist(CutoverState{State: CutoverMounted, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(jobSupervisor.Started).To(BeFalse())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("does not unmount the store on a normal restart of a leg that became passive", func() {
+			specService.Spec.Passive = "enabled"
+			fs.WriteFileString("/proc/drbd", procDrbdPrimaryConnected)
+			cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: storeMounted, Sticky: true})
+			persist(CutoverState{State: CutoverMounted, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+			Expect(persisted().State).To(Equal(CutoverMounted))
+		})
+
+		It("reports an interrupted cutover past its target instead of unmounting", func() {
+			specService.Spec.Passive = "enabled"
+			fs.WriteFileString("/proc/drbd", procDrbdPrimaryConnected)
+			cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: storeMounted, Sticky: true})
+			persist(CutoverState{State: CutoverConnected, Target: CutoverSecondary})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Leg is 'mounted', it is not taken down on bootstrap"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"umount", "/var/vcap/store"}))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "secondary", "r0"}))
+			Expect(persisted().State).To(Equal(CutoverMounted))
+			Expect(persisted().Target).To(Equal(CutoverSecondary))
+			Expect(persisted().Error).To(ContainSubstring("not taken down"))
+		})
+
+		It("records the failed transition", func() {
+			cmdRunner.AddCmdResult("drbdadm primary r0", fakesys.FakeCmdResult{Error: errors.New("fake-primary-error")})
+			persist(CutoverState{State: CutoverSecondary, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Cutover to 'primary'"))
+
+			Expect(persisted().State).To(Equal(CutoverSecondary))
+			Expect(persisted().Error).To(ContainSubstring("primary: fake-primary-error"))
+		})
+
+		It("does nothing without drbd", func() {
+			specService.Spec.DrbdEnabled = false
+			persist(CutoverState{State: CutoverSecondary, Target: CutoverMounted})
+
+			err := dualDCSupport.ReconcileCutover()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+	})
+
+	Describe("RecordCutoverJobs", func() {
+		It("follows the jobs of the active leg", func() {
+			Expect(dualDCSupport.RecordCutoverJobs(true)).To(Succeed())
+			Expect(persisted().State).To(Equal(CutoverJobsStarted))
+
+			Expect(dualDCSupport.RecordCutoverJobs(false)).To(Succeed())
+			Expect(persisted().State).To(Equal(CutoverMounted))
+		})
+
+		It("is not recorded on the passive leg", func() {
+			specService.Spec.Passive = "enabled"
+
+			Expect(dualDCSupport.RecordCutoverJobs(true)).To(Succeed())
+			Expect(persisted()).To(BeNil())
+		})
+	})
+})
@@ -4,14 +4,50 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
-	"github.com/cloudfoundry/bosh-utils/system"
 )
 
 const dnsUpdateInterval = 60 * time.Second
 
+// dnsUpdater is shared by every copy of DualDCSupport, the updates are started and
+// stopped concurrently by actions, switchover steps, the peer monitor and agent shutdown.
+type dnsUpdater struct {
+	lock       sync.Mutex
+	cancelChan chan struct{}
+	doneChan   chan struct{}
+}
+
+func newDNSUpdater() *dnsUpdater {
+	return &dnsUpdater{}
+}
+
+// running, start and stop are called with lock held
+func (u *dnsUpdater) running() bool {
+	return u.cancelChan != nil
+}
+
+func (u *dnsUpdater) start(run func(cancelChan, doneChan chan struct{})) {
+	u.cancelChan = make(chan struct{})
+	u.doneChan = make(chan struct{})
+	go run(u.cancelChan, u.doneChan)
+}
+
+// stop waits for an update in flight so it can not re-add a record deleted afterwards
+func (u *dnsUpdater) stop() {
+	if u.cancelChan == nil {
+		return
+	}
+
+	close(u.cancelChan)
+	<-u.doneChan
+
+	u.cancelChan = nil
+	u.doneChan = nil
+}
+
 func (r *DualDCSupport) StartDNSUpdatesIfRequired() (err error) {
 	r.logger.Debug(nimbusLogTag, "StartDNSUpdatesIfRequired - begin")
 	var enabled bool
@@ -20,12 +56,12 @@ func (r *DualDCSupport) StartDNSUpdatesIfRequired() (err error) {
 	}
 
 	if enabled {
-		if r.cancelChan != nil {
-			close(r.cancelChan)
-			r.cancelChan = nil
-		}
-		r.cancelChan = make(chan struct{})
-		go r.runPeriodicUpdates(r.cancelChan)
+		r.dnsUpdates.lock.Lock()
+		defer r.dnsUpdates.lock.Unlock()
+
+		r.dnsUpdates.stop()
+		r.dnsHealth.reset()
+		r.dnsUpdates.start(r.runPeriodicUpdates)
 	}
 
 	return
@@ -38,105 +74,278 @@ func (r *DualDCSupport) StopDNSUpdatesIfRequired() (err error) {
 		return
 	}
 
-	if enabled && r.cancelChan != nil {
-		close(r.cancelChan)
-		r.cancelChan = nil
+	if enabled {
+		r.dnsUpdates.lock.Lock()
+		defer r.dnsUpdates.lock.Unlock()
+
+		r.dnsUpdates.stop()
+	}
+
+	return
+}
+
+// DeregisterDNSIfRequired removes the record of the active leg from all DNS servers,
+// it is called when the leg stops, is demoted or the agent shuts down.
+func (r *DualDCSupport) DeregisterDNSIfRequired() (err error) {
+	r.logger.Debug(nimbusLogTag, "DeregisterDNSIfRequired - begin")
+	var enabled bool
+	if enabled, err = r.dnsUpdatesEnabled(); err != nil {
+		return
+	}
+
+	if enabled {
+		// held until the record is gone, updates started meanwhile would add it again
+		r.dnsUpdates.lock.Lock()
+		defer r.dnsUpdates.lock.Unlock()
+
+		r.dnsUpdates.stop()
+		err = r.deregisterAllDNSServers()
 	}
 
 	return
 }
 
+// WithdrawDNSIfPassive stops the updates and removes the record when the leg
+// was registering itself and the spec made it passive since.
+func (r *DualDCSupport) WithdrawDNSIfPassive() error {
+	spec, err := r.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	r.dnsUpdates.lock.Lock()
+	defer r.dnsUpdates.lock.Unlock()
+
+	if !spec.IsPassiveSide() || !r.dnsUpdates.running() {
+		return nil
+	}
+
+	r.logger.Info(nimbusLogTag, "Leg became passive, withdrawing DNS registration of '%s'", dnsRegistrationNames(spec))
+
+	r.dnsUpdates.stop()
+
+	return r.deregisterAllDNSServers()
+}
+
 func (r DualDCSupport) dnsUpdatesEnabled() (enabled bool, err error) {
 	spec, err := r.specService.Get()
 	if err != nil {
 		return false, bosherr.WrapError(err, "Fetching spec")
 	}
 
-	return spec.IsActiveSide() && spec.DNSRegisterOnStart != "", nil
+	return spec.IsActiveSide() && dnsRegistrationEnabled(spec), nil
 }
 
-func (r DualDCSupport) runPeriodicUpdates(cancelChan chan struct{}) {
+func (r DualDCSupport) runPeriodicUpdates(cancelChan, doneChan chan struct{}) {
+	defer close(doneChan)
+
 	tickChan := time.Tick(dnsUpdateInterval)
+	healthTickChan := time.Tick(dnsHealthCheckInterval)
 
-	r.updateAllDNSServers()
+	// with health checks the first registration waits for a healthy check
+	r.refreshDNSRegistration()
+	r.checkDNSHealthGate()
 
 	for {
 		select {
 		case <-tickChan:
-			err := r.updateAllDNSServers()
-			if err != nil {
-				r.logger.Error(nimbusLogTag, "Error updating DNS: %s, will try again in: %d s", err, dnsUpdateInterval)
-			}
+			r.refreshDNSRegistration()
+		case <-healthTickChan:
+			r.checkDNSHealthGate()
 		case <-cancelChan:
 			return
 		}
 	}
 }
 
-func (r DualDCSupport) updateAllDNSServers() (err error) {
+// updateAllDNSServers returns how many servers accepted the update
+func (r DualDCSupport) updateAllDNSServers() (accepted int, err error) {
 
 	spec, err := r.specService.Get()
 	if err != nil {
-		return bosherr.WrapError(err, "Fetching spec")
+		return 0, bosherr.WrapError(err, "Fetching spec")
 	}
 
 	dnsSpec := spec.PropertiesSpec.DNSSpec
-	if len(dnsSpec.DNSServers) == 0 || dnsSpec.Key == "" || dnsSpec.TTL == 0 {
-		r.logger.Error(nimbusLogTag, "dnsSpec.DNSServers or dnsSpec.Key or dnsSpec.TTL empty")
-		return errors.New("dnsSpec.DNSServers or dnsSpec.Key or dnsSpec.TTL empty")
+	if len(dnsSpec.DNSServers) == 0 || dnsSpec.Key == "" {
+		r.logger.Error(nimbusLogTag, "dnsSpec.DNSServers or dnsSpec.Key empty")
+		return 0, errors.New("dnsSpec.DNSServers or dnsSpec.Key empty")
 	}
 
-	thisHostIP, err := r.thisHostIP()
+	key, err := parseDNSKey(dnsSpec.Key)
 	if err != nil {
 		return
 	}
 
+	// a record that does not render must not keep the others from being registered
+	rrsets, renderErr := r.dnsRRsets(spec)
+	if renderErr != nil {
+		r.logger.Error(nimbusLogTag, "error rendering dns records: %s", renderErr)
+		if len(rrsets) == 0 {
+			return 0, renderErr
+		}
+	}
+
+	names := dnsRegistrationNames(spec)
+
+	// a failing server must not keep the others from being updated
+	failed := []string{}
 	for _, dnsServer := range dnsSpec.DNSServers {
-		err = r.updateDNSServer(spec.DNSRegisterOnStart, thisHostIP, dnsServer, dnsSpec.Key, dnsSpec.TTL)
+		attempt := time.Now()
+		err = r.updateDNSServer(rrsets, dnsServer, key)
+		r.dnsStatus.record(dnsServer, attempt, err)
 		if err != nil {
-			r.logger.Error(nimbusLogTag, "error updating dns server: %s, name: %s, error: %s", dnsServer, spec.DNSRegisterOnStart, err)
-			return
+			r.logger.Error(nimbusLogTag, "error updating dns server: %s, name: %s, error: %s", dnsServer, names, err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", dnsServer, err))
+			continue
 		}
+		accepted++
 	}
 
-	return
+	if len(failed) > 0 {
+		return accepted, bosherr.Errorf("Registering '%s' failed on dns servers: %s", names, strings.Join(failed, ", "))
+	}
+
+	return accepted, renderErr
+}
+
+func (r DualDCSupport) deregisterAllDNSServers() error {
+	spec, err := r.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	dnsSpec := spec.PropertiesSpec.DNSSpec
+	if len(dnsSpec.DNSServers) == 0 || dnsSpec.Key == "" {
+		return errors.New("dnsSpec.DNSServers or dnsSpec.Key empty")
+	}
+
+	key, err := parseDNSKey(dnsSpec.Key)
+	if err != nil {
+		return err
+	}
+
+	// records that do not render can not be removed, the others still are
+	rrsets, renderErr := r.dnsRRsets(spec)
+	if renderErr != nil {
+		r.logger.Error(nimbusLogTag, "error rendering dns records: %s", renderErr)
+		if len(rrsets) == 0 {
+			return renderErr
+		}
+	}
+
+	names := dnsRegistrationNames(spec)
+
+	// every server is tried, a server that is down must not keep the record on the others
+	failed := []string{}
+	for _, dnsServer := range dnsSpec.DNSServers {
+		err = r.deregisterDNSServer(rrsets, dnsServer, key)
+		if err != nil {
+			r.logger.Error(nimbusLogTag, "error deregistering from dns server: %s, name: %s, error: %s", dnsServer, names, err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", dnsServer, err))
+			continue
+		}
+
+		r.logger.Info(nimbusLogTag, "Deregistered '%s' from dns server %s", names, dnsServer)
+	}
+
+	if len(failed) > 0 {
+		return bosherr.Errorf("Deregistering '%s' failed on dns servers: %s", names, strings.Join(failed, ", "))
+	}
+
+	r.dnsHealth.setRegistered(false)
+
+	return renderErr
 }
 
-func (r DualDCSupport) updateDNSServer(nameToRegister, ip, dnsServer, dnsKey string, ttl int) (err error) {
+// deregisterDNSServer deletes the records of this host only while an RRset
+// still consists of them, a failed prerequisite means the name was taken
+// over by the other leg. Every RRset is a separate update for that reason,
+// and one that fails does not keep the others from being deleted.
+func (r DualDCSupport) deregisterDNSServer(rrsets []dnsRRset, dnsServer string, key dnsTSIGKey) error {
+	client := newDNSUpdateClient(dnsServer, key)
+	zones := map[string]string{}
+	failed := []string{}
 
-	var tmpFile system.File
-	if tmpFile, err = r.fs.TempFile("dnsRegisterOnStart-"); err != nil {
-		return
+	for _, rrset := range rrsets {
+		zone, err := r.dnsZone(client, zones, rrset.Name)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", rrset.Name, err))
+			continue
+		}
+
+		update := newDNSUpdate(zone)
+		for _, record := range rrset.Records {
+			update.RRsetExistsWithValue(record)
+		}
+		for _, record := range rrset.Records {
+			update.DeleteRR(record)
+		}
+
+		err = client.Update(update)
+		if updateErr, ok := err.(DNSUpdateError); ok && updateErr.PrerequisiteFailed() {
+			r.logger.Info(nimbusLogTag, "'%s' is not registered by this host on dns server %s, nothing to deregister", rrset.Name, dnsServer)
+			continue
+		}
+
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", rrset.Name, err))
+		}
 	}
-	defer r.fs.RemoveAll(tmpFile.Name())
 
-	idx := strings.Index(nameToRegister, ".")
-	zone := nameToRegister[idx+1:]
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
 
-	configBody := fmt.Sprintf(
-		dnsConfigTemplate,
-		dnsServer,
-		zone,
-		nameToRegister,
-		nameToRegister,
-		ttl,
-		ip,
-	)
+	return nil
+}
 
-	if err = r.fs.WriteFileString(tmpFile.Name(), configBody); err != nil {
-		return
+// updateDNSServer replaces the RRsets of this host, each in an update of its own
+// so that one the server rejects does not keep the others from being registered
+func (r DualDCSupport) updateDNSServer(rrsets []dnsRRset, dnsServer string, key dnsTSIGKey) error {
+	client := newDNSUpdateClient(dnsServer, key)
+	zones := map[string]string{}
+	failed := []string{}
+
+	for _, rrset := range rrsets {
+		zone, err := r.dnsZone(client, zones, rrset.Name)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", rrset.Name, err))
+			continue
+		}
+
+		update := newDNSUpdate(zone)
+
+		// records cannot be added next to a CNAME
+		update.RRsetDoesNotExist(rrset.Name, dnsTypeCNAME)
+
+		update.DeleteRRset(rrset.Name, rrset.Type)
+		for _, record := range rrset.Records {
+			update.Add(record)
+		}
+
+		if err = client.Update(update); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", rrset.Name, err))
+		}
 	}
 
-	_, _, _, err = r.cmdRunner.RunCommand("nsupdate", "-t", "4", "-y", dnsKey, "-v", tmpFile.Name())
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
 
-	return
+	return nil
 }
 
-const dnsConfigTemplate = `
-server %s
-zone %s
-update delete %s A
-update add %s %d A %s
-send
+func (r DualDCSupport) dnsZone(client dnsUpdateClient, zones map[string]string, name string) (string, error) {
+	if zone, found := zones[name]; found {
+		return zone, nil
+	}
+
+	zone, err := client.FindZone(name)
+	if err != nil {
+		return "", err
+	}
 
-`
+	zones[name] = zone
+	return zone, nil
+}
@@ -0,0 +1,206 @@
+package nimbus
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+)
+
+const (
+	dnsHealthCheckInterval = 10 * time.Second
+
+	defaultDNSHealthCheckTimeout = 5 * time.Second
+	defaultDNSWithdrawAfter      = 3
+)
+
+// DNSHealthStatus is the local health gating the DNS registration of this leg
+type DNSHealthStatus struct {
+	Healthy             bool       `json:"healthy"`
+	Registered          bool       `json:"registered"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Failures            []string   `json:"failures,omitempty"` // why the last check failed
+}
+
+type dnsHealthTracker struct {
+	lock   sync.Mutex
+	status DNSHealthStatus
+}
+
+func newDNSHealthTracker() *dnsHealthTracker {
+	return &dnsHealthTracker{}
+}
+
+func (t *dnsHealthTracker) record(checked time.Time, failures []string) DNSHealthStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.status.LastCheck = &checked
+	t.status.Healthy = len(failures) == 0
+	t.status.Failures = failures
+
+	if t.status.Healthy {
+		t.status.ConsecutiveFailures = 0
+	} else {
+		t.status.ConsecutiveFailures++
+	}
+
+	return t.status
+}
+
+func (t *dnsHealthTracker) setRegistered(registered bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.Registered = registered
+}
+
+func (t *dnsHealthTracker) get() DNSHealthStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status
+}
+
+func (t *dnsHealthTracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status = DNSHealthStatus{}
+}
+
+// SetJobSupervisor provides the job status for health gated DNS registration,
+// the job supervisor is created after dual DC support.
+func (d *DualDCSupport) SetJobSupervisor(jobSupervisor boshjobsuper.JobSupervisor) {
+	d.jobSupervisor = jobSupervisor
+}
+
+// DNSHealth returns the health gating the DNS registration,
+// nil when registration is not gated on health checks.
+func (d DualDCSupport) DNSHealth() (*DNSHealthStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dnsRegistrationEnabled(spec) || !dnsRegistrationGated(spec) {
+		return nil, nil
+	}
+
+	status := d.dnsHealth.get()
+	return &status, nil
+}
+
+// checkDNSHealthGate registers this leg once it is healthy and withdraws the
+// record after withdraw_after failed checks in a row.
+func (d DualDCSupport) checkDNSHealthGate() {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to check dns health: %s", err)
+		return
+	}
+
+	if !dnsRegistrationGated(spec) {
+		return
+	}
+
+	status := d.dnsHealth.record(time.Now(), d.dnsHealthFailures(spec.PropertiesSpec.DNSSpec.HealthCheck))
+
+	switch {
+	case status.Healthy && !status.Registered:
+		d.logger.Info(nimbusLogTag, "Leg is healthy, registering '%s'", dnsRegistrationNames(spec))
+		d.registerDNS()
+
+	case !status.Healthy && status.Registered && status.ConsecutiveFailures >= dnsWithdrawAfter(spec):
+		d.logger.Info(nimbusLogTag, "Leg failed %d health checks, withdrawing '%s': %v", status.ConsecutiveFailures, dnsRegistrationNames(spec), status.Failures)
+
+		if err = d.deregisterAllDNSServers(); err != nil {
+			d.logger.Error(nimbusLogTag, "Withdrawing dns registration: %s", err)
+		}
+
+	case !status.Healthy:
+		d.logger.Info(nimbusLogTag, "Leg failed %d health checks: %v", status.ConsecutiveFailures, status.Failures)
+	}
+}
+
+// refreshDNSRegistration re-registers periodically, while health checks fail
+// the record is left as it is until it is withdrawn
+func (d DualDCSupport) refreshDNSRegistration() {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to refresh dns registration: %s", err)
+		return
+	}
+
+	if dnsRegistrationGated(spec) && !d.dnsHealth.get().Healthy {
+		return
+	}
+
+	d.registerDNS()
+}
+
+func (d DualDCSupport) registerDNS() {
+	accepted, err := d.updateAllDNSServers()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Error updating DNS: %s, will try again in: %d s", err, dnsUpdateInterval)
+	}
+
+	// also after a partial failure, the record is on the servers that accepted it
+	d.dnsHealth.setRegistered(accepted > 0)
+}
+
+func (d DualDCSupport) dnsHealthFailures(healthCheck boshas.DNSHealthCheckSpec) []string {
+	var failures []string
+
+	timeout := defaultDNSHealthCheckTimeout
+	if healthCheck.Timeout > 0 {
+		timeout = time.Duration(healthCheck.Timeout) * time.Second
+	}
+
+	if healthCheck.Jobs {
+		if d.jobSupervisor == nil {
+			failures = append(failures, "job supervisor not available")
+		} else if status := d.jobSupervisor.Status(); status != "running" {
+			failures = append(failures, fmt.Sprintf("jobs are %s", status))
+		}
+	}
+
+	client := http.Client{Timeout: timeout}
+	for _, url := range healthCheck.HTTP {
+		resp, err := client.Get(url)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("http %s: %s", url, err))
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			failures = append(failures, fmt.Sprintf("http %s: status %d", url, resp.StatusCode))
+		}
+	}
+
+	for _, address := range healthCheck.TCP {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("tcp %s: %s", address, err))
+			continue
+		}
+		conn.Close()
+	}
+
+	return failures
+}
+
+func dnsRegistrationGated(spec boshas.V1ApplySpec) bool {
+	healthCheck := spec.PropertiesSpec.DNSSpec.HealthCheck
+	return healthCheck.Jobs || len(healthCheck.HTTP) > 0 || len(healthCheck.TCP) > 0
+}
+
+func dnsWithdrawAfter(spec boshas.V1ApplySpec) int {
+	if withdrawAfter := spec.PropertiesSpec.DNSSpec.HealthCheck.WithdrawAfter; withdrawAfter > 0 {
+		return withdrawAfter
+	}
+	return defaultDNSWithdrawAfter
+}
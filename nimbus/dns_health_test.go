@@ -0,0 +1,197 @@
+package nimbus
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DNSHealth", func() {
+	var (
+		server        *fakeDNSServer
+		specService   *fakeas.FakeV1Service
+		jobSupervisor *fakejobsuper.FakeJobSupervisor
+		dualDCSupport *DualDCSupport
+	)
+
+	BeforeEach(func() {
+		key, err := parseDNSKey("update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")))
+		Expect(err).ToNot(HaveOccurred())
+
+		server = newFakeDNSServer("example.com", key)
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive:            "disabled",
+			DNSRegisterOnStart: "app.dc1.example.com",
+			PropertiesSpec: boshas.PropertiesSpec{
+				DNSSpec: boshas.DNSSpec{
+					DNSServers: []string{server.Addr()},
+					Key:        "update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")),
+					TTL:        30,
+					HealthCheck: boshas.DNSHealthCheckSpec{
+						Jobs:          true,
+						WithdrawAfter: 2,
+					},
+				},
+			},
+		}
+
+		settingsService := &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Networks = boshsettings.Networks{
+			"default": boshsettings.Network{IP: "10.76.245.71"},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fakesys.NewFakeFileSystem(),
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+		dualDCSupport.SetJobSupervisor(jobSupervisor)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	health := func() DNSHealthStatus {
+		status, err := dualDCSupport.DNSHealth()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).ToNot(BeNil())
+		return *status
+	}
+
+	Describe("checkDNSHealthGate", func() {
+		It("does not register while jobs are not running", func() {
+			jobSupervisor.StatusStatus = "failing"
+
+			dualDCSupport.refreshDNSRegistration()
+			dualDCSupport.checkDNSHealthGate()
+
+			Expect(server.Updates()).To(BeEmpty())
+			Expect(health().Healthy).To(BeFalse())
+			Expect(health().Registered).To(BeFalse())
+			Expect(health().Failures).To(Equal([]string{"jobs are failing"}))
+		})
+
+		It("registers once jobs are running", func() {
+			jobSupervisor.StatusStatus = "failing"
+			dualDCSupport.checkDNSHealthGate()
+
+			jobSupervisor.StatusStatus = "running"
+			dualDCSupport.checkDNSHealthGate()
+			dualDCSupport.checkDNSHealthGate()
+
+			Expect(server.Updates()).To(HaveLen(1))
+			Expect(server.Updates()[0].Authority[1].Class).To(Equal(dnsClassIN))
+			Expect(health().Healthy).To(BeTrue())
+			Expect(health().Registered).To(BeTrue())
+			Expect(health().ConsecutiveFailures).To(Equal(0))
+			Expect(health().LastCheck).ToNot(BeNil())
+		})
+
+		It("withdraws the record after withdraw_after failed checks", func() {
+			jobSupervisor.StatusStatus = "running"
+			dualDCSupport.checkDNSHealthGate()
+
+			jobSupervisor.StatusStatus = "failing"
+			dualDCSupport.checkDNSHealthGate()
+			Expect(server.Updates()).To(HaveLen(1))
+
+			dualDCSupport.refreshDNSRegistration()
+			Expect(server.Updates()).To(HaveLen(1))
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(server.Updates()).To(HaveLen(2))
+			Expect(server.Updates()[1].Authority[0].Class).To(Equal(dnsClassNONE))
+			Expect(health().Registered).To(BeFalse())
+			Expect(health().ConsecutiveFailures).To(Equal(2))
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(server.Updates()).To(HaveLen(2))
+		})
+
+		It("does not count as registered when every server refused the update", func() {
+			server.SetUpdateRcode(DNSRcodeRefused)
+			jobSupervisor.StatusStatus = "running"
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(server.Updates()).To(HaveLen(1))
+			Expect(health().Healthy).To(BeTrue())
+			Expect(health().Registered).To(BeFalse())
+
+			server.SetUpdateRcode(DNSRcodeNoError)
+			dualDCSupport.checkDNSHealthGate()
+			Expect(server.Updates()).To(HaveLen(2))
+			Expect(health().Registered).To(BeTrue())
+		})
+
+		It("requires http probes to succeed", func() {
+			status := http.StatusServiceUnavailable
+			probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			defer probe.Close()
+
+			specService.Spec.PropertiesSpec.DNSSpec.HealthCheck = boshas.DNSHealthCheckSpec{HTTP: []string{probe.URL + "/health"}}
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(health().Failures).To(Equal([]string{"http " + probe.URL + "/health: status 503"}))
+			Expect(server.Updates()).To(BeEmpty())
+
+			status = http.StatusOK
+			dualDCSupport.checkDNSHealthGate()
+			Expect(health().Healthy).To(BeTrue())
+			Expect(server.Updates()).To(HaveLen(1))
+		})
+
+		It("requires tcp probes to connect", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			address := listener.Addr().String()
+
+			specService.Spec.PropertiesSpec.DNSSpec.HealthCheck = boshas.DNSHealthCheckSpec{TCP: []string{address}}
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(health().Healthy).To(BeTrue())
+
+			listener.Close()
+
+			dualDCSupport.checkDNSHealthGate()
+			Expect(health().Healthy).To(BeFalse())
+			Expect(health().Failures[0]).To(ContainSubstring("tcp " + address))
+		})
+	})
+
+	Describe("refreshDNSRegistration", func() {
+		It("registers right away when no health check is set", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.HealthCheck = boshas.DNSHealthCheckSpec{}
+
+			dualDCSupport.refreshDNSRegistration()
+			Expect(server.Updates()).To(HaveLen(1))
+
+			status, err := dualDCSupport.DNSHealth()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(BeNil())
+		})
+	})
+})
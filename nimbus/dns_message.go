@@ -0,0 +1,488 @@
+package nimbus
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// Just enough of the DNS wire format (RFC 1035) to send RFC 2136 updates
+// signed with RFC 8945 TSIG and to look up the SOA of the zone to update.
+
+const (
+	dnsTypeA     uint16 = 1
+	dnsTypeCNAME uint16 = 5
+	dnsTypeSOA   uint16 = 6
+	dnsTypeTSIG  uint16 = 250
+	dnsTypeANY   uint16 = 255
+
+	dnsClassIN   uint16 = 1
+	dnsClassNONE uint16 = 254
+	dnsClassANY  uint16 = 255
+
+	dnsOpcodeQuery  = 0
+	dnsOpcodeUpdate = 5
+
+	dnsFlagResponse  uint16 = 1 << 15
+	dnsFlagTruncated uint16 = 1 << 9
+
+	dnsHeaderLen = 12
+
+	dnsTSIGFudge = 300
+
+	// HMAC-MD5 keeps the name it had before RFC 4635
+	dnsTSIGHMACMD5 = "hmac-md5.sig-alg.reg.int"
+)
+
+var dnsTSIGAlgorithms = map[string]func() hash.Hash{
+	dnsTSIGHMACMD5: md5.New,
+	"hmac-sha256":  sha256.New,
+	"hmac-sha512":  sha512.New,
+}
+
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+type dnsRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// dnsMessage uses the query section names, for updates Question is the zone
+// section, Answer the prerequisite section and Authority the update section.
+type dnsMessage struct {
+	ID         uint16
+	Flags      uint16
+	Question   []dnsQuestion
+	Answer     []dnsRR
+	Authority  []dnsRR
+	Additional []dnsRR
+
+	// set when parsing a signed message
+	TSIG      *dnsTSIG
+	tsigStart int
+}
+
+type dnsTSIG struct {
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OrigID     uint16
+	Error      uint16
+	Other      []byte
+}
+
+type dnsTSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+func (m dnsMessage) Opcode() int {
+	return int(m.Flags>>11) & 0xf
+}
+
+func (m dnsMessage) Rcode() int {
+	return int(m.Flags & 0xf)
+}
+
+func dnsFlags(opcode, rcode int) uint16 {
+	return uint16(opcode&0xf)<<11 | uint16(rcode&0xf)
+}
+
+func (m dnsMessage) pack() (msg []byte, err error) {
+	msg = make([]byte, dnsHeaderLen, 512)
+	binary.BigEndian.PutUint16(msg[0:], m.ID)
+	binary.BigEndian.PutUint16(msg[2:], m.Flags)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(m.Question)))
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(m.Answer)))
+	binary.BigEndian.PutUint16(msg[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(m.Additional)))
+
+	for _, q := range m.Question {
+		if msg, err = appendDNSName(msg, q.Name); err != nil {
+			return
+		}
+		msg = appendUint16(msg, q.Type)
+		msg = appendUint16(msg, q.Class)
+	}
+
+	for _, section := range [][]dnsRR{m.Answer, m.Authority, m.Additional} {
+		for _, rr := range section {
+			if msg, err = appendDNSRR(msg, rr); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func parseDNSMessage(msg []byte) (m dnsMessage, err error) {
+	if len(msg) < dnsHeaderLen {
+		err = errors.New("DNS message too short")
+		return
+	}
+
+	m.ID = binary.BigEndian.Uint16(msg[0:])
+	m.Flags = binary.BigEndian.Uint16(msg[2:])
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+	nsCount := int(binary.BigEndian.Uint16(msg[8:]))
+	arCount := int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := dnsHeaderLen
+	for i := 0; i < qdCount; i++ {
+		var q dnsQuestion
+		if q.Name, off, err = readDNSName(msg, off); err != nil {
+			return
+		}
+		if off+4 > len(msg) {
+			err = errors.New("DNS question truncated")
+			return
+		}
+		q.Type = binary.BigEndian.Uint16(msg[off:])
+		q.Class = binary.BigEndian.Uint16(msg[off+2:])
+		off += 4
+		m.Question = append(m.Question, q)
+	}
+
+	if m.Answer, off, err = readDNSRRs(msg, off, anCount); err != nil {
+		return
+	}
+
+	if m.Authority, off, err = readDNSRRs(msg, off, nsCount); err != nil {
+		return
+	}
+
+	var rr dnsRR
+	for i := 0; i < arCount; i++ {
+		start := off
+		if rr, off, err = readDNSRR(msg, off); err != nil {
+			return
+		}
+
+		// TSIG has to be the last record, it is not part of the signed data
+		if rr.Type == dnsTypeTSIG && i == arCount-1 {
+			var tsig dnsTSIG
+			if tsig, err = parseDNSTSIG(msg, off-len(rr.Data)); err != nil {
+				return
+			}
+			m.TSIG = &tsig
+			m.tsigStart = start
+			continue
+		}
+		m.Additional = append(m.Additional, rr)
+	}
+
+	return
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendDNSName writes name uncompressed, with or without the trailing dot
+func appendDNSName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, bosherr.Errorf("DNS name '%s' too long", name)
+	}
+
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, bosherr.Errorf("Invalid DNS name '%s'", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+
+	return append(b, 0), nil
+}
+
+func readDNSName(msg []byte, off int) (name string, next int, err error) {
+	labels := []string{}
+	next = -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("DNS name truncated")
+		}
+
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("DNS name truncated")
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("DNS name compression loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+
+		case length > 63:
+			return "", 0, errors.New("Invalid DNS label")
+
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("DNS name truncated")
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+func appendDNSRR(b []byte, rr dnsRR) ([]byte, error) {
+	b, err := appendDNSName(b, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	b = appendUint16(b, rr.Type)
+	b = appendUint16(b, rr.Class)
+	b = appendUint32(b, rr.TTL)
+	b = appendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+func readDNSRR(msg []byte, off int) (rr dnsRR, next int, err error) {
+	if rr.Name, off, err = readDNSName(msg, off); err != nil {
+		return
+	}
+
+	if off+10 > len(msg) {
+		err = errors.New("DNS record truncated")
+		return
+	}
+
+	rr.Type = binary.BigEndian.Uint16(msg[off:])
+	rr.Class = binary.BigEndian.Uint16(msg[off+2:])
+	rr.TTL = binary.BigEndian.Uint32(msg[off+4:])
+	length := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+
+	if off+length > len(msg) {
+		err = errors.New("DNS record data truncated")
+		return
+	}
+
+	rr.Data = msg[off : off+length]
+	return rr, off + length, nil
+}
+
+func readDNSRRs(msg []byte, off int, count int) (rrs []dnsRR, next int, err error) {
+	var rr dnsRR
+	for i := 0; i < count; i++ {
+		if rr, off, err = readDNSRR(msg, off); err != nil {
+			return
+		}
+		rrs = append(rrs, rr)
+	}
+
+	return rrs, off, nil
+}
+
+func parseDNSTSIG(msg []byte, off int) (tsig dnsTSIG, err error) {
+	if tsig.Algorithm, off, err = readDNSName(msg, off); err != nil {
+		return
+	}
+
+	if off+10 > len(msg) {
+		err = errors.New("TSIG record truncated")
+		return
+	}
+
+	tsig.TimeSigned = uint64(binary.BigEndian.Uint16(msg[off:]))<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	tsig.Fudge = binary.BigEndian.Uint16(msg[off+6:])
+	macSize := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+
+	if off+macSize+6 > len(msg) {
+		err = errors.New("TSIG record truncated")
+		return
+	}
+
+	tsig.MAC = msg[off : off+macSize]
+	off += macSize
+	tsig.OrigID = binary.BigEndian.Uint16(msg[off:])
+	tsig.Error = binary.BigEndian.Uint16(msg[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+
+	if off+otherLen > len(msg) {
+		err = errors.New("TSIG record truncated")
+		return
+	}
+
+	tsig.Other = msg[off : off+otherLen]
+	return
+}
+
+// parseDNSKey takes the key in the [algorithm:]name:secret form nsupdate -y
+// accepted, the secret being base64. HMAC-MD5, HMAC-SHA256 and HMAC-SHA512
+// are supported, a key without algorithm is HMAC-MD5 like it was for nsupdate.
+func parseDNSKey(key string) (dnsTSIGKey, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) == 2 {
+		parts = append([]string{dnsTSIGHMACMD5}, parts...)
+	}
+
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return dnsTSIGKey{}, errors.New("DNS key must be in the form [algorithm:]name:secret")
+	}
+
+	algorithm := strings.ToLower(parts[0])
+	if algorithm == "hmac-md5" {
+		algorithm = dnsTSIGHMACMD5
+	}
+	if _, found := dnsTSIGAlgorithms[algorithm]; !found {
+		return dnsTSIGKey{}, bosherr.Errorf("Unsupported DNS key algorithm '%s'", parts[0])
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return dnsTSIGKey{}, bosherr.WrapError(err, "Decoding DNS key secret")
+	}
+
+	return dnsTSIGKey{Name: parts[1], Algorithm: algorithm, Secret: secret}, nil
+}
+
+// sign appends a TSIG record to msg, requestMAC is only set when signing a response
+func (k dnsTSIGKey) sign(msg []byte, requestMAC []byte, now time.Time) (signed []byte, mac []byte, err error) {
+	tsig := dnsTSIG{
+		Algorithm:  k.Algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      dnsTSIGFudge,
+		OrigID:     binary.BigEndian.Uint16(msg[0:]),
+	}
+
+	if mac, err = k.mac(msg, requestMAC, tsig); err != nil {
+		return
+	}
+	tsig.MAC = mac
+
+	rdata, err := appendDNSName(nil, tsig.Algorithm)
+	if err != nil {
+		return
+	}
+	rdata = appendUint48(rdata, tsig.TimeSigned)
+	rdata = appendUint16(rdata, tsig.Fudge)
+	rdata = appendUint16(rdata, uint16(len(tsig.MAC)))
+	rdata = append(rdata, tsig.MAC...)
+	rdata = appendUint16(rdata, tsig.OrigID)
+	rdata = appendUint16(rdata, tsig.Error)
+	rdata = appendUint16(rdata, 0)
+
+	signed = append([]byte{}, msg...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(msg[10:])+1)
+
+	signed, err = appendDNSRR(signed, dnsRR{Name: k.Name, Type: dnsTypeTSIG, Class: dnsClassANY, Data: rdata})
+	return
+}
+
+// verify checks the TSIG of the parsed message m, requestMAC is only set when verifying a response
+func (k dnsTSIGKey) verify(msg []byte, m dnsMessage, requestMAC []byte, now time.Time) error {
+	tsig := m.TSIG
+	if tsig == nil {
+		return errors.New("Message is not signed")
+	}
+
+	if !strings.EqualFold(strings.TrimSuffix(tsig.Algorithm, "."), k.Algorithm) {
+		return bosherr.Errorf("Message signed with algorithm '%s', expected '%s'", tsig.Algorithm, k.Algorithm)
+	}
+
+	// the signed data is the message as it was before the TSIG was added
+	unsigned := append([]byte{}, msg[:m.tsigStart]...)
+	binary.BigEndian.PutUint16(unsigned[0:], tsig.OrigID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(msg[10:])-1)
+
+	expected, err := k.mac(unsigned, requestMAC, *tsig)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(expected, tsig.MAC) {
+		return errors.New("Message signature does not match")
+	}
+
+	skew := now.Unix() - int64(tsig.TimeSigned)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > int64(tsig.Fudge) {
+		return bosherr.Errorf("Message signed %d seconds away from local time", skew)
+	}
+
+	return nil
+}
+
+func (k dnsTSIGKey) mac(msg []byte, requestMAC []byte, tsig dnsTSIG) ([]byte, error) {
+	newHash, found := dnsTSIGAlgorithms[k.Algorithm]
+	if !found {
+		return nil, bosherr.Errorf("Unsupported DNS key algorithm '%s'", k.Algorithm)
+	}
+
+	keyName, err := appendDNSName(nil, strings.ToLower(k.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := appendDNSName(nil, strings.ToLower(tsig.Algorithm))
+	if err != nil {
+		return nil, err
+	}
+
+	h := hmac.New(newHash, k.Secret)
+	if requestMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(msg)
+
+	variables := append(keyName, appendUint16(nil, dnsClassANY)...)
+	variables = appendUint32(variables, 0)
+	variables = append(variables, algorithm...)
+	variables = appendUint48(variables, tsig.TimeSigned)
+	variables = appendUint16(variables, tsig.Fudge)
+	variables = appendUint16(variables, tsig.Error)
+	variables = appendUint16(variables, uint16(len(tsig.Other)))
+	variables = append(variables, tsig.Other...)
+	h.Write(variables)
+
+	return h.Sum(nil), nil
+}
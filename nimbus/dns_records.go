@@ -0,0 +1,267 @@
+package nimbus
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	dnsTypeAAAA uint16 = 28
+	dnsTypeTXT  uint16 = 16
+	dnsTypeSRV  uint16 = 33
+)
+
+var dnsRecordTypes = map[string]uint16{
+	"A":    dnsTypeA,
+	"AAAA": dnsTypeAAAA,
+	"TXT":  dnsTypeTXT,
+	"SRV":  dnsTypeSRV,
+}
+
+// values used when a record does not set one, AAAA has none
+// as the IP of a network is an IPv4 address
+var dnsRecordDefaultValues = map[uint16]string{
+	dnsTypeA: "{{ .IP }}",
+}
+
+// dnsRecordValues can be used in the value template of a record
+type dnsRecordValues struct {
+	Name       string // name of the record
+	IP         string // IP on the network of the record
+	Deployment string
+	Job        string
+	Index      int
+	ID         string
+}
+
+// dnsRRset is the set of records of this leg with the same name and type,
+// registering replaces the whole set on the DNS server.
+type dnsRRset struct {
+	Name    string
+	Type    uint16
+	Records []dnsRR
+}
+
+func dnsRegistrationEnabled(spec boshas.V1ApplySpec) bool {
+	return spec.DNSRegisterOnStart != "" || len(spec.PropertiesSpec.DNSSpec.Records) > 0
+}
+
+// dnsRegistrationNames lists the names this leg registers, for logs and alerts
+func dnsRegistrationNames(spec boshas.V1ApplySpec) string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, record := range dnsRecordSpecs(spec) {
+		if !seen[record.Name] {
+			seen[record.Name] = true
+			names = append(names, record.Name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// dnsRecordSpecs are the records from the spec, dns_register_on_start is an A record
+func dnsRecordSpecs(spec boshas.V1ApplySpec) []boshas.DNSRecordSpec {
+	records := []boshas.DNSRecordSpec{}
+
+	if spec.DNSRegisterOnStart != "" {
+		records = append(records, boshas.DNSRecordSpec{Name: spec.DNSRegisterOnStart, Type: "A"})
+	}
+
+	return append(records, spec.PropertiesSpec.DNSSpec.Records...)
+}
+
+// dnsRRsets renders the records of this leg grouped into RRsets in spec order.
+// An RRset with a record that does not render is left out and reported in err,
+// the other RRsets are still returned so they can be registered.
+func (d DualDCSupport) dnsRRsets(spec boshas.V1ApplySpec) ([]dnsRRset, error) {
+	rrsets := []dnsRRset{}
+	failedRRsets := map[string]bool{}
+	failed := []string{}
+
+	for _, recordSpec := range dnsRecordSpecs(spec) {
+		rr, err := d.dnsRecord(spec, recordSpec)
+		if err != nil {
+			failedRRsets[dnsRRsetKey(recordSpec.Name, recordSpec.Type)] = true
+			failed = append(failed, bosherr.WrapErrorf(err, "Rendering dns record '%s'", recordSpec.Name).Error())
+			continue
+		}
+
+		found := false
+		for i, rrset := range rrsets {
+			if strings.EqualFold(rrset.Name, rr.Name) && rrset.Type == rr.Type {
+				rrsets[i].Records = append(rrsets[i].Records, rr)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			rrsets = append(rrsets, dnsRRset{Name: rr.Name, Type: rr.Type, Records: []dnsRR{rr}})
+		}
+	}
+
+	// registering an incomplete RRset would replace the records left out
+	complete := []dnsRRset{}
+	for _, rrset := range rrsets {
+		if !failedRRsets[dnsRRsetKey(rrset.Name, dnsRecordTypeName(rrset.Type))] {
+			complete = append(complete, rrset)
+		}
+	}
+
+	if len(failed) > 0 {
+		return complete, errors.New(strings.Join(failed, "; "))
+	}
+
+	return complete, nil
+}
+
+func dnsRRsetKey(name, recordType string) string {
+	if recordType == "" {
+		recordType = "A"
+	}
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + " " + strings.ToUpper(recordType)
+}
+
+func dnsRecordTypeName(recordType uint16) string {
+	for name, t := range dnsRecordTypes {
+		if t == recordType {
+			return name
+		}
+	}
+	return ""
+}
+
+func (d DualDCSupport) dnsRecord(spec boshas.V1ApplySpec, recordSpec boshas.DNSRecordSpec) (dnsRR, error) {
+	recordTypeName := strings.ToUpper(recordSpec.Type)
+	if recordTypeName == "" {
+		recordTypeName = "A"
+	}
+
+	recordType, found := dnsRecordTypes[recordTypeName]
+	if !found {
+		return dnsRR{}, bosherr.Errorf("Unsupported record type '%s'", recordSpec.Type)
+	}
+
+	ttl := recordSpec.TTL
+	if ttl == 0 {
+		ttl = spec.PropertiesSpec.DNSSpec.TTL
+	}
+
+	if ttl <= 0 {
+		return dnsRR{}, errors.New("Record requires a ttl, neither the record nor dns.ttl sets one")
+	}
+
+	ip, err := d.dnsRegistrationIP(spec, recordSpec.Network)
+	if err != nil {
+		return dnsRR{}, err
+	}
+
+	valueTemplate := recordSpec.Value
+	if valueTemplate == "" {
+		valueTemplate = dnsRecordDefaultValues[recordType]
+	}
+
+	if valueTemplate == "" {
+		return dnsRR{}, bosherr.Errorf("%s record requires a value", recordTypeName)
+	}
+
+	values := dnsRecordValues{
+		Name:       recordSpec.Name,
+		IP:         ip,
+		Deployment: spec.Deployment,
+		ID:         spec.NodeID,
+	}
+	if spec.JobSpec.Name != nil {
+		values.Job = *spec.JobSpec.Name
+	}
+	if spec.Index != nil {
+		values.Index = *spec.Index
+	}
+
+	value, err := renderDNSRecordValue(valueTemplate, values)
+	if err != nil {
+		return dnsRR{}, err
+	}
+
+	data, err := dnsRecordData(recordType, value)
+	if err != nil {
+		return dnsRR{}, err
+	}
+
+	return dnsRR{
+		Name:  strings.TrimSuffix(recordSpec.Name, "."),
+		Type:  recordType,
+		Class: dnsClassIN,
+		TTL:   uint32(ttl),
+		Data:  data,
+	}, nil
+}
+
+func renderDNSRecordValue(valueTemplate string, values dnsRecordValues) (string, error) {
+	t, err := template.New("dns-record").Parse(valueTemplate)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Parsing value template")
+	}
+
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, values); err != nil {
+		return "", bosherr.WrapError(err, "Rendering value template")
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// dnsRecordData encodes the presentation format of value as RDATA
+func dnsRecordData(recordType uint16, value string) ([]byte, error) {
+	switch recordType {
+	case dnsTypeA:
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, bosherr.Errorf("Invalid IPv4 address '%s'", value)
+		}
+		return []byte(ip), nil
+
+	case dnsTypeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return nil, bosherr.Errorf("Invalid IPv6 address '%s'", value)
+		}
+		return []byte(ip.To16()), nil
+
+	case dnsTypeTXT:
+		// long values are split into strings of at most 255 bytes
+		data := []byte{}
+		for len(value) > 255 {
+			data = append(append(data, 255), value[:255]...)
+			value = value[255:]
+		}
+		return append(append(data, byte(len(value))), value...), nil
+
+	case dnsTypeSRV:
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return nil, bosherr.Errorf("SRV value '%s' must be 'priority weight port target'", value)
+		}
+
+		data := []byte{}
+		for _, field := range fields[:3] {
+			number, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return nil, bosherr.Errorf("SRV value '%s' must be 'priority weight port target'", value)
+			}
+			data = appendUint16(data, uint16(number))
+		}
+		return appendDNSName(data, fields[3])
+	}
+
+	return nil, bosherr.Errorf("Unsupported record type %d", recordType)
+}
@@ -0,0 +1,279 @@
+package nimbus
+
+import (
+	"encoding/base64"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DNS records", func() {
+	var (
+		specService   *fakeas.FakeV1Service
+		dualDCSupport *DualDCSupport
+	)
+
+	BeforeEach(func() {
+		jobName := "app"
+		index := 2
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive:            "disabled",
+			Deployment:         "cf",
+			DNSRegisterOnStart: "app.dc1.example.com",
+			JobSpec:            boshas.JobSpec{Name: &jobName},
+			Index:              &index,
+			NodeID:             "fake-id",
+			PropertiesSpec: boshas.PropertiesSpec{
+				DNSSpec: boshas.DNSSpec{
+					Key: "update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")),
+					TTL: 30,
+				},
+			},
+		}
+
+		settingsService := &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Networks = boshsettings.Networks{
+			"default": boshsettings.Network{IP: "10.76.245.71", Default: []string{"dns", "gateway"}},
+			"v6":      boshsettings.Network{IP: "fd00::71"},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fakesys.NewFakeFileSystem(),
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+	})
+
+	Describe("dnsRRsets", func() {
+		It("registers dns_register_on_start as an A record", func() {
+			rrsets, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rrsets).To(Equal([]dnsRRset{
+				{Name: "app.dc1.example.com", Type: dnsTypeA, Records: []dnsRR{
+					{Name: "app.dc1.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 30, Data: []byte{10, 76, 245, 71}},
+				}},
+			}))
+		})
+
+		It("renders AAAA, TXT and SRV records", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "aaaa", Network: "v6", TTL: 60, Value: "{{ .IP }}"},
+				{Name: "app.dc1.example.com", Type: "TXT", Value: "{{ .Deployment }}/{{ .Job }}/{{ .Index }} {{ .ID }}"},
+				{Name: "_app._tcp.example.com", Type: "SRV", Value: "10 5 8443 {{ .Job }}-{{ .Index }}.dc1.example.com."},
+			}
+
+			rrsets, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rrsets).To(HaveLen(4))
+
+			Expect(rrsets[1].Records).To(Equal([]dnsRR{{
+				Name: "app.dc1.example.com", Type: dnsTypeAAAA, Class: dnsClassIN, TTL: 60,
+				Data: []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x71},
+			}}))
+
+			txt := "cf/app/2 fake-id"
+			Expect(rrsets[2].Records[0].Data).To(Equal(append([]byte{byte(len(txt))}, txt...)))
+
+			srv, err := appendDNSName([]byte{0, 10, 0, 5, 0x20, 0xfb}, "app-2.dc1.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rrsets[3].Name).To(Equal("_app._tcp.example.com"))
+			Expect(rrsets[3].Records[0].Data).To(Equal(srv))
+		})
+
+		It("groups records with the same name and type into one RRset", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Value: "10.76.245.72"},
+			}
+
+			rrsets, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rrsets).To(HaveLen(1))
+			Expect(rrsets[0].Records).To(HaveLen(2))
+			Expect(rrsets[0].Records[1].Data).To(Equal([]byte{10, 76, 245, 72}))
+		})
+
+		It("splits long TXT values into strings of 255 bytes", func() {
+			data, err := dnsRecordData(dnsTypeTXT, string(make([]byte, 300)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(HaveLen(302))
+			Expect(data[0]).To(Equal(byte(255)))
+			Expect(data[256]).To(Equal(byte(45)))
+		})
+
+		It("fails for AAAA records without a value instead of using the IPv4 address", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "AAAA"},
+			}
+
+			_, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("AAAA record requires a value"))
+		})
+
+		It("still returns the other RRsets when a record fails", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "AAAA", Value: "{{ .IP }}"},
+				{Name: "app.dc1.example.com", Type: "TXT", Value: "{{ .Deployment }}"},
+				{Name: "app.dc1.example.com", Type: "TXT"},
+			}
+
+			rrsets, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid IPv6 address '10.76.245.71'"))
+			Expect(err.Error()).To(ContainSubstring("TXT record requires a value"))
+
+			// the TXT RRset is incomplete without the failed record
+			Expect(rrsets).To(HaveLen(1))
+			Expect(rrsets[0].Type).To(Equal(dnsTypeA))
+		})
+
+		It("fails for unsupported types", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "MX", Value: "10 mail.example.com"},
+			}
+
+			_, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unsupported record type 'MX'"))
+		})
+
+		It("fails for TXT records without a value", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "TXT"},
+			}
+
+			_, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("TXT record requires a value"))
+		})
+
+		It("fails for SRV values that do not parse", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "_app._tcp.example.com", Type: "SRV", Value: "10 5 app.example.com."},
+			}
+
+			_, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must be 'priority weight port target'"))
+		})
+
+		It("fails without a ttl", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.TTL = 0
+
+			_, err := dualDCSupport.dnsRRsets(specService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Record requires a ttl"))
+		})
+	})
+
+	Context("with a DNS server", func() {
+		var server *fakeDNSServer
+
+		BeforeEach(func() {
+			key, err := parseDNSKey(specService.Spec.PropertiesSpec.DNSSpec.Key)
+			Expect(err).ToNot(HaveOccurred())
+
+			server = newFakeDNSServer("example.com", key)
+			specService.Spec.PropertiesSpec.DNSSpec.DNSServers = []string{server.Addr()}
+			specService.Spec.PropertiesSpec.DNSSpec.Records = []boshas.DNSRecordSpec{
+				{Name: "app.dc1.example.com", Type: "TXT", Value: "{{ .Deployment }}"},
+				{Name: "_app._tcp.example.com", Type: "SRV", Value: "10 5 8443 app.dc1.example.com."},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("replaces every RRset in an update of its own", func() {
+			_, err := dualDCSupport.updateAllDNSServers()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(server.Updates()).To(HaveLen(3))
+			for _, request := range server.Updates() {
+				Expect(request.Answer).To(HaveLen(1))
+				Expect(request.Answer[0].Type).To(Equal(dnsTypeCNAME))
+				Expect(request.Authority).To(HaveLen(2))
+			}
+
+			request := server.Updates()[1]
+			Expect(request.Answer[0]).To(Equal(
+				dnsRR{Name: "app.dc1.example.com", Type: dnsTypeCNAME, Class: dnsClassNONE, Data: []byte{}},
+			))
+			Expect(request.Authority[0]).To(Equal(
+				dnsRR{Name: "app.dc1.example.com", Type: dnsTypeTXT, Class: dnsClassANY, Data: []byte{}},
+			))
+			Expect(request.Authority[1].Data).To(Equal([]byte("\x02cf")))
+			Expect(server.Updates()[2].Authority[1].Type).To(Equal(dnsTypeSRV))
+		})
+
+		It("registers the other records when one does not render", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.Records = append(
+				specService.Spec.PropertiesSpec.DNSSpec.Records,
+				boshas.DNSRecordSpec{Name: "app.dc1.example.com", Type: "AAAA"},
+			)
+
+			_, err := dualDCSupport.updateAllDNSServers()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("AAAA record requires a value"))
+
+			Expect(server.Updates()).To(HaveLen(3))
+			Expect(server.Updates()[0].Authority[1]).To(Equal(
+				dnsRR{Name: "app.dc1.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 30, Data: []byte{10, 76, 245, 71}},
+			))
+		})
+
+		It("keeps registering the other RRsets when the server rejects one", func() {
+			server.SetUpdateRcode(DNSRcodeRefused)
+
+			_, err := dualDCSupport.updateAllDNSServers()
+			Expect(err).To(HaveOccurred())
+			Expect(server.Updates()).To(HaveLen(3))
+		})
+
+		It("removes every RRset on stop", func() {
+			err := dualDCSupport.DeregisterDNSIfRequired()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(server.Updates()).To(HaveLen(3))
+			for _, request := range server.Updates() {
+				Expect(request.Authority).To(HaveLen(1))
+				Expect(request.Authority[0].Class).To(Equal(dnsClassNONE))
+			}
+			Expect(server.Updates()[1].Answer[0].Type).To(Equal(dnsTypeTXT))
+			Expect(server.Updates()[2].Authority[0].Name).To(Equal("_app._tcp.example.com"))
+		})
+
+		It("keeps removing the other RRsets when one was taken over", func() {
+			server.SetUpdateRcode(DNSRcodeNXRRSet)
+
+			err := dualDCSupport.DeregisterDNSIfRequired()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.Updates()).To(HaveLen(3))
+		})
+
+		It("registers records without dns_register_on_start", func() {
+			specService.Spec.DNSRegisterOnStart = ""
+
+			_, err := dualDCSupport.updateAllDNSServers()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.Updates()).To(HaveLen(2))
+			Expect(server.Updates()[0].Authority[0].Name).To(Equal("app.dc1.example.com"))
+			Expect(server.Updates()[0].Authority[0].Type).To(Equal(dnsTypeTXT))
+		})
+	})
+})
@@ -0,0 +1,177 @@
+package nimbus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+)
+
+const (
+	dnsRegistrationCheckInterval = 30 * time.Second
+
+	defaultDNSFailureThreshold = 3
+)
+
+// DNS registration events, also the keys of dns.alert_severity in the apply spec
+const (
+	DNSEventRegistrationFailing   = "dns_registration_failing"
+	DNSEventRegistrationRecovered = "dns_registration_recovered"
+)
+
+var dnsEventDefaultSeverity = map[string]boshalert.SeverityLevel{
+	DNSEventRegistrationFailing:   boshalert.SeverityCritical,
+	DNSEventRegistrationRecovered: boshalert.SeverityWarning,
+}
+
+// DNSServerStatus is the outcome of the latest registrations with a DNS server
+type DNSServerStatus struct {
+	Server              string     `json:"server"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// dnsStatusTracker is shared by the update goroutine, get_state and the monitor
+type dnsStatusTracker struct {
+	lock    sync.Mutex
+	servers map[string]DNSServerStatus
+}
+
+func newDNSStatusTracker() *dnsStatusTracker {
+	return &dnsStatusTracker{servers: map[string]DNSServerStatus{}}
+}
+
+func (t *dnsStatusTracker) record(server string, attempt time.Time, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	status := t.servers[server]
+	status.Server = server
+	status.LastAttempt = &attempt
+
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+	} else {
+		status.LastSuccess = &attempt
+		status.LastError = ""
+		status.ConsecutiveFailures = 0
+	}
+
+	t.servers[server] = status
+}
+
+// statuses returns the status of the given servers in their order,
+// servers not tried yet are listed without attempts
+func (t *dnsStatusTracker) statuses(servers []string) []DNSServerStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	statuses := []DNSServerStatus{}
+	for _, server := range servers {
+		status, found := t.servers[server]
+		if !found {
+			status = DNSServerStatus{Server: server}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// DNSStatus returns the registration status per DNS server from the spec,
+// nil when this leg does not register itself.
+func (d DualDCSupport) DNSStatus() ([]DNSServerStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dnsRegistrationEnabled(spec) {
+		return nil, nil
+	}
+
+	return d.dnsStatus.statuses(spec.PropertiesSpec.DNSSpec.DNSServers), nil
+}
+
+// MonitorDNSRegistration alerts when registering with a DNS server failed
+// failure_threshold times in a row and again when it recovers.
+func (d DualDCSupport) MonitorDNSRegistration(handler NimbusAlertHandler, stopCh <-chan struct{}) {
+	defer d.logger.HandlePanic("Nimbus Monitor DNS Registration")
+
+	failing := map[string]bool{}
+	ticker := time.NewTicker(dnsRegistrationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			failing = d.checkDNSRegistration(failing, handler)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d DualDCSupport) checkDNSRegistration(previous map[string]bool, handler NimbusAlertHandler) map[string]bool {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to check dns registration: %s", err)
+		return previous
+	}
+
+	statuses, err := d.DNSStatus()
+	if err != nil || statuses == nil {
+		return map[string]bool{}
+	}
+
+	threshold := dnsFailureThreshold(spec)
+
+	current := map[string]bool{}
+	for _, status := range statuses {
+		current[status.Server] = status.ConsecutiveFailures >= threshold
+
+		var alert boshalert.NimbusAlert
+		switch {
+		case current[status.Server] && !previous[status.Server]:
+			alert = dnsRegistrationAlert(spec, status, DNSEventRegistrationFailing,
+				fmt.Sprintf("Registering '%s' with DNS server %s failed %d times in a row: %s", dnsRegistrationNames(spec), status.Server, status.ConsecutiveFailures, status.LastError))
+		case !current[status.Server] && previous[status.Server]:
+			alert = dnsRegistrationAlert(spec, status, DNSEventRegistrationRecovered,
+				fmt.Sprintf("Registering '%s' with DNS server %s succeeded again", dnsRegistrationNames(spec), status.Server))
+		default:
+			continue
+		}
+
+		d.logger.Info(nimbusLogTag, "DNS registration event '%s' on server %s", alert.Event, status.Server)
+
+		if err = handler(alert); err != nil {
+			d.logger.Error(nimbusLogTag, "Reporting dns registration event: %s", err)
+			current[status.Server] = previous[status.Server]
+		}
+	}
+
+	return current
+}
+
+func dnsRegistrationAlert(spec boshas.V1ApplySpec, status DNSServerStatus, event, summary string) boshalert.NimbusAlert {
+	return boshalert.NimbusAlert{
+		Component: "dns",
+		Resource:  status.Server,
+		Event:     strings.Replace(event, "_", " ", -1),
+		Severity:  alertSeverity(spec.PropertiesSpec.DNSSpec.AlertSeverity, dnsEventDefaultSeverity, event),
+		Summary:   summary,
+	}
+}
+
+func dnsFailureThreshold(spec boshas.V1ApplySpec) int {
+	if threshold := spec.PropertiesSpec.DNSSpec.FailureThreshold; threshold > 0 {
+		return threshold
+	}
+	return defaultDNSFailureThreshold
+}
@@ -0,0 +1,153 @@
+package nimbus
+
+import (
+	"errors"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DNSStatus", func() {
+	var (
+		dualDCSupport *DualDCSupport
+		specService   *fakeas.FakeV1Service
+
+		alerts  []boshalert.NimbusAlert
+		handler NimbusAlertHandler
+	)
+
+	BeforeEach(func() {
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive:            "disabled",
+			DNSRegisterOnStart: "app.dc1.example.com",
+			PropertiesSpec: boshas.PropertiesSpec{
+				DNSSpec: boshas.DNSSpec{DNSServers: []string{"10.0.0.53", "10.1.0.53"}},
+			},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fakesys.NewFakeFileSystem(),
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		alerts = nil
+		handler = func(alert boshalert.NimbusAlert) error {
+			alerts = append(alerts, alert)
+			return nil
+		}
+	})
+
+	fail := func(server string, times int) {
+		for i := 0; i < times; i++ {
+			dualDCSupport.dnsStatus.record(server, time.Now(), errors.New("fake-dns-error"))
+		}
+	}
+
+	Describe("DNSStatus", func() {
+		It("tracks attempts per server", func() {
+			failedAt := time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC)
+			succeededAt := time.Date(2016, 3, 1, 10, 1, 0, 0, time.UTC)
+
+			dualDCSupport.dnsStatus.record("10.0.0.53", succeededAt, nil)
+			dualDCSupport.dnsStatus.record("10.1.0.53", failedAt, errors.New("fake-dns-error"))
+			dualDCSupport.dnsStatus.record("10.1.0.53", failedAt, errors.New("fake-dns-error"))
+
+			statuses, err := dualDCSupport.DNSStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(Equal([]DNSServerStatus{
+				{Server: "10.0.0.53", LastAttempt: &succeededAt, LastSuccess: &succeededAt},
+				{Server: "10.1.0.53", LastAttempt: &failedAt, LastError: "fake-dns-error", ConsecutiveFailures: 2},
+			}))
+		})
+
+		It("resets failures on success", func() {
+			fail("10.0.0.53", 2)
+			dualDCSupport.dnsStatus.record("10.0.0.53", time.Now(), nil)
+
+			statuses, err := dualDCSupport.DNSStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses[0].ConsecutiveFailures).To(Equal(0))
+			Expect(statuses[0].LastError).To(BeEmpty())
+		})
+
+		It("returns nil when this leg does not register itself", func() {
+			specService.Spec.DNSRegisterOnStart = ""
+
+			statuses, err := dualDCSupport.DNSStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(BeNil())
+		})
+	})
+
+	Describe("checkDNSRegistration", func() {
+		It("alerts once when failures reach the threshold and again when recovered", func() {
+			fail("10.1.0.53", 2)
+			failing := dualDCSupport.checkDNSRegistration(map[string]bool{}, handler)
+			Expect(alerts).To(BeEmpty())
+
+			fail("10.1.0.53", 1)
+			failing = dualDCSupport.checkDNSRegistration(failing, handler)
+			Expect(alerts).To(Equal([]boshalert.NimbusAlert{{
+				Component: "dns",
+				Resource:  "10.1.0.53",
+				Event:     "dns registration failing",
+				Severity:  boshalert.SeverityCritical,
+				Summary:   "Registering 'app.dc1.example.com' with DNS server 10.1.0.53 failed 3 times in a row: fake-dns-error",
+			}}))
+
+			fail("10.1.0.53", 1)
+			failing = dualDCSupport.checkDNSRegistration(failing, handler)
+			Expect(alerts).To(HaveLen(1))
+
+			dualDCSupport.dnsStatus.record("10.1.0.53", time.Now(), nil)
+			dualDCSupport.checkDNSRegistration(failing, handler)
+			Expect(alerts).To(HaveLen(2))
+			Expect(alerts[1].Event).To(Equal("dns registration recovered"))
+			Expect(alerts[1].Severity).To(Equal(boshalert.SeverityWarning))
+		})
+
+		It("uses failure threshold from dns spec", func() {
+			specService.Spec.PropertiesSpec.DNSSpec.FailureThreshold = 1
+
+			fail("10.0.0.53", 1)
+			dualDCSupport.checkDNSRegistration(map[string]bool{}, handler)
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Resource).To(Equal("10.0.0.53"))
+		})
+
+		It("uses severity from dns spec and not the one of drbd", func() {
+			specService.Spec.DrbdAlertSeverity = map[string]string{DNSEventRegistrationFailing: "warning"}
+			specService.Spec.PropertiesSpec.DNSSpec.AlertSeverity = map[string]string{DNSEventRegistrationFailing: "alert"}
+
+			fail("10.0.0.53", 3)
+			dualDCSupport.checkDNSRegistration(map[string]bool{}, handler)
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Severity).To(Equal(boshalert.SeverityAlert))
+		})
+
+		It("alerts again when sending failed", func() {
+			fail("10.0.0.53", 3)
+			failing := dualDCSupport.checkDNSRegistration(map[string]bool{}, func(boshalert.NimbusAlert) error {
+				return errors.New("fake-send-error")
+			})
+
+			dualDCSupport.checkDNSRegistration(failing, handler)
+			Expect(alerts).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,339 @@
+package nimbus
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	dnsPort    = "53"
+	dnsTimeout = 4 * time.Second
+)
+
+// DNS response codes (RFC 1035, RFC 2136) and TSIG errors (RFC 8945)
+const (
+	DNSRcodeNoError  = 0
+	DNSRcodeFormErr  = 1
+	DNSRcodeServFail = 2
+	DNSRcodeNXDomain = 3
+	DNSRcodeNotImp   = 4
+	DNSRcodeRefused  = 5
+	DNSRcodeYXDomain = 6
+	DNSRcodeYXRRSet  = 7
+	DNSRcodeNXRRSet  = 8
+	DNSRcodeNotAuth  = 9
+	DNSRcodeNotZone  = 10
+	DNSRcodeBadSig   = 16
+	DNSRcodeBadKey   = 17
+	DNSRcodeBadTime  = 18
+)
+
+var dnsRcodeNames = map[int]string{
+	DNSRcodeNoError:  "NOERROR",
+	DNSRcodeFormErr:  "FORMERR",
+	DNSRcodeServFail: "SERVFAIL",
+	DNSRcodeNXDomain: "NXDOMAIN",
+	DNSRcodeNotImp:   "NOTIMP",
+	DNSRcodeRefused:  "REFUSED",
+	DNSRcodeYXDomain: "YXDOMAIN",
+	DNSRcodeYXRRSet:  "YXRRSET",
+	DNSRcodeNXRRSet:  "NXRRSET",
+	DNSRcodeNotAuth:  "NOTAUTH",
+	DNSRcodeNotZone:  "NOTZONE",
+	DNSRcodeBadSig:   "BADSIG",
+	DNSRcodeBadKey:   "BADKEY",
+	DNSRcodeBadTime:  "BADTIME",
+}
+
+// DNSUpdateError is returned when a DNS server answers with an error rcode,
+// TSIG errors are reported with their own codes.
+type DNSUpdateError struct {
+	Server string
+	Rcode  int
+}
+
+func (e DNSUpdateError) Error() string {
+	return fmt.Sprintf("DNS server %s responded %s", e.Server, DNSRcodeName(e.Rcode))
+}
+
+// NotAuthorized is true when the server is not authoritative for the zone or did not accept the key
+func (e DNSUpdateError) NotAuthorized() bool {
+	switch e.Rcode {
+	case DNSRcodeNotAuth, DNSRcodeBadSig, DNSRcodeBadKey, DNSRcodeBadTime:
+		return true
+	}
+	return false
+}
+
+// Refused is true when the server policy does not allow the update
+func (e DNSUpdateError) Refused() bool {
+	return e.Rcode == DNSRcodeRefused
+}
+
+// PrerequisiteFailed is true when the update was not applied because a prerequisite did not hold
+func (e DNSUpdateError) PrerequisiteFailed() bool {
+	switch e.Rcode {
+	case DNSRcodeYXDomain, DNSRcodeYXRRSet, DNSRcodeNXDomain, DNSRcodeNXRRSet:
+		return true
+	}
+	return false
+}
+
+func DNSRcodeName(rcode int) string {
+	if name, found := dnsRcodeNames[rcode]; found {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// dnsUpdate collects the prerequisites and updates of a single RFC 2136 update
+type dnsUpdate struct {
+	Zone          string
+	Prerequisites []dnsRR
+	Updates       []dnsRR
+}
+
+func newDNSUpdate(zone string) *dnsUpdate {
+	return &dnsUpdate{Zone: zone}
+}
+
+func (u *dnsUpdate) RRsetExists(name string, rrType uint16) {
+	u.Prerequisites = append(u.Prerequisites, dnsRR{Name: name, Type: rrType, Class: dnsClassANY})
+}
+
+// RRsetExistsWithValue requires the whole RRset of rr to consist of rr
+func (u *dnsUpdate) RRsetExistsWithValue(rr dnsRR) {
+	rr.Class = dnsClassIN
+	rr.TTL = 0
+	u.Prerequisites = append(u.Prerequisites, rr)
+}
+
+func (u *dnsUpdate) RRsetDoesNotExist(name string, rrType uint16) {
+	u.Prerequisites = append(u.Prerequisites, dnsRR{Name: name, Type: rrType, Class: dnsClassNONE})
+}
+
+func (u *dnsUpdate) Add(rr dnsRR) {
+	rr.Class = dnsClassIN
+	u.Updates = append(u.Updates, rr)
+}
+
+func (u *dnsUpdate) DeleteRRset(name string, rrType uint16) {
+	u.Updates = append(u.Updates, dnsRR{Name: name, Type: rrType, Class: dnsClassANY})
+}
+
+func (u *dnsUpdate) DeleteRR(rr dnsRR) {
+	rr.Class = dnsClassNONE
+	rr.TTL = 0
+	u.Updates = append(u.Updates, rr)
+}
+
+func (u *dnsUpdate) message(id uint16) dnsMessage {
+	return dnsMessage{
+		ID:        id,
+		Flags:     dnsFlags(dnsOpcodeUpdate, DNSRcodeNoError),
+		Question:  []dnsQuestion{{Name: u.Zone, Type: dnsTypeSOA, Class: dnsClassIN}},
+		Answer:    u.Prerequisites,
+		Authority: u.Updates,
+	}
+}
+
+// dnsUpdateClient talks to a single DNS server, over UDP with a TCP retry for truncated responses
+type dnsUpdateClient struct {
+	server  string
+	key     dnsTSIGKey
+	timeout time.Duration
+	now     func() time.Time
+}
+
+func newDNSUpdateClient(server string, key dnsTSIGKey) dnsUpdateClient {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, dnsPort)
+	}
+
+	return dnsUpdateClient{
+		server:  server,
+		key:     key,
+		timeout: dnsTimeout,
+		now:     time.Now,
+	}
+}
+
+// FindZone returns the zone name belongs to, taken from the SOA record the server returns for it
+func (c dnsUpdateClient) FindZone(name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	query := dnsMessage{
+		ID:       dnsMessageID(),
+		Flags:    dnsFlags(dnsOpcodeQuery, DNSRcodeNoError),
+		Question: []dnsQuestion{{Name: name, Type: dnsTypeSOA, Class: dnsClassIN}},
+	}
+
+	msg, err := query.pack()
+	if err != nil {
+		return "", err
+	}
+
+	_, response, err := c.exchange(msg, query.ID)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Looking up SOA of '%s'", name)
+	}
+
+	if rcode := response.Rcode(); rcode != DNSRcodeNoError && rcode != DNSRcodeNXDomain {
+		return "", DNSUpdateError{Server: c.server, Rcode: rcode}
+	}
+
+	for _, rr := range response.Answer {
+		if rr.Type == dnsTypeSOA && strings.EqualFold(rr.Name, name) {
+			return rr.Name, nil
+		}
+	}
+
+	for _, rr := range response.Authority {
+		if rr.Type == dnsTypeSOA {
+			return rr.Name, nil
+		}
+	}
+
+	return "", bosherr.Errorf("DNS server %s returned no SOA for '%s'", c.server, name)
+}
+
+// Update sends the signed update and checks the signature of the response
+func (c dnsUpdateClient) Update(update *dnsUpdate) error {
+	id := dnsMessageID()
+
+	msg, err := update.message(id).pack()
+	if err != nil {
+		return err
+	}
+
+	signed, requestMAC, err := c.key.sign(msg, nil, c.now())
+	if err != nil {
+		return bosherr.WrapError(err, "Signing DNS update")
+	}
+
+	raw, response, err := c.exchange(signed, id)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Updating zone '%s'", update.Zone)
+	}
+
+	// servers reject bad keys and signatures with an unsigned response
+	if response.TSIG != nil && response.TSIG.Error != DNSRcodeNoError {
+		return DNSUpdateError{Server: c.server, Rcode: int(response.TSIG.Error)}
+	}
+
+	if response.TSIG == nil && response.Rcode() != DNSRcodeNoError {
+		return DNSUpdateError{Server: c.server, Rcode: response.Rcode()}
+	}
+
+	if err = c.key.verify(raw, response, requestMAC, c.now()); err != nil {
+		return bosherr.WrapErrorf(err, "Verifying response from DNS server %s", c.server)
+	}
+
+	if response.Rcode() != DNSRcodeNoError {
+		return DNSUpdateError{Server: c.server, Rcode: response.Rcode()}
+	}
+
+	return nil
+}
+
+func (c dnsUpdateClient) exchange(msg []byte, id uint16) (raw []byte, response dnsMessage, err error) {
+	raw, err = c.exchangeUDP(msg)
+	if err != nil {
+		return
+	}
+
+	if response, err = c.parseResponse(raw, id); err != nil {
+		return
+	}
+
+	if response.Flags&dnsFlagTruncated == 0 {
+		return
+	}
+
+	if raw, err = c.exchangeTCP(msg); err != nil {
+		return
+	}
+
+	response, err = c.parseResponse(raw, id)
+	return
+}
+
+func (c dnsUpdateClient) parseResponse(raw []byte, id uint16) (dnsMessage, error) {
+	response, err := parseDNSMessage(raw)
+	if err != nil {
+		return response, bosherr.WrapErrorf(err, "Parsing response from DNS server %s", c.server)
+	}
+
+	if response.ID != id || response.Flags&dnsFlagResponse == 0 {
+		return response, bosherr.Errorf("Unexpected message from DNS server %s", c.server)
+	}
+
+	return response, nil
+}
+
+func (c dnsUpdateClient) exchangeUDP(msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", c.server, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func (c dnsUpdateClient) exchangeTCP(msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", c.server, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err = io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func dnsMessageID() uint16 {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return uint16(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint16(b)
+}
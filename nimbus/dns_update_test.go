@@ -0,0 +1,595 @@
+package nimbus
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeDNSServer stands in for an authoritative server of a single zone,
+// answering SOA queries and checking the TSIG of updates
+type fakeDNSServer struct {
+	Zone string
+
+	udp net.PacketConn
+	tcp net.Listener
+
+	// guards everything below, shared between the serving goroutines and the test
+	lock sync.Mutex
+
+	key dnsTSIGKey
+
+	// answers updates with this rcode, BADSIG/BADKEY/BADTIME are sent as TSIG errors
+	updateRcode int
+	// answers over UDP with the truncated flag only
+	truncate bool
+	// corrupts the signature of responses to updates
+	badResponseMAC bool
+
+	updates   []dnsMessage
+	tsigError error
+}
+
+func newFakeDNSServer(zone string, key dnsTSIGKey) *fakeDNSServer {
+	s := &fakeDNSServer{Zone: zone, key: key}
+
+	var err error
+	s.tcp, err = net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	s.udp, err = net.ListenPacket("udp", s.tcp.Addr().String())
+	Expect(err).ToNot(HaveOccurred())
+
+	go s.serveUDP()
+	go s.serveTCP()
+
+	return s
+}
+
+func (s *fakeDNSServer) Addr() string {
+	return s.tcp.Addr().String()
+}
+
+func (s *fakeDNSServer) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *fakeDNSServer) SetKey(key dnsTSIGKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.key = key
+}
+
+func (s *fakeDNSServer) SetUpdateRcode(rcode int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.updateRcode = rcode
+}
+
+func (s *fakeDNSServer) SetTruncate(truncate bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.truncate = truncate
+}
+
+func (s *fakeDNSServer) SetBadResponseMAC(badResponseMAC bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.badResponseMAC = badResponseMAC
+}
+
+func (s *fakeDNSServer) Updates() []dnsMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]dnsMessage{}, s.updates...)
+}
+
+func (s *fakeDNSServer) TSIGError() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tsigError
+}
+
+func (s *fakeDNSServer) serveUDP() {
+	defer GinkgoRecover()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		response := s.respond(append([]byte{}, buf[:n]...), true)
+		s.udp.WriteTo(response, addr)
+	}
+}
+
+func (s *fakeDNSServer) serveTCP() {
+	defer GinkgoRecover()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err == nil {
+			request := make([]byte, binary.BigEndian.Uint16(length))
+			if _, err = io.ReadFull(conn, request); err == nil {
+				response := s.respond(request, false)
+				conn.Write(append(appendUint16(nil, uint16(len(response))), response...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+func (s *fakeDNSServer) respond(raw []byte, udp bool) []byte {
+	request, err := parseDNSMessage(raw)
+	Expect(err).ToNot(HaveOccurred())
+
+	s.lock.Lock()
+	key, updateRcode, truncate, badResponseMAC := s.key, s.updateRcode, s.truncate, s.badResponseMAC
+	s.lock.Unlock()
+
+	response := dnsMessage{ID: request.ID, Question: request.Question}
+	rcode := DNSRcodeNoError
+
+	if udp && truncate {
+		response.Flags = dnsFlagResponse | dnsFlagTruncated | dnsFlags(request.Opcode(), rcode)
+		msg, err := response.pack()
+		Expect(err).ToNot(HaveOccurred())
+		return msg
+	}
+
+	if request.Opcode() == dnsOpcodeQuery {
+		soa := dnsRR{Name: s.Zone, Type: dnsTypeSOA, Class: dnsClassIN, TTL: 300, Data: []byte{0, 0}}
+		if strings.EqualFold(request.Question[0].Name, s.Zone) {
+			response.Answer = []dnsRR{soa}
+		} else {
+			response.Authority = []dnsRR{soa}
+		}
+
+		response.Flags = dnsFlagResponse | dnsFlags(request.Opcode(), rcode)
+		msg, err := response.pack()
+		Expect(err).ToNot(HaveOccurred())
+		return msg
+	}
+
+	tsigErr := key.verify(raw, request, nil, time.Now())
+
+	s.lock.Lock()
+	s.updates = append(s.updates, request)
+	s.tsigError = tsigErr
+	s.lock.Unlock()
+
+	if tsigErr != nil || updateRcode >= DNSRcodeBadSig {
+		// unsigned response carrying the TSIG error
+		tsigRcode := DNSRcodeBadSig
+		if updateRcode >= DNSRcodeBadSig {
+			tsigRcode = updateRcode
+		}
+
+		response.Flags = dnsFlagResponse | dnsFlags(request.Opcode(), DNSRcodeNotAuth)
+		msg, err := response.pack()
+		Expect(err).ToNot(HaveOccurred())
+
+		rdata, _ := appendDNSName(nil, key.Algorithm)
+		rdata = appendUint48(rdata, uint64(time.Now().Unix()))
+		rdata = appendUint16(rdata, dnsTSIGFudge)
+		rdata = appendUint16(rdata, 0)
+		rdata = appendUint16(rdata, request.ID)
+		rdata = appendUint16(rdata, uint16(tsigRcode))
+		rdata = appendUint16(rdata, 0)
+
+		binary.BigEndian.PutUint16(msg[10:], 1)
+		msg, err = appendDNSRR(msg, dnsRR{Name: key.Name, Type: dnsTypeTSIG, Class: dnsClassANY, Data: rdata})
+		Expect(err).ToNot(HaveOccurred())
+		return msg
+	}
+
+	response.Flags = dnsFlagResponse | dnsFlags(request.Opcode(), updateRcode)
+	msg, err := response.pack()
+	Expect(err).ToNot(HaveOccurred())
+
+	signed, _, err := key.sign(msg, request.TSIG.MAC, time.Now())
+	Expect(err).ToNot(HaveOccurred())
+
+	if badResponseMAC {
+		signed[len(signed)-10] ^= 0xff
+	}
+
+	return signed
+}
+
+// lockedSpecService lets a test change the spec while DNS updates run in the background
+type lockedSpecService struct {
+	*fakeas.FakeV1Service
+	lock sync.Mutex
+}
+
+func (s *lockedSpecService) Get() (boshas.V1ApplySpec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.FakeV1Service.Get()
+}
+
+func (s *lockedSpecService) Update(update func(*boshas.V1ApplySpec)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	update(&s.Spec)
+}
+
+var _ = Describe("DNS update", func() {
+	var (
+		key    dnsTSIGKey
+		server *fakeDNSServer
+		client dnsUpdateClient
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = parseDNSKey("hmac-sha256:update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")))
+		Expect(err).ToNot(HaveOccurred())
+
+		server = newFakeDNSServer("example.com", key)
+		client = newDNSUpdateClient(server.Addr(), key)
+		client.timeout = time.Second
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("parseDNSKey", func() {
+		It("parses algorithm, name and secret", func() {
+			key, err := parseDNSKey("HMAC-SHA512:fake-key:ZmFrZS1zZWNyZXQ=")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal(dnsTSIGKey{Name: "fake-key", Algorithm: "hmac-sha512", Secret: []byte("fake-secret")}))
+		})
+
+		It("defaults the legacy name:secret form to hmac-md5 like nsupdate", func() {
+			key, err := parseDNSKey("fake-key:ZmFrZS1zZWNyZXQ=")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal(dnsTSIGKey{Name: "fake-key", Algorithm: "hmac-md5.sig-alg.reg.int", Secret: []byte("fake-secret")}))
+		})
+
+		It("names hmac-md5 keys with the tsig algorithm name", func() {
+			key, err := parseDNSKey("hmac-md5:fake-key:ZmFrZS1zZWNyZXQ=")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.Algorithm).To(Equal("hmac-md5.sig-alg.reg.int"))
+		})
+
+		It("rejects unsupported algorithms", func() {
+			_, err := parseDNSKey("hmac-sha1:fake-key:ZmFrZS1zZWNyZXQ=")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unsupported DNS key algorithm 'hmac-sha1'"))
+		})
+
+		It("rejects keys without a secret", func() {
+			_, err := parseDNSKey("fake-key")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("FindZone", func() {
+		It("takes the zone from the SOA in the authority section", func() {
+			zone, err := client.FindZone("app.dc1.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zone).To(Equal("example.com"))
+		})
+
+		It("takes the zone from the SOA in the answer section", func() {
+			zone, err := client.FindZone("example.com.")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zone).To(Equal("example.com"))
+		})
+
+		It("retries over tcp when the response is truncated", func() {
+			server.SetTruncate(true)
+
+			zone, err := client.FindZone("app.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zone).To(Equal("example.com"))
+		})
+	})
+
+	Describe("Update", func() {
+		var update *dnsUpdate
+
+		BeforeEach(func() {
+			record := dnsRR{Name: "app.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 60, Data: []byte{10, 76, 245, 71}}
+
+			update = newDNSUpdate("example.com")
+			update.RRsetDoesNotExist("app.example.com", dnsTypeCNAME)
+			update.DeleteRRset("app.example.com", dnsTypeA)
+			update.Add(record)
+		})
+
+		It("sends a signed update with prerequisites", func() {
+			err := client.Update(update)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(server.TSIGError()).ToNot(HaveOccurred())
+			Expect(server.Updates()).To(HaveLen(1))
+
+			request := server.Updates()[0]
+			Expect(request.Opcode()).To(Equal(dnsOpcodeUpdate))
+			Expect(request.Question).To(Equal([]dnsQuestion{{Name: "example.com", Type: dnsTypeSOA, Class: dnsClassIN}}))
+			Expect(request.Answer).To(Equal([]dnsRR{
+				{Name: "app.example.com", Type: dnsTypeCNAME, Class: dnsClassNONE, Data: []byte{}},
+			}))
+			Expect(request.Authority).To(Equal([]dnsRR{
+				{Name: "app.example.com", Type: dnsTypeA, Class: dnsClassANY, Data: []byte{}},
+				{Name: "app.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 60, Data: []byte{10, 76, 245, 71}},
+			}))
+			Expect(request.TSIG.Algorithm).To(Equal("hmac-sha256"))
+		})
+
+		It("signs with hmac-sha512", func() {
+			key.Algorithm = "hmac-sha512"
+			server.SetKey(key)
+			client.key = key
+
+			err := client.Update(update)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.TSIGError()).ToNot(HaveOccurred())
+		})
+
+		It("signs legacy keys with hmac-md5", func() {
+			key, err := parseDNSKey("update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")))
+			Expect(err).ToNot(HaveOccurred())
+			server.SetKey(key)
+			client.key = key
+
+			err = client.Update(update)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.TSIGError()).ToNot(HaveOccurred())
+			Expect(server.Updates()[0].TSIG.Algorithm).To(Equal("hmac-md5.sig-alg.reg.int"))
+		})
+
+		It("returns not authorized error for NOTAUTH", func() {
+			server.SetUpdateRcode(DNSRcodeNotAuth)
+
+			err := client.Update(update)
+			Expect(err).To(Equal(DNSUpdateError{Server: server.Addr(), Rcode: DNSRcodeNotAuth}))
+			Expect(err.(DNSUpdateError).NotAuthorized()).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("responded NOTAUTH"))
+		})
+
+		It("returns refused error for REFUSED", func() {
+			server.SetUpdateRcode(DNSRcodeRefused)
+
+			err := client.Update(update)
+			Expect(err).To(Equal(DNSUpdateError{Server: server.Addr(), Rcode: DNSRcodeRefused}))
+			Expect(err.(DNSUpdateError).Refused()).To(BeTrue())
+		})
+
+		It("returns prerequisite error for YXRRSET", func() {
+			server.SetUpdateRcode(DNSRcodeYXRRSet)
+
+			err := client.Update(update)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(DNSUpdateError).PrerequisiteFailed()).To(BeTrue())
+		})
+
+		It("returns TSIG error when the server does not accept the key", func() {
+			otherKey := key
+			otherKey.Secret = []byte("other-secret")
+			server.SetKey(otherKey)
+
+			err := client.Update(update)
+			Expect(err).To(Equal(DNSUpdateError{Server: server.Addr(), Rcode: DNSRcodeBadSig}))
+			Expect(err.(DNSUpdateError).NotAuthorized()).To(BeTrue())
+		})
+
+		It("returns TSIG error sent by the server", func() {
+			server.SetUpdateRcode(DNSRcodeBadTime)
+
+			err := client.Update(update)
+			Expect(err).To(Equal(DNSUpdateError{Server: server.Addr(), Rcode: DNSRcodeBadTime}))
+		})
+
+		It("rejects responses with a bad signature", func() {
+			server.SetBadResponseMAC(true)
+
+			err := client.Update(update)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Message signature does not match"))
+		})
+
+		It("returns error when the server does not answer", func() {
+			server.Close()
+			client.timeout = 100 * time.Millisecond
+
+			err := client.Update(update)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Updating zone 'example.com'"))
+		})
+	})
+
+	Context("with dual DC support", func() {
+		var (
+			specService   *lockedSpecService
+			dualDCSupport *DualDCSupport
+		)
+
+		BeforeEach(func() {
+			specService = &lockedSpecService{FakeV1Service: fakeas.NewFakeV1Service()}
+			specService.Spec = boshas.V1ApplySpec{
+				Passive:            "disabled",
+				DNSRegisterOnStart: "app.dc1.example.com",
+				PropertiesSpec: boshas.PropertiesSpec{
+					DNSSpec: boshas.DNSSpec{
+						DNSServers: []string{server.Addr()},
+						Key:        "hmac-sha256:update-key:" + base64.StdEncoding.EncodeToString([]byte("fake-secret")),
+						TTL:        30,
+					},
+				},
+			}
+
+			settingsService := &fakesettings.FakeSettingsService{}
+			settingsService.Settings.Networks = boshsettings.Networks{
+				"default": boshsettings.Network{IP: "10.76.245.71"},
+			}
+
+			dualDCSupport = NewDualDCSupport(
+				fakesys.NewFakeCmdRunner(),
+				fakesys.NewFakeFileSystem(),
+				boshdir.NewProvider("/var/vcap"),
+				specService,
+				settingsService,
+				fakedpresolv.NewFakeDevicePathResolver(),
+				boshlog.NewLogger(boshlog.LevelNone),
+			)
+		})
+
+		Describe("updateAllDNSServers", func() {
+			It("registers the dns registration ip with every server", func() {
+				_, err := dualDCSupport.updateAllDNSServers()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(server.Updates()).To(HaveLen(1))
+				Expect(server.Updates()[0].Question[0].Name).To(Equal("example.com"))
+				Expect(server.Updates()[0].Authority[1]).To(Equal(
+					dnsRR{Name: "app.dc1.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 30, Data: []byte{10, 76, 245, 71}},
+				))
+			})
+		})
+
+		Describe("updateAllDNSServers with a failing server", func() {
+			It("still updates the other servers and records the status of each", func() {
+				otherServer := newFakeDNSServer("example.com", key)
+				defer otherServer.Close()
+
+				server.SetUpdateRcode(DNSRcodeRefused)
+				specService.Spec.PropertiesSpec.DNSSpec.DNSServers = []string{server.Addr(), otherServer.Addr()}
+
+				_, err := dualDCSupport.updateAllDNSServers()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Registering 'app.dc1.example.com' failed on dns servers: " + server.Addr()))
+				Expect(otherServer.Updates()).To(HaveLen(1))
+
+				statuses, err := dualDCSupport.DNSStatus()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(statuses).To(HaveLen(2))
+				Expect(statuses[0].ConsecutiveFailures).To(Equal(1))
+				Expect(statuses[0].LastError).To(ContainSubstring("responded REFUSED"))
+				Expect(statuses[0].LastSuccess).To(BeNil())
+				Expect(statuses[1].ConsecutiveFailures).To(Equal(0))
+				Expect(statuses[1].LastSuccess).ToNot(BeNil())
+			})
+		})
+
+		Describe("DeregisterDNSIfRequired", func() {
+			It("deletes the record only while it points at this host", func() {
+				err := dualDCSupport.DeregisterDNSIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(server.Updates()).To(HaveLen(1))
+				request := server.Updates()[0]
+				Expect(request.Answer).To(Equal([]dnsRR{
+					{Name: "app.dc1.example.com", Type: dnsTypeA, Class: dnsClassIN, Data: []byte{10, 76, 245, 71}},
+				}))
+				Expect(request.Authority).To(Equal([]dnsRR{
+					{Name: "app.dc1.example.com", Type: dnsTypeA, Class: dnsClassNONE, Data: []byte{10, 76, 245, 71}},
+				}))
+			})
+
+			It("succeeds when the record already points elsewhere", func() {
+				server.SetUpdateRcode(DNSRcodeNXRRSet)
+
+				err := dualDCSupport.DeregisterDNSIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("tries every server and reports the failed ones", func() {
+				otherServer := newFakeDNSServer("example.com", key)
+				defer otherServer.Close()
+
+				server.SetUpdateRcode(DNSRcodeRefused)
+				specService.Spec.PropertiesSpec.DNSSpec.DNSServers = []string{server.Addr(), otherServer.Addr()}
+
+				err := dualDCSupport.DeregisterDNSIfRequired()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Deregistering 'app.dc1.example.com' failed on dns servers: " + server.Addr()))
+				Expect(err.Error()).To(ContainSubstring("responded REFUSED"))
+				Expect(err.Error()).ToNot(ContainSubstring(otherServer.Addr()))
+				Expect(otherServer.Updates()).To(HaveLen(1))
+			})
+
+			It("does nothing on the passive leg", func() {
+				specService.Spec.Passive = "enabled"
+
+				err := dualDCSupport.DeregisterDNSIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.Updates()).To(BeEmpty())
+			})
+		})
+
+		Describe("StopDNSUpdatesIfRequired", func() {
+			It("stops the updates once when called concurrently", func() {
+				err := dualDCSupport.StartDNSUpdatesIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(server.Updates).Should(HaveLen(1))
+
+				var wg sync.WaitGroup
+				for i := 0; i < 5; i++ {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						Expect(dualDCSupport.StopDNSUpdatesIfRequired()).To(Succeed())
+					}()
+				}
+				wg.Wait()
+
+				err = dualDCSupport.DeregisterDNSIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.Updates()).To(HaveLen(2))
+			})
+		})
+
+		Describe("WithdrawDNSIfPassive", func() {
+			It("stops updates and deregisters when the leg became passive", func() {
+				err := dualDCSupport.StartDNSUpdatesIfRequired()
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(server.Updates).Should(HaveLen(1))
+
+				specService.Update(func(spec *boshas.V1ApplySpec) { spec.Passive = "enabled" })
+
+				err = dualDCSupport.WithdrawDNSIfPassive()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(server.Updates()).To(HaveLen(2))
+				Expect(server.Updates()[1].Authority[0].Class).To(Equal(dnsClassNONE))
+			})
+
+			It("does nothing when the leg was not registering", func() {
+				specService.Spec.Passive = "enabled"
+
+				err := dualDCSupport.WithdrawDNSIfPassive()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(server.Updates()).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -1,14 +1,20 @@
 package nimbus
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"text/template"
 	"time"
+	"unicode"
 
 	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+	boshdpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver"
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
 	boshdisk "github.com/cloudfoundry/bosh-agent/platform/disk"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
@@ -18,15 +24,26 @@ import (
 )
 
 type DualDCSupport struct {
-	cmdRunner       boshsys.CmdRunner
-	fs              boshsys.FileSystem
-	dirProvider     boshdir.Provider
-	specService     boshas.V1Service
-	settingsService boshsettings.Service
-	mounter         boshdisk.Mounter
-	formatter       boshdisk.Formatter
-	cancelChan      chan struct{}
-	logger          boshlog.Logger
+	cmdRunner          boshsys.CmdRunner
+	fs                 boshsys.FileSystem
+	dirProvider        boshdir.Provider
+	specService        boshas.V1Service
+	settingsService    boshsettings.Service
+	devicePathResolver boshdpresolv.DevicePathResolver
+	mounter            boshdisk.Mounter
+	formatter          boshdisk.Formatter
+	partitioner        boshdisk.Partitioner
+	dnsUpdates         *dnsUpdater
+	dnsStatus          *dnsStatusTracker
+	dnsHealth          *dnsHealthTracker
+	jobSupervisor      boshjobsuper.JobSupervisor
+	peer               *peerTracker
+	witnessLease       *witnessLease
+	peerPromoter       func() error
+	mbusStatus         boshhandler.StatusProvider
+	alertFailures      *alertFailureCounter
+	drbdReports        *drbdReportCache
+	logger             boshlog.Logger
 }
 
 func NewDualDCSupport(
@@ -35,6 +52,7 @@ func NewDualDCSupport(
 	dirProvider boshdir.Provider,
 	specService boshas.V1Service,
 	settingsService boshsettings.Service,
+	devicePathResolver boshdpresolv.DevicePathResolver,
 	logger boshlog.Logger,
 ) *DualDCSupport {
 
@@ -42,29 +60,60 @@ func NewDualDCSupport(
 	linuxFormatter := boshdisk.NewLinuxFormatter(cmdRunner, fs)
 
 	return &DualDCSupport{
-		cmdRunner:       cmdRunner,
-		fs:              fs,
-		dirProvider:     dirProvider,
-		specService:     specService,
-		settingsService: settingsService,
-		mounter:         linuxMounter,
-		formatter:       linuxFormatter,
-		logger:          logger,
+		cmdRunner:          cmdRunner,
+		fs:                 fs,
+		dirProvider:        dirProvider,
+		specService:        specService,
+		settingsService:    settingsService,
+		devicePathResolver: devicePathResolver,
+		mounter:            linuxMounter,
+		formatter:          linuxFormatter,
+		partitioner:        boshdisk.NewSfdiskPartitioner(logger, cmdRunner),
+		dnsUpdates:         newDNSUpdater(),
+		dnsStatus:          newDNSStatusTracker(),
+		dnsHealth:          newDNSHealthTracker(),
+		peer:               newPeerTracker(),
+		witnessLease:       newWitnessLease(),
+		alertFailures:      &alertFailureCounter{},
+		drbdReports:        &drbdReportCache{},
+		logger:             logger,
 	}
 }
 
+// DrbdResource derives the DRBD resource layout from the current apply spec.
+// BackingDevice is not resolved, see drbdBackingDevice.
+func (d DualDCSupport) DrbdResource() (resource DrbdResource, err error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return resource, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	return NewDrbdResource(spec), nil
+}
+
 func (d DualDCSupport) setupDRBD() (err error) {
 	d.logger.Info(nimbusLogTag, "setupDRBD - begin")
 
-	if err = d.writeDrbdConfig(); err != nil {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return bosherr.WrapError(err, "DualDCSupport.setupDRBD() error deriving drbd resource")
+	}
+
+	resource.BackingDevice, err = d.drbdBackingDevice()
+	if err != nil {
+		return bosherr.WrapError(err, "DualDCSupport.setupDRBD() error calling drbdBackingDevice()")
+	}
+
+	change, err := d.writeDrbdConfig(resource)
+	if err != nil {
 		return bosherr.WrapError(err, "DualDCSupport.setupDRBD() error calling writeDrbdConfig()")
 	}
 
-	if err = d.createLvm(); err != nil {
+	if err = d.createLvm(resource); err != nil {
 		return bosherr.WrapError(err, "DualDCSupport.setupDRBD() error calling createLvm()")
 	}
 
-	if err = d.drbdCreatePartition(); err != nil {
+	if err = d.drbdCreatePartition(resource, change); err != nil {
 		return bosherr.WrapError(err, "DualDCSupport.setupDRBD() error calling drbdCreatePartition()")
 	}
 
@@ -74,6 +123,11 @@ func (d DualDCSupport) setupDRBD() (err error) {
 func (d DualDCSupport) mountDRBD() (err error) {
 	d.logger.Info(nimbusLogTag, "Drbd mount - begin")
 
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return bosherr.WrapError(err, "mountDRBD() -> error deriving drbd resource")
+	}
+
 	mountPoint := d.dirProvider.StoreDir()
 
 	isMounted, err := d.mounter.IsMounted(mountPoint)
@@ -85,7 +139,7 @@ func (d DualDCSupport) mountDRBD() (err error) {
 		return
 	}
 
-	err = d.drbdMakePrimary()
+	err = d.drbdMakePrimary(resource)
 	if err != nil {
 		return bosherr.WrapError(err, "mountDRBD() -> error calling drbdMakePrimary()")
 	}
@@ -95,18 +149,20 @@ func (d DualDCSupport) mountDRBD() (err error) {
 		return bosherr.WrapError(err, "mountDRBD() -> error calling fs.MkdirAll()")
 	}
 
-	out, _, _, err := d.cmdRunner.RunCommand("sh", "-c", "file -s /dev/drbd1")
+	device := resource.Device()
+
+	out, _, _, err := d.cmdRunner.RunCommand("file", "-s", device)
 	if err != nil {
 		return bosherr.WrapError(err, "mountDRBD() -> error checking if filesystem exists")
 	}
-	if strings.HasPrefix(out, "/dev/drbd1: data") {
-		err = d.formatter.Format("/dev/drbd1", boshdisk.FileSystemExt4)
+	if strings.HasPrefix(out, device+": data") {
+		err = d.formatter.Format(device, boshdisk.FileSystemExt4)
 		if err != nil {
 			return bosherr.WrapError(err, "mountDRBD() -> error calling formatter.Format")
 		}
 	}
 
-	err = d.mounter.Mount("/dev/drbd1", mountPoint)
+	err = d.mounter.Mount(device, mountPoint)
 	if err != nil {
 		return bosherr.WrapError(err, "mountDRBD() -> error calling mounter.Mount()")
 	}
@@ -117,6 +173,11 @@ func (d DualDCSupport) mountDRBD() (err error) {
 func (d DualDCSupport) unmountDRBD() (didUnmount bool, err error) {
 	d.logger.Info(nimbusLogTag, "Drbd unmount - begin")
 
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return false, bosherr.WrapError(err, "unmountDRBD() -> error deriving drbd resource")
+	}
+
 	mountPoint := d.dirProvider.StoreDir()
 
 	didUnmount, err = d.mounter.Unmount(mountPoint)
@@ -127,8 +188,8 @@ func (d DualDCSupport) unmountDRBD() (didUnmount bool, err error) {
 	// In certain scenarios drbd may not have been setup yet: non-drbd job changed to drbd-enabled job - OnStartAction
 	// un-mounts disk first (now drbd enabled according to job spec) but drbd has not been setup yet.
 	// It will be when the disk is mounted - hence the check below
-	if d.isDRBDConfigWritten() {
-		err = d.drbdMakeSecondary()
+	if d.isDRBDConfigWritten(resource) {
+		err = d.drbdMakeSecondary(resource)
 		if err != nil {
 			return false, bosherr.WrapError(err, "unmountDRBD() -> error calling drbdMakeSecondary")
 		}
@@ -137,8 +198,8 @@ func (d DualDCSupport) unmountDRBD() (didUnmount bool, err error) {
 	return
 }
 
-func (d DualDCSupport) isDRBDConfigWritten() bool {
-	return d.fs.FileExists(drbdConfigLocation)
+func (d DualDCSupport) isDRBDConfigWritten(resource DrbdResource) bool {
+	return d.fs.FileExists(resource.ConfigPath())
 }
 
 func (d DualDCSupport) persistentDiskSettings() (persistentDisk boshsettings.DiskSettings, found bool) {
@@ -154,6 +215,39 @@ func (d DualDCSupport) persistentDiskSettings() (persistentDisk boshsettings.Dis
 	return
 }
 
+// drbdBackingDevice resolves the first partition of the persistent disk, e.g. /dev/sdc1 or /dev/nvme0n1p1
+func (d DualDCSupport) drbdBackingDevice() (device string, err error) {
+	disk, err := d.persistentDiskDevice()
+	if err != nil {
+		return "", err
+	}
+
+	return diskPartition(disk, drbdBackingPartition), nil
+}
+
+// diskPartition names a partition of disk the way the kernel does,
+// with a p separator when the disk name ends in a digit (nvme0n1p1, mmcblk0p1)
+func diskPartition(disk string, number int) string {
+	if disk != "" && unicode.IsDigit(rune(disk[len(disk)-1])) {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
+
+func (d DualDCSupport) persistentDiskDevice() (device string, err error) {
+	diskSettings, found := d.persistentDiskSettings()
+	if !found {
+		return "", errors.New("Persistent disk not found")
+	}
+
+	realPath, _, err := d.devicePathResolver.GetRealDevicePath(diskSettings)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Getting real device path of persistent disk %s", diskSettings.ID)
+	}
+
+	return realPath, nil
+}
+
 func (d DualDCSupport) isPassiveSide() (passive bool, err error) {
 	spec, err := d.specService.Get()
 	if err != nil {
@@ -164,14 +258,8 @@ func (d DualDCSupport) isPassiveSide() (passive bool, err error) {
 	return
 }
 
-func (d DualDCSupport) createLvm() (err error) {
-
-	//	diskSettings, found := d.persistentDiskSettings()
-	//	if !found {
-	//		return errors.New("DualDCSupport.createLvm(): persistent disk not found")
-	//	}
-
-	device := "/dev/sdc1"
+func (d DualDCSupport) createLvm(resource DrbdResource) (err error) {
+	device := resource.BackingDevice
 
 	out, _, _, _ := d.cmdRunner.RunCommand("pvs")
 	if !strings.Contains(out, device) {
@@ -181,26 +269,29 @@ func (d DualDCSupport) createLvm() (err error) {
 		if _, _, _, err = d.cmdRunner.RunCommand("pvcreate", device); err != nil {
 			return bosherr.WrapError(err, "Creating physical volume")
 		}
-		if _, _, _, err := d.cmdRunner.RunCommand("vgcreate", "vgStoreData", device); err != nil {
+		if _, _, _, err := d.cmdRunner.RunCommand("vgcreate", resource.VolumeGroup, device); err != nil {
 			return bosherr.WrapError(err, "Creating volume group")
 		}
 	}
 
 	out, _, _, _ = d.cmdRunner.RunCommand("lvs")
-	matchFound, _ := regexp.MatchString("StoreData\\s+vgStoreData", out)
+	lvPattern := regexp.QuoteMeta(resource.LogicalVolume) + `\s+` + regexp.QuoteMeta(resource.VolumeGroup) + `\s`
+	matchFound, _ := regexp.MatchString(lvPattern, out)
 	if !matchFound {
 		if _, err := d.mounter.Unmount(device); err != nil {
 			return bosherr.WrapError(err, "Unmounting device before creating logical volume")
 		}
-		if _, _, _, err := d.cmdRunner.RunCommand("sh", "-c", "lvcreate -n StoreData -l 40%FREE vgStoreData"); err != nil {
-			return bosherr.WrapError(err, "when running: lvcreate -n StoreData -l 40%FREE vgStoreData")
+		args := append([]string{"-n", resource.LogicalVolume}, resource.lvcreateSizeArgs()...)
+		args = append(args, resource.VolumeGroup)
+		if _, _, _, err := d.cmdRunner.RunCommand("lvcreate", args...); err != nil {
+			return bosherr.WrapErrorf(err, "when running: lvcreate %s", strings.Join(args, " "))
 		}
 	}
 
 	return
 }
 
-func (d DualDCSupport) drbdCreatePartition() (err error) {
+func (d DualDCSupport) drbdCreatePartition(resource DrbdResource, change drbdConfigChange) (err error) {
 
 	// TODO: looks like none of this is needed
 	//	out, _, _, _ := d.cmdRunner.RunCommand("drbdadm dstate r0")
@@ -211,28 +302,21 @@ func (d DualDCSupport) drbdCreatePartition() (err error) {
 	//		return
 	//	}
 
-	out, _, _, err := d.cmdRunner.RunCommand("sh", "-c", "echo 'yes' | drbdadm dump-md r0 2>&1")
+	out, _, _, err := d.cmdRunner.RunCommand("sh", "-c", "echo 'yes' | drbdadm dump-md "+resource.Name+" 2>&1")
 	//	if err != nil {
 	//		return bosherr.WrapErrorf(err, "Failure: drbdadm dump-md r0. Output: %s", out)
 	//	}
 	if strings.Contains(out, "No valid meta data found") {
-		_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "echo 'yes' | drbdadm create-md r0")
+		_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "echo 'yes' | drbdadm create-md "+resource.Name)
 		if err != nil {
 			return
 		}
 	}
 
-	_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "drbdadm down r0")
-	if err != nil {
-		return
-	}
-
-	_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "drbdadm up r0")
-
-	return
+	return d.applyDrbdConfig(resource, change)
 }
 
-func (d DualDCSupport) drbdMakePrimary() (err error) {
+func (d DualDCSupport) drbdMakePrimary(resource DrbdResource) (err error) {
 	d.logger.Info(nimbusLogTag, "Drbd making primary")
 
 	spec, err := d.specService.Get()
@@ -240,117 +324,190 @@ func (d DualDCSupport) drbdMakePrimary() (err error) {
 		return
 	}
 
+	if isMultiPeer(spec) {
+		if err = d.checkDrbdQuorum(resource); err != nil {
+			return
+		}
+	}
+
 	if spec.DrbdForceMaster {
-		_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "drbdadm primary --force r0")
+		_, _, _, err = d.cmdRunner.RunCommand("drbdadm", "primary", "--force", resource.Name)
 	} else {
-		_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "drbdadm primary r0")
+		_, _, _, err = d.cmdRunner.RunCommand("drbdadm", "primary", resource.Name)
 	}
 
 	return
 }
 
-func (d DualDCSupport) drbdMakeSecondary() (err error) {
+func (d DualDCSupport) drbdMakeSecondary(resource DrbdResource) (err error) {
 	d.logger.Info(nimbusLogTag, "Drbd making secondary")
 
-	// make sure that dstate is UpToDate on both sides
-	for i := 0; i <= 10; i++ {
+	if err = d.waitForDrbdInSync(resource, 10); err != nil {
+		return
+	}
+
+	_, _, _, err = d.cmdRunner.RunCommand("drbdadm", "secondary", resource.Name)
+	return
+}
+
+// waitForDrbdInSync makes sure that dstate is UpToDate on this node and every peer
+func (d DualDCSupport) waitForDrbdInSync(resource DrbdResource, attempts int) error {
+	for i := 0; i <= attempts; i++ {
 		d.logger.Debug(nimbusLogTag, "Checking if both sides are in sync before demoting to secondary")
-		out, _, _, err := d.cmdRunner.RunCommand("sh", "-c", "drbdadm dstate r0")
+		out, _, _, err := d.cmdRunner.RunCommand("drbdadm", "dstate", resource.Name)
 		if err != nil {
-			return bosherr.WrapError(err, "Checking 'drbdadm dstate r0' before making secondary")
+			return bosherr.WrapErrorf(err, "Checking 'drbdadm dstate %s' before making secondary", resource.Name)
+		}
+		if drbdDiskStatesUpToDate(out) {
+			return nil
 		}
-		if matchFound, _ := regexp.MatchString("UpToDate/UpToDate", out); matchFound {
+		if i == attempts {
 			break
 		}
-		if i == 10 {
-			return errors.New("Checked 'drbdadm dstate r0' 10 times, still not in sync, can not make secondary...")
+		time.Sleep(drbdSyncCheckInterval)
+	}
+	return bosherr.Errorf("Checked 'drbdadm dstate %s' %d times, still not in sync, can not make secondary...", resource.Name, attempts)
+}
+
+// drbdDiskStatesUpToDate checks the local/peer disk states of dstate, one pair per
+// line and volume on DRBD 9, so none of the peers of a multi-peer resource is left out.
+func drbdDiskStatesUpToDate(dstate string) bool {
+	states := strings.FieldsFunc(dstate, func(r rune) bool {
+		return r == '/' || unicode.IsSpace(r)
+	})
+	if len(states) < 2 {
+		return false
+	}
+
+	for _, state := range states {
+		if state != "UpToDate" {
+			return false
 		}
-		time.Sleep(3 * time.Second)
 	}
-	_, _, _, err = d.cmdRunner.RunCommand("sh", "-c", "drbdadm secondary r0")
+
+	return true
+}
+
+// writeDrbdConfig returns how the new config differs from the one written before
+func (d DualDCSupport) writeDrbdConfig(resource DrbdResource) (change drbdConfigChange, err error) {
+	configBody, err := d.renderResourceConfig(resource)
+	if err != nil {
+		return
+	}
+
+	previous, err := d.writtenDrbdConfig(resource)
+	if err != nil {
+		return
+	}
+
+	change = drbdConfigChangeBetween(previous, configBody)
+
+	err = d.fs.WriteFileString(resource.ConfigPath(), configBody)
+
 	return
 }
 
-func (d DualDCSupport) writeDrbdConfig() (err error) {
+func (d DualDCSupport) renderResourceConfig(resource DrbdResource) (configBody string, err error) {
 	spec, err := d.specService.Get()
 	if err != nil {
-		return bosherr.WrapError(err, "Fetching spec")
+		return "", bosherr.WrapError(err, "Fetching spec")
 	}
 
-	thisHostIP, err := d.thisHostIP()
+	afterSplitBrain, err := splitBrainPolicy(spec.DrbdSplitBrainPolicy)
 	if err != nil {
 		return
 	}
-	otherHostIP := ""
 
-	if thisHostIP == spec.DrbdReplicationNode1 {
-		otherHostIP = spec.DrbdReplicationNode2
-	} else {
-		otherHostIP = spec.DrbdReplicationNode1
+	if isMultiPeer(spec) {
+		return d.renderMultiPeerConfig(spec, resource, afterSplitBrain)
 	}
 
-	configBody := fmt.Sprintf(
-		drbdConfigTemplate,
-		spec.DrbdReplicationType,
-		spec.DrbdSecret,
-		d.settingsService.GetSettings().AgentID,
-		thisHostIP,
-		otherHostIP,
-	)
+	thisHostIP, otherHostIP, err := d.replicationPeers(spec)
+	if err != nil {
+		return
+	}
 
-	err = d.fs.WriteFileString(drbdConfigLocation, configBody)
+	return renderDrbdConfig(drbdConfigArgs{
+		Resource:         resource,
+		ReplicationType:  spec.DrbdReplicationType,
+		Secret:           spec.DrbdSecret,
+		AfterSplitBrain:  afterSplitBrain,
+		SplitBrainMarker: d.splitBrainMarkerPath(resource),
+		Tuning:           newDrbdTuning(spec),
+		ThisHostName:     d.settingsService.GetSettings().AgentID,
+		ThisHostIP:       thisHostIP,
+		OtherHostIP:      otherHostIP,
+	})
+}
 
-	return
+type drbdConfigArgs struct {
+	Resource         DrbdResource
+	ReplicationType  string
+	Secret           string
+	AfterSplitBrain  afterSplitBrain
+	SplitBrainMarker string
+	Tuning           drbdTuning
+	ThisHostName     string
+	ThisHostIP       string
+	OtherHostIP      string
 }
 
-func (d DualDCSupport) thisHostIP() (ip string, err error) {
-	ips := d.settingsService.GetSettings().Networks.IPs()
+func renderDrbdConfig(args drbdConfigArgs) (string, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	t := template.Must(template.New("drbd-config").Parse(drbdConfigTemplate))
 
-	if len(ips) == 0 {
-		return "", errors.New("DualDCSupport.thisHostIP() -> settingsService.GetSettings().Networks.IPs(), no ip found")
+	if err := t.Execute(buffer, args); err != nil {
+		return "", bosherr.WrapError(err, "Generating drbd config from template")
 	}
 
-	// TODO: is this correct??? what if multiple networks are configured???
-	return ips[0], nil
+	return buffer.String(), nil
 }
 
-const drbdConfigLocation = "/etc/drbd.d/r0.res"
-
 const nimbusLogTag = "Nimbus"
 
+const drbdSyncCheckInterval = 3 * time.Second
+
+// number of the partition of the persistent disk holding the LVM physical volume
+const drbdBackingPartition = 1
+
 // TODO: add data-integrity-alg sha1; to net section??? kind of makes sense with A protocol???
-// TODO: split brain hanlder: split-brain "/usr/lib/drbd/notify-split-brain.sh root";
 // TODO: congestion policy: https://drbd.linbit.com/users-guide/s-configure-congestion-policy.html
 
 const drbdConfigTemplate = `
-resource r0 {
+resource {{ .Resource.Name }} {
   net {
-    protocol %s;
-    shared-secret %s;
+    protocol {{ .ReplicationType }};
+    shared-secret {{ .Secret }};
     verify-alg sha1;
+    after-sb-0pri {{ .AfterSplitBrain.ZeroPrimaries }};
+    after-sb-1pri {{ .AfterSplitBrain.OnePrimary }};
+    after-sb-2pri {{ .AfterSplitBrain.TwoPrimaries }};{{ if .Tuning.OnCongestion }}
+    on-congestion {{ .Tuning.OnCongestion }};{{ end }}{{ if .Tuning.CongestionFill }}
+    congestion-fill {{ .Tuning.CongestionFill }};{{ end }}
   }
   disk {
-    resync-rate 24M;
+    resync-rate {{ .Tuning.ResyncRate }};
   }
   handlers {
     before-resync-target "/lib/drbd/snapshot-resync-target-lvm.sh";
     after-resync-target "/lib/drbd/unsnapshot-resync-target-lvm.sh";
+    split-brain "/bin/touch {{ .SplitBrainMarker }}";
   }
   startup {
     wfc-timeout 3;
     degr-wfc-timeout 3;
     outdated-wfc-timeout 2;
   }
-  on %s {
-    device    drbd1;
-    disk      /dev/mapper/vgStoreData-StoreData;
-    address   %s:7789;
+  on {{ .ThisHostName }} {
+    device    {{ .Resource.DeviceName }};
+    disk      {{ .Resource.LogicalVolumeDevice }};
+    address   {{ .ThisHostIP }}:{{ .Resource.Port }};
     meta-disk internal;
   }
   on host2 {
-    device    drbd1;
-    disk      /dev/mapper/vgStoreData-StoreData;
-    address   %s:7789;
+    device    {{ .Resource.DeviceName }};
+    disk      {{ .Resource.LogicalVolumeDevice }};
+    address   {{ .OtherHostIP }}:{{ .Resource.Port }};
     meta-disk internal;
   }
 }
@@ -0,0 +1,330 @@
+package nimbus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const defaultDrbdResyncRate = "24M"
+
+// drbdReportTTL is how long get_state reports the output of drbdadm --dry-run adjust
+// and lvs before running them again
+const drbdReportTTL = time.Minute
+
+// drbdConfigChange is what it takes to run a resource with a rewritten config
+type drbdConfigChange string
+
+const (
+	drbdConfigUnchanged drbdConfigChange = "unchanged"
+	drbdConfigAdjust    drbdConfigChange = "adjust"  // drbdadm adjust, the resource stays up
+	drbdConfigRestart   drbdConfigChange = "down-up" // drbdadm down and up, the store must be unmounted
+)
+
+// Options drbdadm adjust changes on a running resource,
+// anything else (hosts, devices, addresses, node ids) needs a down/up.
+var drbdOnlineOptions = map[string]bool{
+	"protocol":             true,
+	"shared-secret":        true,
+	"verify-alg":           true,
+	"after-sb-0pri":        true,
+	"after-sb-1pri":        true,
+	"after-sb-2pri":        true,
+	"on-congestion":        true,
+	"congestion-fill":      true,
+	"resync-rate":          true,
+	"quorum":               true,
+	"on-no-quorum":         true,
+	"before-resync-target": true,
+	"after-resync-target":  true,
+	"split-brain":          true,
+	"wfc-timeout":          true,
+	"degr-wfc-timeout":     true,
+	"outdated-wfc-timeout": true,
+}
+
+type drbdTuning struct {
+	ResyncRate     string
+	OnCongestion   string
+	CongestionFill string
+}
+
+func newDrbdTuning(spec boshas.V1ApplySpec) drbdTuning {
+	tuning := drbdTuning{
+		ResyncRate:     spec.DrbdResyncRate,
+		OnCongestion:   spec.DrbdOnCongestion,
+		CongestionFill: spec.DrbdCongestionFill,
+	}
+
+	if tuning.ResyncRate == "" {
+		tuning.ResyncRate = defaultDrbdResyncRate
+	}
+
+	return tuning
+}
+
+// DrbdConfigStatus tells whether the resource runs with the config rendered from the current spec
+type DrbdConfigStatus struct {
+	FileMatchesSpec    bool     `json:"file_matches_spec"`
+	RunningMatchesFile bool     `json:"running_matches_file"`
+	PendingChanges     []string `json:"pending_changes,omitempty"` // as listed by drbdadm adjust --dry-run
+}
+
+// drbdReportCache keeps the pending config changes and the snapshots reported by get_state,
+// they are cleared when the agent changes the running config or the snapshots.
+type drbdReportCache struct {
+	lock sync.Mutex
+
+	pendingConfig  string // written config the pending changes were listed for
+	pendingChanges []string
+	pendingAt      time.Time
+
+	snapshots   []DrbdSnapshot
+	snapshotsAt time.Time
+}
+
+func (c *drbdReportCache) getPendingChanges(config string, now time.Time) ([]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pendingAt.IsZero() || c.pendingConfig != config || now.Sub(c.pendingAt) >= drbdReportTTL {
+		return nil, false
+	}
+	return c.pendingChanges, true
+}
+
+func (c *drbdReportCache) setPendingChanges(config string, changes []string, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pendingConfig, c.pendingChanges, c.pendingAt = config, changes, now
+}
+
+func (c *drbdReportCache) getSnapshots(now time.Time) ([]DrbdSnapshot, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.snapshotsAt.IsZero() || now.Sub(c.snapshotsAt) >= drbdReportTTL {
+		return nil, false
+	}
+	return c.snapshots, true
+}
+
+func (c *drbdReportCache) setSnapshots(snapshots []DrbdSnapshot, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.snapshots, c.snapshotsAt = snapshots, now
+}
+
+func (c *drbdReportCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pendingAt, c.snapshotsAt = time.Time{}, time.Time{}
+}
+
+// drbdConfigChangeBetween compares two rendered configs line by line,
+// the order of the lines does not matter within the templates.
+func drbdConfigChangeBetween(previous, next string) drbdConfigChange {
+	if previous == "" {
+		return drbdConfigRestart
+	}
+
+	counts := map[string]int{}
+	for _, line := range drbdConfigLines(previous) {
+		counts[line]++
+	}
+	for _, line := range drbdConfigLines(next) {
+		counts[line]--
+	}
+
+	change := drbdConfigUnchanged
+	for line, count := range counts {
+		if count == 0 {
+			continue
+		}
+
+		if !drbdOnlineOptions[strings.Fields(line)[0]] {
+			return drbdConfigRestart
+		}
+		change = drbdConfigAdjust
+	}
+
+	return change
+}
+
+func drbdConfigLines(config string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(config, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (d DualDCSupport) writtenDrbdConfig(resource DrbdResource) (string, error) {
+	if !d.isDRBDConfigWritten(resource) {
+		return "", nil
+	}
+
+	config, err := d.fs.ReadFileString(resource.ConfigPath())
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Reading %s", resource.ConfigPath())
+	}
+
+	return config, nil
+}
+
+// drbdResourceUp is false when the resource is not attached or DRBD is not loaded
+func (d DualDCSupport) drbdResourceUp() bool {
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return false
+	}
+
+	switch status.ConnectionState {
+	case "", "not running", "Unconfigured":
+		return false
+	}
+	return true
+}
+
+// applyDrbdConfig brings the resource up with the written config,
+// it is only taken down when an option can not be changed online
+func (d DualDCSupport) applyDrbdConfig(resource DrbdResource, change drbdConfigChange) (err error) {
+	defer d.drbdReports.clear()
+
+	up := d.drbdResourceUp()
+
+	d.logger.Info(nimbusLogTag, "Applying drbd config of %s: %s (up: %t)", resource.Name, change, up)
+
+	switch {
+	case !up || change == drbdConfigRestart:
+		if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "down", resource.Name); err != nil {
+			return
+		}
+		_, _, _, err = d.cmdRunner.RunCommand("drbdadm", "up", resource.Name)
+
+	case change == drbdConfigAdjust:
+		if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "adjust", resource.Name); err != nil {
+			return bosherr.WrapErrorf(err, "Adjusting drbd resource %s", resource.Name)
+		}
+	}
+
+	return
+}
+
+// ApplyDrbdConfigOnline applies a new spec to a running resource without unmounting
+// the store. It returns false without changing anything when that is not possible:
+// the resource is down, the role of the leg changed or an option needs a down/up,
+// and when the config did not change, which leaves the apply to the cutover.
+func (d DualDCSupport) ApplyDrbdConfigOnline() (applied bool, err error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return false, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return false, err
+	}
+
+	if !d.isDRBDConfigWritten(resource) || !d.drbdResourceUp() {
+		return false, nil
+	}
+
+	observed, err := d.observeCutoverState()
+	if err != nil {
+		return false, err
+	}
+
+	if observed != cutoverTarget(spec) {
+		d.logger.Info(nimbusLogTag, "Leg is '%s' and should be '%s', drbd config needs a cutover", observed, cutoverTarget(spec))
+		return false, nil
+	}
+
+	configBody, err := d.renderResourceConfig(resource)
+	if err != nil {
+		return false, err
+	}
+
+	previous, err := d.writtenDrbdConfig(resource)
+	if err != nil {
+		return false, err
+	}
+
+	change := drbdConfigChangeBetween(previous, configBody)
+	switch change {
+	case drbdConfigUnchanged:
+		d.logger.Info(nimbusLogTag, "Drbd config of %s did not change", resource.Name)
+		return false, nil
+
+	case drbdConfigRestart:
+		d.logger.Info(nimbusLogTag, "Drbd config of %s can not be changed online", resource.Name)
+		return false, nil
+	}
+
+	if err = d.fs.WriteFileString(resource.ConfigPath(), configBody); err != nil {
+		return false, bosherr.WrapErrorf(err, "Writing %s", resource.ConfigPath())
+	}
+
+	if err = d.applyDrbdConfig(resource, change); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DrbdConfigStatus compares the config rendered from the spec with the written
+// one and asks drbdadm what adjust would change, nil when DRBD is disabled.
+// The answer of drbdadm is kept for drbdReportTTL while the written config stays the same.
+func (d DualDCSupport) DrbdConfigStatus() (*DrbdConfigStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return nil, nil
+	}
+
+	resource := NewDrbdResource(spec)
+
+	configBody, err := d.renderResourceConfig(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := d.writtenDrbdConfig(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &DrbdConfigStatus{FileMatchesSpec: previous == configBody}
+
+	if previous == "" || !d.drbdResourceUp() {
+		return status, nil
+	}
+
+	now := time.Now()
+	pending, found := d.drbdReports.getPendingChanges(previous, now)
+	if !found {
+		out, _, _, err := d.cmdRunner.RunCommand("drbdadm", "--dry-run", "adjust", resource.Name)
+		if err != nil {
+			return status, bosherr.WrapErrorf(err, "Checking running config of drbd resource %s", resource.Name)
+		}
+
+		pending = drbdConfigLines(out)
+		d.drbdReports.setPendingChanges(previous, pending, now)
+	}
+
+	status.PendingChanges = pending
+	status.RunningMatchesFile = len(status.PendingChanges) == 0
+
+	return status, nil
+}
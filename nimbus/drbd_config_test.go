@@ -0,0 +1,213 @@
+package nimbus
+
+import (
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DrbdConfig", func() {
+	var (
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+		dualDCSupport *DualDCSupport
+	)
+
+	// writes the config of the current spec as if it was applied before
+	writeConfig := func() {
+		config, err := dualDCSupport.renderResourceConfig(NewDrbdResource(specService.Spec))
+		Expect(err).ToNot(HaveOccurred())
+		fs.WriteFileString("/etc/drbd.d/r0.res", config)
+	}
+
+	BeforeEach(func() {
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		fs = fakesys.NewFakeFileSystem()
+		fs.WriteFileString("/proc/drbd", procDrbdPrimaryConnected)
+		cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: storeMounted, Sticky: true})
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive:              "disabled",
+			DrbdEnabled:          true,
+			DrbdReplicationNode1: "10.76.245.71",
+			DrbdReplicationNode2: "10.92.245.71",
+			DrbdReplicationType:  "C",
+			DrbdSecret:           "fake-secret",
+		}
+
+		settingsService := &fakesettings.FakeSettingsService{}
+		settingsService.Settings = boshsettings.Settings{
+			AgentID: "fake-agent-id",
+			Networks: boshsettings.Networks{
+				"default": boshsettings.Network{IP: "10.76.245.71"},
+			},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		writeConfig()
+	})
+
+	Describe("drbdConfigChangeBetween", func() {
+		It("needs nothing for the same config", func() {
+			Expect(drbdConfigChangeBetween("net {\n protocol A;\n}\n", "net {\n protocol A;\n}\n")).To(Equal(drbdConfigUnchanged))
+		})
+
+		It("adjusts options that can change online", func() {
+			Expect(drbdConfigChangeBetween("net {\n protocol A;\n}\n", "net {\n protocol C;\n}\n")).To(Equal(drbdConfigAdjust))
+			Expect(drbdConfigChangeBetween("net {\n}\n", "net {\n on-congestion pull-ahead;\n}\n")).To(Equal(drbdConfigAdjust))
+		})
+
+		It("takes the resource down for anything else", func() {
+			Expect(drbdConfigChangeBetween("address 10.0.0.1:7789;\n", "address 10.0.0.2:7789;\n")).To(Equal(drbdConfigRestart))
+			Expect(drbdConfigChangeBetween("", "net {\n}\n")).To(Equal(drbdConfigRestart))
+		})
+	})
+
+	Describe("ApplyDrbdConfigOnline", func() {
+		It("adjusts the running resource for a new protocol and resync rate", func() {
+			specService.Spec.DrbdReplicationType = "A"
+			specService.Spec.DrbdResyncRate = "100M"
+
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeTrue())
+
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "adjust", "r0"}))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "down", "r0"}))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"umount", "/var/vcap/store"}))
+
+			config, err := fs.ReadFileString("/etc/drbd.d/r0.res")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config).To(ContainSubstring("protocol A;"))
+			Expect(config).To(ContainSubstring("resync-rate 100M;"))
+		})
+
+		It("leaves an unchanged config to the cutover without running anything", func() {
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeFalse())
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement(ContainElement("drbdadm")))
+		})
+
+		It("leaves a change of the peer address to the cutover", func() {
+			specService.Spec.DrbdReplicationNode2 = "10.92.245.72"
+
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeFalse())
+
+			config, err := fs.ReadFileString("/etc/drbd.d/r0.res")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config).To(ContainSubstring("10.92.245.71:7789"))
+		})
+
+		It("leaves a change of role to the cutover", func() {
+			specService.Spec.Passive = "enabled"
+			specService.Spec.DrbdReplicationType = "A"
+
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeFalse())
+		})
+
+		It("leaves a resource that is down to the cutover", func() {
+			fs.RemoveAll("/proc/drbd")
+
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeFalse())
+		})
+	})
+
+	Describe("DrbdConfigStatus", func() {
+		dryRuns := func() (count int) {
+			for _, cmd := range cmdRunner.RunCommands {
+				if len(cmd) > 1 && cmd[0] == "drbdadm" && cmd[1] == "--dry-run" {
+					count++
+				}
+			}
+			return
+		}
+
+		It("reports a resource running the rendered config", func() {
+			status, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(&DrbdConfigStatus{FileMatchesSpec: true, RunningMatchesFile: true, PendingChanges: []string{}}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "--dry-run", "adjust", "r0"}))
+		})
+
+		It("reports what is not applied yet", func() {
+			specService.Spec.DrbdResyncRate = "100M"
+			cmdRunner.AddCmdResult("drbdadm --dry-run adjust r0", fakesys.FakeCmdResult{
+				Stdout: "drbdsetup-84 disk-options 1 --set-defaults --resync-rate=100M\n",
+			})
+
+			status, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.FileMatchesSpec).To(BeFalse())
+			Expect(status.RunningMatchesFile).To(BeFalse())
+			Expect(status.PendingChanges).To(Equal([]string{"drbdsetup-84 disk-options 1 --set-defaults --resync-rate=100M"}))
+		})
+
+		It("asks drbdadm again only after the written config changed", func() {
+			_, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dryRuns()).To(Equal(1))
+
+			specService.Spec.DrbdResyncRate = "100M"
+			status, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.FileMatchesSpec).To(BeFalse())
+			Expect(dryRuns()).To(Equal(1))
+
+			writeConfig()
+			_, err = dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dryRuns()).To(Equal(2))
+		})
+
+		It("asks drbdadm again after the config was applied", func() {
+			_, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+
+			specService.Spec.DrbdReplicationType = "A"
+			applied, err := dualDCSupport.ApplyDrbdConfigOnline()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeTrue())
+
+			specService.Spec.DrbdReplicationType = "C"
+			writeConfig()
+			_, err = dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dryRuns()).To(Equal(2))
+		})
+
+		It("is nil without drbd", func() {
+			specService.Spec.DrbdEnabled = false
+
+			status, err := dualDCSupport.DrbdConfigStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package nimbus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+)
+
+const drbdHealthCheckInterval = 30 * time.Second
+
+// DRBD health events, also the keys of drbd_alert_severity in the apply spec
+const (
+	DrbdEventConnectionLost     = "connection_lost"
+	DrbdEventConnectionRestored = "connection_restored"
+	DrbdEventDiskDegraded       = "disk_degraded"
+	DrbdEventDiskRecovered      = "disk_recovered"
+	DrbdEventResyncStarted      = "resync_started"
+	DrbdEventResyncFinished     = "resync_finished"
+	DrbdEventRoleChanged        = "role_changed"
+	DrbdEventVerifyOutOfSync    = "verify_out_of_sync"
+	DrbdEventVerifyAborted      = "verify_aborted"
+)
+
+var drbdEventDefaultSeverity = map[string]boshalert.SeverityLevel{
+	DrbdEventConnectionLost:     boshalert.SeverityCritical,
+	DrbdEventConnectionRestored: boshalert.SeverityWarning,
+	DrbdEventDiskDegraded:       boshalert.SeverityCritical,
+	DrbdEventDiskRecovered:      boshalert.SeverityWarning,
+	DrbdEventResyncStarted:      boshalert.SeverityWarning,
+	DrbdEventResyncFinished:     boshalert.SeverityWarning,
+	DrbdEventRoleChanged:        boshalert.SeverityError,
+	DrbdEventVerifyOutOfSync:    boshalert.SeverityCritical,
+	DrbdEventVerifyAborted:      boshalert.SeverityWarning,
+}
+
+var alertSeverityNames = map[string]boshalert.SeverityLevel{
+	"critical": boshalert.SeverityCritical,
+	"alert":    boshalert.SeverityAlert,
+	"error":    boshalert.SeverityError,
+	"warning":  boshalert.SeverityWarning,
+	"ignored":  boshalert.SeverityIgnored,
+}
+
+var drbdDegradedDiskStates = map[string]bool{
+	"Inconsistent": true,
+	"Outdated":     true,
+	"Diskless":     true,
+}
+
+var drbdResyncStates = map[string]bool{
+	"StartingSyncS": true,
+	"StartingSyncT": true,
+	"WFBitMapS":     true,
+	"WFBitMapT":     true,
+	"WFSyncUUID":    true,
+	"SyncSource":    true,
+	"SyncTarget":    true,
+	"PausedSyncS":   true,
+	"PausedSyncT":   true,
+}
+
+// drbdHealth is the last observed state, alerts are only sent when it changes
+type drbdHealth struct {
+	Observed       bool
+	ConnectionLost bool
+	DiskDegraded   bool
+	Resyncing      bool
+	Role           string
+
+	// by peer name for DRBD 9, where each connection is reported on its own
+	Peers map[string]drbdPeerHealth
+}
+
+type drbdPeerHealth struct {
+	ConnectionLost bool
+	Resyncing      bool
+}
+
+// MonitorDrbdHealth periodically polls the DRBD status and calls handler
+// on connection, disk, resync and role transitions.
+func (d DualDCSupport) MonitorDrbdHealth(handler DrbdAlertHandler, stopCh <-chan struct{}) {
+	defer d.logger.HandlePanic("Nimbus Monitor Drbd Health")
+
+	var health drbdHealth
+	ticker := time.NewTicker(drbdHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			health = d.checkDrbdHealth(health, handler)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d DualDCSupport) checkDrbdHealth(previous drbdHealth, handler DrbdAlertHandler) drbdHealth {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to check drbd health: %s", err)
+		return previous
+	}
+
+	resource := NewDrbdResource(spec)
+	if !spec.DrbdEnabled || !d.isDRBDConfigWritten(resource) {
+		return drbdHealth{}
+	}
+
+	status, err := d.DrbdStatus()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Checking drbd health: %s", err)
+		return previous
+	}
+
+	// not brought up yet, mount and unmount take care of it
+	if status.ConnectionState == "not running" || status.ConnectionState == "Unconfigured" {
+		return previous
+	}
+
+	current := drbdHealth{
+		Observed:       true,
+		ConnectionLost: status.ConnectionState != "Connected",
+		DiskDegraded:   drbdDegradedDiskStates[status.DiskState],
+		Resyncing:      drbdResyncStates[status.SyncState],
+		Role:           status.Role,
+	}
+
+	if len(status.Peers) > 0 {
+		current.Peers = map[string]drbdPeerHealth{}
+		for _, peer := range status.Peers {
+			current.Peers[peer.Name] = drbdPeerHealth{
+				ConnectionLost: peer.ConnectionState != "Connected",
+				Resyncing:      drbdResyncStates[peer.SyncState],
+			}
+		}
+	}
+
+	for _, alert := range drbdHealthAlerts(spec, resource, previous, current, status) {
+		d.logger.Info(nimbusLogTag, "Drbd health event '%s' on resource %s", alert.Event, resource.Name)
+
+		if err = handler(alert); err != nil {
+			d.logger.Error(nimbusLogTag, "Reporting drbd health event: %s", err)
+			return previous
+		}
+	}
+
+	return current
+}
+
+func drbdHealthAlerts(spec boshas.V1ApplySpec, resource DrbdResource, previous, current drbdHealth, status DrbdStatus) (alerts []boshalert.DrbdAlert) {
+	add := func(event, summary string) {
+		alerts = append(alerts, boshalert.DrbdAlert{
+			Resource: resource.Name,
+			Event:    strings.Replace(event, "_", " ", -1),
+			Severity: drbdEventSeverity(spec, event),
+			Summary:  summary,
+		})
+	}
+
+	// a summary of the least healthy peer would hide a second peer failing
+	for _, peer := range status.Peers {
+		was, is := previous.Peers[peer.Name], current.Peers[peer.Name]
+
+		if is.ConnectionLost && !was.ConnectionLost {
+			add(DrbdEventConnectionLost, fmt.Sprintf("DRBD resource %s lost connection to peer %s, connection state is %s", resource.Name, peer.Name, peer.ConnectionState))
+		} else if !is.ConnectionLost && was.ConnectionLost {
+			add(DrbdEventConnectionRestored, fmt.Sprintf("DRBD resource %s is connected to peer %s again", resource.Name, peer.Name))
+		}
+	}
+
+	if len(status.Peers) == 0 {
+		if current.ConnectionLost && !previous.ConnectionLost {
+			add(DrbdEventConnectionLost, fmt.Sprintf("DRBD resource %s lost connection to peer, connection state is %s", resource.Name, status.ConnectionState))
+		} else if !current.ConnectionLost && previous.ConnectionLost {
+			add(DrbdEventConnectionRestored, fmt.Sprintf("DRBD resource %s is connected to peer again", resource.Name))
+		}
+	}
+
+	if current.DiskDegraded && !previous.DiskDegraded {
+		add(DrbdEventDiskDegraded, fmt.Sprintf("DRBD resource %s local disk is %s", resource.Name, status.DiskState))
+	} else if !current.DiskDegraded && previous.DiskDegraded {
+		add(DrbdEventDiskRecovered, fmt.Sprintf("DRBD resource %s local disk is %s again", resource.Name, status.DiskState))
+	}
+
+	for _, peer := range status.Peers {
+		was, is := previous.Peers[peer.Name], current.Peers[peer.Name]
+
+		if is.Resyncing && !was.Resyncing {
+			add(DrbdEventResyncStarted, fmt.Sprintf("DRBD resource %s started resync with peer %s (%s), %d KiB out of sync", resource.Name, peer.Name, peer.SyncState, peer.OutOfSyncKiB))
+		} else if !is.Resyncing && was.Resyncing {
+			add(DrbdEventResyncFinished, fmt.Sprintf("DRBD resource %s finished resync with peer %s", resource.Name, peer.Name))
+		}
+	}
+
+	if len(status.Peers) == 0 {
+		if current.Resyncing && !previous.Resyncing {
+			add(DrbdEventResyncStarted, fmt.Sprintf("DRBD resource %s started resync (%s), %d KiB out of sync", resource.Name, status.SyncState, status.OutOfSyncKiB))
+		} else if !current.Resyncing && previous.Resyncing {
+			add(DrbdEventResyncFinished, fmt.Sprintf("DRBD resource %s finished resync", resource.Name))
+		}
+	}
+
+	expectedRole := drbdExpectedRole(spec)
+	if previous.Observed && current.Role != previous.Role && expectedRole != "" && current.Role != expectedRole {
+		add(DrbdEventRoleChanged, fmt.Sprintf("DRBD resource %s changed role from %s to %s, expected %s", resource.Name, previous.Role, current.Role, expectedRole))
+	}
+
+	return alerts
+}
+
+// drbdExpectedRole is the role following from the passive setting of this leg
+func drbdExpectedRole(spec boshas.V1ApplySpec) string {
+	switch {
+	case spec.IsActiveSide():
+		return "Primary"
+	case spec.IsPassiveSide():
+		return "Secondary"
+	}
+	return ""
+}
+
+func drbdEventSeverity(spec boshas.V1ApplySpec, event string) boshalert.SeverityLevel {
+	return alertSeverity(spec.DrbdAlertSeverity, drbdEventDefaultSeverity, event)
+}
+
+// alertSeverity is the severity set for the event in the spec, the default one otherwise
+func alertSeverity(configured map[string]string, defaults map[string]boshalert.SeverityLevel, event string) boshalert.SeverityLevel {
+	if name, found := configured[event]; found {
+		if severity, found := alertSeverityNames[strings.ToLower(name)]; found {
+			return severity
+		}
+	}
+	return defaults[event]
+}
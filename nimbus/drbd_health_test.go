@@ -0,0 +1,233 @@
+package nimbus
+
+import (
+	"errors"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const procDrbdConnected = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+const procDrbdWFConnection = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:WFConnection ro:Primary/Unknown ds:UpToDate/DUnknown A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:2048
+`
+
+const procDrbdSyncTarget = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:SyncTarget ro:Secondary/Primary ds:Inconsistent/UpToDate A r-----
+    ns:0 nr:1024 dw:1024 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:2048
+`
+
+const procDrbd9 = `version: 9.0.28-1 (api:2/proto:86-119)
+`
+
+const drbdsetupStatusPeerConnecting = `r0 node-id:0 role:Primary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:yes
+  dc1-b node-id:1 connection:Connecting role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+  dc2-a node-id:2 connection:Connected role:Secondary
+    volume:0 replication:Established peer-disk:UpToDate resync-suspended:no
+`
+
+const drbdsetupStatusPeersDown = `r0 node-id:0 role:Primary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:no
+  dc1-b node-id:1 connection:Connecting role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+  dc2-a node-id:2 connection:StandAlone role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+`
+
+const drbdsetupStatusPeerResyncing = `r0 node-id:0 role:Primary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:yes
+  dc1-b node-id:1 connection:Connected role:Secondary
+    volume:0 replication:SyncSource peer-disk:Inconsistent done:10.00 resync-suspended:no
+        received:0 sent:1024 out-of-sync:4096 pending:0 unacked:0
+  dc2-a node-id:2 connection:Connected role:Secondary
+    volume:0 replication:Established peer-disk:UpToDate resync-suspended:no
+`
+
+var _ = Describe("DrbdHealth", func() {
+	var (
+		dualDCSupport *DualDCSupport
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+
+		alerts  []boshalert.DrbdAlert
+		handler DrbdAlertHandler
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		fs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+
+		alerts = nil
+		handler = func(alert boshalert.DrbdAlert) error {
+			alerts = append(alerts, alert)
+			return nil
+		}
+	})
+
+	check := func(previous drbdHealth, procDrbd string) drbdHealth {
+		fs.WriteFileString("/proc/drbd", procDrbd)
+		return dualDCSupport.checkDrbdHealth(previous, handler)
+	}
+
+	It("does not alert while healthy", func() {
+		health := check(drbdHealth{}, procDrbdConnected)
+		check(health, procDrbdConnected)
+		Expect(alerts).To(BeEmpty())
+	})
+
+	It("alerts once when connection is lost and again when restored", func() {
+		health := check(drbdHealth{}, procDrbdConnected)
+
+		health = check(health, procDrbdWFConnection)
+		Expect(alerts).To(HaveLen(1))
+		Expect(alerts[0].Resource).To(Equal("r0"))
+		Expect(alerts[0].Event).To(Equal("connection lost"))
+		Expect(alerts[0].Severity).To(Equal(boshalert.SeverityCritical))
+		Expect(alerts[0].Summary).To(ContainSubstring("WFConnection"))
+
+		health = check(health, procDrbdWFConnection)
+		Expect(alerts).To(HaveLen(1))
+
+		check(health, procDrbdConnected)
+		Expect(alerts).To(HaveLen(2))
+		Expect(alerts[1].Event).To(Equal("connection restored"))
+		Expect(alerts[1].Severity).To(Equal(boshalert.SeverityWarning))
+	})
+
+	It("alerts on resync, degraded disk and unexpected role", func() {
+		health := check(drbdHealth{}, procDrbdConnected)
+
+		health = check(health, procDrbdSyncTarget)
+		Expect(alerts).To(HaveLen(3))
+		Expect(alerts[0].Event).To(Equal("disk degraded"))
+		Expect(alerts[1].Event).To(Equal("resync started"))
+		Expect(alerts[2].Event).To(Equal("role changed"))
+		Expect(alerts[2].Summary).To(ContainSubstring("from Primary to Secondary, expected Primary"))
+
+		check(health, procDrbdConnected)
+		Expect(alerts).To(HaveLen(5))
+		Expect(alerts[3].Event).To(Equal("disk recovered"))
+		Expect(alerts[4].Event).To(Equal("resync finished"))
+	})
+
+	It("does not alert on role matching passive setting", func() {
+		health := check(drbdHealth{}, procDrbdConnected)
+
+		specService.Spec.Passive = "enabled"
+		check(health, procDrbdSyncTarget)
+		for _, alert := range alerts {
+			Expect(alert.Event).ToNot(Equal("role changed"))
+		}
+	})
+
+	It("uses severity configured in apply spec", func() {
+		specService.Spec.DrbdAlertSeverity = map[string]string{
+			DrbdEventConnectionLost: "warning",
+			DrbdEventResyncStarted:  "ignored",
+			DrbdEventDiskDegraded:   "bogus",
+		}
+
+		check(drbdHealth{}, procDrbdSyncTarget)
+		check(drbdHealth{Observed: true, Role: "Secondary"}, procDrbdWFConnection)
+
+		severities := map[string]boshalert.SeverityLevel{}
+		for _, alert := range alerts {
+			severities[alert.Event] = alert.Severity
+		}
+		Expect(severities).To(Equal(map[string]boshalert.SeverityLevel{
+			"disk degraded":   boshalert.SeverityCritical,
+			"resync started":  boshalert.SeverityIgnored,
+			"connection lost": boshalert.SeverityWarning,
+		}))
+	})
+
+	It("keeps previous state when alert could not be sent", func() {
+		previous := check(drbdHealth{}, procDrbdConnected)
+
+		fs.WriteFileString("/proc/drbd", procDrbdWFConnection)
+		health := dualDCSupport.checkDrbdHealth(previous, func(boshalert.DrbdAlert) error {
+			return errors.New("fake-send-error")
+		})
+		Expect(health).To(Equal(previous))
+	})
+
+	It("does not check before drbd is brought up", func() {
+		health := dualDCSupport.checkDrbdHealth(drbdHealth{}, handler)
+		Expect(health).To(Equal(drbdHealth{}))
+		Expect(alerts).To(BeEmpty())
+	})
+
+	It("does not check when drbd is disabled", func() {
+		specService.Spec = boshas.V1ApplySpec{}
+
+		health := check(drbdHealth{}, procDrbdWFConnection)
+		Expect(health).To(Equal(drbdHealth{}))
+		Expect(alerts).To(BeEmpty())
+	})
+	Context("when resource is multi-peer", func() {
+		checkPeers := func(previous drbdHealth, status string) drbdHealth {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose --statistics", fakesys.FakeCmdResult{Stdout: status})
+			return check(previous, procDrbd9)
+		}
+
+		It("alerts for each peer losing connection while another one is already down", func() {
+			health := checkPeers(drbdHealth{}, drbdsetupStatusPeerConnecting)
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("connection lost"))
+			Expect(alerts[0].Summary).To(ContainSubstring("peer dc1-b, connection state is Connecting"))
+
+			health = checkPeers(health, drbdsetupStatusPeersDown)
+			Expect(alerts).To(HaveLen(2))
+			Expect(alerts[1].Event).To(Equal("connection lost"))
+			Expect(alerts[1].Summary).To(ContainSubstring("peer dc2-a, connection state is StandAlone"))
+
+			checkPeers(health, drbdsetupStatusPeerConnecting)
+			Expect(alerts).To(HaveLen(3))
+			Expect(alerts[2].Event).To(Equal("connection restored"))
+			Expect(alerts[2].Summary).To(ContainSubstring("peer dc2-a"))
+		})
+
+		It("alerts on resync of each peer", func() {
+			health := checkPeers(drbdHealth{}, drbdsetupStatusPeerResyncing)
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("resync started"))
+			Expect(alerts[0].Summary).To(ContainSubstring("with peer dc1-b (SyncSource), 4096 KiB out of sync"))
+
+			checkPeers(health, drbdsetupStatusPeerConnecting)
+			Expect(alerts).To(HaveLen(3))
+			Expect(alerts[1].Event).To(Equal("connection lost"))
+			Expect(alerts[2].Event).To(Equal("resync finished"))
+			Expect(alerts[2].Summary).To(ContainSubstring("with peer dc1-b"))
+		})
+	})
+})
@@ -0,0 +1,114 @@
+package nimbus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+)
+
+const (
+	defaultDrbdResourceName      = "r0"
+	defaultDrbdMinor             = 1
+	defaultDrbdPort              = 7789
+	defaultDrbdVolumeGroup       = "vgStoreData"
+	defaultDrbdLogicalVolume     = "StoreData"
+	defaultDrbdLogicalVolumeSize = "40%FREE"
+	defaultDrbdSnapshotSize      = "50%FREE"
+
+	drbdConfigDir = "/etc/drbd.d"
+)
+
+// DrbdResource describes a single replicated volume:
+// the DRBD resource and the LVM logical volume backing it.
+type DrbdResource struct {
+	Name              string
+	Minor             int
+	Port              int
+	VolumeGroup       string
+	LogicalVolume     string
+	LogicalVolumeSize string
+
+	// Copy-on-write space taken from the free extents of the VG for each snapshot
+	SnapshotSize string
+
+	// Partition of the persistent disk holding the LVM physical volume.
+	// Resolved from disk settings, only needed while setting up the volume.
+	BackingDevice string
+}
+
+func NewDrbdResource(spec boshas.V1ApplySpec) DrbdResource {
+	resource := DrbdResource{
+		Name:              spec.DrbdResourceName,
+		Minor:             defaultDrbdMinor,
+		Port:              spec.DrbdPort,
+		VolumeGroup:       spec.DrbdVolumeGroup,
+		LogicalVolume:     spec.DrbdLogicalVolume,
+		LogicalVolumeSize: spec.DrbdLogicalVolumeSize,
+		SnapshotSize:      spec.DrbdSnapshotSize,
+	}
+
+	if resource.Name == "" {
+		resource.Name = defaultDrbdResourceName
+	}
+	if spec.DrbdMinor != nil {
+		resource.Minor = *spec.DrbdMinor
+	}
+	if resource.Port == 0 {
+		resource.Port = defaultDrbdPort
+	}
+	if resource.VolumeGroup == "" {
+		resource.VolumeGroup = defaultDrbdVolumeGroup
+	}
+	if resource.LogicalVolume == "" {
+		resource.LogicalVolume = defaultDrbdLogicalVolume
+	}
+	if resource.LogicalVolumeSize == "" {
+		resource.LogicalVolumeSize = defaultDrbdLogicalVolumeSize
+	}
+	if resource.SnapshotSize == "" {
+		resource.SnapshotSize = defaultDrbdSnapshotSize
+	}
+
+	return resource
+}
+
+// DeviceName is the DRBD device name used in the resource config, e.g. drbd1
+func (r DrbdResource) DeviceName() string {
+	return fmt.Sprintf("drbd%d", r.Minor)
+}
+
+// Device is the block device to format and mount on the primary, e.g. /dev/drbd1
+func (r DrbdResource) Device() string {
+	return filepath.Join("/dev", r.DeviceName())
+}
+
+// LogicalVolumeDevice is the device mapper path of the backing LV, e.g. /dev/mapper/vgStoreData-StoreData
+func (r DrbdResource) LogicalVolumeDevice() string {
+	// device mapper escapes dashes in VG and LV names by doubling them
+	escape := func(name string) string { return strings.Replace(name, "-", "--", -1) }
+	return filepath.Join("/dev/mapper", escape(r.VolumeGroup)+"-"+escape(r.LogicalVolume))
+}
+
+func (r DrbdResource) ConfigPath() string {
+	return filepath.Join(drbdConfigDir, r.Name+".res")
+}
+
+func (r DrbdResource) lvcreateSizeArgs() []string {
+	return lvmSizeArgs(r.LogicalVolumeSize)
+}
+
+// SnapshotLogicalVolume is the name of the LV holding the named snapshot of the backing LV
+func (r DrbdResource) SnapshotLogicalVolume(snapshot string) string {
+	return r.LogicalVolume + drbdSnapshotInfix + snapshot
+}
+
+// lvmSizeArgs returns -l for extent based sizes (40%FREE, 100%VG, 512) and -L for absolute ones (10G),
+// sizes prefixed with + are increments for lvextend
+func lvmSizeArgs(size string) []string {
+	if strings.Contains(size, "%") || strings.Trim(strings.TrimPrefix(size, "+"), "0123456789") == "" {
+		return []string{"-l", size}
+	}
+	return []string{"-L", size}
+}
@@ -0,0 +1,316 @@
+package nimbus
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const procDrbdPath = "/proc/drbd"
+
+// DrbdStatus is the state of a DRBD resource as reported in get_state.
+// Counters are in KiB, ETA is in seconds.
+type DrbdStatus struct {
+	Resource        string  `json:"resource,omitempty"`
+	ConnectionState string  `json:"connection_state"`
+	Role            string  `json:"role"`
+	PeerRole        string  `json:"peer_role"`
+	DiskState       string  `json:"disk_state"`
+	PeerDiskState   string  `json:"peer_disk_state"`
+	SyncState       string  `json:"sync_state"`
+	Protocol        string  `json:"protocol"`
+	ResyncPercent   float64 `json:"resync_percent"`
+	OutOfSyncKiB    int64   `json:"out_of_sync_kib"`
+	ETASeconds      int64   `json:"eta_seconds"`
+	SentKiB         int64   `json:"sent_kib"`
+	ReceivedKiB     int64   `json:"received_kib"`
+
+	// DRBD 9 only: quorum of the local node (yes|no) and the state of every peer
+	Quorum string           `json:"quorum,omitempty"`
+	Peers  []DrbdPeerStatus `json:"peers,omitempty"`
+
+	Verify    *DrbdVerifyStatus `json:"verify,omitempty"`
+	Snapshots []DrbdSnapshot    `json:"snapshots,omitempty"`
+	Config    *DrbdConfigStatus `json:"config,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// DrbdPeerStatus is the state of the connection to one peer of a DRBD 9 resource
+type DrbdPeerStatus struct {
+	Name            string  `json:"name"`
+	NodeID          int64   `json:"node_id"`
+	ConnectionState string  `json:"connection_state"`
+	Role            string  `json:"role"`
+	DiskState       string  `json:"disk_state"`
+	SyncState       string  `json:"sync_state"`
+	ResyncPercent   float64 `json:"resync_percent"`
+	OutOfSyncKiB    int64   `json:"out_of_sync_kib"`
+	SentKiB         int64   `json:"sent_kib"`
+	ReceivedKiB     int64   `json:"received_kib"`
+}
+
+// DRBD 8.4 reports replication states in place of the connection state (cs:SyncSource)
+var drbdReplicationStates = map[string]bool{
+	"Established":   true,
+	"StartingSyncS": true,
+	"StartingSyncT": true,
+	"WFBitMapS":     true,
+	"WFBitMapT":     true,
+	"WFSyncUUID":    true,
+	"SyncSource":    true,
+	"SyncTarget":    true,
+	"VerifyS":       true,
+	"VerifyT":       true,
+	"PausedSyncS":   true,
+	"PausedSyncT":   true,
+	"Ahead":         true,
+	"Behind":        true,
+}
+
+var procDrbdMinorLine = regexp.MustCompile(`^\s*(\d+):\s`)
+
+// DrbdStatus reads the status of the resource from /proc/drbd (DRBD 8.4),
+// falling back to drbdsetup status for DRBD 9 where /proc/drbd only carries the version.
+func (d DualDCSupport) DrbdStatus() (status DrbdStatus, err error) {
+	if !d.fs.FileExists(procDrbdPath) {
+		return DrbdStatus{ConnectionState: "not running"}, nil
+	}
+
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return DrbdStatus{}, err
+	}
+
+	procDrbd, err := d.fs.ReadFileString(procDrbdPath)
+	if err != nil {
+		return DrbdStatus{}, bosherr.WrapErrorf(err, "Reading %s", procDrbdPath)
+	}
+
+	status, found := ParseProcDrbd(procDrbd, resource.Minor)
+	if !found {
+		out, _, _, err := d.cmdRunner.RunCommand("drbdsetup", "status", resource.Name, "--verbose", "--statistics")
+		if err != nil {
+			return DrbdStatus{ConnectionState: "Unconfigured"}, nil
+		}
+
+		status = ParseDrbdsetupStatus(out)
+	}
+
+	status.Resource = resource.Name
+
+	if status.Protocol == "" {
+		spec, err := d.specService.Get()
+		if err != nil {
+			return DrbdStatus{}, bosherr.WrapError(err, "Fetching spec")
+		}
+		status.Protocol = spec.DrbdReplicationType
+	}
+
+	verify, found, err := d.loadDrbdVerifyStatus(resource)
+	if err != nil {
+		return DrbdStatus{}, err
+	}
+	if found {
+		status.Verify = &verify
+	}
+
+	return status, nil
+}
+
+// ParseProcDrbd parses the block of the given minor in DRBD 8.4 /proc/drbd:
+//
+//	1: cs:SyncSource ro:Primary/Secondary ds:UpToDate/Inconsistent C r-----
+//	   ns:1048576 nr:0 dw:0 dr:1049264 al:0 bm:64 lo:0 pe:2 ua:0 ap:0 ep:1 wo:f oos:3145728
+//	   [===>................] sync'ed: 25.0% (3072/4096)M
+//	   finish: 0:01:20 speed: 13,056 (13,056) K/sec
+func ParseProcDrbd(procDrbd string, minor int) (status DrbdStatus, found bool) {
+	var tokens []string
+
+	for _, line := range strings.Split(procDrbd, "\n") {
+		matches := procDrbdMinorLine.FindStringSubmatch(line)
+		if matches != nil {
+			if found {
+				break
+			}
+			found = matches[1] == strconv.Itoa(minor)
+			line = line[len(matches[0]):]
+		}
+		if found {
+			tokens = append(tokens, strings.Fields(line)...)
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	for i, token := range tokens {
+		key, value := splitDrbdToken(token)
+
+		switch key {
+		case "cs":
+			if drbdReplicationStates[value] {
+				status.ConnectionState = "Connected"
+				status.SyncState = drbdSyncState(value)
+			} else {
+				status.ConnectionState = value
+			}
+		case "ro":
+			status.Role, status.PeerRole = splitDrbdPair(value)
+		case "ds":
+			status.DiskState, status.PeerDiskState = splitDrbdPair(value)
+			// protocol letter follows disk states
+			if i+1 < len(tokens) && len(tokens[i+1]) == 1 {
+				status.Protocol = tokens[i+1]
+			}
+		case "ns":
+			status.SentKiB = parseDrbdInt(value)
+		case "nr":
+			status.ReceivedKiB = parseDrbdInt(value)
+		case "oos":
+			status.OutOfSyncKiB = parseDrbdInt(value)
+		case "sync'ed", "verified":
+			if i+1 < len(tokens) {
+				status.ResyncPercent = parseDrbdFloat(strings.TrimSuffix(tokens[i+1], "%"))
+			}
+		case "finish":
+			if i+1 < len(tokens) {
+				status.ETASeconds = parseDrbdDuration(tokens[i+1])
+			}
+		}
+	}
+
+	return status, true
+}
+
+// ParseDrbdsetupStatus parses DRBD 9 drbdsetup status --verbose --statistics output:
+//
+//	r0 node-id:0 role:Primary suspended:no
+//	  volume:0 minor:1 disk:UpToDate quorum:yes
+//	      size:4194304 read:1049 written:0 al-writes:0 bm-writes:0 upper-pending:0 lower-pending:0
+//	  host2 node-id:1 connection:Connected role:Secondary congested:no
+//	    volume:0 replication:SyncSource peer-disk:Inconsistent done:25.00 resync-suspended:no
+//	        received:0 sent:1048576 out-of-sync:3145728 pending:0 unacked:0
+//
+// Peer fields of the status itself describe the least healthy peer:
+// the first one not connected, otherwise the first one resyncing.
+func ParseDrbdsetupStatus(output string) (status DrbdStatus) {
+	var peer *DrbdPeerStatus
+
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+
+		if strings.Contains(line, "connection:") {
+			status.Peers = append(status.Peers, DrbdPeerStatus{Name: fields[0]})
+			peer = &status.Peers[len(status.Peers)-1]
+		}
+
+		for _, token := range fields {
+			key, value := splitDrbdToken(token)
+
+			if i == 0 || peer == nil {
+				switch key {
+				case "role":
+					status.Role = value
+				case "disk":
+					status.DiskState = value
+				case "quorum":
+					status.Quorum = value
+				}
+				continue
+			}
+
+			switch key {
+			case "node-id":
+				peer.NodeID = parseDrbdInt(value)
+			case "role":
+				peer.Role = value
+			case "connection":
+				peer.ConnectionState = value
+			case "replication":
+				peer.SyncState = drbdSyncState(value)
+			case "peer-disk":
+				peer.DiskState = value
+			case "done":
+				peer.ResyncPercent = parseDrbdFloat(value)
+			case "eta":
+				status.ETASeconds = parseDrbdInt(value)
+			case "sent":
+				peer.SentKiB = parseDrbdInt(value)
+			case "received":
+				peer.ReceivedKiB = parseDrbdInt(value)
+			case "out-of-sync":
+				peer.OutOfSyncKiB = parseDrbdInt(value)
+			}
+		}
+	}
+
+	if len(status.Peers) > 0 {
+		worst := status.Peers[0]
+		for _, p := range status.Peers {
+			if p.ConnectionState != "Connected" {
+				worst = p
+				break
+			}
+			if p.SyncState != "" && worst.SyncState == "" {
+				worst = p
+			}
+		}
+
+		status.ConnectionState = worst.ConnectionState
+		status.PeerRole = worst.Role
+		status.PeerDiskState = worst.DiskState
+		status.SyncState = worst.SyncState
+		status.ResyncPercent = worst.ResyncPercent
+		status.OutOfSyncKiB = worst.OutOfSyncKiB
+		status.SentKiB = worst.SentKiB
+		status.ReceivedKiB = worst.ReceivedKiB
+	}
+
+	return status
+}
+
+// drbdSyncState is empty for peers that are in sync or not replicating
+func drbdSyncState(replication string) string {
+	if replication == "Established" || replication == "Off" {
+		return ""
+	}
+	return replication
+}
+
+func splitDrbdToken(token string) (key, value string) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func splitDrbdPair(value string) (local, peer string) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func parseDrbdInt(value string) int64 {
+	i, _ := strconv.ParseInt(value, 10, 64)
+	return i
+}
+
+func parseDrbdFloat(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+// parseDrbdDuration converts h:mm:ss to seconds
+func parseDrbdDuration(value string) (seconds int64) {
+	for _, part := range strings.Split(value, ":") {
+		seconds = seconds*60 + parseDrbdInt(part)
+	}
+	return
+}
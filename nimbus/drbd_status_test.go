@@ -0,0 +1,199 @@
+package nimbus
+
+import (
+	"errors"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const procDrbd84 = `version: 8.4.3 (api:1/proto:86-101)
+srcversion: 1A9F77B1CA5FF92235C2213
+
+ 0: cs:Unconfigured
+ 1: cs:SyncSource ro:Primary/Secondary ds:UpToDate/Inconsistent C r-----
+    ns:1048576 nr:0 dw:0 dr:1049264 al:0 bm:64 lo:0 pe:2 ua:0 ap:0 ep:1 wo:f oos:3145728
+	[===>................] sync'ed: 25.0% (3072/4096)M
+	finish: 1:01:20 speed: 13,056 (13,056) K/sec
+ 2: cs:WFConnection ro:Secondary/Unknown ds:UpToDate/DUnknown A r-----
+    ns:0 nr:2048 dw:2048 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+const drbdsetupStatus9 = `r0 node-id:0 role:Primary suspended:no
+    write-ordering:flush
+  volume:0 minor:1 disk:UpToDate quorum:yes
+      size:4194304 read:1049 written:0 al-writes:0 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no
+  host2 node-id:1 connection:Connected role:Secondary congested:no
+    volume:0 replication:SyncSource peer-disk:Inconsistent done:25.00 resync-suspended:no
+        received:0 sent:1048576 out-of-sync:3145728 pending:0 unacked:0
+`
+
+const drbdsetupStatus9ThreeNodes = `r0 node-id:0 role:Secondary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:no
+  host2 node-id:1 connection:Connected role:Secondary congested:no
+    volume:0 replication:Established peer-disk:UpToDate resync-suspended:no
+        received:2048 sent:0 out-of-sync:0 pending:0 unacked:0
+  host3 node-id:2 connection:Connecting role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+        received:0 sent:0 out-of-sync:1024 pending:0 unacked:0
+`
+
+var _ = Describe("DrbdStatus", func() {
+	Describe("ParseProcDrbd", func() {
+		It("parses resync in progress", func() {
+			status, found := ParseProcDrbd(procDrbd84, 1)
+			Expect(found).To(BeTrue())
+			Expect(status).To(Equal(DrbdStatus{
+				ConnectionState: "Connected",
+				Role:            "Primary",
+				PeerRole:        "Secondary",
+				DiskState:       "UpToDate",
+				PeerDiskState:   "Inconsistent",
+				SyncState:       "SyncSource",
+				Protocol:        "C",
+				ResyncPercent:   25.0,
+				OutOfSyncKiB:    3145728,
+				ETASeconds:      3680,
+				SentKiB:         1048576,
+			}))
+		})
+
+		It("parses disconnected resource", func() {
+			status, found := ParseProcDrbd(procDrbd84, 2)
+			Expect(found).To(BeTrue())
+			Expect(status).To(Equal(DrbdStatus{
+				ConnectionState: "WFConnection",
+				Role:            "Secondary",
+				PeerRole:        "Unknown",
+				DiskState:       "UpToDate",
+				PeerDiskState:   "DUnknown",
+				Protocol:        "A",
+				ReceivedKiB:     2048,
+			}))
+		})
+
+		It("parses unconfigured resource", func() {
+			status, found := ParseProcDrbd(procDrbd84, 0)
+			Expect(found).To(BeTrue())
+			Expect(status).To(Equal(DrbdStatus{ConnectionState: "Unconfigured"}))
+		})
+
+		It("does not find missing minor", func() {
+			_, found := ParseProcDrbd("version: 9.0.1 (api:2/proto:86-112)\n", 1)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Describe("ParseDrbdsetupStatus", func() {
+		It("parses local and peer state with statistics", func() {
+			Expect(ParseDrbdsetupStatus(drbdsetupStatus9)).To(Equal(DrbdStatus{
+				ConnectionState: "Connected",
+				Role:            "Primary",
+				PeerRole:        "Secondary",
+				DiskState:       "UpToDate",
+				PeerDiskState:   "Inconsistent",
+				SyncState:       "SyncSource",
+				ResyncPercent:   25.0,
+				OutOfSyncKiB:    3145728,
+				SentKiB:         1048576,
+				Quorum:          "yes",
+				Peers: []DrbdPeerStatus{{
+					Name:            "host2",
+					NodeID:          1,
+					ConnectionState: "Connected",
+					Role:            "Secondary",
+					DiskState:       "Inconsistent",
+					SyncState:       "SyncSource",
+					ResyncPercent:   25.0,
+					OutOfSyncKiB:    3145728,
+					SentKiB:         1048576,
+				}},
+			}))
+		})
+
+		It("reports every peer and the least healthy one as overall state", func() {
+			status := ParseDrbdsetupStatus(drbdsetupStatus9ThreeNodes)
+			Expect(status.Role).To(Equal("Secondary"))
+			Expect(status.DiskState).To(Equal("UpToDate"))
+			Expect(status.Quorum).To(Equal("no"))
+			Expect(status.ConnectionState).To(Equal("Connecting"))
+			Expect(status.PeerDiskState).To(Equal("DUnknown"))
+			Expect(status.OutOfSyncKiB).To(Equal(int64(1024)))
+
+			Expect(status.Peers).To(Equal([]DrbdPeerStatus{
+				{Name: "host2", NodeID: 1, ConnectionState: "Connected", Role: "Secondary", DiskState: "UpToDate", ReceivedKiB: 2048},
+				{Name: "host3", NodeID: 2, ConnectionState: "Connecting", Role: "Unknown", DiskState: "DUnknown", OutOfSyncKiB: 1024},
+			}))
+		})
+	})
+
+	Describe("DualDCSupport.DrbdStatus", func() {
+		var (
+			dualDCSupport *DualDCSupport
+			cmdRunner     *fakesys.FakeCmdRunner
+			fs            *fakesys.FakeFileSystem
+			specService   *fakeas.FakeV1Service
+		)
+
+		BeforeEach(func() {
+			fs = fakesys.NewFakeFileSystem()
+			cmdRunner = fakesys.NewFakeCmdRunner()
+			specService = fakeas.NewFakeV1Service()
+			specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, DrbdReplicationType: "A"}
+
+			dualDCSupport = NewDualDCSupport(
+				cmdRunner,
+				fs,
+				boshdir.NewProvider("/var/vcap"),
+				specService,
+				&fakesettings.FakeSettingsService{},
+				fakedpresolv.NewFakeDevicePathResolver(),
+				boshlog.NewLogger(boshlog.LevelNone),
+			)
+		})
+
+		It("reports not running without drbd module", func() {
+			status, err := dualDCSupport.DrbdStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(DrbdStatus{ConnectionState: "not running"}))
+		})
+
+		It("reads /proc/drbd for DRBD 8.4", func() {
+			fs.WriteFileString("/proc/drbd", procDrbd84)
+
+			status, err := dualDCSupport.DrbdStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Resource).To(Equal("r0"))
+			Expect(status.SyncState).To(Equal("SyncSource"))
+			Expect(status.Protocol).To(Equal("C"))
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("falls back to drbdsetup status for DRBD 9", func() {
+			fs.WriteFileString("/proc/drbd", "version: 9.0.1 (api:2/proto:86-112)\n")
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose --statistics", fakesys.FakeCmdResult{Stdout: drbdsetupStatus9})
+
+			status, err := dualDCSupport.DrbdStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Resource).To(Equal("r0"))
+			Expect(status.ConnectionState).To(Equal("Connected"))
+			Expect(status.Protocol).To(Equal("A"))
+		})
+
+		It("reports unconfigured when resource is not up", func() {
+			fs.WriteFileString("/proc/drbd", "version: 9.0.1 (api:2/proto:86-112)\n")
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose --statistics", fakesys.FakeCmdResult{Error: errors.New("fake-error")})
+
+			status, err := dualDCSupport.DrbdStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(DrbdStatus{ConnectionState: "Unconfigured"}))
+		})
+	})
+})
@@ -0,0 +1,251 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// States of an online verify run
+const (
+	DrbdVerifyRunning  = "running"
+	DrbdVerifyFinished = "finished"
+	DrbdVerifyAborted  = "aborted"
+)
+
+// DrbdVerifyStatus is the last online verify of a resource, persisted under the bosh dir
+// so that the result stays available in get_state after the agent restarts.
+// Times are unix timestamps.
+type DrbdVerifyStatus struct {
+	State        string `json:"state"`
+	StartedAt    int64  `json:"started_at"`
+	FinishedAt   int64  `json:"finished_at,omitempty"`
+	OutOfSyncKiB int64  `json:"out_of_sync_kib"`
+	Resynced     bool   `json:"resynced"`
+}
+
+func (d DualDCSupport) drbdVerifyStatusPath(resource DrbdResource) string {
+	return filepath.Join(d.dirProvider.BoshDir(), "drbd-verify-"+resource.Name+".json")
+}
+
+// StartDrbdVerify starts an online verify of the resource on the active leg,
+// progress and result are picked up by MonitorDrbdVerify.
+func (d DualDCSupport) StartDrbdVerify() (DrbdVerifyStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return DrbdVerifyStatus{}, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return DrbdVerifyStatus{}, bosherr.Error("Verify requires drbd to be enabled")
+	}
+
+	if !spec.IsActiveSide() {
+		return DrbdVerifyStatus{}, bosherr.Error("Verify can only be started on the active leg")
+	}
+
+	resource := NewDrbdResource(spec)
+
+	previous, found, err := d.loadDrbdVerifyStatus(resource)
+	if err != nil {
+		return DrbdVerifyStatus{}, err
+	}
+
+	if found && previous.State == DrbdVerifyRunning {
+		return previous, nil
+	}
+
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return DrbdVerifyStatus{}, err
+	}
+
+	if status.ConnectionState != "Connected" || status.SyncState != "" {
+		return DrbdVerifyStatus{}, bosherr.Errorf("Resource %s must be connected and in sync to verify, connection state is %s %s", resource.Name, status.ConnectionState, status.SyncState)
+	}
+
+	d.logger.Info(nimbusLogTag, "Starting online verify of drbd resource %s", resource.Name)
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "verify", resource.Name); err != nil {
+		return DrbdVerifyStatus{}, bosherr.WrapErrorf(err, "Starting 'drbdadm verify %s'", resource.Name)
+	}
+
+	verify := DrbdVerifyStatus{State: DrbdVerifyRunning, StartedAt: time.Now().Unix()}
+
+	if err = d.saveDrbdVerifyStatus(resource, verify); err != nil {
+		return DrbdVerifyStatus{}, err
+	}
+
+	return verify, nil
+}
+
+// MonitorDrbdVerify tracks running verifies, reports out-of-sync blocks through handler
+// and starts verifies every drbd_verify_interval when one is configured.
+func (d DualDCSupport) MonitorDrbdVerify(handler DrbdAlertHandler, stopCh <-chan struct{}) {
+	defer d.logger.HandlePanic("Nimbus Monitor Drbd Verify")
+
+	ticker := time.NewTicker(drbdHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.checkDrbdVerify(handler)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d DualDCSupport) checkDrbdVerify(handler DrbdAlertHandler) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to check drbd verify: %s", err)
+		return
+	}
+
+	resource := NewDrbdResource(spec)
+	if !spec.DrbdEnabled || !d.isDRBDConfigWritten(resource) {
+		return
+	}
+
+	verify, found, err := d.loadDrbdVerifyStatus(resource)
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Checking drbd verify: %s", err)
+		return
+	}
+
+	if found && verify.State == DrbdVerifyRunning {
+		if err = d.trackDrbdVerify(spec, resource, verify, handler); err != nil {
+			d.logger.Error(nimbusLogTag, "Tracking drbd verify: %s", err)
+		}
+		return
+	}
+
+	if d.drbdVerifyDue(spec, verify, found) {
+		if _, err = d.StartDrbdVerify(); err != nil {
+			d.logger.Error(nimbusLogTag, "Starting scheduled drbd verify: %s", err)
+		}
+	}
+}
+
+func (d DualDCSupport) drbdVerifyDue(spec boshas.V1ApplySpec, verify DrbdVerifyStatus, found bool) bool {
+	if spec.DrbdVerifyInterval == "" || !spec.IsActiveSide() {
+		return false
+	}
+
+	interval, err := time.ParseDuration(spec.DrbdVerifyInterval)
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Parsing drbd_verify_interval '%s': %s", spec.DrbdVerifyInterval, err)
+		return false
+	}
+
+	return !found || time.Since(time.Unix(verify.StartedAt, 0)) >= interval
+}
+
+func (d DualDCSupport) trackDrbdVerify(spec boshas.V1ApplySpec, resource DrbdResource, verify DrbdVerifyStatus, handler DrbdAlertHandler) error {
+	status, err := d.DrbdStatus()
+	if err != nil {
+		return err
+	}
+
+	if status.SyncState == "VerifyS" || status.SyncState == "VerifyT" {
+		return nil
+	}
+
+	var alert *boshalert.DrbdAlert
+
+	if status.ConnectionState != "Connected" {
+		verify.State = DrbdVerifyAborted
+		alert = &boshalert.DrbdAlert{
+			Resource: resource.Name,
+			Event:    "verify aborted",
+			Severity: drbdEventSeverity(spec, DrbdEventVerifyAborted),
+			Summary:  fmt.Sprintf("DRBD resource %s online verify was aborted, connection state is %s", resource.Name, status.ConnectionState),
+		}
+	} else {
+		verify.State = DrbdVerifyFinished
+		verify.OutOfSyncKiB = status.OutOfSyncKiB
+
+		if verify.OutOfSyncKiB > 0 {
+			alert = &boshalert.DrbdAlert{
+				Resource: resource.Name,
+				Event:    "verify out of sync",
+				Severity: drbdEventSeverity(spec, DrbdEventVerifyOutOfSync),
+				Summary:  fmt.Sprintf("DRBD resource %s online verify found %d KiB out of sync", resource.Name, verify.OutOfSyncKiB),
+			}
+
+			if spec.DrbdVerifyResync {
+				if err = d.resyncAfterVerify(resource); err != nil {
+					return err
+				}
+				verify.Resynced = true
+			}
+		}
+	}
+
+	verify.FinishedAt = time.Now().Unix()
+
+	d.logger.Info(nimbusLogTag, "Online verify of drbd resource %s %s, %d KiB out of sync", resource.Name, verify.State, verify.OutOfSyncKiB)
+
+	if alert != nil {
+		if err = handler(*alert); err != nil {
+			return bosherr.WrapError(err, "Reporting drbd verify result")
+		}
+	}
+
+	return d.saveDrbdVerifyStatus(resource, verify)
+}
+
+// resyncAfterVerify reconnects the resource so that blocks marked out of sync by verify are resynced
+func (d DualDCSupport) resyncAfterVerify(resource DrbdResource) error {
+	d.logger.Info(nimbusLogTag, "Reconnecting drbd resource %s to resync blocks found by verify", resource.Name)
+
+	if _, _, _, err := d.cmdRunner.RunCommand("drbdadm", "disconnect", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Disconnecting drbd resource %s", resource.Name)
+	}
+
+	if _, _, _, err := d.cmdRunner.RunCommand("drbdadm", "connect", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Connecting drbd resource %s", resource.Name)
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) loadDrbdVerifyStatus(resource DrbdResource) (verify DrbdVerifyStatus, found bool, err error) {
+	path := d.drbdVerifyStatusPath(resource)
+	if !d.fs.FileExists(path) {
+		return
+	}
+
+	contents, err := d.fs.ReadFile(path)
+	if err != nil {
+		err = bosherr.WrapError(err, "Reading drbd verify status")
+		return
+	}
+
+	if err = json.Unmarshal(contents, &verify); err != nil {
+		err = bosherr.WrapError(err, "Unmarshalling drbd verify status")
+		return
+	}
+
+	return verify, true, nil
+}
+
+func (d DualDCSupport) saveDrbdVerifyStatus(resource DrbdResource, verify DrbdVerifyStatus) error {
+	contents, err := json.Marshal(verify)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling drbd verify status")
+	}
+
+	if err = d.fs.WriteFile(d.drbdVerifyStatusPath(resource), contents); err != nil {
+		return bosherr.WrapError(err, "Writing drbd verify status")
+	}
+
+	return nil
+}
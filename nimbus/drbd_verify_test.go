@@ -0,0 +1,212 @@
+package nimbus
+
+import (
+	"fmt"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const procDrbdVerifyS = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:VerifyS ro:Primary/Secondary ds:UpToDate/UpToDate A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+	[===>................] verified: 25.0% (3072/4096)M
+`
+
+const procDrbdVerifiedOutOfSync = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:4096
+`
+
+var _ = Describe("DrbdVerify", func() {
+	const verifyStatusPath = "/var/vcap/bosh/drbd-verify-r0.json"
+
+	var (
+		dualDCSupport *DualDCSupport
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+
+		alerts  []boshalert.DrbdAlert
+		handler DrbdAlertHandler
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		fs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+
+		alerts = nil
+		handler = func(alert boshalert.DrbdAlert) error {
+			alerts = append(alerts, alert)
+			return nil
+		}
+	})
+
+	writeVerifyStatus := func(state string, startedAt time.Time) {
+		fs.WriteFileString(verifyStatusPath, fmt.Sprintf(`{"state":"%s","started_at":%d}`, state, startedAt.Unix()))
+	}
+
+	readVerifyStatus := func() DrbdVerifyStatus {
+		verify, found, err := dualDCSupport.loadDrbdVerifyStatus(NewDrbdResource(specService.Spec))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+		return verify
+	}
+
+	Describe("StartDrbdVerify", func() {
+		It("starts verify on connected active leg", func() {
+			fs.WriteFileString("/proc/drbd", procDrbdConnected)
+
+			verify, err := dualDCSupport.StartDrbdVerify()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verify.State).To(Equal(DrbdVerifyRunning))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"drbdadm", "verify", "r0"}}))
+			Expect(readVerifyStatus()).To(Equal(verify))
+		})
+
+		It("refuses to verify on passive leg", func() {
+			specService.Spec.Passive = "enabled"
+
+			_, err := dualDCSupport.StartDrbdVerify()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("active leg"))
+		})
+
+		It("refuses to verify while disconnected", func() {
+			fs.WriteFileString("/proc/drbd", procDrbdWFConnection)
+
+			_, err := dualDCSupport.StartDrbdVerify()
+			Expect(err).To(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("returns verify already running", func() {
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+
+			verify, err := dualDCSupport.StartDrbdVerify()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verify.State).To(Equal(DrbdVerifyRunning))
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+	})
+
+	Describe("checkDrbdVerify", func() {
+		It("leaves running verify alone", func() {
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+			fs.WriteFileString("/proc/drbd", procDrbdVerifyS)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(readVerifyStatus().State).To(Equal(DrbdVerifyRunning))
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("records clean verify without alert", func() {
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+			fs.WriteFileString("/proc/drbd", procDrbdConnected)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			verify := readVerifyStatus()
+			Expect(verify.State).To(Equal(DrbdVerifyFinished))
+			Expect(verify.FinishedAt).ToNot(BeZero())
+			Expect(verify.OutOfSyncKiB).To(BeZero())
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("alerts on out of sync blocks", func() {
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+			fs.WriteFileString("/proc/drbd", procDrbdVerifiedOutOfSync)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			verify := readVerifyStatus()
+			Expect(verify.OutOfSyncKiB).To(Equal(int64(4096)))
+			Expect(verify.Resynced).To(BeFalse())
+
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("verify out of sync"))
+			Expect(alerts[0].Severity).To(Equal(boshalert.SeverityCritical))
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("reconnects to resync out of sync blocks when configured", func() {
+			specService.Spec.DrbdVerifyResync = true
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+			fs.WriteFileString("/proc/drbd", procDrbdVerifiedOutOfSync)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(readVerifyStatus().Resynced).To(BeTrue())
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{
+				{"drbdadm", "disconnect", "r0"},
+				{"drbdadm", "connect", "r0"},
+			}))
+		})
+
+		It("marks verify aborted when connection is lost", func() {
+			writeVerifyStatus(DrbdVerifyRunning, time.Now())
+			fs.WriteFileString("/proc/drbd", procDrbdWFConnection)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(readVerifyStatus().State).To(Equal(DrbdVerifyAborted))
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("verify aborted"))
+		})
+
+		It("starts scheduled verify when interval has passed", func() {
+			specService.Spec.DrbdVerifyInterval = "24h"
+			writeVerifyStatus(DrbdVerifyFinished, time.Now().Add(-25*time.Hour))
+			fs.WriteFileString("/proc/drbd", procDrbdConnected)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"drbdadm", "verify", "r0"}}))
+		})
+
+		It("waits for interval to pass", func() {
+			specService.Spec.DrbdVerifyInterval = "24h"
+			writeVerifyStatus(DrbdVerifyFinished, time.Now().Add(-time.Hour))
+			fs.WriteFileString("/proc/drbd", procDrbdConnected)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("does not schedule verify without interval", func() {
+			fs.WriteFileString("/proc/drbd", procDrbdConnected)
+
+			dualDCSupport.checkDrbdVerify(handler)
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+	})
+
+	It("reports last verify in drbd status", func() {
+		writeVerifyStatus(DrbdVerifyRunning, time.Unix(1000, 0))
+		fs.WriteFileString("/proc/drbd", procDrbdVerifyS)
+
+		status, err := dualDCSupport.DrbdStatus()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.SyncState).To(Equal("VerifyS"))
+		Expect(status.ResyncPercent).To(Equal(25.0))
+		Expect(status.Verify).To(Equal(&DrbdVerifyStatus{State: DrbdVerifyRunning, StartedAt: 1000}))
+	})
+})
@@ -0,0 +1,203 @@
+package nimbus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// DualDCStatus is the document returned by the nimbus_status action,
+// everything an operator needs to tell the state of a leg at once.
+type DualDCStatus struct {
+	Role                string `json:"role"` // active|passive
+	DrbdEnabled         bool   `json:"drbd_enabled"`
+	DrbdForceMaster     bool   `json:"drbd_force_master"`
+	DrbdReplicationType string `json:"drbd_replication_type"`
+
+	// sha256 of the rendered DRBD config, empty when it is not written
+	DrbdConfigChecksum string                `json:"drbd_config_checksum,omitempty"`
+	Drbd               *DrbdStatus           `json:"drbd,omitempty"`
+	ReplicationPeers   []DrbdReplicationPeer `json:"replication_peers,omitempty"`
+
+	DNS   []DNSServerStatus `json:"dns,omitempty"`
+	Store StoreStatus       `json:"store"`
+
+	Inconsistencies []string `json:"inconsistencies"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// DrbdReplicationPeer is another node the DRBD resource replicates to as configured in the spec
+type DrbdReplicationPeer struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// StoreStatus is the mount state of the store dir
+type StoreStatus struct {
+	MountPoint string `json:"mount_point"`
+	Mounted    bool   `json:"mounted"`
+}
+
+// DualDCStatus collects the role, DRBD, DNS and store state of this leg and
+// lists where they disagree. Parts that cannot be queried are left out and
+// reported in Error, the status itself is always returned.
+func (d DualDCSupport) DualDCStatus() (status DualDCStatus, err error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return status, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	status.Role = "passive"
+	if spec.IsActiveSide() {
+		status.Role = "active"
+	}
+	status.DrbdEnabled = spec.DrbdEnabled
+	status.DrbdForceMaster = spec.DrbdForceMaster
+	status.DrbdReplicationType = spec.DrbdReplicationType
+
+	fail := func(err error) {
+		status.Error = err.Error()
+	}
+
+	status.Store.MountPoint = d.dirProvider.StoreDir()
+	if status.Store.Mounted, err = d.mounter.IsMounted(status.Store.MountPoint); err != nil {
+		fail(bosherr.WrapErrorf(err, "Checking if %s is mounted", status.Store.MountPoint))
+	}
+
+	if status.DNS, err = d.DNSStatus(); err != nil {
+		fail(err)
+	}
+
+	if spec.DrbdEnabled {
+		resource := NewDrbdResource(spec)
+
+		if status.DrbdConfigChecksum, err = d.drbdConfigChecksum(resource); err != nil {
+			fail(err)
+		}
+
+		if status.ReplicationPeers, err = d.drbdReplicationPeers(); err != nil {
+			fail(err)
+		}
+
+		if drbd, drbdErr := d.DrbdStatus(); drbdErr != nil {
+			fail(bosherr.WrapError(drbdErr, "Getting drbd status"))
+		} else {
+			status.Drbd = &drbd
+		}
+	}
+
+	peer, err := d.Peer()
+	if err != nil {
+		fail(err)
+	}
+
+	status.Inconsistencies = dualDCInconsistencies(status, peer)
+
+	return status, nil
+}
+
+func (d DualDCSupport) drbdConfigChecksum(resource DrbdResource) (string, error) {
+	if !d.isDRBDConfigWritten(resource) {
+		return "", nil
+	}
+
+	contents, err := d.fs.ReadFile(resource.ConfigPath())
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Reading %s", resource.ConfigPath())
+	}
+
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (d DualDCSupport) drbdReplicationPeers() ([]DrbdReplicationPeer, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !isMultiPeer(spec) {
+		_, otherHostIP, err := d.replicationPeers(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []DrbdReplicationPeer{{Address: otherHostIP}}, nil
+	}
+
+	thisHostIP, err := d.replicationIP(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := d.replicationNodes(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []DrbdReplicationPeer{}
+	for _, node := range nodes {
+		if node.Address != thisHostIP {
+			peers = append(peers, DrbdReplicationPeer{Name: node.Name, Address: node.Address})
+		}
+	}
+
+	return peers, nil
+}
+
+func dualDCInconsistencies(status DualDCStatus, peer *PeerLinkStatus) []string {
+	inconsistencies := []string{}
+	add := func(format string, args ...interface{}) {
+		inconsistencies = append(inconsistencies, fmt.Sprintf(format, args...))
+	}
+
+	active := status.Role == "active"
+
+	if status.DrbdEnabled {
+		if status.DrbdConfigChecksum == "" {
+			add("drbd is enabled but its config is not written")
+		}
+
+		if status.Drbd != nil {
+			switch status.Drbd.ConnectionState {
+			case "not running", "Unconfigured":
+				add("drbd is enabled but the resource is %s", status.Drbd.ConnectionState)
+
+			default:
+				if active && status.Drbd.Role != "Primary" {
+					add("spec says active but DRBD is %s", status.Drbd.Role)
+				}
+				if !active && status.Drbd.Role == "Primary" {
+					add("spec says passive but DRBD is Primary")
+				}
+				if status.Drbd.Role == "Primary" && status.Drbd.PeerRole == "Primary" {
+					add("DRBD is Primary on both nodes")
+				}
+			}
+		}
+
+		if active && !status.Store.Mounted {
+			add("spec says active but %s is not mounted", status.Store.MountPoint)
+		}
+		if !active && status.Store.Mounted {
+			add("spec says passive but %s is mounted", status.Store.MountPoint)
+		}
+
+		if !active && status.DrbdForceMaster {
+			add("drbd_force_master is set on the passive leg")
+		}
+	}
+
+	for _, server := range status.DNS {
+		if server.ConsecutiveFailures > 0 {
+			add("DNS registration with %s is failing: %s", server.Server, server.LastError)
+		}
+	}
+
+	if active && peer != nil && peer.Reachable && peer.Peer != nil && peer.Peer.Role == "active" {
+		add("spec says active and the peer reports active as well")
+	}
+
+	return inconsistencies
+}
@@ -0,0 +1,112 @@
+package nimbus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DualDCStatus", func() {
+	var (
+		cmdRunner       *fakesys.FakeCmdRunner
+		fs              *fakesys.FakeFileSystem
+		specService     *fakeas.FakeV1Service
+		settingsService *fakesettings.FakeSettingsService
+		dualDCSupport   *DualDCSupport
+	)
+
+	BeforeEach(func() {
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		fs = fakesys.NewFakeFileSystem()
+		fs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+		fs.WriteFileString("/proc/drbd", procDrbdPrimaryConnected)
+		cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: storeMounted, Sticky: true})
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive:              "disabled",
+			DrbdEnabled:          true,
+			DrbdForceMaster:      true,
+			DrbdReplicationType:  "A",
+			DrbdReplicationNode1: "10.76.245.71",
+			DrbdReplicationNode2: "10.92.245.71",
+		}
+
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Networks = boshsettings.Networks{
+			"default": boshsettings.Network{IP: "10.76.245.71", Default: []string{"dns", "gateway"}},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+	})
+
+	It("reports a consistent active leg", func() {
+		status, err := dualDCSupport.DualDCStatus()
+		Expect(err).ToNot(HaveOccurred())
+
+		checksum := sha256.Sum256([]byte("fake-config"))
+
+		Expect(status.Role).To(Equal("active"))
+		Expect(status.DrbdForceMaster).To(BeTrue())
+		Expect(status.DrbdReplicationType).To(Equal("A"))
+		Expect(status.DrbdConfigChecksum).To(Equal(hex.EncodeToString(checksum[:])))
+		Expect(status.Drbd.Role).To(Equal("Primary"))
+		Expect(status.Drbd.PeerRole).To(Equal("Secondary"))
+		Expect(status.ReplicationPeers).To(Equal([]DrbdReplicationPeer{{Address: "10.92.245.71"}}))
+		Expect(status.Store).To(Equal(StoreStatus{MountPoint: "/var/vcap/store", Mounted: true}))
+		Expect(status.Inconsistencies).To(BeEmpty())
+		Expect(status.Error).To(BeEmpty())
+	})
+
+	It("lists where the spec and the leg disagree", func() {
+		specService.Spec.Passive = "enabled"
+
+		status, err := dualDCSupport.DualDCStatus()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Role).To(Equal("passive"))
+		Expect(status.Inconsistencies).To(Equal([]string{
+			"spec says passive but DRBD is Primary",
+			"spec says passive but /var/vcap/store is mounted",
+			"drbd_force_master is set on the passive leg",
+		}))
+	})
+
+	It("reports an active leg whose drbd is secondary", func() {
+		fs.WriteFileString("/proc/drbd", procDrbdSecondaryConnected)
+		cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: "", Sticky: true})
+		fs.RemoveAll("/etc/drbd.d/r0.res")
+
+		status, err := dualDCSupport.DualDCStatus()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.DrbdConfigChecksum).To(BeEmpty())
+		Expect(status.Inconsistencies).To(ContainElement("drbd is enabled but its config is not written"))
+		Expect(status.Inconsistencies).To(ContainElement("spec says active but DRBD is Secondary"))
+	})
+
+	It("still returns the status when a part cannot be queried", func() {
+		specService.Spec.DrbdReplicationNode1 = "10.0.0.1"
+
+		status, err := dualDCSupport.DualDCStatus()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Error).To(ContainSubstring("matches local replication IP"))
+		Expect(status.Drbd).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,161 @@
+package nimbus
+
+import (
+	"strconv"
+	"strings"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// Outcome of growing the volume on one leg
+const (
+	DrbdGrowExtended  = "extended"  // passive leg, backing LV extended
+	DrbdGrowGrown     = "grown"     // active leg, DRBD device and filesystem grown
+	DrbdGrowUnchanged = "unchanged" // active leg, DRBD device did not grow, the peer LV is not larger yet
+)
+
+type DrbdGrowResult struct {
+	State            string   `json:"state"`
+	Steps            []string `json:"steps"`
+	LogicalVolumeKiB int64    `json:"logical_volume_kib"`
+	DeviceKiB        int64    `json:"device_kib"`
+}
+
+// GrowDrbdVolume grows the volume stack in place, it is run on the passive leg first and then on the active one.
+// Both legs grow the partition, the PV and the backing LV, the active leg then resizes the DRBD device,
+// which only grows once both backing LVs are larger, and the filesystem on top of it.
+// Every step can be repeated, size is passed to lvextend and defaults to drbd_logical_volume_size.
+// The LV was created from the free extents of an empty VG, so sizes relative to free
+// extents (40%FREE) become the same share of the VG, which keeps a repeated grow from
+// taking the free extents left for snapshots.
+func (d DualDCSupport) GrowDrbdVolume(size string) (result DrbdGrowResult, err error) {
+	result.Steps = []string{}
+
+	spec, err := d.specService.Get()
+	if err != nil {
+		return result, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return result, bosherr.Error("Growing the volume requires drbd to be enabled")
+	}
+
+	resource := NewDrbdResource(spec)
+
+	disk, err := d.persistentDiskDevice()
+	if err != nil {
+		return result, err
+	}
+	backingDevice := diskPartition(disk, drbdBackingPartition)
+
+	d.logger.Info(nimbusLogTag, "Growing drbd volume %s/%s on %s", resource.VolumeGroup, resource.LogicalVolume, backingDevice)
+
+	if _, _, _, err = d.cmdRunner.RunCommand("growpart", disk, strconv.Itoa(drbdBackingPartition)); err != nil {
+		// growpart exits with NOCHANGE when the disk was not resized
+		if !strings.Contains(err.Error(), "NOCHANGE") {
+			return result, bosherr.WrapErrorf(err, "Growing partition %s", backingDevice)
+		}
+	}
+	result.Steps = append(result.Steps, "growpart")
+
+	if _, _, _, err = d.cmdRunner.RunCommand("pvresize", backingDevice); err != nil {
+		return result, bosherr.WrapErrorf(err, "Resizing physical volume %s", backingDevice)
+	}
+	result.Steps = append(result.Steps, "pvresize")
+
+	return d.extendDrbdVolume(spec, resource, size, result)
+}
+
+// extendDrbdVolume extends the backing LV once the volume group has grown and,
+// on the active leg, the DRBD device and the filesystem on top of it.
+func (d DualDCSupport) extendDrbdVolume(spec boshas.V1ApplySpec, resource DrbdResource, size string, result DrbdGrowResult) (DrbdGrowResult, error) {
+	if size == "" {
+		size = resource.LogicalVolumeSize
+	}
+	if strings.HasSuffix(size, "%FREE") && !strings.HasPrefix(size, "+") {
+		size = strings.TrimSuffix(size, "%FREE") + "%VG"
+	}
+
+	d.logger.Info(nimbusLogTag, "Extending drbd volume %s/%s to %s", resource.VolumeGroup, resource.LogicalVolume, size)
+
+	args := append([]string{}, lvmSizeArgs(size)...)
+	args = append(args, resource.VolumeGroup+"/"+resource.LogicalVolume)
+
+	if _, _, _, err := d.cmdRunner.RunCommand("lvextend", args...); err != nil {
+		// lvextend refuses to extend to the current or a smaller size, a repeated grow is fine
+		if !strings.Contains(err.Error(), "matches existing size") && !strings.Contains(err.Error(), "not larger than existing size") {
+			return result, bosherr.WrapErrorf(err, "when running: lvextend %s", strings.Join(args, " "))
+		}
+	}
+	result.Steps = append(result.Steps, "lvextend")
+
+	logicalVolumeKiB, err := d.logicalVolumeKiB(resource)
+	if err != nil {
+		return result, err
+	}
+	result.LogicalVolumeKiB = logicalVolumeKiB
+
+	if !spec.IsActiveSide() {
+		result.State = DrbdGrowExtended
+		return result, nil
+	}
+
+	cstate, err := d.drbdConnectionState(resource)
+	if err != nil {
+		return result, err
+	}
+
+	// resizing while disconnected would need --assume-peer-has-space
+	if cstate != "Connected" {
+		return result, bosherr.Errorf("Resource %s must be connected to resize, connection state is %s", resource.Name, cstate)
+	}
+
+	before, err := d.blockDeviceKiB(resource.Device())
+	if err != nil {
+		return result, err
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "resize", resource.Name); err != nil {
+		return result, bosherr.WrapErrorf(err, "Resizing drbd resource %s", resource.Name)
+	}
+	result.Steps = append(result.Steps, "drbdadm_resize")
+
+	if result.DeviceKiB, err = d.blockDeviceKiB(resource.Device()); err != nil {
+		return result, err
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("resize2fs", resource.Device()); err != nil {
+		return result, bosherr.WrapErrorf(err, "Resizing filesystem on %s", resource.Device())
+	}
+	result.Steps = append(result.Steps, "resize2fs")
+
+	if result.DeviceKiB > before {
+		result.State = DrbdGrowGrown
+	} else {
+		result.State = DrbdGrowUnchanged
+	}
+
+	return result, nil
+}
+
+func (d DualDCSupport) logicalVolumeKiB(resource DrbdResource) (int64, error) {
+	out, _, _, err := d.cmdRunner.RunCommand(
+		"lvs", "--noheadings", "--units", "k", "--nosuffix", "-o", "lv_size",
+		resource.VolumeGroup+"/"+resource.LogicalVolume,
+	)
+	if err != nil {
+		return 0, bosherr.WrapErrorf(err, "Getting size of %s/%s", resource.VolumeGroup, resource.LogicalVolume)
+	}
+
+	return int64(parseDrbdFloat(strings.TrimSpace(out))), nil
+}
+
+func (d DualDCSupport) blockDeviceKiB(device string) (int64, error) {
+	out, _, _, err := d.cmdRunner.RunCommand("blockdev", "--getsize64", device)
+	if err != nil {
+		return 0, bosherr.WrapErrorf(err, "Getting size of %s", device)
+	}
+
+	return parseDrbdInt(strings.TrimSpace(out)) / 1024, nil
+}
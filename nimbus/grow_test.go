@@ -0,0 +1,176 @@
+package nimbus
+
+import (
+	"errors"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GrowDrbdVolume", func() {
+	var (
+		dualDCSupport   *DualDCSupport
+		cmdRunner       *fakesys.FakeCmdRunner
+		specService     *fakeas.FakeV1Service
+		settingsService *fakesettings.FakeSettingsService
+
+		devicePathResolver *fakedpresolv.FakeDevicePathResolver
+	)
+
+	BeforeEach(func() {
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "enabled"}
+
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Disks.Persistent = map[string]interface{}{"fake-disk-id": "/dev/sdd"}
+
+		devicePathResolver = fakedpresolv.NewFakeDevicePathResolver()
+		devicePathResolver.RealDevicePath = "/dev/xvdd"
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fakesys.NewFakeFileSystem(),
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			devicePathResolver,
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		cmdRunner.AddCmdResult("lvs --noheadings --units k --nosuffix -o lv_size vgStoreData/StoreData", fakesys.FakeCmdResult{Stdout: "  8388608.00\n"})
+	})
+
+	It("extends pv and lv on passive leg", func() {
+		result, err := dualDCSupport.GrowDrbdVolume("+10G")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(DrbdGrowResult{
+			State:            DrbdGrowExtended,
+			Steps:            []string{"growpart", "pvresize", "lvextend"},
+			LogicalVolumeKiB: 8388608,
+		}))
+
+		Expect(cmdRunner.RunCommands).To(Equal([][]string{
+			{"growpart", "/dev/xvdd", "1"},
+			{"pvresize", "/dev/xvdd1"},
+			{"lvextend", "-L", "+10G", "vgStoreData/StoreData"},
+			{"lvs", "--noheadings", "--units", "k", "--nosuffix", "-o", "lv_size", "vgStoreData/StoreData"},
+		}))
+	})
+
+	It("names the partition of disks ending in a digit with a p separator", func() {
+		devicePathResolver.RealDevicePath = "/dev/nvme1n1"
+
+		_, err := dualDCSupport.GrowDrbdVolume("+10G")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cmdRunner.RunCommands).To(ContainElement([]string{"growpart", "/dev/nvme1n1", "1"}))
+		Expect(cmdRunner.RunCommands).To(ContainElement([]string{"pvresize", "/dev/nvme1n1p1"}))
+	})
+
+	It("grows to the share of the volume group from spec by default", func() {
+		_, err := dualDCSupport.GrowDrbdVolume("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cmdRunner.RunCommands).To(ContainElement([]string{"lvextend", "-l", "40%VG", "vgStoreData/StoreData"}))
+	})
+
+	It("does not grow again when repeated", func() {
+		cmdRunner.AddCmdResult("growpart /dev/xvdd 1", fakesys.FakeCmdResult{
+			Error: errors.New("NOCHANGE: partition 1 is size 20969439. it cannot be grown"),
+		})
+		cmdRunner.AddCmdResult("lvextend -l 40%VG vgStoreData/StoreData", fakesys.FakeCmdResult{
+			Error: errors.New("New size given (1024 extents) not larger than existing size (2048 extents)"),
+		})
+
+		result, err := dualDCSupport.GrowDrbdVolume("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.State).To(Equal(DrbdGrowExtended))
+	})
+
+	It("fails when the partition cannot be grown", func() {
+		cmdRunner.AddCmdResult("growpart /dev/xvdd 1", fakesys.FakeCmdResult{Error: errors.New("fake-growpart-error")})
+
+		result, err := dualDCSupport.GrowDrbdVolume("+10G")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("fake-growpart-error"))
+		Expect(result.Steps).To(BeEmpty())
+	})
+
+	It("accepts lv already at requested size", func() {
+		cmdRunner.AddCmdResult("lvextend -l 100%VG vgStoreData/StoreData", fakesys.FakeCmdResult{
+			Error: errors.New("New size (2048 extents) matches existing size (2048 extents)"),
+		})
+
+		_, err := dualDCSupport.GrowDrbdVolume("100%VG")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("fails when lvextend fails", func() {
+		cmdRunner.AddCmdResult("lvextend -l 100%VG vgStoreData/StoreData", fakesys.FakeCmdResult{Error: errors.New("Insufficient free space")})
+
+		result, err := dualDCSupport.GrowDrbdVolume("100%VG")
+		Expect(err).To(HaveOccurred())
+		Expect(result.Steps).To(Equal([]string{"growpart", "pvresize"}))
+	})
+
+	Context("on active leg", func() {
+		BeforeEach(func() {
+			specService.Spec.Passive = "disabled"
+		})
+
+		It("resizes drbd and the filesystem", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "Connected\n"})
+			cmdRunner.AddCmdResult("blockdev --getsize64 /dev/drbd1", fakesys.FakeCmdResult{Stdout: "4294967296\n"})
+			cmdRunner.AddCmdResult("blockdev --getsize64 /dev/drbd1", fakesys.FakeCmdResult{Stdout: "8589934592\n"})
+
+			result, err := dualDCSupport.GrowDrbdVolume("+10G")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.State).To(Equal(DrbdGrowGrown))
+			Expect(result.Steps).To(Equal([]string{"growpart", "pvresize", "lvextend", "drbdadm_resize", "resize2fs"}))
+			Expect(result.DeviceKiB).To(Equal(int64(8388608)))
+
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "resize", "r0"}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"resize2fs", "/dev/drbd1"}))
+		})
+
+		It("reports unchanged device when peer is not extended yet", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "Connected\n"})
+			cmdRunner.AddCmdResult("blockdev --getsize64 /dev/drbd1", fakesys.FakeCmdResult{Stdout: "4294967296\n", Sticky: true})
+
+			result, err := dualDCSupport.GrowDrbdVolume("+10G")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.State).To(Equal(DrbdGrowUnchanged))
+		})
+
+		It("refuses to resize disconnected resource", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "WFConnection\n"})
+
+			_, err := dualDCSupport.GrowDrbdVolume("+10G")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("must be connected to resize"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "resize", "r0"}))
+		})
+	})
+
+	It("requires drbd to be enabled", func() {
+		specService.Spec = boshas.V1ApplySpec{}
+
+		_, err := dualDCSupport.GrowDrbdVolume("+10G")
+		Expect(err).To(HaveOccurred())
+		Expect(cmdRunner.RunCommands).To(BeEmpty())
+	})
+
+	It("fails without persistent disk", func() {
+		settingsService.Settings = boshsettings.Settings{}
+
+		_, err := dualDCSupport.GrowDrbdVolume("+10G")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,179 @@
+package nimbus
+
+import (
+	"path/filepath"
+	"strings"
+
+	boshdisk "github.com/cloudfoundry/bosh-agent/platform/disk"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// The director replaces the persistent disk with a larger one by attaching the new disk next to the
+// old one, mounting it and migrating the data. The data of a DRBD box is not copied between filesystems,
+// the new disk joins the volume group, the extents are moved onto it and the volume stack is then grown
+// like with drbd_grow_volume.
+
+// drbdMigrating tells if the director attached a second persistent disk to migrate to.
+func (d DualDCSupport) drbdMigrating() bool {
+	return len(d.settingsService.GetSettings().Disks.Persistent) > 1
+}
+
+func (d DualDCSupport) drbdMigrationTargetPath() string {
+	return filepath.Join(d.dirProvider.BoshDir(), "drbd-migration-target")
+}
+
+// addDrbdMigrationDisk partitions the disk attached for a migration and adds it to the volume group,
+// it can be repeated.
+func (d DualDCSupport) addDrbdMigrationDisk(diskSettings boshsettings.DiskSettings) error {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return err
+	}
+
+	disk, _, err := d.devicePathResolver.GetRealDevicePath(diskSettings)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Getting real device path of persistent disk %s", diskSettings.ID)
+	}
+	device := diskPartition(disk, drbdBackingPartition)
+
+	volumes, err := d.physicalVolumes(resource)
+	if err != nil {
+		return err
+	}
+
+	if !containsString(volumes, device) {
+		d.logger.Info(nimbusLogTag, "Adding %s to volume group %s for the migration", device, resource.VolumeGroup)
+
+		err = d.partitioner.Partition(disk, []boshdisk.Partition{{Type: boshdisk.PartitionTypeLinux}})
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Partitioning %s", disk)
+		}
+
+		if _, _, _, err = d.cmdRunner.RunCommand("pvcreate", device); err != nil {
+			return bosherr.WrapErrorf(err, "Creating physical volume %s", device)
+		}
+
+		if _, _, _, err = d.cmdRunner.RunCommand("vgextend", resource.VolumeGroup, device); err != nil {
+			return bosherr.WrapErrorf(err, "Extending volume group %s with %s", resource.VolumeGroup, device)
+		}
+	}
+
+	if err = d.fs.WriteFileString(d.drbdMigrationTargetPath(), device); err != nil {
+		return bosherr.WrapError(err, "Saving drbd migration target")
+	}
+
+	return nil
+}
+
+// MigrateDrbdDisk moves the volume group off the old persistent disk onto the one added by
+// addDrbdMigrationDisk and grows the volume stack to the spec size on the larger disk.
+// The director migrates one leg after the other, the active leg grows the DRBD device only
+// once both backing LVs are larger. When the passive leg is migrated last drbd_grow_volume
+// has to be run on the active leg afterwards.
+func (d DualDCSupport) MigrateDrbdDisk() (result DrbdGrowResult, err error) {
+	result.Steps = []string{}
+
+	spec, err := d.specService.Get()
+	if err != nil {
+		return result, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return result, bosherr.Error("Migrating the volume requires drbd to be enabled")
+	}
+
+	resource := NewDrbdResource(spec)
+
+	if !d.fs.FileExists(d.drbdMigrationTargetPath()) {
+		return result, bosherr.Errorf("No disk was added to volume group %s to migrate to", resource.VolumeGroup)
+	}
+
+	target, err := d.fs.ReadFileString(d.drbdMigrationTargetPath())
+	if err != nil {
+		return result, bosherr.WrapError(err, "Reading drbd migration target")
+	}
+	target = strings.TrimSpace(target)
+
+	volumes, err := d.physicalVolumes(resource)
+	if err != nil {
+		return result, err
+	}
+
+	if !containsString(volumes, target) {
+		return result, bosherr.Errorf("Migration target %s is not part of volume group %s", target, resource.VolumeGroup)
+	}
+
+	for _, volume := range volumes {
+		if volume == target {
+			continue
+		}
+
+		d.logger.Info(nimbusLogTag, "Moving volume group %s from %s to %s", resource.VolumeGroup, volume, target)
+
+		if _, _, _, err = d.cmdRunner.RunCommand("pvmove", volume, target); err != nil {
+			// a repeated migration finds the old volume already empty
+			if !strings.Contains(err.Error(), "No data to move") {
+				return result, bosherr.WrapErrorf(err, "Moving extents from %s to %s", volume, target)
+			}
+		}
+
+		if _, _, _, err = d.cmdRunner.RunCommand("vgreduce", resource.VolumeGroup, volume); err != nil {
+			return result, bosherr.WrapErrorf(err, "Removing %s from volume group %s", volume, resource.VolumeGroup)
+		}
+
+		if _, _, _, err = d.cmdRunner.RunCommand("pvremove", volume); err != nil {
+			return result, bosherr.WrapErrorf(err, "Removing physical volume %s", volume)
+		}
+	}
+	result.Steps = append(result.Steps, "pvmove")
+
+	// the director expects the store on the new disk to be mounted after the migration
+	if spec.IsActiveSide() {
+		if err = d.mountDRBD(); err != nil {
+			return result, bosherr.WrapError(err, "Mounting drbd store")
+		}
+	}
+
+	result, err = d.extendDrbdVolume(spec, resource, "", result)
+	if err != nil {
+		return result, err
+	}
+
+	if result.State == DrbdGrowUnchanged {
+		d.logger.Warn(nimbusLogTag, "Drbd device %s did not grow, run drbd_grow_volume on this leg once the peer was migrated", resource.Device())
+	}
+
+	if err = d.fs.RemoveAll(d.drbdMigrationTargetPath()); err != nil {
+		return result, bosherr.WrapError(err, "Removing drbd migration target")
+	}
+
+	return result, nil
+}
+
+// physicalVolumes lists the physical volumes of the volume group of resource
+func (d DualDCSupport) physicalVolumes(resource DrbdResource) ([]string, error) {
+	out, _, _, err := d.cmdRunner.RunCommand("pvs", "--noheadings", "-o", "pv_name,vg_name")
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing physical volumes")
+	}
+
+	volumes := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == resource.VolumeGroup {
+			volumes = append(volumes, fields[0])
+		}
+	}
+
+	return volumes, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package nimbus
+
+import (
+	"errors"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshplatform "github.com/cloudfoundry/bosh-agent/platform"
+	fakeplatform "github.com/cloudfoundry/bosh-agent/platform/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrating the persistent disk", func() {
+	var (
+		cmdRunner       *fakesys.FakeCmdRunner
+		fs              *fakesys.FakeFileSystem
+		specService     *fakeas.FakeV1Service
+		settingsService *fakesettings.FakeSettingsService
+		platform        *fakeplatform.FakePlatform
+		wrapper         boshplatform.Platform
+
+		devicePathResolver *fakedpresolv.FakeDevicePathResolver
+	)
+
+	const targetPath = "/var/vcap/bosh/drbd-migration-target"
+
+	BeforeEach(func() {
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		fs = fakesys.NewFakeFileSystem()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "enabled"}
+
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Disks.Persistent = map[string]interface{}{
+			"fake-old-disk-id": "/dev/sdd",
+			"fake-new-disk-id": "/dev/sde",
+		}
+
+		devicePathResolver = fakedpresolv.NewFakeDevicePathResolver()
+		devicePathResolver.RealDevicePath = "/dev/xvde"
+
+		platform = fakeplatform.NewFakePlatform()
+		wrapper = NewPlatformWrapper(platform, NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			devicePathResolver,
+			boshlog.NewLogger(boshlog.LevelNone),
+		))
+
+		cmdRunner.AddCmdResult("lvs --noheadings --units k --nosuffix -o lv_size vgStoreData/StoreData", fakesys.FakeCmdResult{Stdout: "  16777216.00\n"})
+	})
+
+	Describe("MountPersistentDisk", func() {
+		It("adds the new disk to the volume group instead of mounting it", func() {
+			cmdRunner.AddCmdResult("pvs --noheadings -o pv_name,vg_name", fakesys.FakeCmdResult{Stdout: "  /dev/xvdd1 vgStoreData\n"})
+
+			err := wrapper.MountPersistentDisk(boshsettings.DiskSettings{ID: "fake-new-disk-id"}, "/var/vcap/store_migration_target")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cmdRunner.RunCommandsWithInput).To(Equal([][]string{{",,L\n", "sfdisk", "-uM", "/dev/xvde"}}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"pvcreate", "/dev/xvde1"}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"vgextend", "vgStoreData", "/dev/xvde1"}))
+			Expect(platform.MountPersistentDiskCalled).To(BeFalse())
+
+			target, err := fs.ReadFileString(targetPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target).To(Equal("/dev/xvde1"))
+		})
+
+		It("does not partition the new disk again when it already joined the volume group", func() {
+			cmdRunner.AddCmdResult("pvs --noheadings -o pv_name,vg_name", fakesys.FakeCmdResult{Stdout: "  /dev/xvdd1 vgStoreData\n  /dev/xvde1 vgStoreData\n"})
+
+			err := wrapper.MountPersistentDisk(boshsettings.DiskSettings{ID: "fake-new-disk-id"}, "/var/vcap/store_migration_target")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cmdRunner.RunCommandsWithInput).To(BeEmpty())
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"pvs", "--noheadings", "-o", "pv_name,vg_name"}}))
+			Expect(fs.FileExists(targetPath)).To(BeTrue())
+		})
+	})
+
+	Describe("MigratePersistentDisk", func() {
+		BeforeEach(func() {
+			fs.WriteFileString(targetPath, "/dev/xvde1")
+			cmdRunner.AddCmdResult("pvs --noheadings -o pv_name,vg_name", fakesys.FakeCmdResult{Stdout: "  /dev/xvdd1 vgStoreData\n  /dev/xvde1 vgStoreData\n  /dev/sda3  vgRoot\n"})
+		})
+
+		It("moves the volume group onto the new disk and extends the logical volume", func() {
+			err := wrapper.MigratePersistentDisk("/var/vcap/store", "/var/vcap/store_migration_target")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{
+				{"pvs", "--noheadings", "-o", "pv_name,vg_name"},
+				{"pvmove", "/dev/xvdd1", "/dev/xvde1"},
+				{"vgreduce", "vgStoreData", "/dev/xvdd1"},
+				{"pvremove", "/dev/xvdd1"},
+				{"lvextend", "-l", "40%VG", "vgStoreData/StoreData"},
+				{"lvs", "--noheadings", "--units", "k", "--nosuffix", "-o", "lv_size", "vgStoreData/StoreData"},
+			}))
+			Expect(platform.MigratePersistentDiskFromMountPoint).To(BeEmpty())
+			Expect(fs.FileExists(targetPath)).To(BeFalse())
+		})
+
+		It("continues a migration whose extents were already moved", func() {
+			cmdRunner.AddCmdResult("pvmove /dev/xvdd1 /dev/xvde1", fakesys.FakeCmdResult{Error: errors.New("No data to move for vgStoreData")})
+
+			err := wrapper.MigratePersistentDisk("/var/vcap/store", "/var/vcap/store_migration_target")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"pvremove", "/dev/xvdd1"}))
+		})
+
+		It("keeps the old disk in the volume group when moving the extents fails", func() {
+			cmdRunner.AddCmdResult("pvmove /dev/xvdd1 /dev/xvde1", fakesys.FakeCmdResult{Error: errors.New("fake-pvmove-err")})
+
+			err := wrapper.MigratePersistentDisk("/var/vcap/store", "/var/vcap/store_migration_target")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("fake-pvmove-err"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"vgreduce", "vgStoreData", "/dev/xvdd1"}))
+			Expect(fs.FileExists(targetPath)).To(BeTrue())
+		})
+
+		It("returns error when no disk was added to the volume group", func() {
+			fs.RemoveAll(targetPath)
+
+			err := wrapper.MigratePersistentDisk("/var/vcap/store", "/var/vcap/store_migration_target")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("No disk was added to volume group vgStoreData"))
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+	})
+
+	Describe("UnmountPersistentDisk", func() {
+		It("leaves the drbd store mounted when the old disk is unmounted after a migration", func() {
+			didUnmount, err := wrapper.UnmountPersistentDisk(boshsettings.DiskSettings{ID: "fake-old-disk-id"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(didUnmount).To(BeFalse())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+			Expect(platform.UnmountPersistentDiskSettings).To(Equal(boshsettings.DiskSettings{}))
+		})
+	})
+})
@@ -0,0 +1,187 @@
+package nimbus
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	defaultDrbdQuorum     = "majority"
+	defaultDrbdOnNoQuorum = "suspend-io"
+)
+
+type drbdNode struct {
+	Name    string
+	NodeID  int
+	Address string
+}
+
+// isMultiPeer is true for DRBD 9 resources replicated between the nodes listed in drbd_replication_nodes
+func isMultiPeer(spec boshas.V1ApplySpec) bool {
+	return len(spec.DrbdReplicationNodes) > 0
+}
+
+// replicationNodes assigns node ids in list order and makes sure
+// that exactly one of the nodes is this host's replication IP.
+func (d DualDCSupport) replicationNodes(spec boshas.V1ApplySpec) ([]drbdNode, error) {
+	thisHostIP, err := d.replicationIP(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(spec.DrbdReplicationNodes) < 2 {
+		return nil, bosherr.Errorf("drbd_replication_nodes must list at least 2 nodes, got %d", len(spec.DrbdReplicationNodes))
+	}
+
+	nodes := []drbdNode{}
+	names := map[string]bool{}
+	addresses := map[string]bool{}
+	local := 0
+
+	for i, nodeSpec := range spec.DrbdReplicationNodes {
+		if nodeSpec.Name == "" || nodeSpec.Address == "" {
+			return nil, bosherr.Errorf("drbd_replication_nodes[%d] must have name and address", i)
+		}
+
+		if names[nodeSpec.Name] || addresses[nodeSpec.Address] {
+			return nil, bosherr.Errorf("drbd_replication_nodes[%d] '%s' (%s) is listed twice", i, nodeSpec.Name, nodeSpec.Address)
+		}
+		names[nodeSpec.Name] = true
+		addresses[nodeSpec.Address] = true
+
+		if nodeSpec.Address == thisHostIP {
+			local++
+		}
+
+		nodes = append(nodes, drbdNode{Name: nodeSpec.Name, NodeID: i, Address: nodeSpec.Address})
+	}
+
+	if local == 0 {
+		return nil, bosherr.Errorf("None of drbd_replication_nodes matches local replication IP '%s'", thisHostIP)
+	}
+
+	return nodes, nil
+}
+
+func (d DualDCSupport) renderMultiPeerConfig(spec boshas.V1ApplySpec, resource DrbdResource, afterSplitBrain afterSplitBrain) (string, error) {
+	nodes, err := d.replicationNodes(spec)
+	if err != nil {
+		return "", err
+	}
+
+	quorum := spec.DrbdQuorum
+	if quorum == "" {
+		quorum = defaultDrbdQuorum
+	}
+
+	onNoQuorum := spec.DrbdOnNoQuorum
+	if onNoQuorum == "" {
+		onNoQuorum = defaultDrbdOnNoQuorum
+	}
+
+	return renderDrbdMeshConfig(drbdMeshConfigArgs{
+		Resource:         resource,
+		ReplicationType:  spec.DrbdReplicationType,
+		Secret:           spec.DrbdSecret,
+		AfterSplitBrain:  afterSplitBrain,
+		SplitBrainMarker: d.splitBrainMarkerPath(resource),
+		Tuning:           newDrbdTuning(spec),
+		Quorum:           quorum,
+		OnNoQuorum:       onNoQuorum,
+		Nodes:            nodes,
+	})
+}
+
+// checkDrbdQuorum refuses promotion of a multi-peer resource that has lost quorum,
+// promoting it would only suspend or fail I/O.
+func (d DualDCSupport) checkDrbdQuorum(resource DrbdResource) error {
+	out, _, _, err := d.cmdRunner.RunCommand("drbdsetup", "status", resource.Name, "--verbose")
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Checking quorum of drbd resource %s", resource.Name)
+	}
+
+	if ParseDrbdsetupStatus(out).Quorum == "no" {
+		return bosherr.Errorf("Drbd resource %s has no quorum, refusing to promote", resource.Name)
+	}
+
+	return nil
+}
+
+type drbdMeshConfigArgs struct {
+	Resource         DrbdResource
+	ReplicationType  string
+	Secret           string
+	AfterSplitBrain  afterSplitBrain
+	SplitBrainMarker string
+	Tuning           drbdTuning
+	Quorum           string
+	OnNoQuorum       string
+	Nodes            []drbdNode
+}
+
+func (a drbdMeshConfigArgs) HostNames() string {
+	names := []string{}
+	for _, node := range a.Nodes {
+		names = append(names, node.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func renderDrbdMeshConfig(args drbdMeshConfigArgs) (string, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	t := template.Must(template.New("drbd-mesh-config").Parse(drbdMeshConfigTemplate))
+
+	if err := t.Execute(buffer, args); err != nil {
+		return "", bosherr.WrapError(err, "Generating drbd mesh config from template")
+	}
+
+	return buffer.String(), nil
+}
+
+const drbdMeshConfigTemplate = `
+resource {{ .Resource.Name }} {
+  options {
+    quorum {{ .Quorum }};
+    on-no-quorum {{ .OnNoQuorum }};
+  }
+  net {
+    protocol {{ .ReplicationType }};
+    shared-secret {{ .Secret }};
+    verify-alg sha1;
+    after-sb-0pri {{ .AfterSplitBrain.ZeroPrimaries }};
+    after-sb-1pri {{ .AfterSplitBrain.OnePrimary }};
+    after-sb-2pri {{ .AfterSplitBrain.TwoPrimaries }};{{ if .Tuning.OnCongestion }}
+    on-congestion {{ .Tuning.OnCongestion }};{{ end }}{{ if .Tuning.CongestionFill }}
+    congestion-fill {{ .Tuning.CongestionFill }};{{ end }}
+  }
+  disk {
+    resync-rate {{ .Tuning.ResyncRate }};
+  }
+  handlers {
+    before-resync-target "/lib/drbd/snapshot-resync-target-lvm.sh";
+    after-resync-target "/lib/drbd/unsnapshot-resync-target-lvm.sh";
+    split-brain "/bin/touch {{ .SplitBrainMarker }}";
+  }
+  startup {
+    wfc-timeout 3;
+    degr-wfc-timeout 3;
+    outdated-wfc-timeout 2;
+  }
+{{- range .Nodes }}
+  on {{ .Name }} {
+    node-id   {{ .NodeID }};
+    device    {{ $.Resource.Device }};
+    disk      {{ $.Resource.LogicalVolumeDevice }};
+    address   {{ .Address }}:{{ $.Resource.Port }};
+    meta-disk internal;
+  }
+{{- end }}
+  connection-mesh {
+    hosts {{ .HostNames }};
+  }
+}
+`
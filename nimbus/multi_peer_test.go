@@ -0,0 +1,174 @@
+package nimbus
+
+import (
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MultiPeer", func() {
+	var (
+		dualDCSupport *DualDCSupport
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			DrbdEnabled:         true,
+			Passive:             "disabled",
+			DrbdReplicationType: "C",
+			DrbdSecret:          "fake-secret",
+			DrbdReplicationNodes: []boshas.DrbdNodeSpec{
+				{Name: "dc1-a", Address: "10.76.245.71"},
+				{Name: "dc1-b", Address: "10.76.245.72"},
+				{Name: "dc2-a", Address: "10.92.245.71"},
+			},
+		}
+
+		settingsService := &fakesettings.FakeSettingsService{}
+		settingsService.Settings = boshsettings.Settings{
+			AgentID: "dc1-b",
+			Disks: boshsettings.Disks{
+				Persistent: map[string]interface{}{"fake-disk-id": "/dev/sdd"},
+			},
+			Networks: boshsettings.Networks{
+				"default": boshsettings.Network{IP: "10.76.245.72"},
+			},
+		}
+
+		devicePathResolver := fakedpresolv.NewFakeDevicePathResolver()
+		devicePathResolver.RealDevicePath = "/dev/xvdd"
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			devicePathResolver,
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+	})
+
+	It("writes connection mesh config with node ids and quorum", func() {
+		err := dualDCSupport.setupDRBD()
+		Expect(err).ToNot(HaveOccurred())
+
+		config, err := fs.ReadFileString("/etc/drbd.d/r0.res")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(Equal(`
+resource r0 {
+  options {
+    quorum majority;
+    on-no-quorum suspend-io;
+  }
+  net {
+    protocol C;
+    shared-secret fake-secret;
+    verify-alg sha1;
+    after-sb-0pri disconnect;
+    after-sb-1pri disconnect;
+    after-sb-2pri disconnect;
+  }
+  disk {
+    resync-rate 24M;
+  }
+  handlers {
+    before-resync-target "/lib/drbd/snapshot-resync-target-lvm.sh";
+    after-resync-target "/lib/drbd/unsnapshot-resync-target-lvm.sh";
+    split-brain "/bin/touch /var/vcap/bosh/drbd-split-brain-r0";
+  }
+  startup {
+    wfc-timeout 3;
+    degr-wfc-timeout 3;
+    outdated-wfc-timeout 2;
+  }
+  on dc1-a {
+    node-id   0;
+    device    /dev/drbd1;
+    disk      /dev/mapper/vgStoreData-StoreData;
+    address   10.76.245.71:7789;
+    meta-disk internal;
+  }
+  on dc1-b {
+    node-id   1;
+    device    /dev/drbd1;
+    disk      /dev/mapper/vgStoreData-StoreData;
+    address   10.76.245.72:7789;
+    meta-disk internal;
+  }
+  on dc2-a {
+    node-id   2;
+    device    /dev/drbd1;
+    disk      /dev/mapper/vgStoreData-StoreData;
+    address   10.92.245.71:7789;
+    meta-disk internal;
+  }
+  connection-mesh {
+    hosts dc1-a dc1-b dc2-a;
+  }
+}
+`))
+	})
+
+	It("uses quorum options from spec", func() {
+		specService.Spec.DrbdQuorum = "all"
+		specService.Spec.DrbdOnNoQuorum = "io-error"
+
+		err := dualDCSupport.setupDRBD()
+		Expect(err).ToNot(HaveOccurred())
+
+		config, err := fs.ReadFileString("/etc/drbd.d/r0.res")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(ContainSubstring("quorum all;"))
+		Expect(config).To(ContainSubstring("on-no-quorum io-error;"))
+	})
+
+	It("does not write config when no node is local", func() {
+		specService.Spec.DrbdReplicationNodes[1].Address = "10.76.245.73"
+
+		err := dualDCSupport.setupDRBD()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("None of drbd_replication_nodes matches local replication IP '10.76.245.72'"))
+		Expect(fs.FileExists("/etc/drbd.d/r0.res")).To(BeFalse())
+	})
+
+	It("does not write config when a node is listed twice", func() {
+		specService.Spec.DrbdReplicationNodes[2].Name = "dc1-a"
+
+		err := dualDCSupport.setupDRBD()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is listed twice"))
+	})
+
+	Describe("drbdMakePrimary", func() {
+		It("promotes with quorum", func() {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: "r0 node-id:1 role:Secondary\n  volume:0 minor:1 disk:UpToDate quorum:yes\n"})
+
+			err := dualDCSupport.drbdMakePrimary(NewDrbdResource(specService.Spec))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "primary", "r0"}))
+		})
+
+		It("refuses promotion without quorum", func() {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: "r0 node-id:1 role:Secondary\n  volume:0 minor:1 disk:UpToDate quorum:no\n"})
+
+			err := dualDCSupport.drbdMakePrimary(NewDrbdResource(specService.Spec))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("has no quorum, refusing to promote"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "primary", "r0"}))
+		})
+	})
+})
@@ -0,0 +1,73 @@
+package nimbus
+
+import (
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// networkIP returns the IP of the named network from settings,
+// or of the default gateway network when no name is given.
+func (d DualDCSupport) networkIP(networkName string) (string, error) {
+	networks := d.settingsService.GetSettings().Networks
+
+	if networkName != "" {
+		network, found := networks[networkName]
+		if !found || network.IP == "" {
+			return "", bosherr.Errorf("Network '%s' with an IP not found in settings", networkName)
+		}
+		return network.IP, nil
+	}
+
+	network, found := networks.DefaultNetworkFor("gateway")
+	if !found || network.IP == "" {
+		return "", bosherr.Error("Default gateway network with an IP not found in settings")
+	}
+
+	return network.IP, nil
+}
+
+func (d DualDCSupport) replicationIP(spec boshas.V1ApplySpec) (string, error) {
+	ip, err := d.networkIP(spec.DrbdReplicationNetwork)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Finding replication IP")
+	}
+	return ip, nil
+}
+
+// dnsRegistrationIP returns the IP on network, dns_register_network when it is empty
+func (d DualDCSupport) dnsRegistrationIP(spec boshas.V1ApplySpec, network string) (string, error) {
+	if network == "" {
+		network = spec.DNSRegisterNetwork
+	}
+
+	ip, err := d.networkIP(network)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Finding DNS registration IP")
+	}
+	return ip, nil
+}
+
+// replicationPeers makes sure that one of drbd_replication_node1/2 is the
+// replication IP of this host and returns it together with the other node.
+func (d DualDCSupport) replicationPeers(spec boshas.V1ApplySpec) (thisHostIP, otherHostIP string, err error) {
+	thisHostIP, err = d.replicationIP(spec)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case spec.DrbdReplicationNode1 == spec.DrbdReplicationNode2:
+		return "", "", bosherr.Errorf("drbd_replication_node1 and drbd_replication_node2 must differ, both are '%s'", spec.DrbdReplicationNode1)
+	case thisHostIP == spec.DrbdReplicationNode1:
+		return thisHostIP, spec.DrbdReplicationNode2, nil
+	case thisHostIP == spec.DrbdReplicationNode2:
+		return thisHostIP, spec.DrbdReplicationNode1, nil
+	}
+
+	return "", "", bosherr.Errorf(
+		"Neither drbd_replication_node1 '%s' nor drbd_replication_node2 '%s' matches local replication IP '%s'",
+		spec.DrbdReplicationNode1,
+		spec.DrbdReplicationNode2,
+		thisHostIP,
+	)
+}
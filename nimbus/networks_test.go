@@ -0,0 +1,132 @@
+package nimbus
+
+import (
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Networks", func() {
+	var (
+		dualDCSupport   *DualDCSupport
+		settingsService *fakesettings.FakeSettingsService
+		spec            boshas.V1ApplySpec
+	)
+
+	BeforeEach(func() {
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings.Networks = boshsettings.Networks{
+			"public":      boshsettings.Network{IP: "192.168.1.10", Default: []string{"dns", "gateway"}},
+			"replication": boshsettings.Network{IP: "10.76.245.71"},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fakesys.NewFakeFileSystem(),
+			boshdir.NewProvider("/var/vcap"),
+			fakeas.NewFakeV1Service(),
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		spec = boshas.V1ApplySpec{
+			DrbdReplicationNode1: "10.76.245.71",
+			DrbdReplicationNode2: "10.92.245.71",
+		}
+	})
+
+	Describe("replicationIP", func() {
+		It("uses the named network", func() {
+			spec.DrbdReplicationNetwork = "replication"
+
+			ip, err := dualDCSupport.replicationIP(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ip).To(Equal("10.76.245.71"))
+		})
+
+		It("falls back to the default gateway network", func() {
+			ip, err := dualDCSupport.replicationIP(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ip).To(Equal("192.168.1.10"))
+		})
+
+		It("fails when the named network is missing", func() {
+			spec.DrbdReplicationNetwork = "missing"
+
+			_, err := dualDCSupport.replicationIP(spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Network 'missing' with an IP not found in settings"))
+		})
+
+		It("fails without a default gateway network", func() {
+			settingsService.Settings.Networks["public"] = boshsettings.Network{IP: "192.168.1.10"}
+
+			_, err := dualDCSupport.replicationIP(spec)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("dnsRegistrationIP", func() {
+		It("is chosen separately from the replication IP", func() {
+			spec.DrbdReplicationNetwork = "replication"
+			spec.DNSRegisterNetwork = "public"
+
+			ip, err := dualDCSupport.dnsRegistrationIP(spec, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ip).To(Equal("192.168.1.10"))
+		})
+
+		It("uses the network of a record when it sets one", func() {
+			spec.DNSRegisterNetwork = "public"
+
+			ip, err := dualDCSupport.dnsRegistrationIP(spec, "replication")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ip).To(Equal("10.76.245.71"))
+		})
+	})
+
+	Describe("replicationPeers", func() {
+		BeforeEach(func() {
+			spec.DrbdReplicationNetwork = "replication"
+		})
+
+		It("returns node1 as this host", func() {
+			thisHostIP, otherHostIP, err := dualDCSupport.replicationPeers(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(thisHostIP).To(Equal("10.76.245.71"))
+			Expect(otherHostIP).To(Equal("10.92.245.71"))
+		})
+
+		It("returns node2 as this host", func() {
+			spec.DrbdReplicationNode1, spec.DrbdReplicationNode2 = spec.DrbdReplicationNode2, spec.DrbdReplicationNode1
+
+			thisHostIP, otherHostIP, err := dualDCSupport.replicationPeers(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(thisHostIP).To(Equal("10.76.245.71"))
+			Expect(otherHostIP).To(Equal("10.92.245.71"))
+		})
+
+		It("fails when neither node is local", func() {
+			spec.DrbdReplicationNetwork = "public"
+
+			_, _, err := dualDCSupport.replicationPeers(spec)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("matches local replication IP '192.168.1.10'"))
+		})
+
+		It("fails when both nodes are the same", func() {
+			spec.DrbdReplicationNode2 = spec.DrbdReplicationNode1
+
+			_, _, err := dualDCSupport.replicationPeers(spec)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package nimbus
+
+import (
+	"sync"
+
+	boshhandler "github.com/cloudfoundry/bosh-agent/handler"
+)
+
+// NimbusStatus is the nimbus section of get_state
+type NimbusStatus struct {
+	DNS           []DNSServerStatus       `json:"dns,omitempty"`
+	DNSHealth     *DNSHealthStatus        `json:"dns_health,omitempty"`
+	PassiveSafe   *PassiveSafeStatus      `json:"passive_safe,omitempty"`
+	Peer          *PeerLinkStatus         `json:"peer,omitempty"`
+	Cutover       *CutoverState           `json:"cutover,omitempty"`
+	Mbus          *boshhandler.MbusStatus `json:"mbus,omitempty"`
+	AlertFailures int                     `json:"alert_failures,omitempty"` // alerts of the monitors not delivered
+	Error         string                  `json:"error,omitempty"`
+}
+
+// SetMbusStatusProvider is called once the mbus handler is built,
+// handlers without a connection to keep do not provide a status
+func (d *DualDCSupport) SetMbusStatusProvider(provider boshhandler.StatusProvider) {
+	d.mbusStatus = provider
+}
+
+type alertFailureCounter struct {
+	lock  sync.Mutex
+	count int
+}
+
+// AlertFailed counts an alert of the monitors that was not delivered,
+// the monitors report it again on their next check
+func (d DualDCSupport) AlertFailed() int {
+	d.alertFailures.lock.Lock()
+	defer d.alertFailures.lock.Unlock()
+
+	d.alertFailures.count++
+	return d.alertFailures.count
+}
+
+func (d DualDCSupport) NimbusStatus() (status NimbusStatus) {
+	var err error
+
+	// get_state should still be served when a part cannot be queried
+	if status.DNS, err = d.DNSStatus(); err != nil {
+		status.Error = err.Error()
+	}
+
+	if status.DNSHealth, err = d.DNSHealth(); err != nil {
+		status.Error = err.Error()
+	}
+
+	if status.PassiveSafe, err = d.PassiveSafe(); err != nil {
+		status.Error = err.Error()
+	}
+
+	if status.Peer, err = d.Peer(); err != nil {
+		status.Error = err.Error()
+	}
+
+	if status.Cutover, err = d.Cutover(); err != nil {
+		status.Error = err.Error()
+	}
+
+	if d.mbusStatus != nil {
+		mbus := d.mbusStatus.MbusStatus()
+		status.Mbus = &mbus
+	}
+
+	d.alertFailures.lock.Lock()
+	status.AlertFailures = d.alertFailures.count
+	d.alertFailures.lock.Unlock()
+
+	return
+}
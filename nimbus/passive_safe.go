@@ -0,0 +1,149 @@
+package nimbus
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var monitCheckProcessRegexp = regexp.MustCompile(`(?m)^\s*check\s+process\s+"?([^\s"]+)"?`)
+
+// PassiveSafeStatus is the supervisor status of the processes started on the passive leg
+type PassiveSafeStatus struct {
+	State     string                 `json:"state"` // running|starting|failing
+	Processes []boshjobsuper.Process `json:"processes"`
+}
+
+func passiveSafeConfigured(spec boshas.V1ApplySpec) bool {
+	return len(spec.PassiveSafeJobs) > 0 || len(spec.PassiveSafeProcesses) > 0
+}
+
+// PassiveSafeProcesses returns the monit processes that may run on the passive leg,
+// the processes of passive-safe jobs are read from the monit configuration of the jobs.
+func (d DualDCSupport) PassiveSafeProcesses() ([]string, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	processes := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			processes = append(processes, name)
+		}
+	}
+
+	if len(spec.PassiveSafeJobs) > 0 {
+		jobNames := []string{}
+		isJob := map[string]bool{}
+		for _, template := range spec.JobSpec.JobTemplateSpecs {
+			jobNames = append(jobNames, template.Name)
+			isJob[template.Name] = true
+		}
+
+		safeJobs := map[string]bool{}
+		for _, job := range spec.PassiveSafeJobs {
+			if !isJob[job] {
+				return nil, bosherr.Errorf("Passive-safe job '%s' is not a job of this instance", job)
+			}
+			safeJobs[job] = true
+		}
+
+		configPaths, err := d.fs.Glob(filepath.Join(d.dirProvider.MonitJobsDir(), "*.monitrc"))
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Listing monit job configs")
+		}
+
+		for _, configPath := range configPaths {
+			if !safeJobs[monitConfigJobName(filepath.Base(configPath), jobNames)] {
+				continue
+			}
+
+			config, err := d.fs.ReadFileString(configPath)
+			if err != nil {
+				return nil, bosherr.WrapErrorf(err, "Reading monit job config %s", configPath)
+			}
+
+			for _, match := range monitCheckProcessRegexp.FindAllStringSubmatch(config, -1) {
+				add(match[1])
+			}
+		}
+	}
+
+	for _, process := range spec.PassiveSafeProcesses {
+		add(process)
+	}
+
+	return processes, nil
+}
+
+// PassiveSafe returns the supervisor status of the passive-safe processes,
+// nil on the active leg or when nothing is passive-safe.
+func (d DualDCSupport) PassiveSafe() (*PassiveSafeStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.IsPassiveSide() || !passiveSafeConfigured(spec) || d.jobSupervisor == nil {
+		return nil, nil
+	}
+
+	names, err := d.PassiveSafeProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	supervised, err := d.jobSupervisor.Processes()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting processes status")
+	}
+
+	byName := map[string]boshjobsuper.Process{}
+	for _, process := range supervised {
+		byName[process.Name] = process
+	}
+
+	// same precedence as the job state of the job supervisor
+	status := &PassiveSafeStatus{State: "running", Processes: []boshjobsuper.Process{}}
+	for _, name := range names {
+		process, found := byName[name]
+		if !found {
+			process = boshjobsuper.Process{Name: name, State: "unknown"}
+		}
+		status.Processes = append(status.Processes, process)
+
+		switch {
+		case process.State == "starting":
+			status.State = "starting"
+		case process.State != "running" && status.State != "starting":
+			status.State = "failing"
+		}
+	}
+
+	return status, nil
+}
+
+// monitConfigJobName maps a config written by the job supervisor, 0000_<job>.monitrc
+// or 0000_<job>_<label>.monitrc, to the longest job name it starts with
+func monitConfigJobName(fileName string, jobNames []string) string {
+	name := strings.TrimSuffix(fileName, ".monitrc")
+	if i := strings.Index(name, "_"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	match := ""
+	for _, job := range jobNames {
+		if (name == job || strings.HasPrefix(name, job+"_")) && len(job) > len(match) {
+			match = job
+		}
+	}
+
+	return match
+}
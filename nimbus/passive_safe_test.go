@@ -0,0 +1,142 @@
+package nimbus
+
+import (
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshjobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor"
+	fakejobsuper "github.com/cloudfoundry/bosh-agent/jobsupervisor/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PassiveSafe", func() {
+	var (
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+		jobSupervisor *fakejobsuper.FakeJobSupervisor
+		dualDCSupport *DualDCSupport
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		fs.SetGlob("/var/vcap/monit/job/*.monitrc", []string{
+			"/var/vcap/monit/job/0000_app.monitrc",
+			"/var/vcap/monit/job/0001_app_worker.monitrc",
+			"/var/vcap/monit/job/0002_metrics.monitrc",
+			"/var/vcap/monit/job/0003_metrics_exporter.monitrc",
+		})
+		fs.WriteFileString("/var/vcap/monit/job/0000_app.monitrc", "check process app\n  with pidfile /var/vcap/sys/run/app/app.pid\n  group vcap\n")
+		fs.WriteFileString("/var/vcap/monit/job/0001_app_worker.monitrc", "check process app_worker\n  group vcap\n")
+		fs.WriteFileString("/var/vcap/monit/job/0002_metrics.monitrc", "check process metrics\n  group vcap\n\ncheck process \"metrics-agent\"\n  group vcap\n")
+		fs.WriteFileString("/var/vcap/monit/job/0003_metrics_exporter.monitrc", "check process metrics_exporter\n  group vcap\n")
+
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{
+			Passive: "enabled",
+			JobSpec: boshas.JobSpec{
+				JobTemplateSpecs: []boshas.JobTemplateSpec{
+					{Name: "app"},
+					{Name: "app_worker"},
+					{Name: "metrics"},
+				},
+			},
+			PassiveSafeJobs:      []string{"metrics", "app_worker"},
+			PassiveSafeProcesses: []string{"log-shipper"},
+		}
+
+		jobSupervisor = fakejobsuper.NewFakeJobSupervisor()
+
+		dualDCSupport = NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+		dualDCSupport.SetJobSupervisor(jobSupervisor)
+	})
+
+	Describe("PassiveSafeProcesses", func() {
+		It("returns the processes of passive-safe jobs and the passive-safe processes", func() {
+			processes, err := dualDCSupport.PassiveSafeProcesses()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(processes).To(Equal([]string{"app_worker", "metrics", "metrics-agent", "metrics_exporter", "log-shipper"}))
+		})
+
+		It("does not read the monit configs when no job is passive-safe", func() {
+			specService.Spec.PassiveSafeJobs = nil
+
+			processes, err := dualDCSupport.PassiveSafeProcesses()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(processes).To(Equal([]string{"log-shipper"}))
+		})
+
+		It("fails for jobs that are not on this instance", func() {
+			specService.Spec.PassiveSafeJobs = []string{"missing"}
+
+			_, err := dualDCSupport.PassiveSafeProcesses()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Passive-safe job 'missing' is not a job of this instance"))
+		})
+	})
+
+	Describe("PassiveSafe", func() {
+		BeforeEach(func() {
+			specService.Spec.PassiveSafeJobs = nil
+			specService.Spec.PassiveSafeProcesses = []string{"log-shipper", "exporter"}
+		})
+
+		It("reports the supervisor status of the passive-safe processes", func() {
+			jobSupervisor.ProcessesStatus = []boshjobsuper.Process{
+				{Name: "app", State: "failing"},
+				{Name: "log-shipper", State: "running"},
+				{Name: "exporter", State: "running"},
+			}
+
+			status, err := dualDCSupport.PassiveSafe()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.State).To(Equal("running"))
+			Expect(status.Processes).To(Equal([]boshjobsuper.Process{
+				{Name: "log-shipper", State: "running"},
+				{Name: "exporter", State: "running"},
+			}))
+		})
+
+		It("is failing when a process is not running", func() {
+			jobSupervisor.ProcessesStatus = []boshjobsuper.Process{
+				{Name: "log-shipper", State: "running"},
+			}
+
+			status, err := dualDCSupport.PassiveSafe()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.State).To(Equal("failing"))
+			Expect(status.Processes[1]).To(Equal(boshjobsuper.Process{Name: "exporter", State: "unknown"}))
+		})
+
+		It("is starting while a process starts", func() {
+			jobSupervisor.ProcessesStatus = []boshjobsuper.Process{
+				{Name: "log-shipper", State: "starting"},
+				{Name: "exporter", State: "failing"},
+			}
+
+			status, err := dualDCSupport.PassiveSafe()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.State).To(Equal("starting"))
+		})
+
+		It("is nil on the active leg", func() {
+			specService.Spec.Passive = "disabled"
+
+			status, err := dualDCSupport.PassiveSafe()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,392 @@
+package nimbus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	defaultPeerInterval = 5 * time.Second
+	peerTimeout         = 10 * time.Second
+
+	// binds the MAC of a heartbeat to its TLS connection
+	peerKeyingMaterialLabel = "EXPORTER-nimbus-peer"
+)
+
+// PeerState is what a leg tells the other one on every heartbeat
+type PeerState struct {
+	Role                string `json:"role"` // active|passive
+	DrbdRole            string `json:"drbd_role"`
+	DrbdConnectionState string `json:"drbd_connection_state"`
+	DrbdDiskState       string `json:"drbd_disk_state"`
+	JobState            string `json:"job_state"`
+}
+
+// PeerLinkStatus is the peer section of get_state
+type PeerLinkStatus struct {
+	Address        string     `json:"address"`
+	FailoverPolicy string     `json:"failover_policy"`
+	Reachable      bool       `json:"reachable"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Peer           *PeerState `json:"peer,omitempty"` // as last reported by the peer
+	LastFailover   string     `json:"last_failover,omitempty"`
+}
+
+type peerMessage struct {
+	State PeerState `json:"state"`
+	MAC   string    `json:"mac"`
+}
+
+// peerTracker is shared by the heartbeat server, the monitor and get_state
+type peerTracker struct {
+	lock             sync.Mutex
+	status           PeerLinkStatus
+	unreachableSince *time.Time
+	failedOver       bool // a failover was attempted during the current outage
+}
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{}
+}
+
+func (t *peerTracker) seen(state PeerState, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.status.Reachable = true
+	t.status.LastSeen = &now
+	t.status.LastError = ""
+	t.status.Peer = &state
+	t.unreachableSince = nil
+	t.failedOver = false
+}
+
+func (t *peerTracker) failed(err error, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.status.Reachable = false
+	t.status.LastError = err.Error()
+	if t.unreachableSince == nil {
+		t.unreachableSince = &now
+	}
+}
+
+func (t *peerTracker) setFailedOver() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.failedOver = true
+}
+
+func (t *peerTracker) failedOverDuringOutage() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.failedOver
+}
+
+func (t *peerTracker) recordFailover(action string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if err != nil {
+		action = fmt.Sprintf("failed: %s", err)
+	}
+	t.status.LastFailover = action
+}
+
+// unreachableFor is zero while the peer is reachable
+func (t *peerTracker) unreachableFor(now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.unreachableSince == nil {
+		return 0
+	}
+	return now.Sub(*t.unreachableSince)
+}
+
+func (t *peerTracker) get() PeerLinkStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status
+}
+
+func peerEnabled(spec boshas.V1ApplySpec) bool {
+	return spec.Peer.Port > 0
+}
+
+func peerInterval(spec boshas.V1ApplySpec) time.Duration {
+	if spec.Peer.Interval > 0 {
+		return time.Duration(spec.Peer.Interval) * time.Second
+	}
+	return defaultPeerInterval
+}
+
+func (d DualDCSupport) peerAddress(spec boshas.V1ApplySpec) (string, error) {
+	if spec.Peer.Address != "" {
+		return net.JoinHostPort(spec.Peer.Address, strconv.Itoa(spec.Peer.Port)), nil
+	}
+
+	if isMultiPeer(spec) {
+		return "", errors.New("peer.address is required with drbd_replication_nodes")
+	}
+
+	_, otherHostIP, err := d.replicationPeers(spec)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Finding peer address")
+	}
+
+	return net.JoinHostPort(otherHostIP, strconv.Itoa(spec.Peer.Port)), nil
+}
+
+// Peer returns the state of the heartbeat with the other leg, nil when it is off
+func (d DualDCSupport) Peer() (*PeerLinkStatus, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	if !peerEnabled(spec) {
+		return nil, nil
+	}
+
+	status := d.peer.get()
+	status.FailoverPolicy = peerFailoverPolicy(spec)
+	if status.Address, err = d.peerAddress(spec); err != nil {
+		return &status, err
+	}
+
+	return &status, nil
+}
+
+func (d DualDCSupport) localPeerState(spec boshas.V1ApplySpec) PeerState {
+	state := PeerState{Role: "passive"}
+	if spec.IsActiveSide() {
+		state.Role = "active"
+	}
+
+	if drbd, err := d.DrbdStatus(); err == nil {
+		state.DrbdRole = drbd.Role
+		state.DrbdConnectionState = drbd.ConnectionState
+		state.DrbdDiskState = drbd.DiskState
+	}
+
+	if d.jobSupervisor != nil {
+		state.JobState = d.jobSupervisor.Status()
+	}
+
+	return state
+}
+
+// listenPeer accepts heartbeats from the other leg. TLS uses a throwaway
+// self-signed certificate, both sides authenticate with the shared secret.
+func (d DualDCSupport) listenPeer(port int) (net.Listener, error) {
+	cert, err := peerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)), config)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Listening for peer heartbeats on port %d", port)
+	}
+
+	go d.servePeer(listener)
+
+	return listener, nil
+}
+
+func (d DualDCSupport) servePeer(listener net.Listener) {
+	defer d.logger.HandlePanic("Nimbus Peer Server")
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := d.answerPeerHeartbeat(conn.(*tls.Conn)); err != nil {
+				d.logger.Error(nimbusLogTag, "Peer heartbeat from %s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (d DualDCSupport) answerPeerHeartbeat(conn *tls.Conn) error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	var request peerMessage
+	if err = json.NewDecoder(conn).Decode(&request); err != nil {
+		return bosherr.WrapError(err, "Reading heartbeat")
+	}
+
+	if err = verifyPeerMessage(conn, spec.Peer.Secret, "request", request); err != nil {
+		return err
+	}
+
+	d.peer.seen(request.State, time.Now())
+
+	response, err := signPeerMessage(conn, spec.Peer.Secret, "response", d.localPeerState(spec))
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(conn).Encode(response)
+}
+
+// sendPeerHeartbeat exchanges states with the other leg and records the outcome
+func (d DualDCSupport) sendPeerHeartbeat(spec boshas.V1ApplySpec) error {
+	err := d.exchangePeerHeartbeat(spec)
+	if err != nil {
+		d.peer.failed(err, time.Now())
+	}
+	return err
+}
+
+func (d DualDCSupport) exchangePeerHeartbeat(spec boshas.V1ApplySpec) error {
+	address, err := d.peerAddress(spec)
+	if err != nil {
+		return err
+	}
+
+	// the certificate is self-signed, the MACs bound to the connection authenticate the peer
+	dialer := &net.Dialer{Timeout: peerTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Connecting to peer %s", address)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(peerTimeout))
+
+	request, err := signPeerMessage(conn, spec.Peer.Secret, "request", d.localPeerState(spec))
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return bosherr.WrapErrorf(err, "Sending heartbeat to peer %s", address)
+	}
+
+	var response peerMessage
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return bosherr.WrapErrorf(err, "Reading heartbeat of peer %s", address)
+	}
+
+	if err = verifyPeerMessage(conn, spec.Peer.Secret, "response", response); err != nil {
+		return bosherr.WrapErrorf(err, "Peer %s", address)
+	}
+
+	d.peer.seen(response.State, time.Now())
+
+	return nil
+}
+
+func signPeerMessage(conn *tls.Conn, secret, direction string, state PeerState) (peerMessage, error) {
+	mac, err := peerMAC(conn, secret, direction, state)
+	if err != nil {
+		return peerMessage{}, err
+	}
+	return peerMessage{State: state, MAC: hex.EncodeToString(mac)}, nil
+}
+
+func verifyPeerMessage(conn *tls.Conn, secret, direction string, message peerMessage) error {
+	expected, err := peerMAC(conn, secret, direction, message.State)
+	if err != nil {
+		return err
+	}
+
+	mac, err := hex.DecodeString(message.MAC)
+	if err != nil || !hmac.Equal(mac, expected) {
+		return errors.New("Heartbeat signature does not match, check peer.secret on both legs")
+	}
+
+	return nil
+}
+
+// peerMAC covers the keying material of the connection, so a recorded
+// heartbeat can not be replayed on another connection
+func peerMAC(conn *tls.Conn, secret, direction string, state PeerState) ([]byte, error) {
+	if secret == "" {
+		return nil, errors.New("peer.secret is not set")
+	}
+
+	if err := conn.Handshake(); err != nil {
+		return nil, bosherr.WrapError(err, "TLS handshake")
+	}
+
+	connectionState := conn.ConnectionState()
+	keyingMaterial, err := connectionState.ExportKeyingMaterial(peerKeyingMaterialLabel, nil, 32)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Exporting TLS keying material")
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling peer state")
+	}
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(keyingMaterial)
+	h.Write([]byte(direction))
+	h.Write(payload)
+
+	return h.Sum(nil), nil
+}
+
+func peerCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, bosherr.WrapError(err, "Generating peer key")
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "nimbus-peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, bosherr.WrapError(err, "Creating peer certificate")
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,338 @@
+package nimbus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// Failover policies, manual only reports the peer in get_state
+const (
+	PeerFailoverManual           = "manual"
+	PeerFailoverAlertOnly        = "alert-only"
+	PeerFailoverAutoPromoteAfter = "auto-promote-after"
+
+	defaultPeerGracePeriod = 60 * time.Second
+	peerWitnessTimeout     = 3 * time.Second
+)
+
+// Peer events, also the keys of peer.alert_severity in the apply spec
+const (
+	PeerEventUnreachable     = "peer_unreachable"
+	PeerEventRecovered       = "peer_recovered"
+	PeerEventPromoted        = "peer_failover_promoted"
+	PeerEventFailoverBlocked = "peer_failover_blocked"
+	PeerEventFailoverFailed  = "peer_failover_failed"
+	PeerEventSelfFenced      = "peer_self_fenced"
+	PeerEventDualActive      = "peer_dual_active"
+)
+
+var peerEventDefaultSeverity = map[string]boshalert.SeverityLevel{
+	PeerEventUnreachable:     boshalert.SeverityCritical,
+	PeerEventRecovered:       boshalert.SeverityWarning,
+	PeerEventPromoted:        boshalert.SeverityCritical,
+	PeerEventFailoverBlocked: boshalert.SeverityCritical,
+	PeerEventFailoverFailed:  boshalert.SeverityCritical,
+	PeerEventSelfFenced:      boshalert.SeverityCritical,
+	PeerEventDualActive:      boshalert.SeverityCritical,
+}
+
+// SetPeerPromoter provides the promotion run by auto-promote-after,
+// it is built from the drbd_switchover action so an interrupted promotion resumes.
+func (d *DualDCSupport) SetPeerPromoter(promoter func() error) {
+	d.peerPromoter = promoter
+}
+
+// peerCheck is what the monitor remembers between heartbeats
+type peerCheck struct {
+	alerted    map[string]bool
+	listener   net.Listener
+	listenPort int
+}
+
+// MonitorPeer exchanges heartbeats with the other leg and applies the failover policy.
+// The spec is read on every heartbeat, so the peer can be turned on by an apply.
+// It runs on the shared instance, the promoter and job supervisor are set after it starts
+// and fencing has to stop the DNS updates the actions started.
+func (d *DualDCSupport) MonitorPeer(handler NimbusAlertHandler, stopCh <-chan struct{}) {
+	defer d.logger.HandlePanic("Nimbus Monitor Peer")
+
+	check := peerCheck{alerted: map[string]bool{}}
+	defer func() {
+		if check.listener != nil {
+			check.listener.Close()
+		}
+	}()
+
+	for {
+		spec, err := d.specService.Get()
+		if err != nil {
+			d.logger.Error(nimbusLogTag, "Fetching spec to check peer: %s", err)
+		} else {
+			check = d.checkPeer(spec, check, handler)
+		}
+
+		select {
+		case <-time.After(peerInterval(spec)):
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d *DualDCSupport) checkPeer(spec boshas.V1ApplySpec, check peerCheck, handler NimbusAlertHandler) peerCheck {
+	if !peerEnabled(spec) {
+		return check
+	}
+
+	if check.listener == nil || check.listenPort != spec.Peer.Port {
+		if check.listener != nil {
+			check.listener.Close()
+		}
+
+		listener, err := d.listenPeer(spec.Peer.Port)
+		if err != nil {
+			d.logger.Error(nimbusLogTag, "%s", err)
+		}
+		check.listener = listener
+		check.listenPort = spec.Peer.Port
+	}
+
+	if err := d.sendPeerHeartbeat(spec); err != nil {
+		d.logger.Debug(nimbusLogTag, "Peer heartbeat failed: %s", err)
+	}
+
+	policy := peerFailoverPolicy(spec)
+	if policy == PeerFailoverManual {
+		return check
+	}
+
+	status := d.peer.get()
+	unreachableFor := d.peer.unreachableFor(time.Now())
+	gracePeriod := peerGracePeriod(spec)
+	resource := status.Address
+	if address, err := d.peerAddress(spec); err == nil {
+		resource = address
+	}
+
+	alert := func(event, summary string) {
+		d.logger.Info(nimbusLogTag, "Peer event '%s': %s", event, summary)
+
+		err := handler(boshalert.NimbusAlert{
+			Component: "peer",
+			Resource:  resource,
+			Event:     strings.Replace(event, "_", " ", -1),
+			Severity:  alertSeverity(spec.Peer.AlertSeverity, peerEventDefaultSeverity, event),
+			Summary:   summary,
+		})
+		if err != nil {
+			d.logger.Error(nimbusLogTag, "Reporting peer event: %s", err)
+		}
+	}
+
+	switch {
+	case unreachableFor >= gracePeriod && !check.alerted[PeerEventUnreachable]:
+		check.alerted[PeerEventUnreachable] = true
+		alert(PeerEventUnreachable, fmt.Sprintf("Peer unreachable for %s: %s", unreachableFor, status.LastError))
+
+	case status.Reachable && check.alerted[PeerEventUnreachable]:
+		check.alerted = map[string]bool{}
+		alert(PeerEventRecovered, "Peer reachable again")
+	}
+
+	if status.Reachable && status.Peer != nil {
+		dualActive := spec.IsActiveSide() && status.Peer.Role == "active"
+		if dualActive && !check.alerted[PeerEventDualActive] {
+			alert(PeerEventDualActive, "Both legs are active, demote one of them with drbd_switchover")
+		}
+		check.alerted[PeerEventDualActive] = dualActive
+	}
+
+	if policy != PeerFailoverAutoPromoteAfter {
+		return check
+	}
+
+	// the lease is renewed on every heartbeat, also by a leg that promoted itself during
+	// this outage, and a fence that failed is tried again
+	if spec.IsActiveSide() {
+		if !d.peerSelfFenceRequired(spec, unreachableFor) {
+			return check
+		}
+
+		d.logger.Info(nimbusLogTag, "Peer unreachable and witness lease lost, fencing this leg")
+		d.peer.setFailedOver()
+
+		if err := d.fenceSelf(); err != nil {
+			if !check.alerted[PeerEventFailoverFailed] {
+				check.alerted[PeerEventFailoverFailed] = true
+				alert(PeerEventFailoverFailed, fmt.Sprintf("Fencing this leg failed: %s", err))
+			}
+			return check
+		}
+
+		alert(PeerEventSelfFenced, "Peer unreachable and witness lease lost, demoted this leg to avoid two primaries")
+		return check
+	}
+
+	// only a promotion that ran counts as the failover of this outage,
+	// a blocked one is checked again on the next heartbeat
+	if unreachableFor < gracePeriod || d.peer.failedOverDuringOutage() {
+		return check
+	}
+
+	if reason := d.peerPromotionBlocked(spec); reason != "" {
+		if !check.alerted[PeerEventFailoverBlocked] {
+			check.alerted[PeerEventFailoverBlocked] = true
+			alert(PeerEventFailoverBlocked, "Not promoting this leg: "+reason)
+		}
+		return check
+	}
+
+	// the active leg fences itself once its lease expired, it gets fence_timeout for it
+	if heldFor := d.witnessLease.heldFor(time.Now()); heldFor < peerFenceTimeout(spec) {
+		d.logger.Info(nimbusLogTag, "Holding the witness lease for %s, promoting once the active leg had %s to fence itself", heldFor, peerFenceTimeout(spec))
+		return check
+	}
+
+	d.logger.Info(nimbusLogTag, "Peer unreachable for %s, promoting this leg", unreachableFor)
+	d.peer.setFailedOver()
+
+	if err := d.promoteAfterPeerLoss(); err != nil {
+		alert(PeerEventFailoverFailed, fmt.Sprintf("Promoting this leg failed: %s", err))
+		return check
+	}
+
+	alert(PeerEventPromoted, fmt.Sprintf("Promoted this leg after the peer was unreachable for %s", unreachableFor))
+
+	return check
+}
+
+// peerPromotionBlocked returns why the passive leg must not promote itself,
+// empty when it is safe: DRBD is disconnected so the peer is not writing through
+// this node, the local disk is up to date and the witnesses granted this leg the lease.
+func (d DualDCSupport) peerPromotionBlocked(spec boshas.V1ApplySpec) string {
+	if !spec.DrbdEnabled {
+		return "drbd is not enabled"
+	}
+
+	drbd, err := d.DrbdStatus()
+	if err != nil {
+		return fmt.Sprintf("drbd status unknown: %s", err)
+	}
+
+	if drbd.ConnectionState == "Connected" || drbdReplicationStates[drbd.ConnectionState] {
+		return fmt.Sprintf("drbd is still %s, the peer is alive", drbd.ConnectionState)
+	}
+
+	if drbd.Role != "Secondary" {
+		return fmt.Sprintf("drbd role is %s", drbd.Role)
+	}
+
+	if drbd.DiskState != "UpToDate" {
+		return fmt.Sprintf("local disk is %s", drbd.DiskState)
+	}
+
+	if d.peer.get().LastSeen == nil {
+		return "the peer was never seen since the agent started"
+	}
+
+	// without the lease a partition that leaves both legs reaching a witness would leave two primaries
+	if len(spec.Peer.Witnesses) == 0 {
+		return "auto-promote-after requires peer.witnesses"
+	}
+
+	held, err := d.renewWitnessLease(spec)
+	if err != nil {
+		return err.Error()
+	}
+
+	if !held {
+		if holder := d.witnessLease.otherHolder(); holder != "" {
+			return fmt.Sprintf("the witnesses grant the lease to %s, the active leg is alive", holder)
+		}
+		return "no majority of the witnesses granted the lease, this leg may be the one cut off"
+	}
+
+	return ""
+}
+
+// peerSelfFenceRequired is true when the active leg can not reach the peer and
+// its lease runs out before the next heartbeat, the other leg may get the lease then.
+// The lease is renewed on every heartbeat, also while the peer is reachable.
+func (d DualDCSupport) peerSelfFenceRequired(spec boshas.V1ApplySpec, unreachableFor time.Duration) bool {
+	if !spec.DrbdEnabled || len(spec.Peer.Witnesses) == 0 {
+		return false
+	}
+
+	held, err := d.renewWitnessLease(spec)
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Renewing witness lease: %s", err)
+	}
+
+	if held || unreachableFor == 0 {
+		return false
+	}
+
+	return !d.witnessLease.validAt(time.Now().Add(peerInterval(spec)))
+}
+
+func (d *DualDCSupport) promoteAfterPeerLoss() error {
+	if d.peerPromoter == nil {
+		return bosherr.Error("No promoter set")
+	}
+
+	err := d.peerPromoter()
+	d.peer.recordFailover(fmt.Sprintf("promoted at %s", time.Now().Format(time.RFC3339)), err)
+
+	return err
+}
+
+// fenceSelf stops writing to the store without waiting for the disconnected peer
+func (d *DualDCSupport) fenceSelf() error {
+	var err error
+	defer func() {
+		d.peer.recordFailover(fmt.Sprintf("fenced at %s", time.Now().Format(time.RFC3339)), err)
+	}()
+
+	if d.jobSupervisor != nil {
+		if err = d.jobSupervisor.Stop(); err != nil {
+			return bosherr.WrapError(err, "Stopping jobs")
+		}
+	}
+
+	// the DNS servers are most likely out of reach as well
+	if dnsErr := d.DeregisterDNSIfRequired(); dnsErr != nil {
+		d.logger.Error(nimbusLogTag, "Deregistering DNS while fencing: %s", dnsErr)
+	}
+
+	if err = d.UnmountDrbdStore(); err != nil {
+		return err
+	}
+
+	if err = d.DemoteDrbd(); err != nil {
+		return err
+	}
+
+	err = d.SetPassive(true)
+	return err
+}
+
+func peerFailoverPolicy(spec boshas.V1ApplySpec) string {
+	switch spec.Peer.FailoverPolicy {
+	case PeerFailoverAlertOnly, PeerFailoverAutoPromoteAfter:
+		return spec.Peer.FailoverPolicy
+	}
+	return PeerFailoverManual
+}
+
+func peerGracePeriod(spec boshas.V1ApplySpec) time.Duration {
+	if spec.Peer.GracePeriod > 0 {
+		return time.Duration(spec.Peer.GracePeriod) * time.Second
+	}
+	return defaultPeerGracePeriod
+}
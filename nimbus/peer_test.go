@@ -0,0 +1,481 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const procDrbdSecondaryWFConnection = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:WFConnection ro:Secondary/Unknown ds:UpToDate/DUnknown A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+const procDrbdSecondaryConnected = `version: 8.4.3 (api:1/proto:86-101)
+ 1: cs:Connected ro:Secondary/Primary ds:UpToDate/UpToDate A r-----
+    ns:0 nr:0 dw:0 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+`
+
+func freePeerPort() int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// fakeWitness grants the lease of a pair to one holder at a time like a witness does
+type fakeWitness struct {
+	listener net.Listener
+
+	// guards the leases, shared between the serving goroutine and the test
+	lock   sync.Mutex
+	leases map[string]fakeWitnessLease
+}
+
+type fakeWitnessLease struct {
+	holder string
+	until  time.Time
+}
+
+func newFakeWitness() *fakeWitness {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	w := &fakeWitness{listener: listener, leases: map[string]fakeWitnessLease{}}
+	go w.serve()
+
+	return w
+}
+
+func (w *fakeWitness) Addr() string {
+	return w.listener.Addr().String()
+}
+
+func (w *fakeWitness) Close() {
+	w.listener.Close()
+}
+
+func (w *fakeWitness) Holder(lease string) string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.leases[lease].holder
+}
+
+// Expire lets the lease run out as if its holder stopped renewing it
+func (w *fakeWitness) Expire(lease string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.leases, lease)
+}
+
+func (w *fakeWitness) serve() {
+	defer GinkgoRecover()
+
+	for {
+		conn, err := w.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		var request witnessLeaseRequest
+		if err = json.NewDecoder(conn).Decode(&request); err == nil {
+			json.NewEncoder(conn).Encode(w.request(request))
+		}
+		conn.Close()
+	}
+}
+
+func (w *fakeWitness) request(request witnessLeaseRequest) witnessLeaseResponse {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+	lease, found := w.leases[request.Lease]
+	if found && lease.holder != request.Holder && now.Before(lease.until) {
+		return witnessLeaseResponse{Holder: lease.holder, Remaining: lease.until.Sub(now).Seconds()}
+	}
+
+	duration := time.Duration(request.Duration) * time.Second
+	w.leases[request.Lease] = fakeWitnessLease{holder: request.Holder, until: now.Add(duration)}
+
+	return witnessLeaseResponse{Granted: true, Holder: request.Holder, Remaining: duration.Seconds()}
+}
+
+var _ = Describe("Peer", func() {
+	var (
+		port int
+
+		activeSpecService  *fakeas.FakeV1Service
+		passiveSpecService *fakeas.FakeV1Service
+		passiveFs          *fakesys.FakeFileSystem
+		active             *DualDCSupport
+		passive            *DualDCSupport
+	)
+
+	newLeg := func(agentID string, specService *fakeas.FakeV1Service, fs *fakesys.FakeFileSystem) *DualDCSupport {
+		settingsService := &fakesettings.FakeSettingsService{}
+		settingsService.Settings.AgentID = agentID
+
+		return NewDualDCSupport(
+			fakesys.NewFakeCmdRunner(),
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+	}
+
+	BeforeEach(func() {
+		port = freePeerPort()
+
+		activeSpecService = fakeas.NewFakeV1Service()
+		activeSpecService.Spec = boshas.V1ApplySpec{
+			Passive:     "disabled",
+			DrbdEnabled: true,
+			Peer:        boshas.PeerSpec{Address: "127.0.0.1", Port: port, Secret: "fake-secret"},
+		}
+
+		passiveSpecService = fakeas.NewFakeV1Service()
+		passiveSpecService.Spec = boshas.V1ApplySpec{
+			Passive:     "enabled",
+			DrbdEnabled: true,
+			Peer:        boshas.PeerSpec{Address: "127.0.0.1", Port: port, Secret: "fake-secret"},
+		}
+
+		passiveFs = fakesys.NewFakeFileSystem()
+		passiveFs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+		passiveFs.WriteFileString("/proc/drbd", procDrbdSecondaryWFConnection)
+
+		active = newLeg("active-agent-id", activeSpecService, fakesys.NewFakeFileSystem())
+		passive = newLeg("passive-agent-id", passiveSpecService, passiveFs)
+	})
+
+	Describe("heartbeat", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			listener, err = active.listenPeer(port)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("exchanges the state of both legs", func() {
+			err := passive.sendPeerHeartbeat(passiveSpecService.Spec)
+			Expect(err).ToNot(HaveOccurred())
+
+			status, err := passive.Peer()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Reachable).To(BeTrue())
+			Expect(status.LastSeen).ToNot(BeNil())
+			Expect(status.Peer.Role).To(Equal("active"))
+
+			Expect(active.peer.get().Peer).To(Equal(&PeerState{
+				Role:                "passive",
+				DrbdRole:            "Secondary",
+				DrbdConnectionState: "WFConnection",
+				DrbdDiskState:       "UpToDate",
+			}))
+		})
+
+		It("rejects heartbeats signed with another secret", func() {
+			passiveSpecService.Spec.Peer.Secret = "other-secret"
+
+			err := passive.sendPeerHeartbeat(passiveSpecService.Spec)
+			Expect(err).To(HaveOccurred())
+			Expect(passive.peer.get().Reachable).To(BeFalse())
+			Expect(active.peer.get().LastSeen).To(BeNil())
+		})
+	})
+
+	It("records an unreachable peer", func() {
+		err := passive.sendPeerHeartbeat(passiveSpecService.Spec)
+		Expect(err).To(HaveOccurred())
+
+		status, err := passive.Peer()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Reachable).To(BeFalse())
+		Expect(status.LastError).To(ContainSubstring("Connecting to peer"))
+		Expect(status.FailoverPolicy).To(Equal(PeerFailoverManual))
+	})
+
+	It("is off without a port", func() {
+		passiveSpecService.Spec.Peer = boshas.PeerSpec{}
+
+		status, err := passive.Peer()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(BeNil())
+	})
+
+	Describe("checkPeer", func() {
+		var (
+			alerts   []boshalert.NimbusAlert
+			handler  NimbusAlertHandler
+			check    peerCheck
+			promoted int
+			witness  *fakeWitness
+			lease    string
+		)
+
+		newCheck := func() peerCheck {
+			// nobody listens on the peer port, the listener of the leg is elsewhere
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			return peerCheck{alerted: map[string]bool{}, listener: listener, listenPort: port}
+		}
+
+		// waitFenceTimeout lets the leg hold its lease as long as the peer gets to fence itself
+		waitFenceTimeout := func(leg *DualDCSupport) {
+			leg.witnessLease.lock.Lock()
+			defer leg.witnessLease.lock.Unlock()
+
+			Expect(leg.witnessLease.acquiredAt).ToNot(BeNil())
+			acquiredAt := leg.witnessLease.acquiredAt.Add(-defaultPeerFenceTimeout)
+			leg.witnessLease.acquiredAt = &acquiredAt
+		}
+
+		BeforeEach(func() {
+			alerts = nil
+			handler = func(alert boshalert.NimbusAlert) error {
+				alerts = append(alerts, alert)
+				return nil
+			}
+
+			promoted = 0
+			passive.SetPeerPromoter(func() error {
+				promoted++
+				return nil
+			})
+
+			witness = newFakeWitness()
+			check = newCheck()
+
+			passiveSpecService.Spec.Deployment = "fake-deployment"
+			passiveSpecService.Spec.Peer.FailoverPolicy = PeerFailoverAutoPromoteAfter
+			passiveSpecService.Spec.Peer.GracePeriod = 30
+			passiveSpecService.Spec.Peer.Witnesses = []string{witness.Addr()}
+			lease = peerLeaseName(passiveSpecService.Spec)
+
+			// the peer was seen before it went away a minute ago
+			passive.peer.seen(PeerState{Role: "active"}, time.Now().Add(-2*time.Minute))
+			passive.peer.failed(errors.New("fake-error"), time.Now().Add(-time.Minute))
+		})
+
+		AfterEach(func() {
+			check.listener.Close()
+			witness.Close()
+		})
+
+		It("promotes the passive leg once it held the lease for the fence timeout", func() {
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(witness.Holder(lease)).To(Equal("passive-agent-id"))
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("peer unreachable"))
+
+			waitFenceTimeout(passive)
+
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(1))
+			Expect(alerts).To(HaveLen(2))
+			Expect(alerts[1].Event).To(Equal("peer failover promoted"))
+			Expect(alerts[1].Component).To(Equal("peer"))
+			Expect(passive.peer.get().LastFailover).To(ContainSubstring("promoted at"))
+
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(1))
+		})
+
+		It("does not promote within the grace period", func() {
+			passiveSpecService.Spec.Peer.GracePeriod = 300
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts).To(BeEmpty())
+			Expect(witness.Holder(lease)).To(BeEmpty())
+		})
+
+		It("does not promote while drbd is connected", func() {
+			passiveFs.WriteFileString("/proc/drbd", procDrbdSecondaryConnected)
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts[1].Event).To(Equal("peer failover blocked"))
+			Expect(alerts[1].Summary).To(ContainSubstring("drbd is still Connected"))
+		})
+
+		It("does not promote when no witness is reachable", func() {
+			passiveSpecService.Spec.Peer.Witnesses = []string{net.JoinHostPort("127.0.0.1", "1")}
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts[1].Summary).To(ContainSubstring("no majority of the witnesses granted the lease"))
+		})
+
+		It("does not promote when only a minority of the witnesses granted the lease", func() {
+			passiveSpecService.Spec.Peer.Witnesses = []string{witness.Addr(), net.JoinHostPort("127.0.0.1", "1")}
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts[1].Summary).To(ContainSubstring("no majority of the witnesses granted the lease"))
+		})
+
+		It("does not promote without witnesses", func() {
+			passiveSpecService.Spec.Peer.Witnesses = nil
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts[1].Event).To(Equal("peer failover blocked"))
+			Expect(alerts[1].Summary).To(ContainSubstring("auto-promote-after requires peer.witnesses"))
+		})
+
+		It("does not promote without an agent id to hold the lease", func() {
+			passive = newLeg("", passiveSpecService, passiveFs)
+			passive.peer.seen(PeerState{Role: "active"}, time.Now().Add(-2*time.Minute))
+			passive.peer.failed(errors.New("fake-error"), time.Now().Add(-time.Minute))
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(alerts[1].Summary).To(ContainSubstring("Agent id required to hold the witness lease"))
+		})
+
+		It("promotes once a blocked promotion is no longer blocked during the same outage", func() {
+			passiveFs.WriteFileString("/proc/drbd", procDrbdSecondaryConnected)
+
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts).To(HaveLen(2))
+			Expect(alerts[1].Event).To(Equal("peer failover blocked"))
+
+			passiveFs.WriteFileString("/proc/drbd", procDrbdSecondaryWFConnection)
+
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			waitFenceTimeout(passive)
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(1))
+			Expect(alerts[2].Event).To(Equal("peer failover promoted"))
+		})
+
+		It("only alerts with alert-only", func() {
+			passiveSpecService.Spec.Peer.FailoverPolicy = PeerFailoverAlertOnly
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Event).To(Equal("peer unreachable"))
+		})
+
+		It("does nothing with manual", func() {
+			passiveSpecService.Spec.Peer.FailoverPolicy = PeerFailoverManual
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(promoted).To(Equal(0))
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("reports a failed promotion", func() {
+			passive.SetPeerPromoter(func() error { return errors.New("fake-promote-error") })
+
+			check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+			waitFenceTimeout(passive)
+
+			passive.checkPeer(passiveSpecService.Spec, check, handler)
+			Expect(alerts[1].Event).To(Equal("peer failover failed"))
+			Expect(alerts[1].Summary).To(ContainSubstring("fake-promote-error"))
+		})
+
+		Context("link down, witness reachable from both legs", func() {
+			var activeCheck peerCheck
+
+			BeforeEach(func() {
+				activeCheck = newCheck()
+
+				activeSpecService.Spec.Deployment = "fake-deployment"
+				activeSpecService.Spec.Peer.FailoverPolicy = PeerFailoverAutoPromoteAfter
+				activeSpecService.Spec.Peer.GracePeriod = 30
+				activeSpecService.Spec.Peer.Witnesses = []string{witness.Addr()}
+
+				// the active leg held the lease while the link was up
+				activeCheck = active.checkPeer(activeSpecService.Spec, activeCheck, handler)
+				Expect(witness.Holder(lease)).To(Equal("active-agent-id"))
+
+				active.peer.seen(PeerState{Role: "passive"}, time.Now().Add(-2*time.Minute))
+				active.peer.failed(errors.New("fake-error"), time.Now().Add(-time.Minute))
+				alerts = nil
+			})
+
+			AfterEach(func() {
+				activeCheck.listener.Close()
+			})
+
+			It("keeps the active leg and does not promote the passive leg", func() {
+				for i := 0; i < 3; i++ {
+					activeCheck = active.checkPeer(activeSpecService.Spec, activeCheck, handler)
+					check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+				}
+
+				Expect(promoted).To(Equal(0))
+				Expect(activeSpecService.Spec.Passive).To(Equal("disabled"))
+				Expect(active.peer.get().LastFailover).To(BeEmpty())
+				Expect(witness.Holder(lease)).To(Equal("active-agent-id"))
+
+				Expect(alerts).To(HaveLen(3))
+				Expect(alerts[0].Event).To(Equal("peer unreachable"))
+				Expect(alerts[1].Event).To(Equal("peer unreachable"))
+				Expect(alerts[2].Event).To(Equal("peer failover blocked"))
+				Expect(alerts[2].Summary).To(ContainSubstring("the witnesses grant the lease to active-agent-id"))
+			})
+
+			It("fences the active leg once its lease runs out and promotes the passive leg after the fence timeout", func() {
+				// the active leg loses the witness as well and can not renew its lease
+				activeSpecService.Spec.Peer.Witnesses = []string{net.JoinHostPort("127.0.0.1", "1")}
+				witness.Expire(lease)
+
+				activeCheck = active.checkPeer(activeSpecService.Spec, activeCheck, handler)
+				Expect(activeSpecService.Spec.Passive).To(Equal("disabled"))
+
+				active.witnessLease.lock.Lock()
+				active.witnessLease.heldUntil = time.Now()
+				active.witnessLease.lock.Unlock()
+
+				activeCheck = active.checkPeer(activeSpecService.Spec, activeCheck, handler)
+				Expect(activeSpecService.Spec.Passive).To(Equal("enabled"))
+				Expect(active.peer.get().LastFailover).To(ContainSubstring("fenced at"))
+
+				check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+				Expect(witness.Holder(lease)).To(Equal("passive-agent-id"))
+				Expect(promoted).To(Equal(0))
+
+				waitFenceTimeout(passive)
+
+				check = passive.checkPeer(passiveSpecService.Spec, check, handler)
+				Expect(promoted).To(Equal(1))
+
+				events := []string{}
+				for _, alert := range alerts {
+					events = append(events, alert.Event)
+				}
+				Expect(events).To(Equal([]string{"peer unreachable", "peer self fenced", "peer unreachable", "peer failover promoted"}))
+			})
+		})
+	})
+})
@@ -0,0 +1,193 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	defaultPeerLeaseDuration = 30 * time.Second
+	defaultPeerFenceTimeout  = 30 * time.Second
+)
+
+// The witnesses arbitrate which leg may be active while the legs can not reach each other.
+// A leg asks every witness for the lease of the pair with one JSON request per connection:
+//
+//	{"lease": "<deployment>/r0", "holder": "<agent id>", "duration": 30}
+//
+// and the witness answers
+//
+//	{"granted": true, "holder": "<agent id>", "remaining": 30}
+//
+// A witness grants the lease when it is free, expired or already held by the same holder,
+// the expiry then moves to duration seconds from now. Otherwise it answers with the current
+// holder and the seconds left. A leg holds the lease while a majority of the witnesses granted it.
+//
+// The active leg renews the lease on every heartbeat and fences itself before its lease runs out
+// while the peer is unreachable. The passive leg asks for the lease only once it would promote,
+// so it gets it at the earliest when the lease of the active leg expired, and it promotes only
+// after holding it for fence_timeout, the time the active leg has to fence itself.
+
+type witnessLeaseRequest struct {
+	Lease    string `json:"lease"`
+	Holder   string `json:"holder"`
+	Duration int    `json:"duration"`
+}
+
+type witnessLeaseResponse struct {
+	Granted   bool    `json:"granted"`
+	Holder    string  `json:"holder"`
+	Remaining float64 `json:"remaining"`
+}
+
+// witnessLease is what this leg knows about its lease, shared like the peer tracker
+type witnessLease struct {
+	lock       sync.Mutex
+	heldUntil  time.Time  // by the clock of this leg, counted from when the last renewal was sent
+	acquiredAt *time.Time // start of the current uninterrupted hold
+	holder     string     // the other holder as last reported by a witness
+}
+
+func newWitnessLease() *witnessLease {
+	return &witnessLease{}
+}
+
+func (l *witnessLease) granted(sent time.Time, duration time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.heldUntil = sent.Add(duration)
+	if l.acquiredAt == nil {
+		l.acquiredAt = &sent
+	}
+	l.holder = ""
+}
+
+func (l *witnessLease) refused(holder string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.acquiredAt = nil
+	l.holder = holder
+}
+
+// validAt tells if the lease is still held at the given time
+func (l *witnessLease) validAt(at time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return at.Before(l.heldUntil)
+}
+
+// heldFor is zero unless the lease is held since the last renewal
+func (l *witnessLease) heldFor(now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.acquiredAt == nil || !now.Before(l.heldUntil) {
+		return 0
+	}
+	return now.Sub(*l.acquiredAt)
+}
+
+func (l *witnessLease) otherHolder() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.holder
+}
+
+// renewWitnessLease asks every witness for the lease of the pair,
+// it is held when a majority granted it
+func (d DualDCSupport) renewWitnessLease(spec boshas.V1ApplySpec) (bool, error) {
+	holder := d.settingsService.GetSettings().AgentID
+	if holder == "" {
+		return false, errors.New("Agent id required to hold the witness lease")
+	}
+
+	duration := peerLeaseDuration(spec)
+	request := witnessLeaseRequest{
+		Lease:    peerLeaseName(spec),
+		Holder:   holder,
+		Duration: int(duration / time.Second),
+	}
+
+	sent := time.Now()
+	granted := 0
+	otherHolder := ""
+	failures := []string{}
+
+	for _, witness := range spec.Peer.Witnesses {
+		response, err := requestWitnessLease(witness, request)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		if response.Granted {
+			granted++
+		} else {
+			otherHolder = response.Holder
+		}
+	}
+
+	if granted <= len(spec.Peer.Witnesses)/2 {
+		d.witnessLease.refused(otherHolder)
+		if len(failures) > 0 {
+			d.logger.Debug(nimbusLogTag, "Witness lease not granted by a majority: %v", failures)
+		}
+		return false, nil
+	}
+
+	d.witnessLease.granted(sent, duration)
+	return true, nil
+}
+
+func requestWitnessLease(witness string, request witnessLeaseRequest) (witnessLeaseResponse, error) {
+	var response witnessLeaseResponse
+
+	conn, err := net.DialTimeout("tcp", witness, peerWitnessTimeout)
+	if err != nil {
+		return response, bosherr.WrapErrorf(err, "Connecting to witness %s", witness)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(peerWitnessTimeout))
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return response, bosherr.WrapErrorf(err, "Requesting lease from witness %s", witness)
+	}
+
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return response, bosherr.WrapErrorf(err, "Reading lease of witness %s", witness)
+	}
+
+	return response, nil
+}
+
+// peerLeaseName defaults to the deployment and the DRBD resource, shared by both legs
+func peerLeaseName(spec boshas.V1ApplySpec) string {
+	if spec.Peer.Lease != "" {
+		return spec.Peer.Lease
+	}
+	return fmt.Sprintf("%s/%s", spec.Deployment, NewDrbdResource(spec).Name)
+}
+
+func peerLeaseDuration(spec boshas.V1ApplySpec) time.Duration {
+	if spec.Peer.LeaseDuration > 0 {
+		return time.Duration(spec.Peer.LeaseDuration) * time.Second
+	}
+	return defaultPeerLeaseDuration
+}
+
+func peerFenceTimeout(spec boshas.V1ApplySpec) time.Duration {
+	if spec.Peer.FenceTimeout > 0 {
+		return time.Duration(spec.Peer.FenceTimeout) * time.Second
+	}
+	return defaultPeerFenceTimeout
+}
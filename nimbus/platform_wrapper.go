@@ -1,7 +1,6 @@
 package nimbus
 
 import (
-	"errors"
 	"github.com/cloudfoundry/bosh-agent/platform"
 	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -30,6 +29,14 @@ func (w PlatformWrapper) MountPersistentDisk(diskSettings boshsettings.DiskSetti
 	if spec.DrbdEnabled {
 		w.dualDCSupport.logger.Debug(nimbusLogTag, "MountPersistentDisk - drbd is enabled")
 
+		// the director attached a larger disk to migrate to, it joins the volume group instead
+		if w.dualDCSupport.drbdMigrating() {
+			if err = w.dualDCSupport.addDrbdMigrationDisk(diskSettings); err != nil {
+				return bosherr.WrapError(err, "Adding migration disk to DRBD volume group")
+			}
+			return nil
+		}
+
 		if err = w.dualDCSupport.setupDRBD(); err != nil {
 			return bosherr.WrapError(err, "setting up DRBD")
 		}
@@ -59,6 +66,11 @@ func (w PlatformWrapper) UnmountPersistentDisk(diskSettings boshsettings.DiskSet
 	if spec.DrbdEnabled {
 		w.dualDCSupport.logger.Debug(nimbusLogTag, "UnmountPersistentDisk - drbd is enabled")
 
+		// the old disk is unmounted after a migration, the store lives on the new disk now
+		if w.dualDCSupport.drbdMigrating() {
+			return false, nil
+		}
+
 		// always unmount
 		didUnmount, err = w.dualDCSupport.unmountDRBD()
 		if err != nil {
@@ -71,6 +83,8 @@ func (w PlatformWrapper) UnmountPersistentDisk(diskSettings boshsettings.DiskSet
 	return w.Platform.UnmountPersistentDisk(diskSettings)
 }
 
+// DRBD volumes are not copied between filesystems, the volume group is moved
+// onto the new disk and the volume stack grown on it, see MigrateDrbdDisk.
 func (w PlatformWrapper) MigratePersistentDisk(fromMountPoint, toMountPoint string) (err error) {
 
 	spec, err := w.dualDCSupport.specService.Get()
@@ -79,7 +93,13 @@ func (w PlatformWrapper) MigratePersistentDisk(fromMountPoint, toMountPoint stri
 	}
 
 	if spec.DrbdEnabled {
-		return errors.New("Migrating a drbd box is not supported.")
+		result, err := w.dualDCSupport.MigrateDrbdDisk()
+		if err != nil {
+			return bosherr.WrapError(err, "Migrating DRBD volume")
+		}
+
+		w.dualDCSupport.logger.Info(nimbusLogTag, "MigratePersistentDisk - drbd volume %s after %v", result.State, result.Steps)
+		return nil
 	}
 
 	return w.Platform.MigratePersistentDisk(fromMountPoint, toMountPoint)
@@ -0,0 +1,413 @@
+package nimbus
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const (
+	drbdSnapshotInfix = "-snap-"
+
+	// a rolled back volume waits up to 3 minutes for the peer to connect before it is promoted
+	rollbackConnectAttempts = 60
+)
+
+var drbdSnapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.+]+$`)
+
+// connection states in which this leg receives a resync from the peer
+var drbdRollbackSyncTargetStates = map[string]bool{
+	"StartingSyncT": true,
+	"WFBitMapT":     true,
+	"WFSyncUUID":    true,
+	"SyncTarget":    true,
+	"PausedSyncT":   true,
+}
+
+// DrbdSnapshot is an LVM snapshot of the LV backing the DRBD resource.
+// Snapshots are taken below DRBD, so they are local to each leg.
+type DrbdSnapshot struct {
+	Name        string  `json:"name"`
+	SizeKiB     int64   `json:"size_kib"`
+	DataPercent float64 `json:"data_percent"`
+	CreatedAt   string  `json:"created_at"`
+	Invalid     bool    `json:"invalid"`
+}
+
+// DrbdSnapshots lists the snapshots of the backing LV, none when drbd is disabled.
+// The list is kept for drbdReportTTL unless the agent changes the snapshots.
+func (d DualDCSupport) DrbdSnapshots() ([]DrbdSnapshot, error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if snapshots, found := d.drbdReports.getSnapshots(now); found {
+		return snapshots, nil
+	}
+
+	snapshots, err := d.drbdSnapshots(NewDrbdResource(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	d.drbdReports.setSnapshots(snapshots, now)
+	return snapshots, nil
+}
+
+func (d DualDCSupport) drbdSnapshots(resource DrbdResource) ([]DrbdSnapshot, error) {
+	out, _, _, err := d.cmdRunner.RunCommand(
+		"lvs", "--noheadings", "--separator", ",", "--units", "k", "--nosuffix",
+		"-o", "lv_name,origin,lv_attr,lv_size,data_percent,lv_time",
+		resource.VolumeGroup,
+	)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Listing logical volumes of %s", resource.VolumeGroup)
+	}
+
+	return parseDrbdSnapshots(out, resource), nil
+}
+
+// parseDrbdSnapshots parses lvs output limited to snapshots of the backing LV:
+//
+//	StoreData-snap-pre_upgrade,StoreData,swi-a-s---,2097152.00,12.50,2016-03-01 10:00:00 +0000
+func parseDrbdSnapshots(output string, resource DrbdResource) []DrbdSnapshot {
+	snapshots := []DrbdSnapshot{}
+	prefix := resource.SnapshotLogicalVolume("")
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 6 {
+			continue
+		}
+
+		if fields[1] != resource.LogicalVolume || !strings.HasPrefix(fields[0], prefix) {
+			continue
+		}
+
+		attr := fields[2]
+
+		snapshots = append(snapshots, DrbdSnapshot{
+			Name:        strings.TrimPrefix(fields[0], prefix),
+			SizeKiB:     int64(parseDrbdFloat(fields[3])),
+			DataPercent: parseDrbdFloat(fields[4]),
+			CreatedAt:   fields[5],
+			// fifth attribute is the volume state, I for an invalid (overflown) snapshot
+			Invalid: len(attr) > 4 && attr[4] == 'I',
+		})
+	}
+
+	return snapshots
+}
+
+// CreateDrbdSnapshot takes a point-in-time copy of the store on the active leg,
+// the filesystem is frozen while the snapshot is taken.
+func (d DualDCSupport) CreateDrbdSnapshot(name string) (DrbdSnapshot, error) {
+	defer d.drbdReports.clear()
+
+	resource, err := d.drbdSnapshotResource(name)
+	if err != nil {
+		return DrbdSnapshot{}, err
+	}
+
+	found, err := d.drbdSnapshotExists(resource, name)
+	if err != nil {
+		return DrbdSnapshot{}, err
+	}
+
+	if found {
+		return DrbdSnapshot{}, bosherr.Errorf("Snapshot '%s' already exists", name)
+	}
+
+	mountPoint := d.dirProvider.StoreDir()
+
+	isMounted, err := d.mounter.IsMounted(mountPoint)
+	if err != nil {
+		return DrbdSnapshot{}, bosherr.WrapErrorf(err, "Checking if %s is mounted", mountPoint)
+	}
+
+	if isMounted {
+		if _, _, _, err = d.cmdRunner.RunCommand("fsfreeze", "--freeze", mountPoint); err != nil {
+			return DrbdSnapshot{}, bosherr.WrapErrorf(err, "Freezing %s", mountPoint)
+		}
+
+		defer func() {
+			if _, _, _, err := d.cmdRunner.RunCommand("fsfreeze", "--unfreeze", mountPoint); err != nil {
+				d.logger.Error(nimbusLogTag, "Unfreezing %s: %s", mountPoint, err)
+			}
+		}()
+	}
+
+	d.logger.Info(nimbusLogTag, "Creating snapshot '%s' of %s/%s", name, resource.VolumeGroup, resource.LogicalVolume)
+
+	args := append([]string{"-s", "-n", resource.SnapshotLogicalVolume(name)}, lvmSizeArgs(resource.SnapshotSize)...)
+	args = append(args, resource.VolumeGroup+"/"+resource.LogicalVolume)
+
+	if _, _, _, err = d.cmdRunner.RunCommand("lvcreate", args...); err != nil {
+		return DrbdSnapshot{}, bosherr.WrapErrorf(err, "when running: lvcreate %s", strings.Join(args, " "))
+	}
+
+	snapshots, err := d.drbdSnapshots(resource)
+	if err != nil {
+		return DrbdSnapshot{}, err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+
+	return DrbdSnapshot{Name: name}, nil
+}
+
+func (d DualDCSupport) DeleteDrbdSnapshot(name string) error {
+	defer d.drbdReports.clear()
+
+	resource, err := d.drbdSnapshotResource(name)
+	if err != nil {
+		return err
+	}
+
+	found, err := d.drbdSnapshotExists(resource, name)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return bosherr.Errorf("Snapshot '%s' not found", name)
+	}
+
+	d.logger.Info(nimbusLogTag, "Deleting snapshot '%s'", name)
+
+	if _, _, _, err = d.cmdRunner.RunCommand("lvremove", "-f", d.snapshotLvPath(resource, name)); err != nil {
+		return bosherr.WrapErrorf(err, "Removing snapshot '%s'", name)
+	}
+
+	return nil
+}
+
+// ValidateDrbdSnapshotRollback checks that RollbackDrbdSnapshot can run,
+// so jobs are only stopped for a rollback that can happen.
+func (d DualDCSupport) ValidateDrbdSnapshotRollback(name string) error {
+	resource, err := d.drbdRollbackResource(name)
+	if err != nil {
+		return err
+	}
+
+	return d.checkDrbdPeersDiscarded(resource)
+}
+
+// RollbackDrbdSnapshot merges the snapshot back into the backing LV on the active leg.
+// Jobs using the store must be stopped, the snapshot is consumed by the merge.
+//
+// The merge also rolls back the DRBD metadata, so the peer looks newer and would
+// resync over the rolled back data. Every peer therefore discards its data with
+// DiscardDrbdData first: an Inconsistent disk is always the sync target, so this
+// leg becomes the sync source when it connects again. It is promoted only once
+// connected, without forcing and with the quorum of a multi-peer resource.
+func (d DualDCSupport) RollbackDrbdSnapshot(name string) error {
+	defer d.drbdReports.clear()
+
+	resource, err := d.drbdRollbackResource(name)
+	if err != nil {
+		return err
+	}
+
+	// a peer finishing its resync before this leg is down would be UpToDate again
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "pause-sync", resource.Name); err != nil {
+		d.logger.Warn(nimbusLogTag, "Pausing resync of drbd resource %s: %s", resource.Name, err)
+	}
+
+	if err = d.checkDrbdPeersDiscarded(resource); err != nil {
+		if _, _, _, resumeErr := d.cmdRunner.RunCommand("drbdadm", "resume-sync", resource.Name); resumeErr != nil {
+			d.logger.Warn(nimbusLogTag, "Resuming resync of drbd resource %s: %s", resource.Name, resumeErr)
+		}
+		return err
+	}
+
+	d.logger.Info(nimbusLogTag, "Rolling back to snapshot '%s'", name)
+
+	if err = d.UnmountDrbdStore(); err != nil {
+		return err
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "down", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Taking down drbd resource %s", resource.Name)
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("lvconvert", "--merge", d.snapshotLvPath(resource, name)); err != nil {
+		return bosherr.WrapErrorf(err, "Merging snapshot '%s'", name)
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "up", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Bringing up drbd resource %s", resource.Name)
+	}
+
+	if err = d.waitForDrbdPeers(resource, rollbackConnectAttempts); err != nil {
+		return err
+	}
+
+	return d.mountDRBD()
+}
+
+// DiscardDrbdData is run on the passive leg before the active one rolls back a snapshot.
+// The disk is invalidated, which keeps it Inconsistent until it is fully resynced,
+// so the rolled back leg overwrites it instead of being overwritten.
+func (d DualDCSupport) DiscardDrbdData() error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return bosherr.Error("Discarding data requires drbd to be enabled")
+	}
+
+	if !spec.IsPassiveSide() {
+		return bosherr.Error("Data can only be discarded on the passive leg")
+	}
+
+	resource := NewDrbdResource(spec)
+
+	d.logger.Info(nimbusLogTag, "Discarding data of drbd resource %s", resource.Name)
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "disconnect", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Disconnecting drbd resource %s", resource.Name)
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "invalidate", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Invalidating drbd resource %s", resource.Name)
+	}
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "connect", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Connecting drbd resource %s", resource.Name)
+	}
+
+	return nil
+}
+
+// checkDrbdPeersDiscarded makes sure every peer is connected with an Inconsistent disk
+func (d DualDCSupport) checkDrbdPeersDiscarded(resource DrbdResource) error {
+	out, _, _, err := d.cmdRunner.RunCommand("drbdadm", "dstate", resource.Name)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Checking 'drbdadm dstate %s'", resource.Name)
+	}
+
+	states := strings.Fields(out)
+	if len(states) == 0 {
+		return bosherr.Errorf("No disk state of drbd resource %s", resource.Name)
+	}
+
+	for _, state := range states {
+		pair := strings.SplitN(state, "/", 2)
+		if len(pair) != 2 || pair[1] != "Inconsistent" {
+			return bosherr.Errorf(
+				"Disk states of drbd resource %s are %s, run drbd_discard_data on the passive leg before rolling back",
+				resource.Name,
+				strings.Join(states, " "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// waitForDrbdPeers waits until every peer is connected or being resynced from this leg
+func (d DualDCSupport) waitForDrbdPeers(resource DrbdResource, attempts int) error {
+	for i := 0; i <= attempts; i++ {
+		out, _, _, err := d.cmdRunner.RunCommand("drbdadm", "cstate", resource.Name)
+		if err != nil {
+			return bosherr.WrapErrorf(err, "Checking 'drbdadm cstate %s'", resource.Name)
+		}
+
+		cstates := strings.Fields(out)
+		connected := len(cstates) > 0
+
+		for _, cstate := range cstates {
+			if drbdRollbackSyncTargetStates[cstate] {
+				return bosherr.Errorf("Drbd resource %s is resynced from the peer (%s), the rollback is overwritten", resource.Name, cstate)
+			}
+			if cstate != "Connected" && !drbdResyncStates[cstate] {
+				connected = false
+			}
+		}
+
+		if connected {
+			return nil
+		}
+
+		if i == attempts {
+			break
+		}
+		time.Sleep(drbdSyncCheckInterval)
+	}
+	return bosherr.Errorf("Checked 'drbdadm cstate %s' %d times, the peer did not connect, the rolled back leg is not promoted", resource.Name, attempts)
+}
+
+// drbdRollbackResource validates the snapshot name, the leg and that the snapshot exists
+func (d DualDCSupport) drbdRollbackResource(name string) (DrbdResource, error) {
+	resource, err := d.drbdSnapshotResource(name)
+	if err != nil {
+		return DrbdResource{}, err
+	}
+
+	found, err := d.drbdSnapshotExists(resource, name)
+	if err != nil {
+		return DrbdResource{}, err
+	}
+
+	if !found {
+		return DrbdResource{}, bosherr.Errorf("Snapshot '%s' not found", name)
+	}
+
+	return resource, nil
+}
+
+// drbdSnapshotResource validates the snapshot name and that this is the active leg
+func (d DualDCSupport) drbdSnapshotResource(name string) (DrbdResource, error) {
+	if !drbdSnapshotNamePattern.MatchString(name) {
+		return DrbdResource{}, bosherr.Errorf("Invalid snapshot name '%s', only letters, digits and _.+ are allowed", name)
+	}
+
+	spec, err := d.specService.Get()
+	if err != nil {
+		return DrbdResource{}, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !spec.DrbdEnabled {
+		return DrbdResource{}, bosherr.Error("Snapshots require drbd to be enabled")
+	}
+
+	if !spec.IsActiveSide() {
+		return DrbdResource{}, bosherr.Error("Snapshots can only be managed on the active leg")
+	}
+
+	return NewDrbdResource(spec), nil
+}
+
+func (d DualDCSupport) drbdSnapshotExists(resource DrbdResource, name string) (bool, error) {
+	snapshots, err := d.drbdSnapshots(resource)
+	if err != nil {
+		return false, err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (d DualDCSupport) snapshotLvPath(resource DrbdResource, name string) string {
+	return resource.VolumeGroup + "/" + resource.SnapshotLogicalVolume(name)
+}
@@ -0,0 +1,325 @@
+package nimbus
+
+import (
+	"errors"
+	"strings"
+
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const lvsCmd = "lvs --noheadings --separator , --units k --nosuffix -o lv_name,origin,lv_attr,lv_size,data_percent,lv_time vgStoreData"
+
+const lvsOutput = `  StoreData,,owi-aos---,41943040.00,,2016-02-01 09:00:00 +0000
+  StoreData-snap-pre_upgrade,StoreData,swi-a-s---,2097152.00,12.50,2016-03-01 10:00:00 +0000
+  StoreData-snap-full,StoreData,swi-I-s---,2097152.00,100.00,2016-03-02 10:00:00 +0000
+  other-snap-x,other,swi-a-s---,1024.00,1.00,2016-03-02 10:00:00 +0000
+`
+
+var _ = Describe("Snapshots", func() {
+	var (
+		dualDCSupport *DualDCSupport
+		cmdRunner     *fakesys.FakeCmdRunner
+		fs            *fakesys.FakeFileSystem
+		specService   *fakeas.FakeV1Service
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, Passive: "disabled"}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			&fakesettings.FakeSettingsService{},
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: "/dev/drbd1 on /var/vcap/store type ext4 (rw)\n"})
+		cmdRunner.AddCmdResult("mount", fakesys.FakeCmdResult{Stdout: "", Sticky: true})
+		cmdRunner.AddCmdResult(lvsCmd, fakesys.FakeCmdResult{Stdout: lvsOutput, Sticky: true})
+	})
+
+	It("parses snapshots of the backing volume", func() {
+		snapshots, err := dualDCSupport.DrbdSnapshots()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(Equal([]DrbdSnapshot{
+			{Name: "pre_upgrade", SizeKiB: 2097152, DataPercent: 12.5, CreatedAt: "2016-03-01 10:00:00 +0000"},
+			{Name: "full", SizeKiB: 2097152, DataPercent: 100, CreatedAt: "2016-03-02 10:00:00 +0000", Invalid: true},
+		}))
+	})
+
+	It("lists the snapshots again only after they were changed", func() {
+		_, err := dualDCSupport.DrbdSnapshots()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = dualDCSupport.DrbdSnapshots()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cmdRunner.RunCommands).To(Equal([][]string{strings.Fields(lvsCmd)}))
+
+		err = dualDCSupport.DeleteDrbdSnapshot("pre_upgrade")
+		Expect(err).ToNot(HaveOccurred())
+
+		cmdRunner.RunCommands = nil
+		_, err = dualDCSupport.DrbdSnapshots()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cmdRunner.RunCommands).To(Equal([][]string{strings.Fields(lvsCmd)}))
+	})
+
+	It("lists nothing when drbd is disabled", func() {
+		specService.Spec = boshas.V1ApplySpec{}
+
+		snapshots, err := dualDCSupport.DrbdSnapshots()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(BeNil())
+		Expect(cmdRunner.RunCommands).To(BeEmpty())
+	})
+
+	Describe("CreateDrbdSnapshot", func() {
+		It("creates snapshot from free extents with frozen filesystem", func() {
+			snapshot, err := dualDCSupport.CreateDrbdSnapshot("pre_upgrade2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(snapshot.Name).To(Equal("pre_upgrade2"))
+
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{
+				{"lvs", "--noheadings", "--separator", ",", "--units", "k", "--nosuffix", "-o", "lv_name,origin,lv_attr,lv_size,data_percent,lv_time", "vgStoreData"},
+				{"mount"},
+				{"fsfreeze", "--freeze", "/var/vcap/store"},
+				{"lvcreate", "-s", "-n", "StoreData-snap-pre_upgrade2", "-l", "50%FREE", "vgStoreData/StoreData"},
+				{"lvs", "--noheadings", "--separator", ",", "--units", "k", "--nosuffix", "-o", "lv_name,origin,lv_attr,lv_size,data_percent,lv_time", "vgStoreData"},
+				{"fsfreeze", "--unfreeze", "/var/vcap/store"},
+			}))
+		})
+
+		It("uses snapshot size from spec", func() {
+			specService.Spec.DrbdSnapshotSize = "5G"
+
+			_, err := dualDCSupport.CreateDrbdSnapshot("pre_upgrade2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"lvcreate", "-s", "-n", "StoreData-snap-pre_upgrade2", "-L", "5G", "vgStoreData/StoreData"}))
+		})
+
+		It("does not freeze unmounted store", func() {
+			// consume the mounted store result
+			cmdRunner.RunCommand("mount")
+
+			_, err := dualDCSupport.CreateDrbdSnapshot("pre_upgrade2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"fsfreeze", "--freeze", "/var/vcap/store"}))
+		})
+
+		It("refuses existing snapshot name", func() {
+			_, err := dualDCSupport.CreateDrbdSnapshot("pre_upgrade")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Snapshot 'pre_upgrade' already exists"))
+		})
+
+		It("refuses invalid snapshot name", func() {
+			_, err := dualDCSupport.CreateDrbdSnapshot("../x")
+			Expect(err).To(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("refuses to snapshot on passive leg", func() {
+			specService.Spec.Passive = "enabled"
+
+			_, err := dualDCSupport.CreateDrbdSnapshot("pre_upgrade2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("active leg"))
+		})
+	})
+
+	Describe("DeleteDrbdSnapshot", func() {
+		It("removes the snapshot volume", func() {
+			err := dualDCSupport.DeleteDrbdSnapshot("pre_upgrade")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"lvremove", "-f", "vgStoreData/StoreData-snap-pre_upgrade"}))
+		})
+
+		It("fails for unknown snapshot", func() {
+			err := dualDCSupport.DeleteDrbdSnapshot("missing")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Snapshot 'missing' not found"))
+		})
+	})
+
+	Describe("RollbackDrbdSnapshot", func() {
+		var drbd *drbdRollbackSimulator
+
+		BeforeEach(func() {
+			drbd = newDrbdRollbackSimulator(cmdRunner)
+			dualDCSupport = NewDualDCSupport(
+				drbd,
+				fs,
+				boshdir.NewProvider("/var/vcap"),
+				specService,
+				&fakesettings.FakeSettingsService{},
+				fakedpresolv.NewFakeDevicePathResolver(),
+				boshlog.NewLogger(boshlog.LevelNone),
+			)
+			cmdRunner.AddCmdResult("file -s /dev/drbd1", fakesys.FakeCmdResult{Stdout: "/dev/drbd1: Linux rev 1.0 ext4 filesystem data"})
+		})
+
+		It("becomes the sync source of a peer that discarded its data and promotes once connected", func() {
+			drbd.peerDisk = "Inconsistent"
+
+			Expect(dualDCSupport.ValidateDrbdSnapshotRollback("pre_upgrade")).To(Succeed())
+
+			err := dualDCSupport.RollbackDrbdSnapshot("pre_upgrade")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(drbd.merged).To(BeTrue())
+			Expect(drbd.cstate()).To(Equal("SyncSource"))
+			Expect(drbd.localDisk).To(Equal("UpToDate"))
+			Expect(drbd.peerDisk).To(Equal("Inconsistent"))
+			Expect(drbd.role).To(Equal("Primary"))
+			Expect(drbd.promotedDisconnected).To(BeFalse())
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "primary", "--force", "r0"}))
+		})
+
+		It("refuses to roll back before the peer discarded its data", func() {
+			drbd.peerDisk = "UpToDate"
+
+			err := dualDCSupport.ValidateDrbdSnapshotRollback("pre_upgrade")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("run drbd_discard_data on the passive leg"))
+
+			err = dualDCSupport.RollbackDrbdSnapshot("pre_upgrade")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("run drbd_discard_data on the passive leg"))
+
+			Expect(drbd.up).To(BeTrue())
+			Expect(drbd.merged).To(BeFalse())
+			Expect(drbd.paused).To(BeFalse())
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "down", "r0"}))
+		})
+
+		It("fails for unknown snapshot before touching drbd", func() {
+			err := dualDCSupport.ValidateDrbdSnapshotRollback("missing")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Snapshot 'missing' not found"))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{strings.Split(lvsCmd, " ")}))
+		})
+	})
+
+	Describe("DiscardDrbdData", func() {
+		It("invalidates the disk of the passive leg and connects again", func() {
+			specService.Spec.Passive = "enabled"
+
+			err := dualDCSupport.DiscardDrbdData()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{
+				{"drbdadm", "disconnect", "r0"},
+				{"drbdadm", "invalidate", "r0"},
+				{"drbdadm", "connect", "r0"},
+			}))
+		})
+
+		It("refuses to discard the data of the active leg", func() {
+			err := dualDCSupport.DiscardDrbdData()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("only be discarded on the passive leg"))
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+	})
+})
+
+// drbdRollbackSimulator models the active leg of a resource with one peer that is
+// always waiting for a connection, other commands are passed to the fake runner.
+// A peer that is not Inconsistent has newer data than the merged snapshot.
+type drbdRollbackSimulator struct {
+	*fakesys.FakeCmdRunner
+
+	up        bool
+	connected bool
+	merged    bool
+	paused    bool
+	role      string
+	localDisk string
+	peerDisk  string
+
+	promotedDisconnected bool
+}
+
+func newDrbdRollbackSimulator(runner *fakesys.FakeCmdRunner) *drbdRollbackSimulator {
+	return &drbdRollbackSimulator{
+		FakeCmdRunner: runner,
+		up:            true,
+		connected:     true,
+		role:          "Primary",
+		localDisk:     "UpToDate",
+	}
+}
+
+func (s *drbdRollbackSimulator) cstate() string {
+	switch {
+	case !s.up:
+		return "Unconfigured"
+	case !s.connected:
+		return "WFConnection"
+	case s.localDisk == "Inconsistent":
+		return "SyncTarget"
+	case s.peerDisk == "Inconsistent" && s.paused:
+		return "PausedSyncS"
+	case s.peerDisk == "Inconsistent":
+		return "SyncSource"
+	}
+	return "Connected"
+}
+
+func (s *drbdRollbackSimulator) RunCommand(cmdName string, args ...string) (string, string, int, error) {
+	s.RunCommands = append(s.RunCommands, append([]string{cmdName}, args...))
+
+	switch strings.Join(append([]string{cmdName}, args...), " ") {
+	case "drbdadm pause-sync r0":
+		s.paused = true
+	case "drbdadm resume-sync r0":
+		s.paused = false
+	case "drbdadm cstate r0":
+		return s.cstate() + "\n", "", 0, nil
+	case "drbdadm dstate r0":
+		if !s.connected {
+			return s.localDisk + "/DUnknown\n", "", 0, nil
+		}
+		return s.localDisk + "/" + s.peerDisk + "\n", "", 0, nil
+	case "drbdadm down r0":
+		s.up, s.connected, s.paused, s.role = false, false, false, "Secondary"
+	case "lvconvert --merge vgStoreData/StoreData-snap-pre_upgrade":
+		if s.up {
+			return "", "", 1, errors.New("Can't merge over open origin volume")
+		}
+		s.merged = true
+		s.localDisk = "Consistent"
+	case "drbdadm up r0":
+		s.up, s.connected = true, true
+		// an Inconsistent disk is always the sync target, otherwise the newer data wins
+		if s.peerDisk == "Inconsistent" {
+			s.localDisk = "UpToDate"
+		} else if s.merged {
+			s.localDisk = "Inconsistent"
+		}
+	case "drbdadm primary r0":
+		if s.localDisk != "UpToDate" {
+			return "", "", 11, errors.New("State change failed: Need access to UpToDate data")
+		}
+		s.promotedDisconnected = !s.connected
+		s.role = "Primary"
+	default:
+		// not recorded twice by the fake runner
+		s.RunCommands = s.RunCommands[:len(s.RunCommands)-1]
+		return s.FakeCmdRunner.RunCommand(cmdName, args...)
+	}
+
+	return "", "", 0, nil
+}
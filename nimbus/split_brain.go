@@ -0,0 +1,278 @@
+package nimbus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const splitBrainCheckInterval = 30 * time.Second
+
+// Split brain recovery policies accepted in drbd_split_brain_policy
+const (
+	SplitBrainPolicyManual                = "manual"
+	SplitBrainPolicyDiscardYoungerPrimary = "discard-younger-primary"
+	SplitBrainPolicyDiscardLeastChanges   = "discard-least-changes"
+	SplitBrainPolicyDiscardSecondary      = "discard-secondary"
+)
+
+// Roles played in manual split brain recovery
+const (
+	SplitBrainVictim   = "victim"
+	SplitBrainSurvivor = "survivor"
+)
+
+type DrbdAlertHandler func(boshalert.DrbdAlert) error
+
+type NimbusAlertHandler func(boshalert.NimbusAlert) error
+
+type afterSplitBrain struct {
+	ZeroPrimaries string
+	OnePrimary    string
+	TwoPrimaries  string
+}
+
+// after-sb-0pri, after-sb-1pri and after-sb-2pri for each policy,
+// anything not resolved automatically leaves the resource StandAlone
+var splitBrainPolicies = map[string]afterSplitBrain{
+	SplitBrainPolicyManual:                {"disconnect", "disconnect", "disconnect"},
+	SplitBrainPolicyDiscardYoungerPrimary: {"discard-younger-primary", "consensus", "disconnect"},
+	SplitBrainPolicyDiscardLeastChanges:   {"discard-least-changes", "consensus", "disconnect"},
+	SplitBrainPolicyDiscardSecondary:      {"discard-zero-changes", "discard-secondary", "disconnect"},
+}
+
+func splitBrainPolicy(policy string) (afterSplitBrain, error) {
+	if policy == "" {
+		policy = SplitBrainPolicyManual
+	}
+
+	afterSb, found := splitBrainPolicies[policy]
+	if !found {
+		return afterSplitBrain{}, bosherr.Errorf("Unknown split brain policy '%s'", policy)
+	}
+
+	return afterSb, nil
+}
+
+// splitBrainMarkerPath is touched by the DRBD split-brain handler
+func (d DualDCSupport) splitBrainMarkerPath(resource DrbdResource) string {
+	return filepath.Join(d.dirProvider.BoshDir(), "drbd-split-brain-"+resource.Name)
+}
+
+// DetectSplitBrain reports split brain when the DRBD split-brain handler has fired.
+// A StandAlone resource alone is no sign of split brain, the agent disconnects
+// resources itself to resync after verify and to roll back a snapshot.
+func (d DualDCSupport) DetectSplitBrain(resource DrbdResource) bool {
+	return d.fs.FileExists(d.splitBrainMarkerPath(resource))
+}
+
+// drbdStandAlone checks every connection of a multi-peer resource on its own,
+// the summary of drbdsetup status only shows the least healthy peer.
+// peers names the StandAlone peers of a multi-peer resource.
+func (d DualDCSupport) drbdStandAlone(resource DrbdResource) (standAlone bool, peers []string, err error) {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return false, nil, bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if !isMultiPeer(spec) {
+		cstate, err := d.drbdConnectionState(resource)
+		if err != nil {
+			return false, nil, err
+		}
+
+		return cstate == "StandAlone", nil, nil
+	}
+
+	out, _, _, err := d.cmdRunner.RunCommand("drbdsetup", "status", resource.Name, "--verbose")
+	if err != nil {
+		return false, nil, bosherr.WrapErrorf(err, "Checking 'drbdsetup status %s'", resource.Name)
+	}
+
+	for _, peer := range ParseDrbdsetupStatus(out).Peers {
+		if peer.ConnectionState == "StandAlone" {
+			peers = append(peers, peer.Name)
+		}
+	}
+
+	return len(peers) > 0, peers, nil
+}
+
+func (d DualDCSupport) drbdConnectionState(resource DrbdResource) (string, error) {
+	out, _, _, err := d.cmdRunner.RunCommand("drbdadm", "cstate", resource.Name)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Checking 'drbdadm cstate %s'", resource.Name)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// MonitorSplitBrain periodically checks DRBD enabled resources for split brain
+// and calls handler once per occurrence with a critical alert.
+func (d DualDCSupport) MonitorSplitBrain(handler DrbdAlertHandler, stopCh <-chan struct{}) {
+	defer d.logger.HandlePanic("Nimbus Monitor Split Brain")
+
+	reported := false
+	ticker := time.NewTicker(splitBrainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reported = d.checkSplitBrain(reported, handler)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (d DualDCSupport) checkSplitBrain(reported bool, handler DrbdAlertHandler) bool {
+	spec, err := d.specService.Get()
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Fetching spec to check for split brain: %s", err)
+		return reported
+	}
+
+	resource := NewDrbdResource(spec)
+	if !spec.DrbdEnabled || !d.isDRBDConfigWritten(resource) {
+		return false
+	}
+
+	detected := d.DetectSplitBrain(resource)
+	if !detected || reported {
+		return detected
+	}
+
+	policy := spec.DrbdSplitBrainPolicy
+	if policy == "" {
+		policy = SplitBrainPolicyManual
+	}
+
+	d.logger.Error(nimbusLogTag, "Split brain detected on drbd resource %s", resource.Name)
+
+	state := "StandAlone"
+	_, peers, err := d.drbdStandAlone(resource)
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Checking StandAlone peers after split brain: %s", err)
+	}
+	if len(peers) > 0 {
+		state = fmt.Sprintf("StandAlone towards %s", strings.Join(peers, ", "))
+	}
+
+	err = handler(boshalert.DrbdAlert{
+		Resource: resource.Name,
+		Event:    "split brain",
+		Severity: boshalert.SeverityCritical,
+		Summary: fmt.Sprintf(
+			"DRBD resource %s is %s after split brain (policy: %s), run drbd_resolve_split_brain choosing a victim node",
+			resource.Name,
+			state,
+			policy,
+		),
+	})
+	if err != nil {
+		d.logger.Error(nimbusLogTag, "Reporting split brain: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// ResolveSplitBrain runs the manual split brain recovery on this node.
+// victim is the replication IP or agent id of the node whose changes are discarded,
+// every other node acts as the survivor.
+func (d DualDCSupport) ResolveSplitBrain(victim string) (role string, err error) {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := d.specService.Get()
+	if err != nil {
+		return "", bosherr.WrapError(err, "Fetching spec")
+	}
+
+	thisHostIP, err := d.replicationIP(spec)
+	if err != nil {
+		return "", err
+	}
+
+	if victim == thisHostIP || victim == d.settingsService.GetSettings().AgentID {
+		err = d.resolveSplitBrainAsVictim(resource)
+		role = SplitBrainVictim
+	} else {
+		err = d.resolveSplitBrainAsSurvivor(resource)
+		role = SplitBrainSurvivor
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = d.fs.RemoveAll(d.splitBrainMarkerPath(resource)); err != nil {
+		return "", bosherr.WrapError(err, "Removing split brain marker")
+	}
+
+	return role, nil
+}
+
+func (d DualDCSupport) resolveSplitBrainAsVictim(resource DrbdResource) error {
+	if !d.DetectSplitBrain(resource) {
+		return bosherr.Errorf("No split brain detected on drbd resource %s", resource.Name)
+	}
+
+	d.logger.Info(nimbusLogTag, "Discarding local changes to resolve split brain on %s", resource.Name)
+
+	mountPoint := d.dirProvider.StoreDir()
+	if _, err := d.mounter.Unmount(mountPoint); err != nil {
+		return bosherr.WrapErrorf(err, "Unmounting %s", mountPoint)
+	}
+
+	if _, _, _, err := d.cmdRunner.RunCommand("drbdadm", "disconnect", resource.Name); err != nil {
+		return bosherr.WrapError(err, "Disconnecting split brain victim")
+	}
+
+	if _, _, _, err := d.cmdRunner.RunCommand("drbdadm", "secondary", resource.Name); err != nil {
+		return bosherr.WrapError(err, "Demoting split brain victim")
+	}
+
+	if _, _, _, err := d.cmdRunner.RunCommand("drbdadm", "connect", "--discard-my-data", resource.Name); err != nil {
+		return bosherr.WrapError(err, "Reconnecting split brain victim")
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) resolveSplitBrainAsSurvivor(resource DrbdResource) error {
+	standAlone, peers, err := d.drbdStandAlone(resource)
+	if err != nil {
+		return err
+	}
+
+	// survivor may already be waiting for the victim to connect
+	if !standAlone {
+		return nil
+	}
+
+	d.logger.Info(nimbusLogTag, "Reconnecting split brain survivor %s", resource.Name)
+
+	// only the StandAlone connections of a multi-peer resource, the others are up
+	connections := []string{resource.Name}
+	if len(peers) > 0 {
+		connections = []string{}
+		for _, peer := range peers {
+			connections = append(connections, resource.Name+":"+peer)
+		}
+	}
+
+	for _, connection := range connections {
+		if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "connect", connection); err != nil {
+			return bosherr.WrapErrorf(err, "Reconnecting split brain survivor to %s", connection)
+		}
+	}
+
+	return nil
+}
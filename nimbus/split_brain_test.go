@@ -0,0 +1,235 @@
+package nimbus
+
+import (
+	"errors"
+
+	boshalert "github.com/cloudfoundry/bosh-agent/agent/alert"
+	boshas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec"
+	fakeas "github.com/cloudfoundry/bosh-agent/agent/applier/applyspec/fakes"
+	fakedpresolv "github.com/cloudfoundry/bosh-agent/infrastructure/devicepathresolver/fakes"
+	boshsettings "github.com/cloudfoundry/bosh-agent/settings"
+	boshdir "github.com/cloudfoundry/bosh-agent/settings/directories"
+	fakesettings "github.com/cloudfoundry/bosh-agent/settings/fakes"
+	boshlog "github.com/cloudfoundry/bosh-utils/logger"
+	fakesys "github.com/cloudfoundry/bosh-utils/system/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const drbdsetupStatusOnePeerStandAlone = `r0 node-id:0 role:Primary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:yes
+  dc1-b node-id:1 connection:Connecting role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+  dc2-a node-id:2 connection:StandAlone role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+`
+
+const drbdsetupStatusNoPeerStandAlone = `r0 node-id:0 role:Primary suspended:no
+  volume:0 minor:1 disk:UpToDate quorum:yes
+  dc1-b node-id:1 connection:Connected role:Secondary
+    volume:0 replication:Established peer-disk:UpToDate resync-suspended:no
+  dc2-a node-id:2 connection:Connecting role:Unknown
+    volume:0 replication:Off peer-disk:DUnknown resync-suspended:no
+`
+
+var _ = Describe("SplitBrain", func() {
+	var (
+		dualDCSupport   *DualDCSupport
+		cmdRunner       *fakesys.FakeCmdRunner
+		fs              *fakesys.FakeFileSystem
+		specService     *fakeas.FakeV1Service
+		settingsService *fakesettings.FakeSettingsService
+
+		alerts  []boshalert.DrbdAlert
+		handler DrbdAlertHandler
+	)
+
+	BeforeEach(func() {
+		fs = fakesys.NewFakeFileSystem()
+		cmdRunner = fakesys.NewFakeCmdRunner()
+		specService = fakeas.NewFakeV1Service()
+		settingsService = &fakesettings.FakeSettingsService{}
+		settingsService.Settings = boshsettings.Settings{
+			AgentID: "fake-agent-id",
+			Networks: boshsettings.Networks{
+				"default": boshsettings.Network{IP: "10.76.245.71"},
+			},
+		}
+
+		dualDCSupport = NewDualDCSupport(
+			cmdRunner,
+			fs,
+			boshdir.NewProvider("/var/vcap"),
+			specService,
+			settingsService,
+			fakedpresolv.NewFakeDevicePathResolver(),
+			boshlog.NewLogger(boshlog.LevelNone),
+		)
+
+		specService.Spec = boshas.V1ApplySpec{DrbdEnabled: true, DrbdSplitBrainPolicy: "discard-secondary"}
+		fs.WriteFileString("/etc/drbd.d/r0.res", "fake-config")
+
+		alerts = nil
+		handler = func(alert boshalert.DrbdAlert) error {
+			alerts = append(alerts, alert)
+			return nil
+		}
+	})
+
+	Describe("checkSplitBrain", func() {
+		It("raises critical alert when split brain handler has fired", func() {
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone\n"})
+
+			reported := dualDCSupport.checkSplitBrain(false, handler)
+			Expect(reported).To(BeTrue())
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Resource).To(Equal("r0"))
+			Expect(alerts[0].Event).To(Equal("split brain"))
+			Expect(alerts[0].Severity).To(Equal(boshalert.SeverityCritical))
+			Expect(alerts[0].Summary).To(ContainSubstring("is StandAlone after split brain"))
+			Expect(alerts[0].Summary).To(ContainSubstring("policy: discard-secondary"))
+		})
+
+		It("does not alert when resource is StandAlone without split brain", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone\n"})
+
+			reported := dualDCSupport.checkSplitBrain(false, handler)
+			Expect(reported).To(BeFalse())
+			Expect(alerts).To(BeEmpty())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("does not repeat alert while split brain persists", func() {
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+
+			reported := dualDCSupport.checkSplitBrain(true, handler)
+			Expect(reported).To(BeTrue())
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("clears reported state when split brain was resolved", func() {
+
+			reported := dualDCSupport.checkSplitBrain(true, handler)
+			Expect(reported).To(BeFalse())
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("does not check when drbd is disabled", func() {
+			specService.Spec = boshas.V1ApplySpec{}
+
+			reported := dualDCSupport.checkSplitBrain(false, handler)
+			Expect(reported).To(BeFalse())
+			Expect(cmdRunner.RunCommands).To(BeEmpty())
+		})
+
+		It("reports again later when alert could not be sent", func() {
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+
+			reported := dualDCSupport.checkSplitBrain(false, func(boshalert.DrbdAlert) error {
+				return errors.New("fake-send-error")
+			})
+			Expect(reported).To(BeFalse())
+		})
+	})
+
+	Describe("ResolveSplitBrain", func() {
+		It("discards local data when this node is the victim", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone\n"})
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+
+			role, err := dualDCSupport.ResolveSplitBrain("10.76.245.71")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainVictim))
+
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "disconnect", "r0"}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "secondary", "r0"}))
+			Expect(cmdRunner.RunCommands).To(ContainElement([]string{"drbdadm", "connect", "--discard-my-data", "r0"}))
+			Expect(fs.FileExists("/var/vcap/bosh/drbd-split-brain-r0")).To(BeFalse())
+		})
+
+		It("accepts agent id as victim", func() {
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+
+			role, err := dualDCSupport.ResolveSplitBrain("fake-agent-id")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainVictim))
+		})
+
+		It("refuses to discard data when there is no split brain", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone\n"})
+
+			_, err := dualDCSupport.ResolveSplitBrain("10.76.245.71")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("No split brain detected"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "connect", "--discard-my-data", "r0"}))
+		})
+
+		It("reconnects StandAlone survivor", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "StandAlone\n"})
+
+			role, err := dualDCSupport.ResolveSplitBrain("10.92.245.71")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainSurvivor))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"drbdadm", "cstate", "r0"}, {"drbdadm", "connect", "r0"}}))
+		})
+
+		It("leaves survivor waiting for connection alone", func() {
+			cmdRunner.AddCmdResult("drbdadm cstate r0", fakesys.FakeCmdResult{Stdout: "WFConnection\n"})
+
+			role, err := dualDCSupport.ResolveSplitBrain("10.92.245.71")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainSurvivor))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"drbdadm", "cstate", "r0"}}))
+		})
+	})
+	Context("when resource is multi-peer", func() {
+		BeforeEach(func() {
+			specService.Spec.DrbdReplicationNodes = []boshas.DrbdNodeSpec{
+				{Name: "dc1-a", Address: "10.76.245.71"},
+				{Name: "dc1-b", Address: "10.76.245.72"},
+				{Name: "dc2-a", Address: "10.92.245.71"},
+			}
+		})
+
+		It("raises alert naming the StandAlone peer while another peer is only connecting", func() {
+			fs.WriteFileString("/var/vcap/bosh/drbd-split-brain-r0", "")
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: drbdsetupStatusOnePeerStandAlone})
+
+			reported := dualDCSupport.checkSplitBrain(false, handler)
+			Expect(reported).To(BeTrue())
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].Summary).To(ContainSubstring("StandAlone towards dc2-a"))
+			Expect(cmdRunner.RunCommands).ToNot(ContainElement([]string{"drbdadm", "cstate", "r0"}))
+		})
+
+		It("does not alert when a peer is StandAlone without split brain", func() {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: drbdsetupStatusOnePeerStandAlone})
+
+			reported := dualDCSupport.checkSplitBrain(false, handler)
+			Expect(reported).To(BeFalse())
+			Expect(alerts).To(BeEmpty())
+		})
+
+		It("reconnects survivor to the StandAlone peers only", func() {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: drbdsetupStatusOnePeerStandAlone})
+
+			role, err := dualDCSupport.ResolveSplitBrain("10.92.245.71")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainSurvivor))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{
+				{"drbdsetup", "status", "r0", "--verbose"},
+				{"drbdadm", "connect", "r0:dc2-a"},
+			}))
+		})
+
+		It("leaves survivor alone when no peer is StandAlone", func() {
+			cmdRunner.AddCmdResult("drbdsetup status r0 --verbose", fakesys.FakeCmdResult{Stdout: drbdsetupStatusNoPeerStandAlone})
+
+			role, err := dualDCSupport.ResolveSplitBrain("10.92.245.71")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(SplitBrainSurvivor))
+			Expect(cmdRunner.RunCommands).To(Equal([][]string{{"drbdsetup", "status", "r0", "--verbose"}}))
+		})
+	})
+})
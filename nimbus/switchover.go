@@ -0,0 +1,70 @@
+package nimbus
+
+import (
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// a switchover waits for resync to finish for up to 10 minutes
+const switchoverSyncAttempts = 200
+
+// Building blocks of the drbd_switchover action, each of them is safe to repeat
+// so that an interrupted switchover can be resumed from its last recorded step.
+
+func (d DualDCSupport) WaitForDrbdInSync() error {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return err
+	}
+
+	return d.waitForDrbdInSync(resource, switchoverSyncAttempts)
+}
+
+func (d DualDCSupport) UnmountDrbdStore() error {
+	mountPoint := d.dirProvider.StoreDir()
+
+	if _, err := d.mounter.Unmount(mountPoint); err != nil {
+		return bosherr.WrapErrorf(err, "Unmounting %s", mountPoint)
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) DemoteDrbd() error {
+	resource, err := d.DrbdResource()
+	if err != nil {
+		return err
+	}
+
+	d.logger.Info(nimbusLogTag, "Drbd making secondary")
+
+	if _, _, _, err = d.cmdRunner.RunCommand("drbdadm", "secondary", resource.Name); err != nil {
+		return bosherr.WrapErrorf(err, "Demoting drbd resource %s", resource.Name)
+	}
+
+	return nil
+}
+
+func (d DualDCSupport) PromoteDrbdAndMount() error {
+	return d.mountDRBD()
+}
+
+// SetPassive records the new role of this leg in the current apply spec,
+// DNS registration, mounting and get_state all follow the spec.
+func (d DualDCSupport) SetPassive(passive bool) error {
+	spec, err := d.specService.Get()
+	if err != nil {
+		return bosherr.WrapError(err, "Fetching spec")
+	}
+
+	if passive {
+		spec.Passive = "enabled"
+	} else {
+		spec.Passive = "disabled"
+	}
+
+	if err = d.specService.Set(spec); err != nil {
+		return bosherr.WrapError(err, "Persisting spec")
+	}
+
+	return nil
+}
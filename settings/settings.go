@@ -106,6 +106,40 @@ func (e Env) GetPassword() string {
 
 type BoshEnv struct {
 	Password string `json:"password"`
+	Mbus     MBus   `json:"mbus"`
+}
+
+type MBus struct {
+	Cert           CertKeyPair    `json:"cert"`
+	RequestSigning RequestSigning `json:"request_signing"`
+
+	// CN of a client certificate to the actions it may run over https, "*" allows all
+	ClientActions map[string][]string `json:"client_actions"`
+}
+
+// RequestSigning is checked by the agent when at least one key is configured
+type RequestSigning struct {
+	Keys []SigningKey `json:"keys"`
+
+	// Unsigned requests are still accepted unless required, they are written to the audit log
+	Required bool `json:"required"`
+
+	// How far the timestamp of a request may be off, in seconds
+	MaxClockSkew int `json:"max_clock_skew"`
+}
+
+type SigningKey struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"` // hmac-sha256|ed25519
+	Key       string `json:"key"`       // base64 encoded secret or Ed25519 public key
+}
+
+// CertKeyPair holds PEM encoded certificates, a client certificate is
+// only presented when both Certificate and PrivateKey are set
+type CertKeyPair struct {
+	CA          string `json:"ca"`
+	PrivateKey  string `json:"private_key"`
+	Certificate string `json:"certificate"`
 }
 
 type NetworkType string